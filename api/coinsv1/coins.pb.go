@@ -23,8 +23,9 @@ const (
 
 type CreateRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`            // required (coin/account id)
-	Initial       int64                  `protobuf:"varint,2,opt,name=initial,proto3" json:"initial,omitempty"` // optional, default 0
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`                             // required (coin/account id)
+	Initial       int64                  `protobuf:"varint,2,opt,name=initial,proto3" json:"initial,omitempty"`                  // optional, default 0
+	CoinType      string                 `protobuf:"bytes,3,opt,name=coin_type,json=coinType,proto3" json:"coin_type,omitempty"` // optional, default "COIN"
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -73,14 +74,23 @@ func (x *CreateRequest) GetInitial() int64 {
 	return 0
 }
 
+func (x *CreateRequest) GetCoinType() string {
+	if x != nil {
+		return x.CoinType
+	}
+	return ""
+}
+
 type DepleteRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`                       // required (coin/account id)
-	Amount        int64                  `protobuf:"varint,2,opt,name=amount,proto3" json:"amount,omitempty"`              // required, must be > 0
-	UserId        string                 `protobuf:"bytes,3,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"` // required (UUID) - actor responsible for depletion
-	DataId        string                 `protobuf:"bytes,4,opt,name=data_id,json=dataId,proto3" json:"data_id,omitempty"` // optional event id (e.g., "order:12345")
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Id              string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`                                                         // required (coin/account id)
+	Amount          int64                  `protobuf:"varint,2,opt,name=amount,proto3" json:"amount,omitempty"`                                                // required, must be > 0
+	UserId          string                 `protobuf:"bytes,3,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`                                   // required (UUID) - actor responsible for depletion
+	DataId          string                 `protobuf:"bytes,4,opt,name=data_id,json=dataId,proto3" json:"data_id,omitempty"`                                   // optional event id (e.g., "order:12345"); retries with the same id are idempotent
+	CoinType        string                 `protobuf:"bytes,5,opt,name=coin_type,json=coinType,proto3" json:"coin_type,omitempty"`                             // optional, default "COIN"
+	ExpectedVersion *int64                 `protobuf:"varint,6,opt,name=expected_version,json=expectedVersion,proto3,oneof" json:"expected_version,omitempty"` // optional; fails with ABORTED if the account version differs
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
 }
 
 func (x *DepleteRequest) Reset() {
@@ -141,12 +151,32 @@ func (x *DepleteRequest) GetDataId() string {
 	return ""
 }
 
+func (x *DepleteRequest) GetCoinType() string {
+	if x != nil {
+		return x.CoinType
+	}
+	return ""
+}
+
+func (x *DepleteRequest) GetExpectedVersion() int64 {
+	if x != nil && x.ExpectedVersion != nil {
+		return *x.ExpectedVersion
+	}
+	return 0
+}
+
 type AccountReply struct {
 	state            protoimpl.MessageState `protogen:"open.v1"`
 	Id               string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
 	Coins            int64                  `protobuf:"varint,2,opt,name=coins,proto3" json:"coins,omitempty"`
 	LastRechargeDate string                 `protobuf:"bytes,3,opt,name=last_recharge_date,json=lastRechargeDate,proto3" json:"last_recharge_date,omitempty"` // RFC3339
 	LastUsageDate    string                 `protobuf:"bytes,4,opt,name=last_usage_date,json=lastUsageDate,proto3" json:"last_usage_date,omitempty"`          // RFC3339
+	Held             int64                  `protobuf:"varint,5,opt,name=held,proto3" json:"held,omitempty"`                                                  // sum of active holds
+	Available        int64                  `protobuf:"varint,6,opt,name=available,proto3" json:"available,omitempty"`                                        // coins - held + credit_limit
+	CoinType         string                 `protobuf:"bytes,7,opt,name=coin_type,json=coinType,proto3" json:"coin_type,omitempty"`
+	Version          int64                  `protobuf:"varint,8,opt,name=version,proto3" json:"version,omitempty"`                             // incremented by every mutation
+	Status           string                 `protobuf:"bytes,9,opt,name=status,proto3" json:"status,omitempty"`                                // active | frozen | closed
+	CreditLimit      int64                  `protobuf:"varint,10,opt,name=credit_limit,json=creditLimit,proto3" json:"credit_limit,omitempty"` // how far coins may go below zero
 	unknownFields    protoimpl.UnknownFields
 	sizeCache        protoimpl.SizeCache
 }
@@ -209,27 +239,807 @@ func (x *AccountReply) GetLastUsageDate() string {
 	return ""
 }
 
+func (x *AccountReply) GetHeld() int64 {
+	if x != nil {
+		return x.Held
+	}
+	return 0
+}
+
+func (x *AccountReply) GetAvailable() int64 {
+	if x != nil {
+		return x.Available
+	}
+	return 0
+}
+
+func (x *AccountReply) GetCoinType() string {
+	if x != nil {
+		return x.CoinType
+	}
+	return ""
+}
+
+func (x *AccountReply) GetVersion() int64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *AccountReply) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *AccountReply) GetCreditLimit() int64 {
+	if x != nil {
+		return x.CreditLimit
+	}
+	return 0
+}
+
+type AuthorizeRequest struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Id              string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`                                                         // required (coin/account id)
+	Amount          int64                  `protobuf:"varint,2,opt,name=amount,proto3" json:"amount,omitempty"`                                                // required, must be > 0
+	TtlSeconds      int64                  `protobuf:"varint,3,opt,name=ttl_seconds,json=ttlSeconds,proto3" json:"ttl_seconds,omitempty"`                      // optional, default 900
+	UserId          string                 `protobuf:"bytes,4,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`                                   // required (UUID)
+	DataId          string                 `protobuf:"bytes,5,opt,name=data_id,json=dataId,proto3" json:"data_id,omitempty"`                                   // optional event id (e.g., "order:12345")
+	CoinType        string                 `protobuf:"bytes,6,opt,name=coin_type,json=coinType,proto3" json:"coin_type,omitempty"`                             // optional, default "COIN"
+	ExpectedVersion *int64                 `protobuf:"varint,7,opt,name=expected_version,json=expectedVersion,proto3,oneof" json:"expected_version,omitempty"` // optional; fails with ABORTED if the account version differs
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *AuthorizeRequest) Reset() {
+	*x = AuthorizeRequest{}
+	mi := &file_api_coinsv1_coins_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AuthorizeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AuthorizeRequest) ProtoMessage() {}
+
+func (x *AuthorizeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_coinsv1_coins_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AuthorizeRequest.ProtoReflect.Descriptor instead.
+func (*AuthorizeRequest) Descriptor() ([]byte, []int) {
+	return file_api_coinsv1_coins_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *AuthorizeRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *AuthorizeRequest) GetAmount() int64 {
+	if x != nil {
+		return x.Amount
+	}
+	return 0
+}
+
+func (x *AuthorizeRequest) GetTtlSeconds() int64 {
+	if x != nil {
+		return x.TtlSeconds
+	}
+	return 0
+}
+
+func (x *AuthorizeRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *AuthorizeRequest) GetDataId() string {
+	if x != nil {
+		return x.DataId
+	}
+	return ""
+}
+
+func (x *AuthorizeRequest) GetCoinType() string {
+	if x != nil {
+		return x.CoinType
+	}
+	return ""
+}
+
+func (x *AuthorizeRequest) GetExpectedVersion() int64 {
+	if x != nil && x.ExpectedVersion != nil {
+		return *x.ExpectedVersion
+	}
+	return 0
+}
+
+type CaptureRequest struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	HoldId          string                 `protobuf:"bytes,1,opt,name=hold_id,json=holdId,proto3" json:"hold_id,omitempty"`                                   // required
+	Amount          int64                  `protobuf:"varint,2,opt,name=amount,proto3" json:"amount,omitempty"`                                                // optional, 0 captures the full hold
+	UserId          string                 `protobuf:"bytes,3,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`                                   // required (UUID)
+	DataId          string                 `protobuf:"bytes,4,opt,name=data_id,json=dataId,proto3" json:"data_id,omitempty"`                                   // optional event id, defaults to "capture:<hold_id>"
+	ExpectedVersion *int64                 `protobuf:"varint,5,opt,name=expected_version,json=expectedVersion,proto3,oneof" json:"expected_version,omitempty"` // optional; checked against the held account
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *CaptureRequest) Reset() {
+	*x = CaptureRequest{}
+	mi := &file_api_coinsv1_coins_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CaptureRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CaptureRequest) ProtoMessage() {}
+
+func (x *CaptureRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_coinsv1_coins_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CaptureRequest.ProtoReflect.Descriptor instead.
+func (*CaptureRequest) Descriptor() ([]byte, []int) {
+	return file_api_coinsv1_coins_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *CaptureRequest) GetHoldId() string {
+	if x != nil {
+		return x.HoldId
+	}
+	return ""
+}
+
+func (x *CaptureRequest) GetAmount() int64 {
+	if x != nil {
+		return x.Amount
+	}
+	return 0
+}
+
+func (x *CaptureRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *CaptureRequest) GetDataId() string {
+	if x != nil {
+		return x.DataId
+	}
+	return ""
+}
+
+func (x *CaptureRequest) GetExpectedVersion() int64 {
+	if x != nil && x.ExpectedVersion != nil {
+		return *x.ExpectedVersion
+	}
+	return 0
+}
+
+type VoidRequest struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	HoldId          string                 `protobuf:"bytes,1,opt,name=hold_id,json=holdId,proto3" json:"hold_id,omitempty"`                                   // required
+	ExpectedVersion *int64                 `protobuf:"varint,2,opt,name=expected_version,json=expectedVersion,proto3,oneof" json:"expected_version,omitempty"` // optional; checked against the held account
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *VoidRequest) Reset() {
+	*x = VoidRequest{}
+	mi := &file_api_coinsv1_coins_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VoidRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VoidRequest) ProtoMessage() {}
+
+func (x *VoidRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_coinsv1_coins_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VoidRequest.ProtoReflect.Descriptor instead.
+func (*VoidRequest) Descriptor() ([]byte, []int) {
+	return file_api_coinsv1_coins_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *VoidRequest) GetHoldId() string {
+	if x != nil {
+		return x.HoldId
+	}
+	return ""
+}
+
+func (x *VoidRequest) GetExpectedVersion() int64 {
+	if x != nil && x.ExpectedVersion != nil {
+		return *x.ExpectedVersion
+	}
+	return 0
+}
+
+type HoldReply struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	AccountId     string                 `protobuf:"bytes,2,opt,name=account_id,json=accountId,proto3" json:"account_id,omitempty"`
+	Amount        int64                  `protobuf:"varint,3,opt,name=amount,proto3" json:"amount,omitempty"`
+	Captured      int64                  `protobuf:"varint,4,opt,name=captured,proto3" json:"captured,omitempty"`
+	Status        string                 `protobuf:"bytes,5,opt,name=status,proto3" json:"status,omitempty"`                        // active | captured | voided | expired
+	ExpiresAt     string                 `protobuf:"bytes,6,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"` // RFC3339
+	Account       *AccountReply          `protobuf:"bytes,7,opt,name=account,proto3" json:"account,omitempty"`                      // account after the operation
+	CoinType      string                 `protobuf:"bytes,8,opt,name=coin_type,json=coinType,proto3" json:"coin_type,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HoldReply) Reset() {
+	*x = HoldReply{}
+	mi := &file_api_coinsv1_coins_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HoldReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HoldReply) ProtoMessage() {}
+
+func (x *HoldReply) ProtoReflect() protoreflect.Message {
+	mi := &file_api_coinsv1_coins_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HoldReply.ProtoReflect.Descriptor instead.
+func (*HoldReply) Descriptor() ([]byte, []int) {
+	return file_api_coinsv1_coins_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *HoldReply) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *HoldReply) GetAccountId() string {
+	if x != nil {
+		return x.AccountId
+	}
+	return ""
+}
+
+func (x *HoldReply) GetAmount() int64 {
+	if x != nil {
+		return x.Amount
+	}
+	return 0
+}
+
+func (x *HoldReply) GetCaptured() int64 {
+	if x != nil {
+		return x.Captured
+	}
+	return 0
+}
+
+func (x *HoldReply) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *HoldReply) GetExpiresAt() string {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return ""
+}
+
+func (x *HoldReply) GetAccount() *AccountReply {
+	if x != nil {
+		return x.Account
+	}
+	return nil
+}
+
+func (x *HoldReply) GetCoinType() string {
+	if x != nil {
+		return x.CoinType
+	}
+	return ""
+}
+
+type TransferLeg struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	AccountId       string                 `protobuf:"bytes,1,opt,name=account_id,json=accountId,proto3" json:"account_id,omitempty"`                          // required
+	Amount          int64                  `protobuf:"varint,2,opt,name=amount,proto3" json:"amount,omitempty"`                                                // required, non-zero; negative debits, positive credits
+	ExpectedVersion *int64                 `protobuf:"varint,3,opt,name=expected_version,json=expectedVersion,proto3,oneof" json:"expected_version,omitempty"` // optional; fails with ABORTED if the account version differs
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *TransferLeg) Reset() {
+	*x = TransferLeg{}
+	mi := &file_api_coinsv1_coins_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TransferLeg) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TransferLeg) ProtoMessage() {}
+
+func (x *TransferLeg) ProtoReflect() protoreflect.Message {
+	mi := &file_api_coinsv1_coins_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TransferLeg.ProtoReflect.Descriptor instead.
+func (*TransferLeg) Descriptor() ([]byte, []int) {
+	return file_api_coinsv1_coins_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *TransferLeg) GetAccountId() string {
+	if x != nil {
+		return x.AccountId
+	}
+	return ""
+}
+
+func (x *TransferLeg) GetAmount() int64 {
+	if x != nil {
+		return x.Amount
+	}
+	return 0
+}
+
+func (x *TransferLeg) GetExpectedVersion() int64 {
+	if x != nil && x.ExpectedVersion != nil {
+		return *x.ExpectedVersion
+	}
+	return 0
+}
+
+type MultiTransferRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Legs          []*TransferLeg         `protobuf:"bytes,1,rep,name=legs,proto3" json:"legs,omitempty"`                         // required, at least two, amounts summing to zero
+	UserId        string                 `protobuf:"bytes,2,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`       // required (UUID)
+	DataId        string                 `protobuf:"bytes,3,opt,name=data_id,json=dataId,proto3" json:"data_id,omitempty"`       // optional event id; retries with the same id are idempotent
+	CoinType      string                 `protobuf:"bytes,4,opt,name=coin_type,json=coinType,proto3" json:"coin_type,omitempty"` // optional, default "COIN"
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MultiTransferRequest) Reset() {
+	*x = MultiTransferRequest{}
+	mi := &file_api_coinsv1_coins_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MultiTransferRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MultiTransferRequest) ProtoMessage() {}
+
+func (x *MultiTransferRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_coinsv1_coins_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MultiTransferRequest.ProtoReflect.Descriptor instead.
+func (*MultiTransferRequest) Descriptor() ([]byte, []int) {
+	return file_api_coinsv1_coins_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *MultiTransferRequest) GetLegs() []*TransferLeg {
+	if x != nil {
+		return x.Legs
+	}
+	return nil
+}
+
+func (x *MultiTransferRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *MultiTransferRequest) GetDataId() string {
+	if x != nil {
+		return x.DataId
+	}
+	return ""
+}
+
+func (x *MultiTransferRequest) GetCoinType() string {
+	if x != nil {
+		return x.CoinType
+	}
+	return ""
+}
+
+type MultiTransferReply struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Accounts      []*AccountReply        `protobuf:"bytes,1,rep,name=accounts,proto3" json:"accounts,omitempty"` // in leg order
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MultiTransferReply) Reset() {
+	*x = MultiTransferReply{}
+	mi := &file_api_coinsv1_coins_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MultiTransferReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MultiTransferReply) ProtoMessage() {}
+
+func (x *MultiTransferReply) ProtoReflect() protoreflect.Message {
+	mi := &file_api_coinsv1_coins_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MultiTransferReply.ProtoReflect.Descriptor instead.
+func (*MultiTransferReply) Descriptor() ([]byte, []int) {
+	return file_api_coinsv1_coins_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *MultiTransferReply) GetAccounts() []*AccountReply {
+	if x != nil {
+		return x.Accounts
+	}
+	return nil
+}
+
+type ReverseRequest struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	DataId          string                 `protobuf:"bytes,1,opt,name=data_id,json=dataId,proto3" json:"data_id,omitempty"`                                   // required, the data_id of the original operation
+	Amount          *int64                 `protobuf:"varint,2,opt,name=amount,proto3,oneof" json:"amount,omitempty"`                                          // optional, defaults to everything not reversed yet
+	UserId          string                 `protobuf:"bytes,3,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`                                   // required (UUID)
+	AccountId       string                 `protobuf:"bytes,4,opt,name=account_id,json=accountId,proto3" json:"account_id,omitempty"`                          // optional, needed when several accounts used the data_id
+	ExpectedVersion *int64                 `protobuf:"varint,5,opt,name=expected_version,json=expectedVersion,proto3,oneof" json:"expected_version,omitempty"` // optional; checked against the account that is refunded
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *ReverseRequest) Reset() {
+	*x = ReverseRequest{}
+	mi := &file_api_coinsv1_coins_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReverseRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReverseRequest) ProtoMessage() {}
+
+func (x *ReverseRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_coinsv1_coins_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReverseRequest.ProtoReflect.Descriptor instead.
+func (*ReverseRequest) Descriptor() ([]byte, []int) {
+	return file_api_coinsv1_coins_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ReverseRequest) GetDataId() string {
+	if x != nil {
+		return x.DataId
+	}
+	return ""
+}
+
+func (x *ReverseRequest) GetAmount() int64 {
+	if x != nil && x.Amount != nil {
+		return *x.Amount
+	}
+	return 0
+}
+
+func (x *ReverseRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *ReverseRequest) GetAccountId() string {
+	if x != nil {
+		return x.AccountId
+	}
+	return ""
+}
+
+func (x *ReverseRequest) GetExpectedVersion() int64 {
+	if x != nil && x.ExpectedVersion != nil {
+		return *x.ExpectedVersion
+	}
+	return 0
+}
+
+type ReverseReply struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	OriginalLedgerId int64                  `protobuf:"varint,1,opt,name=original_ledger_id,json=originalLedgerId,proto3" json:"original_ledger_id,omitempty"`
+	Amount           int64                  `protobuf:"varint,2,opt,name=amount,proto3" json:"amount,omitempty"` // reversed by this call
+	TotalReversed    int64                  `protobuf:"varint,3,opt,name=total_reversed,json=totalReversed,proto3" json:"total_reversed,omitempty"`
+	Remaining        int64                  `protobuf:"varint,4,opt,name=remaining,proto3" json:"remaining,omitempty"`        // still reversible
+	DataId           string                 `protobuf:"bytes,5,opt,name=data_id,json=dataId,proto3" json:"data_id,omitempty"` // data_id of the compensating entry
+	Account          *AccountReply          `protobuf:"bytes,6,opt,name=account,proto3" json:"account,omitempty"`             // original payer after the reversal
+	Counterparty     *AccountReply          `protobuf:"bytes,7,opt,name=counterparty,proto3" json:"counterparty,omitempty"`   // payee of a reversed transfer
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *ReverseReply) Reset() {
+	*x = ReverseReply{}
+	mi := &file_api_coinsv1_coins_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReverseReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReverseReply) ProtoMessage() {}
+
+func (x *ReverseReply) ProtoReflect() protoreflect.Message {
+	mi := &file_api_coinsv1_coins_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReverseReply.ProtoReflect.Descriptor instead.
+func (*ReverseReply) Descriptor() ([]byte, []int) {
+	return file_api_coinsv1_coins_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *ReverseReply) GetOriginalLedgerId() int64 {
+	if x != nil {
+		return x.OriginalLedgerId
+	}
+	return 0
+}
+
+func (x *ReverseReply) GetAmount() int64 {
+	if x != nil {
+		return x.Amount
+	}
+	return 0
+}
+
+func (x *ReverseReply) GetTotalReversed() int64 {
+	if x != nil {
+		return x.TotalReversed
+	}
+	return 0
+}
+
+func (x *ReverseReply) GetRemaining() int64 {
+	if x != nil {
+		return x.Remaining
+	}
+	return 0
+}
+
+func (x *ReverseReply) GetDataId() string {
+	if x != nil {
+		return x.DataId
+	}
+	return ""
+}
+
+func (x *ReverseReply) GetAccount() *AccountReply {
+	if x != nil {
+		return x.Account
+	}
+	return nil
+}
+
+func (x *ReverseReply) GetCounterparty() *AccountReply {
+	if x != nil {
+		return x.Counterparty
+	}
+	return nil
+}
+
 var File_api_coinsv1_coins_proto protoreflect.FileDescriptor
 
 const file_api_coinsv1_coins_proto_rawDesc = "" +
 	"\n" +
-	"\x17api/coinsv1/coins.proto\x12\bcoins.v1\"9\n" +
+	"\x17api/coinsv1/coins.proto\x12\bcoins.v1\"V\n" +
 	"\rCreateRequest\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x18\n" +
-	"\ainitial\x18\x02 \x01(\x03R\ainitial\"j\n" +
+	"\ainitial\x18\x02 \x01(\x03R\ainitial\x12\x1b\n" +
+	"\tcoin_type\x18\x03 \x01(\tR\bcoinType\"\xcc\x01\n" +
 	"\x0eDepleteRequest\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
 	"\x06amount\x18\x02 \x01(\x03R\x06amount\x12\x17\n" +
 	"\auser_id\x18\x03 \x01(\tR\x06userId\x12\x17\n" +
-	"\adata_id\x18\x04 \x01(\tR\x06dataId\"\x8a\x01\n" +
+	"\adata_id\x18\x04 \x01(\tR\x06dataId\x12\x1b\n" +
+	"\tcoin_type\x18\x05 \x01(\tR\bcoinType\x12.\n" +
+	"\x10expected_version\x18\x06 \x01(\x03H\x00R\x0fexpectedVersion\x88\x01\x01B\x13\n" +
+	"\x11_expected_version\"\xae\x02\n" +
 	"\fAccountReply\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x14\n" +
 	"\x05coins\x18\x02 \x01(\x03R\x05coins\x12,\n" +
 	"\x12last_recharge_date\x18\x03 \x01(\tR\x10lastRechargeDate\x12&\n" +
-	"\x0flast_usage_date\x18\x04 \x01(\tR\rlastUsageDate2\x8d\x01\n" +
+	"\x0flast_usage_date\x18\x04 \x01(\tR\rlastUsageDate\x12\x12\n" +
+	"\x04held\x18\x05 \x01(\x03R\x04held\x12\x1c\n" +
+	"\tavailable\x18\x06 \x01(\x03R\tavailable\x12\x1b\n" +
+	"\tcoin_type\x18\a \x01(\tR\bcoinType\x12\x18\n" +
+	"\aversion\x18\b \x01(\x03R\aversion\x12\x16\n" +
+	"\x06status\x18\t \x01(\tR\x06status\x12!\n" +
+	"\fcredit_limit\x18\n" +
+	" \x01(\x03R\vcreditLimit\"\xef\x01\n" +
+	"\x10AuthorizeRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
+	"\x06amount\x18\x02 \x01(\x03R\x06amount\x12\x1f\n" +
+	"\vttl_seconds\x18\x03 \x01(\x03R\n" +
+	"ttlSeconds\x12\x17\n" +
+	"\auser_id\x18\x04 \x01(\tR\x06userId\x12\x17\n" +
+	"\adata_id\x18\x05 \x01(\tR\x06dataId\x12\x1b\n" +
+	"\tcoin_type\x18\x06 \x01(\tR\bcoinType\x12.\n" +
+	"\x10expected_version\x18\a \x01(\x03H\x00R\x0fexpectedVersion\x88\x01\x01B\x13\n" +
+	"\x11_expected_version\"\xb8\x01\n" +
+	"\x0eCaptureRequest\x12\x17\n" +
+	"\ahold_id\x18\x01 \x01(\tR\x06holdId\x12\x16\n" +
+	"\x06amount\x18\x02 \x01(\x03R\x06amount\x12\x17\n" +
+	"\auser_id\x18\x03 \x01(\tR\x06userId\x12\x17\n" +
+	"\adata_id\x18\x04 \x01(\tR\x06dataId\x12.\n" +
+	"\x10expected_version\x18\x05 \x01(\x03H\x00R\x0fexpectedVersion\x88\x01\x01B\x13\n" +
+	"\x11_expected_version\"k\n" +
+	"\vVoidRequest\x12\x17\n" +
+	"\ahold_id\x18\x01 \x01(\tR\x06holdId\x12.\n" +
+	"\x10expected_version\x18\x02 \x01(\x03H\x00R\x0fexpectedVersion\x88\x01\x01B\x13\n" +
+	"\x11_expected_version\"\xf4\x01\n" +
+	"\tHoldReply\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1d\n" +
+	"\n" +
+	"account_id\x18\x02 \x01(\tR\taccountId\x12\x16\n" +
+	"\x06amount\x18\x03 \x01(\x03R\x06amount\x12\x1a\n" +
+	"\bcaptured\x18\x04 \x01(\x03R\bcaptured\x12\x16\n" +
+	"\x06status\x18\x05 \x01(\tR\x06status\x12\x1d\n" +
+	"\n" +
+	"expires_at\x18\x06 \x01(\tR\texpiresAt\x120\n" +
+	"\aaccount\x18\a \x01(\v2\x16.coins.v1.AccountReplyR\aaccount\x12\x1b\n" +
+	"\tcoin_type\x18\b \x01(\tR\bcoinType\"\x89\x01\n" +
+	"\vTransferLeg\x12\x1d\n" +
+	"\n" +
+	"account_id\x18\x01 \x01(\tR\taccountId\x12\x16\n" +
+	"\x06amount\x18\x02 \x01(\x03R\x06amount\x12.\n" +
+	"\x10expected_version\x18\x03 \x01(\x03H\x00R\x0fexpectedVersion\x88\x01\x01B\x13\n" +
+	"\x11_expected_version\"\x90\x01\n" +
+	"\x14MultiTransferRequest\x12)\n" +
+	"\x04legs\x18\x01 \x03(\v2\x15.coins.v1.TransferLegR\x04legs\x12\x17\n" +
+	"\auser_id\x18\x02 \x01(\tR\x06userId\x12\x17\n" +
+	"\adata_id\x18\x03 \x01(\tR\x06dataId\x12\x1b\n" +
+	"\tcoin_type\x18\x04 \x01(\tR\bcoinType\"H\n" +
+	"\x12MultiTransferReply\x122\n" +
+	"\baccounts\x18\x01 \x03(\v2\x16.coins.v1.AccountReplyR\baccounts\"\xce\x01\n" +
+	"\x0eReverseRequest\x12\x17\n" +
+	"\adata_id\x18\x01 \x01(\tR\x06dataId\x12\x1b\n" +
+	"\x06amount\x18\x02 \x01(\x03H\x00R\x06amount\x88\x01\x01\x12\x17\n" +
+	"\auser_id\x18\x03 \x01(\tR\x06userId\x12\x1d\n" +
+	"\n" +
+	"account_id\x18\x04 \x01(\tR\taccountId\x12.\n" +
+	"\x10expected_version\x18\x05 \x01(\x03H\x01R\x0fexpectedVersion\x88\x01\x01B\t\n" +
+	"\a_amountB\x13\n" +
+	"\x11_expected_version\"\xa0\x02\n" +
+	"\fReverseReply\x12,\n" +
+	"\x12original_ledger_id\x18\x01 \x01(\x03R\x10originalLedgerId\x12\x16\n" +
+	"\x06amount\x18\x02 \x01(\x03R\x06amount\x12%\n" +
+	"\x0etotal_reversed\x18\x03 \x01(\x03R\rtotalReversed\x12\x1c\n" +
+	"\tremaining\x18\x04 \x01(\x03R\tremaining\x12\x17\n" +
+	"\adata_id\x18\x05 \x01(\tR\x06dataId\x120\n" +
+	"\aaccount\x18\x06 \x01(\v2\x16.coins.v1.AccountReplyR\aaccount\x12:\n" +
+	"\fcounterparty\x18\a \x01(\v2\x16.coins.v1.AccountReplyR\fcounterparty2\xc5\x03\n" +
 	"\fCoinsService\x12@\n" +
 	"\rCreateAccount\x12\x17.coins.v1.CreateRequest\x1a\x16.coins.v1.AccountReply\x12;\n" +
-	"\aDeplete\x12\x18.coins.v1.DepleteRequest\x1a\x16.coins.v1.AccountReplyB=Z;github.com/devifyX/go-back-coin-service/api/coinsv1;coinsv1b\x06proto3"
+	"\aDeplete\x12\x18.coins.v1.DepleteRequest\x1a\x16.coins.v1.AccountReply\x12<\n" +
+	"\tAuthorize\x12\x1a.coins.v1.AuthorizeRequest\x1a\x13.coins.v1.HoldReply\x128\n" +
+	"\aCapture\x12\x18.coins.v1.CaptureRequest\x1a\x13.coins.v1.HoldReply\x122\n" +
+	"\x04Void\x12\x15.coins.v1.VoidRequest\x1a\x13.coins.v1.HoldReply\x12M\n" +
+	"\rMultiTransfer\x12\x1e.coins.v1.MultiTransferRequest\x1a\x1c.coins.v1.MultiTransferReply\x12;\n" +
+	"\aReverse\x12\x18.coins.v1.ReverseRequest\x1a\x16.coins.v1.ReverseReplyB=Z;github.com/devifyX/go-back-coin-service/api/coinsv1;coinsv1b\x06proto3"
 
 var (
 	file_api_coinsv1_coins_proto_rawDescOnce sync.Once
@@ -243,22 +1053,46 @@ func file_api_coinsv1_coins_proto_rawDescGZIP() []byte {
 	return file_api_coinsv1_coins_proto_rawDescData
 }
 
-var file_api_coinsv1_coins_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_api_coinsv1_coins_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
 var file_api_coinsv1_coins_proto_goTypes = []any{
-	(*CreateRequest)(nil),  // 0: coins.v1.CreateRequest
-	(*DepleteRequest)(nil), // 1: coins.v1.DepleteRequest
-	(*AccountReply)(nil),   // 2: coins.v1.AccountReply
+	(*CreateRequest)(nil),        // 0: coins.v1.CreateRequest
+	(*DepleteRequest)(nil),       // 1: coins.v1.DepleteRequest
+	(*AccountReply)(nil),         // 2: coins.v1.AccountReply
+	(*AuthorizeRequest)(nil),     // 3: coins.v1.AuthorizeRequest
+	(*CaptureRequest)(nil),       // 4: coins.v1.CaptureRequest
+	(*VoidRequest)(nil),          // 5: coins.v1.VoidRequest
+	(*HoldReply)(nil),            // 6: coins.v1.HoldReply
+	(*TransferLeg)(nil),          // 7: coins.v1.TransferLeg
+	(*MultiTransferRequest)(nil), // 8: coins.v1.MultiTransferRequest
+	(*MultiTransferReply)(nil),   // 9: coins.v1.MultiTransferReply
+	(*ReverseRequest)(nil),       // 10: coins.v1.ReverseRequest
+	(*ReverseReply)(nil),         // 11: coins.v1.ReverseReply
 }
 var file_api_coinsv1_coins_proto_depIdxs = []int32{
-	0, // 0: coins.v1.CoinsService.CreateAccount:input_type -> coins.v1.CreateRequest
-	1, // 1: coins.v1.CoinsService.Deplete:input_type -> coins.v1.DepleteRequest
-	2, // 2: coins.v1.CoinsService.CreateAccount:output_type -> coins.v1.AccountReply
-	2, // 3: coins.v1.CoinsService.Deplete:output_type -> coins.v1.AccountReply
-	2, // [2:4] is the sub-list for method output_type
-	0, // [0:2] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	2,  // 0: coins.v1.HoldReply.account:type_name -> coins.v1.AccountReply
+	7,  // 1: coins.v1.MultiTransferRequest.legs:type_name -> coins.v1.TransferLeg
+	2,  // 2: coins.v1.MultiTransferReply.accounts:type_name -> coins.v1.AccountReply
+	2,  // 3: coins.v1.ReverseReply.account:type_name -> coins.v1.AccountReply
+	2,  // 4: coins.v1.ReverseReply.counterparty:type_name -> coins.v1.AccountReply
+	0,  // 5: coins.v1.CoinsService.CreateAccount:input_type -> coins.v1.CreateRequest
+	1,  // 6: coins.v1.CoinsService.Deplete:input_type -> coins.v1.DepleteRequest
+	3,  // 7: coins.v1.CoinsService.Authorize:input_type -> coins.v1.AuthorizeRequest
+	4,  // 8: coins.v1.CoinsService.Capture:input_type -> coins.v1.CaptureRequest
+	5,  // 9: coins.v1.CoinsService.Void:input_type -> coins.v1.VoidRequest
+	8,  // 10: coins.v1.CoinsService.MultiTransfer:input_type -> coins.v1.MultiTransferRequest
+	10, // 11: coins.v1.CoinsService.Reverse:input_type -> coins.v1.ReverseRequest
+	2,  // 12: coins.v1.CoinsService.CreateAccount:output_type -> coins.v1.AccountReply
+	2,  // 13: coins.v1.CoinsService.Deplete:output_type -> coins.v1.AccountReply
+	6,  // 14: coins.v1.CoinsService.Authorize:output_type -> coins.v1.HoldReply
+	6,  // 15: coins.v1.CoinsService.Capture:output_type -> coins.v1.HoldReply
+	6,  // 16: coins.v1.CoinsService.Void:output_type -> coins.v1.HoldReply
+	9,  // 17: coins.v1.CoinsService.MultiTransfer:output_type -> coins.v1.MultiTransferReply
+	11, // 18: coins.v1.CoinsService.Reverse:output_type -> coins.v1.ReverseReply
+	12, // [12:19] is the sub-list for method output_type
+	5,  // [5:12] is the sub-list for method input_type
+	5,  // [5:5] is the sub-list for extension type_name
+	5,  // [5:5] is the sub-list for extension extendee
+	0,  // [0:5] is the sub-list for field type_name
 }
 
 func init() { file_api_coinsv1_coins_proto_init() }
@@ -266,13 +1100,19 @@ func file_api_coinsv1_coins_proto_init() {
 	if File_api_coinsv1_coins_proto != nil {
 		return
 	}
+	file_api_coinsv1_coins_proto_msgTypes[1].OneofWrappers = []any{}
+	file_api_coinsv1_coins_proto_msgTypes[3].OneofWrappers = []any{}
+	file_api_coinsv1_coins_proto_msgTypes[4].OneofWrappers = []any{}
+	file_api_coinsv1_coins_proto_msgTypes[5].OneofWrappers = []any{}
+	file_api_coinsv1_coins_proto_msgTypes[7].OneofWrappers = []any{}
+	file_api_coinsv1_coins_proto_msgTypes[10].OneofWrappers = []any{}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_api_coinsv1_coins_proto_rawDesc), len(file_api_coinsv1_coins_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   3,
+			NumMessages:   12,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -21,6 +21,11 @@ const _ = grpc.SupportPackageIsVersion9
 const (
 	CoinsService_CreateAccount_FullMethodName = "/coins.v1.CoinsService/CreateAccount"
 	CoinsService_Deplete_FullMethodName       = "/coins.v1.CoinsService/Deplete"
+	CoinsService_Authorize_FullMethodName     = "/coins.v1.CoinsService/Authorize"
+	CoinsService_Capture_FullMethodName       = "/coins.v1.CoinsService/Capture"
+	CoinsService_Void_FullMethodName          = "/coins.v1.CoinsService/Void"
+	CoinsService_MultiTransfer_FullMethodName = "/coins.v1.CoinsService/MultiTransfer"
+	CoinsService_Reverse_FullMethodName       = "/coins.v1.CoinsService/Reverse"
 )
 
 // CoinsServiceClient is the client API for CoinsService service.
@@ -33,6 +38,16 @@ type CoinsServiceClient interface {
 	CreateAccount(ctx context.Context, in *CreateRequest, opts ...grpc.CallOption) (*AccountReply, error)
 	// Deplete an amount of coins from an account (requires user_id UUID).
 	Deplete(ctx context.Context, in *DepleteRequest, opts ...grpc.CallOption) (*AccountReply, error)
+	// Authorize places a hold that lowers the available balance until captured, voided or expired.
+	Authorize(ctx context.Context, in *AuthorizeRequest, opts ...grpc.CallOption) (*HoldReply, error)
+	// Capture debits a hold, fully or partially (requires user_id UUID).
+	Capture(ctx context.Context, in *CaptureRequest, opts ...grpc.CallOption) (*HoldReply, error)
+	// Void releases a hold without debiting the account.
+	Void(ctx context.Context, in *VoidRequest, opts ...grpc.CallOption) (*HoldReply, error)
+	// MultiTransfer applies balanced debit/credit legs atomically (requires user_id UUID).
+	MultiTransfer(ctx context.Context, in *MultiTransferRequest, opts ...grpc.CallOption) (*MultiTransferReply, error)
+	// Reverse gives back all or part of a use, capture or transfer, found by its data_id.
+	Reverse(ctx context.Context, in *ReverseRequest, opts ...grpc.CallOption) (*ReverseReply, error)
 }
 
 type coinsServiceClient struct {
@@ -63,6 +78,56 @@ func (c *coinsServiceClient) Deplete(ctx context.Context, in *DepleteRequest, op
 	return out, nil
 }
 
+func (c *coinsServiceClient) Authorize(ctx context.Context, in *AuthorizeRequest, opts ...grpc.CallOption) (*HoldReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(HoldReply)
+	err := c.cc.Invoke(ctx, CoinsService_Authorize_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coinsServiceClient) Capture(ctx context.Context, in *CaptureRequest, opts ...grpc.CallOption) (*HoldReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(HoldReply)
+	err := c.cc.Invoke(ctx, CoinsService_Capture_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coinsServiceClient) Void(ctx context.Context, in *VoidRequest, opts ...grpc.CallOption) (*HoldReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(HoldReply)
+	err := c.cc.Invoke(ctx, CoinsService_Void_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coinsServiceClient) MultiTransfer(ctx context.Context, in *MultiTransferRequest, opts ...grpc.CallOption) (*MultiTransferReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MultiTransferReply)
+	err := c.cc.Invoke(ctx, CoinsService_MultiTransfer_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coinsServiceClient) Reverse(ctx context.Context, in *ReverseRequest, opts ...grpc.CallOption) (*ReverseReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ReverseReply)
+	err := c.cc.Invoke(ctx, CoinsService_Reverse_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // CoinsServiceServer is the server API for CoinsService service.
 // All implementations must embed UnimplementedCoinsServiceServer
 // for forward compatibility.
@@ -73,6 +138,16 @@ type CoinsServiceServer interface {
 	CreateAccount(context.Context, *CreateRequest) (*AccountReply, error)
 	// Deplete an amount of coins from an account (requires user_id UUID).
 	Deplete(context.Context, *DepleteRequest) (*AccountReply, error)
+	// Authorize places a hold that lowers the available balance until captured, voided or expired.
+	Authorize(context.Context, *AuthorizeRequest) (*HoldReply, error)
+	// Capture debits a hold, fully or partially (requires user_id UUID).
+	Capture(context.Context, *CaptureRequest) (*HoldReply, error)
+	// Void releases a hold without debiting the account.
+	Void(context.Context, *VoidRequest) (*HoldReply, error)
+	// MultiTransfer applies balanced debit/credit legs atomically (requires user_id UUID).
+	MultiTransfer(context.Context, *MultiTransferRequest) (*MultiTransferReply, error)
+	// Reverse gives back all or part of a use, capture or transfer, found by its data_id.
+	Reverse(context.Context, *ReverseRequest) (*ReverseReply, error)
 	mustEmbedUnimplementedCoinsServiceServer()
 }
 
@@ -89,6 +164,21 @@ func (UnimplementedCoinsServiceServer) CreateAccount(context.Context, *CreateReq
 func (UnimplementedCoinsServiceServer) Deplete(context.Context, *DepleteRequest) (*AccountReply, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Deplete not implemented")
 }
+func (UnimplementedCoinsServiceServer) Authorize(context.Context, *AuthorizeRequest) (*HoldReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Authorize not implemented")
+}
+func (UnimplementedCoinsServiceServer) Capture(context.Context, *CaptureRequest) (*HoldReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Capture not implemented")
+}
+func (UnimplementedCoinsServiceServer) Void(context.Context, *VoidRequest) (*HoldReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Void not implemented")
+}
+func (UnimplementedCoinsServiceServer) MultiTransfer(context.Context, *MultiTransferRequest) (*MultiTransferReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MultiTransfer not implemented")
+}
+func (UnimplementedCoinsServiceServer) Reverse(context.Context, *ReverseRequest) (*ReverseReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Reverse not implemented")
+}
 func (UnimplementedCoinsServiceServer) mustEmbedUnimplementedCoinsServiceServer() {}
 func (UnimplementedCoinsServiceServer) testEmbeddedByValue()                      {}
 
@@ -146,6 +236,96 @@ func _CoinsService_Deplete_Handler(srv interface{}, ctx context.Context, dec fun
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CoinsService_Authorize_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AuthorizeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoinsServiceServer).Authorize(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoinsService_Authorize_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoinsServiceServer).Authorize(ctx, req.(*AuthorizeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoinsService_Capture_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CaptureRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoinsServiceServer).Capture(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoinsService_Capture_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoinsServiceServer).Capture(ctx, req.(*CaptureRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoinsService_Void_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VoidRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoinsServiceServer).Void(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoinsService_Void_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoinsServiceServer).Void(ctx, req.(*VoidRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoinsService_MultiTransfer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MultiTransferRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoinsServiceServer).MultiTransfer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoinsService_MultiTransfer_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoinsServiceServer).MultiTransfer(ctx, req.(*MultiTransferRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoinsService_Reverse_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReverseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoinsServiceServer).Reverse(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoinsService_Reverse_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoinsServiceServer).Reverse(ctx, req.(*ReverseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // CoinsService_ServiceDesc is the grpc.ServiceDesc for CoinsService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -161,6 +341,26 @@ var CoinsService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Deplete",
 			Handler:    _CoinsService_Deplete_Handler,
 		},
+		{
+			MethodName: "Authorize",
+			Handler:    _CoinsService_Authorize_Handler,
+		},
+		{
+			MethodName: "Capture",
+			Handler:    _CoinsService_Capture_Handler,
+		},
+		{
+			MethodName: "Void",
+			Handler:    _CoinsService_Void_Handler,
+		},
+		{
+			MethodName: "MultiTransfer",
+			Handler:    _CoinsService_MultiTransfer_Handler,
+		},
+		{
+			MethodName: "Reverse",
+			Handler:    _CoinsService_Reverse_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "api/coinsv1/coins.proto",
@@ -3,6 +3,7 @@ module github.com/devifyX/go-back-coin-service
 go 1.25.0
 
 require (
+	github.com/devifyX/go-back-transaction-service v0.0.0-20250909140849-1648931039bf
 	github.com/google/uuid v1.6.0
 	github.com/graphql-go/graphql v0.8.1
 	github.com/graphql-go/handler v0.2.4
@@ -14,7 +15,6 @@ require (
 )
 
 require (
-	github.com/devifyX/go-back-transaction-service v0.0.0-20250909140849-1648931039bf // indirect
 	github.com/jackc/pgpassfile v1.0.0 // indirect
 	github.com/jackc/pgservicefile v0.0.0-20240606120523-5a60cdf6a761 // indirect
 	github.com/jackc/puddle/v2 v2.2.2 // indirect
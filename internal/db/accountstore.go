@@ -0,0 +1,115 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// --------------------------------------------
+// Storage interface
+// --------------------------------------------
+
+// AccountStore is the account API shared by the Postgres Store and the in-memory
+// MemoryStore. Schema migrations are Postgres-only and stay on *Store.
+type AccountStore interface {
+	// Balances
+	GetAccount(ctx context.Context, id, coinType string) (*Account, error)
+	GetAccounts(ctx context.Context, ids []string, coinType string) ([]*Account, error)
+	ListBalances(ctx context.Context, id string) ([]*Account, error)
+	ListAccounts(ctx context.Context, page PageArgs, f AccountFilter) (*AccountPage, error)
+	ListAccountsByCoinsRange(ctx context.Context, min, max *int64, page PageArgs) (*AccountPage, error)
+	ListRecentRecharges(ctx context.Context, since time.Time, page PageArgs) (*AccountPage, error)
+	ListInactiveSince(ctx context.Context, before time.Time, page PageArgs) (*AccountPage, error)
+	ListOverdrawnAccounts(ctx context.Context, coinType string) ([]*Account, error)
+	CountAccounts(ctx context.Context) (int64, error)
+	SumCoins(ctx context.Context, coinType string) (int64, error)
+	UserExists(ctx context.Context, id string) (bool, error)
+
+	// Account changes
+	CreateAccount(ctx context.Context, id, coinType string, coins *int64, metadata map[string]any, labels map[string]string) (*Account, error)
+	DeleteAccount(ctx context.Context, id string, expectedVersion *int64) (bool, error)
+	UpdateAccountMetadata(ctx context.Context, id string, metadata map[string]any, labels map[string]string, merge bool, expectedVersion *int64) ([]*Account, error)
+	SetCreditLimit(ctx context.Context, id, coinType string, limit int64, expectedVersion *int64) (*Account, error)
+	TouchUsage(ctx context.Context, id, coinType string, expectedVersion *int64) (*Account, error)
+	FreezeAccount(ctx context.Context, id string, expectedVersion *int64) ([]*Account, error)
+	UnfreezeAccount(ctx context.Context, id string, expectedVersion *int64) ([]*Account, error)
+	CloseAccount(ctx context.Context, id, sweepTo string, expectedVersion *int64, userID, dataID string) ([]*Account, error)
+
+	// Balance mutations
+	SetCoinsExact(ctx context.Context, coinID, coinType string, coins int64, expectedVersion *int64, userID, dataID string) (*Account, error)
+	Recharge(ctx context.Context, coinID, coinType string, amount int64, expectedVersion *int64, userID, dataID string) (*Account, error)
+	RechargeWithExpiry(ctx context.Context, coinID, coinType string, amount int64, expiresAt *time.Time, expectedVersion *int64, userID, dataID string) (*Account, error)
+	BatchRecharge(ctx context.Context, items []BatchRechargeItem, coinType, userID, baseDataID string) ([]*BatchRechargeResult, error)
+	Use(ctx context.Context, coinID, coinType string, amount int64, expectedVersion *int64, userID, dataID string) (*Account, error)
+	Transfer(ctx context.Context, fromID, toID, coinType string, amount int64, expectedVersion *int64, userID, dataID string) (*Account, *Account, error)
+	MultiTransfer(ctx context.Context, coinType string, legs []TransferLeg, userID, dataID string) ([]*Account, error)
+	ReverseTransaction(ctx context.Context, dataID string, amount *int64, accountID string, expectedVersion *int64, userID string) (*Reversal, error)
+
+	// Holds
+	GetHold(ctx context.Context, id string) (*Hold, error)
+	Authorize(ctx context.Context, coinID, coinType string, amount int64, ttl time.Duration, expectedVersion *int64, userID, dataID string) (*Hold, error)
+	Capture(ctx context.Context, holdID string, amount, expectedVersion *int64, userID, dataID string) (*Hold, *Account, error)
+	Void(ctx context.Context, holdID string, expectedVersion *int64) (*Hold, error)
+	ExpireHolds(ctx context.Context) (int64, error)
+	RunHoldSweeper(ctx context.Context, interval time.Duration)
+
+	// Ledger, lots and history
+	ListLedger(ctx context.Context, accountID, coinType string, first int, after int64) ([]*LedgerEntry, error)
+	ListUpcomingExpirations(ctx context.Context, accountID, coinType string, before *time.Time) ([]*CoinLot, error)
+	ExpireLots(ctx context.Context) (int64, error)
+	RunLotExpirer(ctx context.Context, interval time.Duration)
+	GetAccountAsOf(ctx context.Context, id, coinType string, at time.Time) (*Account, error)
+	SumCoinsAsOf(ctx context.Context, coinType string, at time.Time) (int64, error)
+	SnapshotBalances(ctx context.Context) (int64, error)
+	RunBalanceSnapshotter(ctx context.Context, interval time.Duration)
+
+	// Spend limits
+	ListSpendLimits(ctx context.Context, accountID, coinType string) ([]*SpendLimit, error)
+	SetSpendLimit(ctx context.Context, accountID, coinType string, window time.Duration, maxAmount, maxCount *int64) ([]*SpendLimit, error)
+	ClearSpendLimit(ctx context.Context, accountID, coinType string, window time.Duration) ([]*SpendLimit, error)
+
+	// Shared wallets
+	SetParent(ctx context.Context, id, parentID string, expectedVersion *int64) ([]*Account, error)
+	SetPoolAllowance(ctx context.Context, id, coinType string, allowance, expectedVersion *int64) (*Account, error)
+	ListMembers(ctx context.Context, parentID, coinType string) ([]*Account, error)
+	GetHierarchyTotals(ctx context.Context, rootID, coinType string) (*HierarchyTotals, error)
+
+	// Recharge schedules
+	CreateRechargeSchedule(ctx context.Context, accountID, coinType string, amount int64, cron string, interval time.Duration, startAt *time.Time, expectedVersion *int64, userID string) (*RechargeSchedule, error)
+	UpdateRechargeSchedule(ctx context.Context, id string, amount *int64, cron *string, interval *time.Duration, enabled *bool, expectedVersion *int64) (*RechargeSchedule, error)
+	DeleteRechargeSchedule(ctx context.Context, id string, expectedVersion *int64) (bool, error)
+	GetRechargeSchedule(ctx context.Context, id string) (*RechargeSchedule, error)
+	ListRechargeSchedules(ctx context.Context, accountID string) ([]*RechargeSchedule, error)
+	FireDueSchedules(ctx context.Context, batch int) (int, error)
+	RunRechargeScheduler(ctx context.Context, interval time.Duration)
+
+	// Notification outbox
+	DispatchOutbox(ctx context.Context, cfg OutboxConfig) (int, error)
+	RunOutboxDispatcher(ctx context.Context, cfg OutboxConfig)
+	ListOutbox(ctx context.Context, status string, first int, after int64) ([]*OutboxEntry, error)
+	ReplayOutbox(ctx context.Context, id int64) (*OutboxEntry, error)
+
+	// Admin audit trail
+	RunAudited(ctx context.Context, e *AuditEntry, state func(context.Context) (any, error), fn func(context.Context) (any, error)) (any, error)
+	StartAudit(ctx context.Context, e *AuditEntry) (*AuditEntry, error)
+	FinishAudit(ctx context.Context, id int64, after json.RawMessage, opErr error) error
+	ListAuditLog(ctx context.Context, f AuditFilter, page PageArgs) (*AuditPage, error)
+	ExportAuditLog(ctx context.Context, f AuditFilter, w io.Writer) (int64, error)
+
+	// Personal data
+	ExportAccount(ctx context.Context, id string) (*AccountExport, error)
+	EraseAccount(ctx context.Context, id string) (*Erasure, error)
+
+	// Dormant account archival
+	ArchiveDormant(ctx context.Context, cfg ArchiveConfig) (*ArchiveReport, error)
+	RunArchiver(ctx context.Context, cfg ArchiveConfig, interval time.Duration)
+
+	Close()
+}
+
+var (
+	_ AccountStore = (*Store)(nil)
+	_ AccountStore = (*MemoryStore)(nil)
+)
@@ -0,0 +1,229 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// --------------------------------------------
+// Dormant account archival
+// --------------------------------------------
+
+// ArchiveConfig selects the accounts ArchiveDormant moves out of public.coins.
+type ArchiveConfig struct {
+	InactiveFor time.Duration // required: no balance opened, used, recharged or changed for this long
+	MaxCoins    int64         // archive balances of 0..MaxCoins coins; 0 archives empty accounts only
+	Batch       int           // accounts per run; 0 means 500
+	DryRun      bool          // report the accounts that would be archived without moving them
+}
+
+// ArchiveReport describes one ArchiveDormant run.
+type ArchiveReport struct {
+	DryRun   bool       `json:"dryRun"`
+	Cutoff   time.Time  `json:"cutoff"`   // accounts active since then were kept
+	Accounts int        `json:"accounts"` // accounts archived, or that would be on a dry run
+	Balances []*Account `json:"balances"` // their balances as they were archived, every coin type
+	Coins    int64      `json:"coins"`    // sum of Balances' coins, any coin type
+}
+
+func (c ArchiveConfig) validate() (ArchiveConfig, error) {
+	if c.InactiveFor <= 0 {
+		return c, errors.New("archive: inactiveFor must be > 0")
+	}
+	if c.MaxCoins < 0 {
+		return c, errors.New("archive: maxCoins must be >= 0")
+	}
+	if c.Batch <= 0 {
+		c.Batch = 500
+	}
+	return c, nil
+}
+
+// dormantAccounts selects the ids of accounts that may be archived, given the cutoff ($1),
+// the largest balance ($2), one id to recheck or NULL for all ($3) and a limit ($4). Every
+// balance of the account must be unsharded, hold 0..$2 coins, be no shared wallet member and
+// show no activity since the cutoff; shared wallets and accounts with active holds, enabled
+// recharge schedules or unspent expiring lots are kept (the lot expirer would restore the
+// latter); lots that never expire are just part of the balance and go with it.
+const dormantAccounts = `
+	SELECT c.id FROM public.coins c
+	WHERE ($3::text IS NULL OR c.id = $3::text)
+	GROUP BY c.id
+	HAVING bool_and(c.shards = 0 AND c.coins BETWEEN 0 AND $2 AND c.parent_id IS NULL
+	                AND COALESCE(GREATEST(c.created_at, c.last_usage_date, c.last_recharge_date), '-infinity') < $1)
+	   AND NOT EXISTS (SELECT 1 FROM public.coin_ledger l WHERE l.account_id = c.id AND l.created_at >= $1)
+	   AND NOT EXISTS (SELECT 1 FROM public.coins m WHERE m.parent_id = c.id)
+	   AND NOT EXISTS (SELECT 1 FROM public.coin_holds h WHERE h.account_id = c.id AND h.status = 'active')
+	   AND NOT EXISTS (SELECT 1 FROM public.coin_recharge_schedules r WHERE r.account_id = c.id AND r.enabled)
+	   AND NOT EXISTS (SELECT 1 FROM public.coin_lots o WHERE o.account_id = c.id AND o.remaining > 0
+	                                                          AND o.expires_at IS NOT NULL)
+	ORDER BY c.id
+	LIMIT $4`
+
+// restoreArchived moves the archived balances of the ids in $1 back into public.coins as
+// they were archived. Columns a row was archived without, added by a later migration, take
+// their defaults; a new public.coins column with a default needs its COALESCE here.
+const restoreArchived = `
+	WITH back AS (DELETE FROM public.archived_coins WHERE id = ANY($1) RETURNING id, coin_type, coins, row)
+	INSERT INTO public.coins (id, coin_type, coins, version, status, credit_limit, last_recharge_date, last_usage_date,
+	                          created_at, metadata, labels, parent_id, pool_allowance, shards)
+	SELECT back.id, back.coin_type, back.coins, COALESCE(r.version, 1), COALESCE(r.status, 'active'),
+	       COALESCE(r.credit_limit, 0), r.last_recharge_date, r.last_usage_date, r.created_at,
+	       COALESCE(r.metadata, '{}'::jsonb), COALESCE(r.labels, '{}'::jsonb), r.parent_id, r.pool_allowance,
+	       COALESCE(r.shards, 0)
+	FROM back, jsonb_populate_record(NULL::public.coins, back.row) r`
+
+// unarchive restores the archived balances of ids within tx and reports whether there were any.
+func unarchive(ctx context.Context, tx pgx.Tx, ids ...string) (bool, error) {
+	tag, err := tx.Exec(ctx, restoreArchived, ids)
+	if err != nil {
+		return false, fmt.Errorf("unarchive: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// restore restores the archived balances of ids outside any transaction but an audited
+// call's (see conn) and reports whether there were any.
+func (s *Store) restore(ctx context.Context, ids ...string) (bool, error) {
+	tag, err := s.conn(ctx).Exec(ctx, restoreArchived, ids)
+	if err != nil {
+		s.logger().Error("restore: failed", slog.Any("ids", ids), slog.String("error", err.Error()))
+		return false, err
+	}
+	if n := tag.RowsAffected(); n > 0 {
+		s.logger().Info("restore: unarchived", slog.Any("ids", ids), slog.Int64("balances", n))
+		return true, nil
+	}
+	return false, nil
+}
+
+// restoreOnRead restores the archived balances of id for a read that missed them, and
+// reports whether there were any. It checks public.archived_coins through the reader first,
+// so a lookup of an id that was never archived stays a read and off the primary.
+func (s *Store) restoreOnRead(ctx context.Context, id string) (bool, error) {
+	var archived bool
+	if err := s.reader(ctx).QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM public.archived_coins WHERE id=$1)`, id).Scan(&archived); err != nil {
+		s.logger().Error("restore: lookup failed", slog.String("id", id), slog.String("error", err.Error()))
+		return false, err
+	}
+	if !archived {
+		return false, nil
+	}
+	return s.restore(ctx, id)
+}
+
+// ArchiveDormant moves accounts that have been inactive for cfg.InactiveFor and hold at
+// most cfg.MaxCoins of every coin type into public.archived_coins, every coin type at once,
+// up to cfg.Batch accounts per call. Archived accounts drop out of listings; GetAccount,
+// Use, Recharge and the other mutations restore them on first touch, and totals still count
+// them. On a dry run nothing is moved and the report lists what would be.
+func (s *Store) ArchiveDormant(ctx context.Context, cfg ArchiveConfig) (*ArchiveReport, error) {
+	log := s.logger()
+	start := time.Now()
+	cfg, err := cfg.validate()
+	if err != nil {
+		return nil, err
+	}
+	out := &ArchiveReport{DryRun: cfg.DryRun, Cutoff: time.Now().UTC().Add(-cfg.InactiveFor), Balances: []*Account{}}
+	log.Info("ArchiveDormant: start", slog.Time("cutoff", out.Cutoff), slog.Int64("maxCoins", cfg.MaxCoins), slog.Bool("dryRun", cfg.DryRun))
+
+	rows, err := s.Pool.Query(ctx, dormantAccounts, out.Cutoff, cfg.MaxCoins, nil, cfg.Batch)
+	if err != nil {
+		log.Error("ArchiveDormant: query failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		log.Error("ArchiveDormant: scan failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	if cfg.DryRun {
+		out.Balances, err = collectRows(ctx, s.Pool, scanAccount, `
+			SELECT `+accountColumns+` FROM public.coins c WHERE c.id = ANY($1) ORDER BY c.id, c.coin_type
+		`, ids)
+		if err != nil {
+			log.Error("ArchiveDormant: report failed", slog.String("error", err.Error()))
+			return nil, err
+		}
+		out.Accounts = len(ids)
+	} else {
+		for _, id := range ids {
+			balances, err := s.archiveAccount(ctx, id, out.Cutoff, cfg.MaxCoins)
+			if err != nil {
+				log.Error("ArchiveDormant: account failed", slog.String("id", id), slog.String("error", err.Error()))
+				continue
+			}
+			if len(balances) > 0 {
+				out.Accounts++
+				out.Balances = append(out.Balances, balances...)
+			}
+		}
+	}
+	for _, b := range out.Balances {
+		out.Coins += b.Coins
+	}
+	log.Info("ArchiveDormant: ok", slog.Bool("dryRun", cfg.DryRun), slog.Int("accounts", out.Accounts), slog.Int64("coins", out.Coins), slog.Duration("dur", time.Since(start)))
+	return out, nil
+}
+
+// archiveAccount archives every balance of id if it is still dormant once locked, and
+// returns them; nothing if it was touched since it was selected.
+func (s *Store) archiveAccount(ctx context.Context, id string, cutoff time.Time, maxCoins int64) ([]*Account, error) {
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM public.coins WHERE id=$1 FOR UPDATE`, id); err != nil {
+		return nil, err
+	}
+	var dormant string
+	if err := tx.QueryRow(ctx, dormantAccounts, cutoff, maxCoins, id, 1).Scan(&dormant); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	balances, err := s.listBalances(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `
+		WITH moved AS (DELETE FROM public.coins c WHERE c.id=$1 RETURNING c.*)
+		INSERT INTO public.archived_coins (id, coin_type, coins, row)
+		SELECT id, coin_type, coins, to_jsonb(moved) FROM moved
+	`, id); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return balances, nil
+}
+
+// RunArchiver calls ArchiveDormant with cfg every interval until ctx is cancelled.
+func (s *Store) RunArchiver(ctx context.Context, cfg ArchiveConfig, interval time.Duration) {
+	log := s.logger()
+	if interval <= 0 {
+		interval = 24 * time.Hour
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("archiver: stopped")
+			return
+		case <-t.C:
+			_, _ = s.ArchiveDormant(ctx, cfg)
+		}
+	}
+}
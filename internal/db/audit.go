@@ -0,0 +1,288 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// --------------------------------------------
+// Admin audit trail
+// --------------------------------------------
+
+// auditListing names the audit log in its page cursors.
+const auditListing = "ListAuditLog"
+
+// AuditFilter narrows the audit log; zero fields match everything.
+type AuditFilter struct {
+	Operation string
+	Caller    string
+	Outcome   string
+	Since     *time.Time // recorded at or after
+	Until     *time.Time // recorded before
+}
+
+// AuditPage is one page of the audit log, newest first. Cursors[i] resumes the log after Entries[i].
+type AuditPage struct {
+	Entries         []*AuditEntry
+	Cursors         []string
+	HasNextPage     bool
+	HasPreviousPage bool
+}
+
+const auditColumns = `id, operation, caller, caller_verified, source_ip, args, before, after, outcome, error, created_at, finished_at`
+
+// auditWhere filters public.admin_audit by f using $1..$5.
+const auditWhere = `($1 = '' OR operation = $1) AND ($2 = '' OR caller = $2) AND ($3 = '' OR outcome = $3)
+		  AND ($4::timestamptz IS NULL OR created_at >= $4::timestamptz)
+		  AND ($5::timestamptz IS NULL OR created_at < $5::timestamptz)`
+
+func (f AuditFilter) args() []any {
+	return []any{f.Operation, f.Caller, f.Outcome, f.Since, f.Until}
+}
+
+func scanAudit(row pgx.Row) (*AuditEntry, error) {
+	var e AuditEntry
+	if err := row.Scan(&e.ID, &e.Operation, &e.Caller, &e.Verified, &e.SourceIP, &e.Args, &e.Before, &e.After, &e.Outcome, &e.Error, &e.CreatedAt, &e.FinishedAt); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// rawArg passes a JSON document as a jsonb parameter; nil is NULL.
+func rawArg(v json.RawMessage) *string {
+	if v == nil {
+		return nil
+	}
+	s := string(v)
+	return &s
+}
+
+// auditCursor decodes an audit log cursor into the id it resumes after.
+func auditCursor(after string) (int64, error) {
+	c, err := decodeCursor(auditListing, after)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseInt(c.ID, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	return id, nil
+}
+
+func auditCursorOf(e *AuditEntry) string {
+	return pageCursor{K: auditListing, ID: strconv.FormatInt(e.ID, 10)}.encode()
+}
+
+// StartAudit records an admin mutation that is about to run, as pending, and returns the
+// stored entry. Operation, Caller, Verified, SourceIP, Args and Before are taken from e. Callers
+// should not run the mutation if this fails, so that every admin change leaves a trace.
+func (s *Store) StartAudit(ctx context.Context, e *AuditEntry) (*AuditEntry, error) {
+	log := s.logger()
+	args := e.Args
+	if args == nil {
+		args = json.RawMessage(`{}`)
+	}
+	out, err := scanAudit(s.conn(ctx).QueryRow(ctx, `
+		INSERT INTO public.admin_audit (operation, caller, caller_verified, source_ip, args, before)
+		VALUES ($1, $2, $3, $4, $5::jsonb, $6::jsonb)
+		RETURNING `+auditColumns,
+		e.Operation, e.Caller, e.Verified, e.SourceIP, string(args), rawArg(e.Before)))
+	if err != nil {
+		log.Error("StartAudit: insert failed", slog.String("operation", e.Operation), slog.String("error", err.Error()))
+		return nil, err
+	}
+	log.Info("audit", slog.Int64("id", out.ID), slog.String("operation", out.Operation), slog.String("caller", out.Caller), slog.String("sourceIP", out.SourceIP))
+	return out, nil
+}
+
+// FinishAudit completes a pending audit entry with the state after the mutation and its
+// outcome: ok if opErr is nil, else error with opErr's message.
+func (s *Store) FinishAudit(ctx context.Context, id int64, after json.RawMessage, opErr error) error {
+	outcome, msg := AuditOK, ""
+	if opErr != nil {
+		outcome, msg = AuditError, opErr.Error()
+	}
+	if _, err := s.conn(ctx).Exec(ctx, `
+		UPDATE public.admin_audit SET after=$2::jsonb, outcome=$3, error=$4, finished_at=NOW()
+		WHERE id=$1 AND outcome='pending'
+	`, id, rawArg(after), outcome, msg); err != nil {
+		s.logger().Error("FinishAudit: update failed", slog.Int64("id", id), slog.String("error", err.Error()))
+		return err
+	}
+	return nil
+}
+
+// RunAudited runs fn, an admin mutation, with an audit entry. e carries the operation,
+// caller, source IP and arguments. With a state, the entry, the state before (read first),
+// fn and the state after all run in one transaction, so no other change can slip in
+// between them; fn gets a ctx whose mutations run in a savepoint of it, which is rolled
+// back if fn fails. The entry then commits with the error as its outcome. Without a state,
+// the entry is recorded as pending before fn runs and completed after it, as fn may run
+// for long. fn doesn't run if the entry can't be written.
+func (s *Store) RunAudited(ctx context.Context, e *AuditEntry, state func(context.Context) (any, error), fn func(context.Context) (any, error)) (any, error) {
+	if state == nil {
+		entry, err := s.StartAudit(ctx, e)
+		if err != nil {
+			return nil, fmt.Errorf("audit: %w", err)
+		}
+		res, opErr := fn(ctx)
+		// the mutation may have committed even if the request went away, so finish regardless
+		_ = s.FinishAudit(context.WithoutCancel(ctx), entry.ID, nil, opErr) // logged; the entry stays pending
+		return res, opErr
+	}
+
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("audit: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	ctx = context.WithValue(WithPrimary(ctx), txKey{}, tx)
+
+	before, err := auditState(ctx, state)
+	if err != nil {
+		return nil, fmt.Errorf("audit: %w", err)
+	}
+	pending := *e
+	pending.Before = before
+	entry, err := s.StartAudit(ctx, &pending)
+	if err != nil {
+		return nil, fmt.Errorf("audit: %w", err)
+	}
+
+	sp, err := tx.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("audit: %w", err)
+	}
+	res, opErr := fn(context.WithValue(ctx, txKey{}, sp))
+	if opErr == nil {
+		opErr = sp.Commit(ctx)
+	}
+	if opErr != nil {
+		res = nil
+		_ = sp.Rollback(ctx)
+	}
+	after, err := auditState(ctx, state)
+	if err != nil {
+		return nil, fmt.Errorf("audit: %w", err)
+	}
+	if err := s.FinishAudit(ctx, entry.ID, after, opErr); err != nil {
+		return nil, fmt.Errorf("audit: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("audit: %w", err)
+	}
+	return res, opErr
+}
+
+// auditState reads state for an audit entry, as JSON.
+func auditState(ctx context.Context, state func(context.Context) (any, error)) (json.RawMessage, error) {
+	v, err := state(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// ListAuditLog pages through the audit entries that match f, newest first.
+func (s *Store) ListAuditLog(ctx context.Context, f AuditFilter, page PageArgs) (*AuditPage, error) {
+	log := s.logger()
+	start := time.Now()
+	first := page.First
+	switch {
+	case first == 0:
+		first = DefaultPageSize
+	case first < 0 || first > MaxPageSize:
+		return nil, fmt.Errorf("auditLog: first must be between 1 and %d", MaxPageSize)
+	}
+	var afterID *int64
+	if page.After != "" {
+		id, err := auditCursor(page.After)
+		if err != nil {
+			return nil, fmt.Errorf("auditLog: %w", err)
+		}
+		afterID = &id
+	}
+	log.Debug("ListAuditLog: query", slog.Any("filter", f), slog.Int("first", first), slog.Bool("after", afterID != nil))
+	rows, err := s.reader(ctx).Query(ctx, `
+		SELECT `+auditColumns+`
+		FROM public.admin_audit
+		WHERE `+auditWhere+` AND ($6::bigint IS NULL OR id < $6::bigint)
+		ORDER BY id DESC
+		LIMIT $7
+	`, append(f.args(), afterID, first+1)...)
+	if err != nil {
+		log.Error("ListAuditLog: query failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := &AuditPage{Entries: []*AuditEntry{}, Cursors: []string{}, HasPreviousPage: afterID != nil}
+	for rows.Next() {
+		e, err := scanAudit(rows)
+		if err != nil {
+			log.Error("ListAuditLog: scan failed", slog.String("error", err.Error()))
+			return nil, err
+		}
+		out.Entries = append(out.Entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error("ListAuditLog: rows err", slog.String("error", err.Error()))
+		return nil, err
+	}
+	if len(out.Entries) > first {
+		out.Entries = out.Entries[:first]
+		out.HasNextPage = true
+	}
+	for _, e := range out.Entries {
+		out.Cursors = append(out.Cursors, auditCursorOf(e))
+	}
+	log.Debug("ListAuditLog: ok", slog.Int("count", len(out.Entries)), slog.Duration("dur", time.Since(start)))
+	return out, nil
+}
+
+// ExportAuditLog writes the audit entries that match f to w as newline-delimited JSON,
+// oldest first, and returns how many it wrote. Rows are streamed, not buffered.
+func (s *Store) ExportAuditLog(ctx context.Context, f AuditFilter, w io.Writer) (int64, error) {
+	log := s.logger()
+	start := time.Now()
+	rows, err := s.reader(ctx).Query(ctx, `
+		SELECT `+auditColumns+`
+		FROM public.admin_audit
+		WHERE `+auditWhere+`
+		ORDER BY id
+	`, f.args()...)
+	if err != nil {
+		log.Error("ExportAuditLog: query failed", slog.String("error", err.Error()))
+		return 0, err
+	}
+	defer rows.Close()
+
+	enc := json.NewEncoder(w)
+	var n int64
+	for rows.Next() {
+		e, err := scanAudit(rows)
+		if err != nil {
+			log.Error("ExportAuditLog: scan failed", slog.String("error", err.Error()))
+			return n, err
+		}
+		if err := enc.Encode(e); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		log.Error("ExportAuditLog: rows err", slog.String("error", err.Error()))
+		return n, err
+	}
+	log.Info("ExportAuditLog: ok", slog.Int64("entries", n), slog.Duration("dur", time.Since(start)))
+	return n, nil
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// --------------------------------------------
+// Overdraft / credit limits
+// --------------------------------------------
+
+// SetCreditLimit sets how far below zero one coin type of an account may be debited.
+// Lowering it under the current overdraft only blocks further debits.
+func (s *Store) SetCreditLimit(ctx context.Context, id, coinType string, limit int64, expectedVersion *int64) (*Account, error) {
+	log := s.logger()
+	start := time.Now()
+	coinType = coinTypeOrDefault(coinType)
+	log.Info("SetCreditLimit: start",
+		slog.String("id", id),
+		slog.String("coinType", coinType),
+		slog.Int64("limit", limit),
+		slog.Any("expectedVersion", expectedVersion),
+	)
+	if limit < 0 {
+		return nil, errors.New("setCreditLimit: limit must be >= 0")
+	}
+
+	tx, err := s.conn(ctx).Begin(ctx)
+	if err != nil {
+		log.Error("SetCreditLimit: begin tx failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	locked, err := lockAccount(ctx, tx, id, coinType, expectedVersion)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("setCreditLimit: account %s/%s not found", id, coinType)
+		}
+		log.Error("SetCreditLimit: select failed", slog.String("id", id), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if err := checkStatus(id, locked.status, false); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE public.coins SET credit_limit=$3, version = version + 1 WHERE id=$1 AND coin_type=$2
+	`, id, coinType, limit); err != nil {
+		log.Error("SetCreditLimit: update failed", slog.String("id", id), slog.String("error", err.Error()))
+		return nil, err
+	}
+	acc, err := s.getAccount(ctx, tx, id, coinType)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Error("SetCreditLimit: commit failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	log.Info("SetCreditLimit: ok", slog.String("id", id), slog.Int64("limit", limit), slog.Duration("dur", time.Since(start)))
+	return acc, nil
+}
+
+// ListOverdrawnAccounts returns the balances below zero, most overdrawn first.
+// An empty coinType lists every coin type.
+func (s *Store) ListOverdrawnAccounts(ctx context.Context, coinType string) ([]*Account, error) {
+	log := s.logger()
+	start := time.Now()
+	log.Debug("ListOverdrawnAccounts: query", slog.String("coinType", coinType))
+	rows, err := s.reader(ctx).Query(ctx, `
+		SELECT `+accountColumns+`
+		FROM public.coins c
+		WHERE c.coins < 0 AND `+shardedCoins+` < 0 AND ($1 = '' OR c.coin_type = $1)
+		ORDER BY `+shardedCoins+`, c.id, c.coin_type
+	`, coinType)
+	if err != nil {
+		log.Error("ListOverdrawnAccounts: query failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Account
+	for rows.Next() {
+		a, err := scanAccount(rows)
+		if err != nil {
+			log.Error("ListOverdrawnAccounts: scan failed", slog.String("error", err.Error()))
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error("ListOverdrawnAccounts: rows err", slog.String("error", err.Error()))
+		return nil, err
+	}
+	log.Debug("ListOverdrawnAccounts: ok", slog.Int("count", len(out)), slog.Duration("dur", time.Since(start)))
+	return out, nil
+}
+
+// notifyOverdrawn queues an OutboxTopicOverdrawn alert for s.Alerts when a debit takes a
+// balance from zero or above to below zero. Its id is "overdrawn:<id>:<nanos>". The alert
+// isn't a transaction, so it never goes to s.Notifier.
+func (s *Store) notifyOverdrawn(ctx context.Context, tx pgx.Tx, userID, accountID, coinType string, before, after int64) error {
+	if before < 0 || after >= 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	s.logger().Warn("account overdrawn",
+		slog.String("accountID", accountID),
+		slog.String("coinType", coinType),
+		slog.Int64("coins", after),
+	)
+	if s.Alerts == nil {
+		return nil
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO public.tx_outbox (account_id, coin_type, user_id, data_id, coin_used, occurred_at, topic)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, accountID, coinType, userID, fmt.Sprintf("overdrawn:%s:%d", accountID, now.UnixNano()), float64(-after), now, OutboxTopicOverdrawn); err != nil {
+		s.logger().Error("notifyOverdrawn: enqueue failed", slog.String("accountID", accountID), slog.String("error", err.Error()))
+		return err
+	}
+	return nil
+}
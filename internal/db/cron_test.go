@@ -0,0 +1,140 @@
+package db
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // America/New_York for the DST cases, wherever the tests run
+)
+
+func utc(s string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// next finds the first matching minute strictly after the given time.
+func TestCron_Next(t *testing.T) {
+	for _, tc := range []struct {
+		name, expr string
+		from       time.Time
+		want       string // "" for no match within five years
+	}{
+		{"every minute", "* * * * *", utc("2026-01-01 10:00"), "2026-01-01 10:01"},
+		{"strictly after", "0 10 * * *", utc("2026-01-01 10:00"), "2026-01-02 10:00"},
+		{"seconds dropped", "*/15 * * * *", utc("2026-01-01 10:14").Add(59 * time.Second), "2026-01-01 10:15"},
+		{"step", "*/15 * * * *", utc("2026-01-01 10:07"), "2026-01-01 10:15"},
+		{"step over a range", "5-20/5 * * * *", utc("2026-01-01 10:21"), "2026-01-01 11:05"},
+		{"step from a value", "10/20 * * * *", utc("2026-01-01 10:31"), "2026-01-01 10:50"},
+		{"range", "0 9-17 * * *", utc("2026-01-01 17:30"), "2026-01-02 09:00"},
+		{"list", "0 0 1,15 * *", utc("2026-01-02 00:00"), "2026-01-15 00:00"},
+		{"list of ranges", "0 0 1-2,20-21 * *", utc("2026-01-03 00:00"), "2026-01-20 00:00"},
+		{"month names", "0 0 1 mar-apr *", utc("2026-01-05 00:00"), "2026-03-01 00:00"},
+		{"day names", "0 9 * * mon-fri", utc("2026-01-03 12:00"), "2026-01-05 09:00"},
+		{"sunday as 7", "0 0 * * 7", utc("2026-01-01 00:00"), "2026-01-04 00:00"},
+		{"macro", "@monthly", utc("2026-01-15 08:00"), "2026-02-01 00:00"},
+		{"macro, any case", "@Hourly", utc("2026-01-15 08:00"), "2026-01-15 09:00"},
+		{"year rollover", "0 0 1 1 *", utc("2026-12-31 23:59"), "2027-01-01 00:00"},
+
+		// with both day fields restricted either one matches; with one a star, both must
+		{"dom or dow: dow first", "0 0 13 * fri", utc("2026-01-01 00:00"), "2026-01-02 00:00"},
+		{"dom or dow: dom first", "0 0 13 * fri", utc("2026-01-10 00:00"), "2026-01-13 00:00"},
+		{"dom only", "0 0 13 * *", utc("2026-01-01 00:00"), "2026-01-13 00:00"},
+		{"dow only", "0 0 * * fri", utc("2026-01-03 00:00"), "2026-01-09 00:00"},
+		{"dom and starred dow step", "0 0 13 * */2", utc("2026-01-01 00:00"), "2026-01-13 00:00"},
+
+		// months without the day are skipped
+		{"31st skips short months", "0 0 31 * *", utc("2026-01-31 00:00"), "2026-03-31 00:00"},
+		{"31st skips April", "0 0 31 * *", utc("2026-03-31 00:00"), "2026-05-31 00:00"},
+		{"leap day", "0 0 29 2 *", utc("2026-03-01 00:00"), "2028-02-29 00:00"},
+		{"30 February never", "0 0 30 2 *", utc("2026-01-01 00:00"), ""},
+		{"last minute of the month", "59 23 28-31 2 *", utc("2026-02-27 00:00"), "2026-02-28 23:59"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			spec, err := parseCron(tc.expr)
+			if err != nil {
+				t.Fatalf("parse %q: %v", tc.expr, err)
+			}
+			got := spec.next(tc.from)
+			if tc.want == "" {
+				if !got.IsZero() {
+					t.Fatalf("expected %q never to match, got %s", tc.expr, got)
+				}
+				return
+			}
+			if want := utc(tc.want); !got.Equal(want) {
+				t.Fatalf("expected %q after %s to be %s, got %s", tc.expr, tc.from, want, got)
+			}
+		})
+	}
+}
+
+// Schedules run in UTC: a local clock change neither skips nor repeats a run.
+func TestCron_DST(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("load zone: %v", err)
+	}
+	for _, tc := range []struct {
+		name, expr string
+		from       time.Time
+		want       []string
+	}{
+		// clocks go forward at 02:00 local on 2026-03-08
+		{"daily across spring forward", "0 9 * * *", time.Date(2026, 3, 7, 12, 0, 0, 0, ny),
+			[]string{"2026-03-08 09:00", "2026-03-09 09:00"}},
+		{"at the local jump", "0 7 * * *", time.Date(2026, 3, 8, 0, 0, 0, 0, ny),
+			[]string{"2026-03-08 07:00", "2026-03-09 07:00"}},
+		// and back at 02:00 local on 2026-11-01, so 01:00-02:00 local happens twice
+		{"in the repeated local hour", "30 5 * * *", time.Date(2026, 11, 1, 0, 0, 0, 0, ny),
+			[]string{"2026-11-01 05:30", "2026-11-02 05:30"}},
+		{"hourly across fall back", "0 * * * *", time.Date(2026, 11, 1, 1, 30, 0, 0, ny),
+			[]string{"2026-11-01 06:00", "2026-11-01 07:00", "2026-11-01 08:00"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			spec, err := parseCron(tc.expr)
+			if err != nil {
+				t.Fatalf("parse %q: %v", tc.expr, err)
+			}
+			at := tc.from
+			for _, w := range tc.want {
+				at = spec.next(at)
+				if want := utc(w); !at.Equal(want) || at.Location() != time.UTC {
+					t.Fatalf("expected %q to run at %s, got %s", tc.expr, want, at)
+				}
+			}
+		})
+	}
+}
+
+func TestCron_Invalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"@every 5m",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 0 *",
+		"* * * 13 *",
+		"* * * * 8",
+		"* * * foo *",
+		"* * * * funday",
+		"-5 * * * *",
+		"5- * * * *",
+		"20-10 * * * *",
+		"1-2-3 * * * *",
+		"*/0 * * * *",
+		"*/-1 * * * *",
+		"*/x * * * *",
+		"1,,2 * * * *",
+		"0 9 * * mon-fri/x",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("expected %q to be rejected", expr)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -24,10 +26,19 @@ type TxNotifier interface {
 	// - userID: the account/user id whose balance changed (MUST be UUID)
 	// - dataID: an event identifier (e.g., "recharge:<id>:<ts>", "use:<id>:<ts>", "transfer:out:...") — should be unique(ish) per event
 	// - coinID: logical coin/currency id, e.g., the coins row id
+	// - coinType: the balance's coin type, e.g., "COIN" or "PROMO"
 	// - platformName: the source system, e.g., "coin-service"
 	// - coinUsed: positive amount of coins affected (we keep it positive for both inflow/outflow)
 	// - ts/expiry: timestamps; expiry may be zero if you don’t use it
-	Create(ctx context.Context, userID, dataID, coinID, platformName string, coinUsed float64, ts time.Time, expiry time.Time) error
+	Create(ctx context.Context, userID, dataID, coinID, coinType, platformName string, coinUsed float64, ts time.Time, expiry time.Time) error
+}
+
+// AlertNotifier receives account alerts. They are not transactions, so they go through their
+// own outbox topic rather than TxNotifier.
+type AlertNotifier interface {
+	// Overdrawn reports that a debit took the coinType balance of accountID from zero or
+	// above to overdraft coins below zero. eventID identifies the alert across retries.
+	Overdrawn(ctx context.Context, eventID, userID, accountID, coinType string, overdraft int64, ts time.Time) error
 }
 
 // --------------------------------------------
@@ -36,8 +47,19 @@ type TxNotifier interface {
 
 type Store struct {
 	Pool     *pgxpool.Pool
-	Notifier TxNotifier // optional; nil means notifications disabled
+	Notifier TxNotifier    // optional; nil means notifications disabled
+	Alerts   AlertNotifier // optional; nil means account alerts are only logged
 	Logger   *slog.Logger
+
+	// Replica, if set, serves the read-only methods while it is within MaxReplicaLag
+	// (DefaultMaxReplicaLag if zero) of Pool; see reader.
+	Replica       *pgxpool.Pool
+	MaxReplicaLag time.Duration
+	replica       replicaState
+
+	// DefaultCoinExpiry is the lifetime of recharged coins when the caller gives no expiry.
+	// Zero means recharged coins never expire.
+	DefaultCoinExpiry time.Duration
 }
 
 // logger returns a usable logger.
@@ -48,8 +70,9 @@ func (s *Store) logger() *slog.Logger {
 	return s.Logger
 }
 
-// New creates a new Store from a Postgres connection string.
-func New(ctx context.Context, connURL string) (*Store, error) {
+// New creates a new Store from a Postgres connection string. A non-empty replicaURL
+// connects a read replica for the read-only methods.
+func New(ctx context.Context, connURL, replicaURL string) (*Store, error) {
 	log := slog.Default()
 	start := time.Now()
 	log.Info("db.New: start")
@@ -68,8 +91,22 @@ func New(ctx context.Context, connURL string) (*Store, error) {
 		log.Error("db.New: connect", slog.String("error", err.Error()))
 		return nil, fmt.Errorf("db.New: connect: %w", err)
 	}
-	log.Info("db.New: success", slog.Duration("dur", time.Since(start)))
-	return &Store{Pool: pool, Logger: log}, nil
+	s := &Store{Pool: pool, Logger: log}
+	if replicaURL != "" {
+		rcfg, err := pgxpool.ParseConfig(replicaURL)
+		if err != nil {
+			pool.Close()
+			log.Error("db.New: parse replica config", slog.String("error", err.Error()))
+			return nil, fmt.Errorf("db.New: parse replica config: %w", err)
+		}
+		if s.Replica, err = pgxpool.NewWithConfig(ctx, rcfg); err != nil {
+			pool.Close()
+			log.Error("db.New: connect replica", slog.String("error", err.Error()))
+			return nil, fmt.Errorf("db.New: connect replica: %w", err)
+		}
+	}
+	log.Info("db.New: success", slog.Bool("replica", s.Replica != nil), slog.Duration("dur", time.Since(start)))
+	return s, nil
 }
 
 // NewFromPool lets you inject an existing pool (handy for tests).
@@ -85,6 +122,9 @@ func (s *Store) Close() {
 		log.Info("db.Close: closing pool")
 		s.Pool.Close()
 	}
+	if s.Replica != nil {
+		s.Replica.Close()
+	}
 	log.Info("db.Close: done", slog.Duration("dur", time.Since(start)))
 }
 
@@ -92,26 +132,7 @@ func (s *Store) Close() {
 // Schema & Models
 // --------------------------------------------
 
-// EnsureSchema creates the coins table if it doesn't exist.
-func (s *Store) EnsureSchema(ctx context.Context) error {
-	log := s.logger()
-	start := time.Now()
-	log.Info("EnsureSchema: ensure coins table")
-	_, err := s.Pool.Exec(ctx, `
-		CREATE TABLE IF NOT EXISTS public.coins (
-			id TEXT PRIMARY KEY,
-			coins BIGINT NOT NULL DEFAULT 0,
-			last_recharge_date TIMESTAMPTZ NULL,
-			last_usage_date TIMESTAMPTZ NULL
-		);
-	`)
-	if err != nil {
-		log.Error("EnsureSchema: failed", slog.String("error", err.Error()))
-		return err
-	}
-	log.Info("EnsureSchema: ok", slog.Duration("dur", time.Since(start)))
-	return nil
-}
+// The schema lives in versioned migrations under migrations/; see MigrateUp.
 
 // Account is assumed to exist elsewhere in this package.
 // type Account struct {
@@ -133,234 +154,358 @@ func canonicalUUID(s string) (string, error) {
 	return u.String(), nil
 }
 
-// internal helper to send transaction notifications
-func (s *Store) notify(ctx context.Context, userID, coinID, dataID string, coinUsed float64, when time.Time) {
-	l := s.logger()
-	if l == nil {
-		l = slog.Default()
-	}
-	l.Debug("notify: called",
-		slog.String("userID_in", userID),
-		slog.String("coinID", coinID),
-		slog.String("dataID", dataID),
-		slog.Float64("coinUsed", coinUsed),
-		slog.Time("when", when),
-	)
+// --------------------------------------------
+// CRUD & Business Operations
+// --------------------------------------------
+
+// DefaultCoinType is the coin type used when a caller does not name one.
+const DefaultCoinType = "COIN"
+
+// ErrVersionConflict is returned when a mutation's expected version doesn't match the account's.
+var ErrVersionConflict = errors.New("version conflict: account was modified concurrently")
+
+// Account statuses. Frozen accounts cannot be debited; closed accounts cannot change at all.
+const (
+	StatusActive = "active"
+	StatusFrozen = "frozen"
+	StatusClosed = "closed"
+)
+
+var (
+	// ErrAccountFrozen is returned when a frozen account would be debited.
+	ErrAccountFrozen = errors.New("account is frozen")
+	// ErrAccountClosed is returned when a closed account would change.
+	ErrAccountClosed = errors.New("account is closed")
+)
 
-	if s.Notifier == nil {
-		l.Debug("notify: notifier nil; skipping",
-			slog.String("userID", userID),
-			slog.String("dataID", dataID),
-		)
-		return
+// lockedBalance is the part of a balance row that mutations decide on.
+// coins is the whole balance, including the sharded part of a sharded balance.
+type lockedBalance struct {
+	coins         int64
+	status        string
+	creditLimit   int64
+	parentID      *string
+	poolAllowance *int64
+	shards        int
+	sharded       int64
+}
+
+// lockAccount locks a balance row for the rest of tx and returns it, restoring the account
+// first if it was archived. A non-nil expectedVersion must match the row's version.
+func lockAccount(ctx context.Context, tx pgx.Tx, id, coinType string, expectedVersion *int64) (*lockedBalance, error) {
+	var b lockedBalance
+	var version int64
+	lock := func() error {
+		return tx.QueryRow(ctx, `
+			SELECT coins, version, status, credit_limit, parent_id, pool_allowance, shards FROM public.coins WHERE id=$1 AND coin_type=$2 FOR UPDATE
+		`, id, coinType).Scan(&b.coins, &version, &b.status, &b.creditLimit, &b.parentID, &b.poolAllowance, &b.shards)
+	}
+	if err := lock(); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return nil, err
+		}
+		restored, rerr := unarchive(ctx, tx, id)
+		if rerr != nil {
+			return nil, rerr
+		}
+		if !restored {
+			return nil, err
+		}
+		if err := lock(); err != nil {
+			return nil, err
+		}
 	}
+	if expectedVersion != nil && *expectedVersion != version {
+		return nil, fmt.Errorf("%s/%s: expected version %d, have %d: %w", id, coinType, *expectedVersion, version, ErrVersionConflict)
+	}
+	if b.shards > 0 {
+		// read after the lock is granted, so credits that held it until now are included
+		sum, err := shardSum(ctx, tx, id, coinType)
+		if err != nil {
+			return nil, err
+		}
+		b.coins += sum
+		b.sharded = sum
+	}
+	return &b, nil
+}
 
-	const platform = "coin-service"
+// checkAccountVersion checks a non-nil expectedVersion for a change to every coin type of an
+// account against its default coin type balance, which stays locked for the rest of tx.
+// Accounts without a default balance are not checked.
+func checkAccountVersion(ctx context.Context, tx pgx.Tx, id string, expectedVersion *int64) error {
+	if expectedVersion == nil {
+		return nil
+	}
+	if _, err := lockAccount(ctx, tx, id, DefaultCoinType, expectedVersion); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	return nil
+}
 
-	if err := s.Notifier.Create(ctx, userID, dataID, coinID, platform, coinUsed, when.UTC(), time.Time{}); err != nil {
-		l.Error("notify: failed",
-			slog.String("userID", userID),
-			slog.String("dataID", dataID),
-			slog.String("coinID", coinID),
-			slog.Float64("coinUsed", coinUsed),
-			slog.Time("when_utc", when.UTC()),
-			slog.String("error", err.Error()),
-		)
-		return
+// checkStatus rejects changes to closed accounts and, if debit, debits of frozen ones.
+func checkStatus(id, status string, debit bool) error {
+	switch {
+	case status == StatusClosed:
+		return fmt.Errorf("%s: %w", id, ErrAccountClosed)
+	case debit && status == StatusFrozen:
+		return fmt.Errorf("%s: %w", id, ErrAccountFrozen)
 	}
+	return nil
+}
 
-	l.Debug("notify: sent",
-		slog.String("userID", userID),
-		slog.String("dataID", dataID),
-		slog.String("coinID", coinID),
-		slog.Float64("coinUsed", coinUsed),
-		slog.Time("when_utc", when.UTC()),
-	)
+func coinTypeOrDefault(coinType string) string {
+	if t := strings.TrimSpace(coinType); t != "" {
+		return t
+	}
+	return DefaultCoinType
 }
 
-// --------------------------------------------
-// CRUD & Business Operations
-// --------------------------------------------
+// accountColumns selects an Account from public.coins aliased as c.
+// coins and last_recharge_date include the shards of a sharded balance; held sums the
+// active, unexpired holds on the same coin type.
+const accountColumns = `c.id, c.coin_type, ` + shardedCoins + `, c.version, c.status, c.credit_limit, ` + rechargedAt + `, c.last_usage_date, c.created_at,
+		c.metadata, c.labels, c.parent_id, c.pool_allowance,
+		COALESCE((SELECT SUM(h.amount) FROM public.coin_holds h
+			WHERE h.account_id = c.id AND h.coin_type = c.coin_type
+			  AND h.status = 'active' AND h.expires_at > NOW()), 0)`
 
-func (s *Store) GetAccount(ctx context.Context, id string) (*Account, error) {
-	log := s.logger()
-	start := time.Now()
-	log.Debug("GetAccount: query", slog.String("id", id))
-	row := s.Pool.QueryRow(ctx, `
-		SELECT id, coins, last_recharge_date, last_usage_date
-		FROM public.coins WHERE id=$1
-	`, id)
+func scanAccount(row pgx.Row) (*Account, error) {
 	var a Account
-	if err := row.Scan(&a.ID, &a.Coins, &a.LastRechargeDate, &a.LastUsageDate); err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			log.Info("GetAccount: not found", slog.String("id", id), slog.Duration("dur", time.Since(start)))
-			return nil, nil
-		}
-		log.Error("GetAccount: scan failed", slog.String("id", id), slog.String("error", err.Error()))
+	if err := row.Scan(&a.ID, &a.CoinType, &a.Coins, &a.Version, &a.Status, &a.CreditLimit, &a.LastRechargeDate, &a.LastUsageDate, &a.CreatedAt, &a.Metadata, &a.Labels, &a.ParentID, &a.PoolAllowance, &a.Held); err != nil {
 		return nil, err
 	}
-	log.Debug("GetAccount: ok", slog.String("id", id), slog.Int64("coins", a.Coins), slog.Duration("dur", time.Since(start)))
+	a.Available = a.Coins - a.Held + a.CreditLimit
 	return &a, nil
 }
 
-func (s *Store) ListAccounts(ctx context.Context, limit, offset int) ([]*Account, error) {
-	log := s.logger()
-	start := time.Now()
-	origLimit, origOffset := limit, offset
-	if limit <= 0 || limit > 200 {
-		limit = 50
-	}
-	if offset < 0 {
-		offset = 0
-	}
-	log.Debug("ListAccounts: query", slog.Int("limit", limit), slog.Int("offset", offset), slog.Int("origLimit", origLimit), slog.Int("origOffset", origOffset))
-	rows, err := s.Pool.Query(ctx, `
-		SELECT id, coins, last_recharge_date, last_usage_date
-		FROM public.coins
-		ORDER BY id
-		LIMIT $1 OFFSET $2
-	`, limit, offset)
-	if err != nil {
-		log.Error("ListAccounts: query failed", slog.String("error", err.Error()))
-		return nil, err
+// querier is satisfied by both *pgxpool.Pool and pgx.Tx.
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// conn is satisfied by both *pgxpool.Pool and pgx.Tx; Begin on a pgx.Tx opens a savepoint.
+type conn interface {
+	querier
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+type txKey struct{}
+
+// conn returns where a mutation runs: the transaction of the audited call ctx belongs to
+// (see RunAudited), else s.conn(ctx). A mutation's own transaction becomes a savepoint in it.
+func (s *Store) conn(ctx context.Context) conn {
+	if tx, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		return tx
 	}
-	defer rows.Close()
+	return s.Pool
+}
 
-	var out []*Account
-	for rows.Next() {
-		var a Account
-		if err := rows.Scan(&a.ID, &a.Coins, &a.LastRechargeDate, &a.LastUsageDate); err != nil {
-			log.Error("ListAccounts: scan failed", slog.String("error", err.Error()))
-			return nil, err
-		}
-		out = append(out, &a)
+// GetAccount returns the balance of one coin type of an account (DefaultCoinType if empty).
+// An archived account is restored and read back from the primary.
+func (s *Store) GetAccount(ctx context.Context, id, coinType string) (*Account, error) {
+	coinType = coinTypeOrDefault(coinType)
+	a, err := s.getAccount(ctx, s.reader(ctx), id, coinType)
+	if err != nil || a != nil {
+		return a, err
 	}
-	if err := rows.Err(); err != nil {
-		log.Error("ListAccounts: rows err", slog.String("error", err.Error()))
+	if restored, err := s.restoreOnRead(ctx, id); err != nil || !restored {
 		return nil, err
 	}
-	log.Debug("ListAccounts: ok", slog.Int("count", len(out)), slog.Duration("dur", time.Since(start)))
-	return out, nil
+	return s.getAccount(ctx, s.conn(ctx), id, coinType)
 }
 
-func (s *Store) ListAccountsByCoinsRange(ctx context.Context, min, max *int64) ([]*Account, error) {
+// getAccount reads an account through q, so mutations can read their own writes before commit.
+func (s *Store) getAccount(ctx context.Context, q querier, id, coinType string) (*Account, error) {
 	log := s.logger()
 	start := time.Now()
-	log.Debug("ListAccountsByCoinsRange: start", slog.Any("min", min), slog.Any("max", max))
-	q := `
-		SELECT id, coins, last_recharge_date, last_usage_date
-		FROM public.coins
-		WHERE 1=1
-	`
-	args := []any{}
-	i := 1
-	if min != nil {
-		q += fmt.Sprintf(" AND coins >= $%d", i)
-		args = append(args, *min)
-		i++
-	}
-	if max != nil {
-		q += fmt.Sprintf(" AND coins <= $%d", i)
-		args = append(args, *max)
-		i++
-	}
-	q += " ORDER BY coins DESC, id ASC"
-
-	rows, err := s.Pool.Query(ctx, q, args...)
+	log.Debug("GetAccount: query", slog.String("id", id), slog.String("coinType", coinType))
+	row := q.QueryRow(ctx, `
+		SELECT `+accountColumns+`
+		FROM public.coins c WHERE c.id=$1 AND c.coin_type=$2
+	`, id, coinType)
+	a, err := scanAccount(row)
 	if err != nil {
-		log.Error("ListAccountsByCoinsRange: query failed", slog.String("error", err.Error()))
-		return nil, err
-	}
-	defer rows.Close()
-	var out []*Account
-	for rows.Next() {
-		var a Account
-		if err := rows.Scan(&a.ID, &a.Coins, &a.LastRechargeDate, &a.LastUsageDate); err != nil {
-			log.Error("ListAccountsByCoinsRange: scan failed", slog.String("error", err.Error()))
-			return nil, err
+		if errors.Is(err, pgx.ErrNoRows) {
+			log.Info("GetAccount: not found", slog.String("id", id), slog.Duration("dur", time.Since(start)))
+			return nil, nil
 		}
-		out = append(out, &a)
-	}
-	if err := rows.Err(); err != nil {
-		log.Error("ListAccountsByCoinsRange: rows err", slog.String("error", err.Error()))
+		log.Error("GetAccount: scan failed", slog.String("id", id), slog.String("error", err.Error()))
 		return nil, err
 	}
-	log.Debug("ListAccountsByCoinsRange: ok", slog.Int("count", len(out)), slog.Duration("dur", time.Since(start)))
-	return out, nil
+	log.Debug("GetAccount: ok", slog.String("id", id), slog.Int64("coins", a.Coins), slog.Duration("dur", time.Since(start)))
+	return a, nil
 }
 
-func (s *Store) ListRecentRecharges(ctx context.Context, since time.Time) ([]*Account, error) {
+// GetAccounts returns the coinType balances (DefaultCoinType if empty) of the given ids
+// that exist, ordered by id.
+func (s *Store) GetAccounts(ctx context.Context, ids []string, coinType string) ([]*Account, error) {
 	log := s.logger()
 	start := time.Now()
-	log.Debug("ListRecentRecharges: start", slog.Time("since", since))
-	rows, err := s.Pool.Query(ctx, `
-		SELECT id, coins, last_recharge_date, last_usage_date
-		FROM public.coins
-		WHERE last_recharge_date IS NOT NULL AND last_recharge_date >= $1
-		ORDER BY last_recharge_date DESC
-	`, since)
+	coinType = coinTypeOrDefault(coinType)
+	log.Debug("GetAccounts: query", slog.Int("ids", len(ids)), slog.String("coinType", coinType))
+	rows, err := s.reader(ctx).Query(ctx, `
+		SELECT `+accountColumns+`
+		FROM public.coins c
+		WHERE c.id = ANY($1) AND c.coin_type=$2
+		ORDER BY c.id
+	`, ids, coinType)
 	if err != nil {
-		log.Error("ListRecentRecharges: query failed", slog.String("error", err.Error()))
+		log.Error("GetAccounts: query failed", slog.String("error", err.Error()))
 		return nil, err
 	}
 	defer rows.Close()
 
-	var out []*Account
+	out := []*Account{}
 	for rows.Next() {
-		var a Account
-		if err := rows.Scan(&a.ID, &a.Coins, &a.LastRechargeDate, &a.LastUsageDate); err != nil {
-			log.Error("ListRecentRecharges: scan failed", slog.String("error", err.Error()))
+		a, err := scanAccount(rows)
+		if err != nil {
+			log.Error("GetAccounts: scan failed", slog.String("error", err.Error()))
 			return nil, err
 		}
-		out = append(out, &a)
+		out = append(out, a)
 	}
 	if err := rows.Err(); err != nil {
-		log.Error("ListRecentRecharges: rows err", slog.String("error", err.Error()))
+		log.Error("GetAccounts: rows err", slog.String("error", err.Error()))
 		return nil, err
 	}
-	log.Debug("ListRecentRecharges: ok", slog.Int("count", len(out)), slog.Duration("dur", time.Since(start)))
+	log.Debug("GetAccounts: ok", slog.Int("count", len(out)), slog.Duration("dur", time.Since(start)))
 	return out, nil
 }
 
-func (s *Store) ListInactiveSince(ctx context.Context, before time.Time) ([]*Account, error) {
+// ListBalances returns every coin type balance of an account, ordered by coin type.
+// An archived account is restored and read back from the primary.
+func (s *Store) ListBalances(ctx context.Context, id string) ([]*Account, error) {
+	out, err := s.listBalances(ctx, s.reader(ctx), id)
+	if err != nil || len(out) > 0 {
+		return out, err
+	}
+	if restored, err := s.restoreOnRead(ctx, id); err != nil || !restored {
+		return out, err
+	}
+	return s.listBalances(ctx, s.conn(ctx), id)
+}
+
+// listBalances reads the balances through q, so mutations can read back from the primary.
+func (s *Store) listBalances(ctx context.Context, q querier, id string) ([]*Account, error) {
 	log := s.logger()
 	start := time.Now()
-	log.Debug("ListInactiveSince: start", slog.Time("before", before))
-	rows, err := s.Pool.Query(ctx, `
-		SELECT id, coins, last_recharge_date, last_usage_date
-		FROM public.coins
-		WHERE last_usage_date IS NULL OR last_usage_date < $1
-		ORDER BY last_usage_date NULLS FIRST, id
-	`, before)
+	log.Debug("ListBalances: query", slog.String("id", id))
+	rows, err := q.Query(ctx, `
+		SELECT `+accountColumns+`
+		FROM public.coins c
+		WHERE c.id=$1
+		ORDER BY c.coin_type
+	`, id)
 	if err != nil {
-		log.Error("ListInactiveSince: query failed", slog.String("error", err.Error()))
+		log.Error("ListBalances: query failed", slog.String("id", id), slog.String("error", err.Error()))
 		return nil, err
 	}
 	defer rows.Close()
 
 	var out []*Account
 	for rows.Next() {
-		var a Account
-		if err := rows.Scan(&a.ID, &a.Coins, &a.LastRechargeDate, &a.LastUsageDate); err != nil {
-			log.Error("ListInactiveSince: scan failed", slog.String("error", err.Error()))
+		a, err := scanAccount(rows)
+		if err != nil {
+			log.Error("ListBalances: scan failed", slog.String("error", err.Error()))
 			return nil, err
 		}
-		out = append(out, &a)
+		out = append(out, a)
 	}
 	if err := rows.Err(); err != nil {
-		log.Error("ListInactiveSince: rows err", slog.String("error", err.Error()))
+		log.Error("ListBalances: rows err", slog.String("error", err.Error()))
 		return nil, err
 	}
-	log.Debug("ListInactiveSince: ok", slog.Int("count", len(out)), slog.Duration("dur", time.Since(start)))
+	log.Debug("ListBalances: ok", slog.String("id", id), slog.Int("count", len(out)), slog.Duration("dur", time.Since(start)))
 	return out, nil
 }
 
+// AccountFilter narrows ListAccounts; zero fields match everything.
+type AccountFilter struct {
+	Status string
+	Labels LabelSelector
+}
+
+// ListAccounts pages through the balances that match f, ordered by id and coin type.
+func (s *Store) ListAccounts(ctx context.Context, page PageArgs, f AccountFilter) (*AccountPage, error) {
+	where := `($1 = '' OR c.status = $1)`
+	args := []any{f.Status}
+	cond, labelArgs := f.Labels.where(len(args) + 1)
+	return s.pageAccounts(ctx, accountListing{
+		name:  "ListAccounts",
+		where: where + cond,
+		args:  append(args, labelArgs...),
+		order: "c.id, c.coin_type",
+		after: func(c *pageCursor, n int) (string, []any) {
+			return fmt.Sprintf("(c.id, c.coin_type) > ($%d, $%d)", n, n+1), []any{c.ID, c.CoinType}
+		},
+		cursor: func(a *Account) pageCursor { return pageCursor{} },
+	}, page)
+}
+
+// ListAccountsByCoinsRange pages through the balances within [min, max], richest first.
+func (s *Store) ListAccountsByCoinsRange(ctx context.Context, min, max *int64, page PageArgs) (*AccountPage, error) {
+	return s.pageAccounts(ctx, accountListing{
+		name:  "ListAccountsByCoinsRange",
+		where: `($1::bigint IS NULL OR c.coins >= $1::bigint) AND ($2::bigint IS NULL OR c.coins <= $2::bigint)`,
+		args:  []any{min, max},
+		order: "c.coins DESC, c.id, c.coin_type",
+		after: func(c *pageCursor, n int) (string, []any) {
+			return fmt.Sprintf("(c.coins < $%[1]d OR (c.coins = $%[1]d AND (c.id, c.coin_type) > ($%[2]d, $%[3]d)))", n, n+1, n+2),
+				[]any{c.Coins, c.ID, c.CoinType}
+		},
+		cursor:     func(a *Account) pageCursor { return pageCursor{Coins: a.Coins} },
+		key:        "c.coins",
+		shardedKey: shardedCoins,
+	}, page)
+}
+
+// ListRecentRecharges pages through the balances recharged since the given time, most recent first.
+func (s *Store) ListRecentRecharges(ctx context.Context, since time.Time, page PageArgs) (*AccountPage, error) {
+	return s.pageAccounts(ctx, accountListing{
+		name:  "ListRecentRecharges",
+		where: `c.last_recharge_date >= $1`,
+		args:  []any{since},
+		order: "c.last_recharge_date DESC, c.id, c.coin_type",
+		after: func(c *pageCursor, n int) (string, []any) {
+			return fmt.Sprintf("(c.last_recharge_date < $%[1]d OR (c.last_recharge_date = $%[1]d AND (c.id, c.coin_type) > ($%[2]d, $%[3]d)))", n, n+1, n+2),
+				[]any{c.At, c.ID, c.CoinType}
+		},
+		cursor:     func(a *Account) pageCursor { return pageCursor{At: a.LastRechargeDate} },
+		key:        "c.last_recharge_date",
+		shardedKey: rechargedAt,
+	}, page)
+}
+
+// lastUsedAt is the last use of public.coins c, never used sorting first; coins_by_usage_idx indexes it.
+const lastUsedAt = `COALESCE(c.last_usage_date, '-infinity'::timestamptz)`
+
+// ListInactiveSince pages through the balances not used since before (or never), least recently used first.
+func (s *Store) ListInactiveSince(ctx context.Context, before time.Time, page PageArgs) (*AccountPage, error) {
+	return s.pageAccounts(ctx, accountListing{
+		name:  "ListInactiveSince",
+		where: lastUsedAt + ` < $1`,
+		args:  []any{before},
+		order: lastUsedAt + ", c.id, c.coin_type",
+		after: func(c *pageCursor, n int) (string, []any) {
+			return fmt.Sprintf("(%s, c.id, c.coin_type) > (COALESCE($%d::timestamptz, '-infinity'), $%d, $%d)", lastUsedAt, n, n+1, n+2),
+				[]any{c.At, c.ID, c.CoinType}
+		},
+		cursor: func(a *Account) pageCursor { return pageCursor{At: a.LastUsageDate} },
+	}, page)
+}
+
+// CountAccounts counts distinct account ids, whatever coin types they hold, archived or not.
 func (s *Store) CountAccounts(ctx context.Context) (int64, error) {
 	log := s.logger()
 	start := time.Now()
 	log.Debug("CountAccounts: start")
 	var n int64
-	if err := s.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM public.coins`).Scan(&n); err != nil {
+	if err := s.reader(ctx).QueryRow(ctx, `
+		SELECT COUNT(*) FROM (SELECT id FROM public.coins UNION SELECT id FROM public.archived_coins) ids
+	`).Scan(&n); err != nil {
 		log.Error("CountAccounts: failed", slog.String("error", err.Error()))
 		return 0, err
 	}
@@ -368,12 +513,18 @@ func (s *Store) CountAccounts(ctx context.Context) (int64, error) {
 	return n, nil
 }
 
-func (s *Store) SumCoins(ctx context.Context) (int64, error) {
+// SumCoins sums the balances of one coin type (DefaultCoinType if empty), archived ones included.
+func (s *Store) SumCoins(ctx context.Context, coinType string) (int64, error) {
 	log := s.logger()
 	start := time.Now()
-	log.Debug("SumCoins: start")
+	coinType = coinTypeOrDefault(coinType)
+	log.Debug("SumCoins: start", slog.String("coinType", coinType))
 	var sum int64
-	if err := s.Pool.QueryRow(ctx, `SELECT COALESCE(SUM(coins),0) FROM public.coins`).Scan(&sum); err != nil {
+	if err := s.reader(ctx).QueryRow(ctx, `
+		SELECT COALESCE((SELECT SUM(coins) FROM public.coins WHERE coin_type=$1), 0)
+		     + COALESCE((SELECT SUM(coins) FROM public.coin_shards WHERE coin_type=$1), 0)
+		     + COALESCE((SELECT SUM(coins) FROM public.archived_coins WHERE coin_type=$1), 0)
+	`, coinType).Scan(&sum); err != nil {
 		log.Error("SumCoins: failed", slog.String("error", err.Error()))
 		return 0, err
 	}
@@ -382,12 +533,16 @@ func (s *Store) SumCoins(ctx context.Context) (int64, error) {
 }
 
 func (s *Store) UserExists(ctx context.Context, id string) (bool, error) {
+	return s.userExists(ctx, s.reader(ctx), id)
+}
+
+func (s *Store) userExists(ctx context.Context, q querier, id string) (bool, error) {
 	log := s.logger()
 	start := time.Now()
 	log.Debug("UserExists: start", slog.String("id", id))
 	var exists bool
-	if err := s.Pool.QueryRow(ctx, `
-		SELECT EXISTS(SELECT 1 FROM public.coins WHERE id=$1)
+	if err := q.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM public.coins WHERE id=$1) OR EXISTS(SELECT 1 FROM public.archived_coins WHERE id=$1)
 	`, id).Scan(&exists); err != nil {
 		log.Error("UserExists: failed", slog.String("id", id), slog.String("error", err.Error()))
 		return false, err
@@ -396,22 +551,87 @@ func (s *Store) UserExists(ctx context.Context, id string) (bool, error) {
 	return exists, nil
 }
 
-func (s *Store) CreateAccount(ctx context.Context, id string, coins *int64) (*Account, error) {
+// CreateAccount opens the coinType balance of an account (DefaultCoinType if empty).
+// Creating an existing balance is a no-op that returns it unchanged. Non-nil metadata or
+// labels replace those of every coin type of the account.
+func (s *Store) CreateAccount(ctx context.Context, id, coinType string, coins *int64, metadata map[string]any, labels map[string]string) (*Account, error) {
 	log := s.logger()
 	start := time.Now()
 	initial := int64(0)
 	if coins != nil {
 		initial = *coins
 	}
-	log.Info("CreateAccount: start", slog.String("id", id), slog.Int64("initial", initial))
-	if _, err := s.Pool.Exec(ctx, `
-		INSERT INTO public.coins (id, coins) VALUES ($1, $2)
-		ON CONFLICT (id) DO NOTHING
-	`, id, initial); err != nil {
+	coinType = coinTypeOrDefault(coinType)
+	log.Info("CreateAccount: start", slog.String("id", id), slog.String("coinType", coinType), slog.Int64("initial", initial))
+	if err := validateLabels(labels); err != nil {
+		return nil, fmt.Errorf("create: %w", err)
+	}
+	metaJSON, err := jsonArg(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("create: %w", err)
+	}
+	labelsJSON, err := jsonArg(labels)
+	if err != nil {
+		return nil, fmt.Errorf("create: %w", err)
+	}
+
+	tx, err := s.conn(ctx).Begin(ctx)
+	if err != nil {
+		log.Error("CreateAccount: begin tx failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err := unarchive(ctx, tx, id); err != nil {
+		return nil, err
+	}
+	// a new coin type joins the account in its current status, with its metadata, labels and parent
+	status := StatusActive
+	curMeta, curLabels := "{}", "{}"
+	var parentID *string
+	if err := tx.QueryRow(ctx, `
+		SELECT status, metadata::text, labels::text, parent_id FROM public.coins WHERE id=$1 LIMIT 1
+	`, id).Scan(&status, &curMeta, &curLabels, &parentID); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		log.Error("CreateAccount: status lookup failed", slog.String("id", id), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if status == StatusClosed {
+		return nil, fmt.Errorf("create: %s: %w", id, ErrAccountClosed)
+	}
+	if metaJSON == nil {
+		metaJSON = &curMeta
+	}
+	if labelsJSON == nil {
+		labelsJSON = &curLabels
+	}
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO public.coins (id, coin_type, coins, status, metadata, labels, parent_id) VALUES ($1, $2, $3, $4, $5::jsonb, $6::jsonb, $7)
+		ON CONFLICT (id, coin_type) DO NOTHING
+	`, id, coinType, initial, status, *metaJSON, *labelsJSON, parentID)
+	if err != nil {
 		log.Error("CreateAccount: insert failed", slog.String("id", id), slog.String("error", err.Error()))
 		return nil, err
 	}
-	acc, err := s.GetAccount(ctx, id)
+	if metadata != nil || labels != nil {
+		if _, err := tx.Exec(ctx, `
+			UPDATE public.coins SET metadata=$2::jsonb, labels=$3::jsonb, version = version + 1
+			WHERE id=$1 AND (metadata <> $2::jsonb OR labels <> $3::jsonb)
+		`, id, *metaJSON, *labelsJSON); err != nil {
+			log.Error("CreateAccount: metadata update failed", slog.String("id", id), slog.String("error", err.Error()))
+			return nil, err
+		}
+	}
+	if tag.RowsAffected() > 0 && initial != 0 {
+		if err := s.insertLedger(ctx, tx, id, coinType, initial, initial, "", "", LedgerKindCreate); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Error("CreateAccount: commit failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	acc, err := s.getAccount(ctx, s.conn(ctx), id, coinType)
 	if err != nil {
 		log.Error("CreateAccount: readback failed", slog.String("id", id), slog.String("error", err.Error()))
 		return nil, err
@@ -420,27 +640,96 @@ func (s *Store) CreateAccount(ctx context.Context, id string, coins *int64) (*Ac
 	return acc, nil
 }
 
-func (s *Store) DeleteAccount(ctx context.Context, id string) (bool, error) {
+// DeleteAccount removes every coin type balance of an account.
+// A non-nil expectedVersion must match the version of the DefaultCoinType balance.
+func (s *Store) DeleteAccount(ctx context.Context, id string, expectedVersion *int64) (bool, error) {
 	log := s.logger()
 	start := time.Now()
-	log.Info("DeleteAccount: start", slog.String("id", id))
-	tag, err := s.Pool.Exec(ctx, `DELETE FROM public.coins WHERE id=$1`, id)
+	log.Info("DeleteAccount: start", slog.String("id", id), slog.Any("expectedVersion", expectedVersion))
+	tx, err := s.conn(ctx).Begin(ctx)
 	if err != nil {
+		log.Error("DeleteAccount: begin tx failed", slog.String("error", err.Error()))
+		return false, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	if _, err := unarchive(ctx, tx, id); err != nil {
+		return false, err
+	}
+	if err := checkAccountVersion(ctx, tx, id, expectedVersion); err != nil {
+		return false, err
+	}
+	// lock every coin type first, so the shards summed below have no credit in flight
+	var sharded bool
+	if err := tx.QueryRow(ctx, `
+		SELECT COALESCE(bool_or(shards > 0), false) FROM (SELECT shards FROM public.coins WHERE id=$1 FOR UPDATE) c
+	`, id).Scan(&sharded); err != nil {
+		log.Error("DeleteAccount: lock failed", slog.String("id", id), slog.String("error", err.Error()))
+		return false, err
+	}
+	shardCoins := map[string]int64{}
+	if sharded {
+		if shardCoins, err = shardTotals(ctx, tx, id); err != nil {
+			return false, err
+		}
+	}
+	rows, err := tx.Query(ctx, `DELETE FROM public.coins WHERE id=$1 RETURNING coin_type, coins`, id)
+	if err != nil {
+		log.Error("DeleteAccount: failed", slog.String("id", id), slog.String("error", err.Error()))
+		return false, err
+	}
+	deleted := map[string]int64{}
+	for rows.Next() {
+		var coinType string
+		var coins int64
+		if err := rows.Scan(&coinType, &coins); err != nil {
+			rows.Close()
+			return false, err
+		}
+		deleted[coinType] = coins + shardCoins[coinType]
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
 		log.Error("DeleteAccount: failed", slog.String("id", id), slog.String("error", err.Error()))
 		return false, err
 	}
-	ok := tag.RowsAffected() > 0
+	// members of a deleted shared wallet fall back to their own balances
+	if len(deleted) > 0 {
+		if _, err := tx.Exec(ctx, `UPDATE public.coins SET parent_id = NULL, version = version + 1 WHERE parent_id=$1`, id); err != nil {
+			log.Error("DeleteAccount: unlink members failed", slog.String("id", id), slog.String("error", err.Error()))
+			return false, err
+		}
+	}
+	// zero the balances in the ledger so point-in-time lookups see the deletion
+	for coinType, coins := range deleted {
+		if coins == 0 {
+			continue
+		}
+		if err := s.insertLedger(ctx, tx, id, coinType, -coins, 0, "", "", LedgerKindDelete); err != nil {
+			return false, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Error("DeleteAccount: commit failed", slog.String("error", err.Error()))
+		return false, err
+	}
+	ok := len(deleted) > 0
 	log.Info("DeleteAccount: done", slog.String("id", id), slog.Bool("deleted", ok), slog.Duration("dur", time.Since(start)))
 	return ok, nil
 }
 
 // SetCoinsExact sets the balance to an exact value and emits a transaction using the caller-provided userID (UUID) and dataID.
-func (s *Store) SetCoinsExact(ctx context.Context, coinID string, coins int64, userID, dataID string) (*Account, error) {
+// A non-nil expectedVersion must match the account's version, so an operator cannot
+// overwrite a balance that changed since they read it. A raise expires after
+// s.DefaultCoinExpiry, if set, like a recharge; a cut draws from the lots like Use.
+func (s *Store) SetCoinsExact(ctx context.Context, coinID, coinType string, coins int64, expectedVersion *int64, userID, dataID string) (*Account, error) {
 	log := s.logger()
 	start := time.Now()
+	coinType = coinTypeOrDefault(coinType)
 	log.Info("SetCoinsExact: start",
 		slog.String("coinID", coinID),
+		slog.String("coinType", coinType),
 		slog.Int64("target", coins),
+		slog.Any("expectedVersion", expectedVersion),
 		slog.String("userID_in", userID),
 		slog.String("dataID_in", dataID),
 	)
@@ -453,32 +742,98 @@ func (s *Store) SetCoinsExact(ctx context.Context, coinID string, coins int64, u
 		return nil, err
 	}
 	userID = uid
+	expiresAt, err := s.defaultExpiry(nil)
+	if err != nil {
+		return nil, err
+	}
+	keyed := strings.TrimSpace(dataID) != ""
+	if !keyed {
+		dataID = fmt.Sprintf("setexact:%s:%d", coinID, time.Now().UnixNano())
+	}
 
-	// fetch current to compute delta
-	cur, _ := s.GetAccount(ctx, coinID)
+	tx, err := s.conn(ctx).Begin(ctx)
+	if err != nil {
+		log.Error("SetCoinsExact: begin tx failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if keyed {
+		prior, err := s.claimIdempotency(ctx, tx, IdemOpSet, coinID, coinType, dataID, requestHash(IdemOpSet, coinID, coinType, coins, userID, versionKey(expectedVersion)))
+		if err != nil {
+			return nil, err
+		}
+		if prior != nil {
+			var acc Account
+			if err := json.Unmarshal(prior, &acc); err != nil {
+				return nil, err
+			}
+			return &acc, nil
+		}
+	}
 
-	if _, err := s.Pool.Exec(ctx, `
-		UPDATE public.coins SET coins=$2 WHERE id=$1
-	`, coinID, coins); err != nil {
+	// lock current to compute delta
+	locked, err := lockAccount(ctx, tx, coinID, coinType, expectedVersion)
+	if err != nil {
+		log.Error("SetCoinsExact: select failed", slog.String("coinID", coinID), slog.String("error", err.Error()))
+		return nil, err
+	}
+	cur := locked.coins
+	if err := checkStatus(coinID, locked.status, false); err != nil {
+		return nil, err
+	}
+	if locked.shards > 0 {
+		if err := zeroShards(ctx, tx, coinID, coinType); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE public.coins SET coins=$3, version = version + 1 WHERE id=$1 AND coin_type=$2
+	`, coinID, coinType, coins); err != nil {
 		log.Error("SetCoinsExact: update failed", slog.String("coinID", coinID), slog.String("error", err.Error()))
 		return nil, err
 	}
-	acc, err := s.GetAccount(ctx, coinID)
+	delta := coins - cur
+	if delta < 0 {
+		if err := s.consumeLots(ctx, tx, coinID, coinType, -delta); err != nil {
+			return nil, err
+		}
+	}
+	if delta > 0 {
+		// a raise is a credit like a recharge and expires like one
+		if err := s.createLot(ctx, tx, coinID, coinType, delta, expiresAt, dataID); err != nil {
+			return nil, err
+		}
+	}
+	if delta != 0 {
+		if err := s.insertLedger(ctx, tx, coinID, coinType, delta, coins, userID, dataID, LedgerKindSet); err != nil {
+			return nil, err
+		}
+	}
+	acc, err := s.getAccount(ctx, tx, coinID, coinType)
 	if err != nil {
 		log.Error("SetCoinsExact: readback failed", slog.String("coinID", coinID), slog.String("error", err.Error()))
 		return nil, err
 	}
+	if keyed {
+		if err := s.completeIdempotency(ctx, tx, IdemOpSet, coinID, coinType, dataID, acc); err != nil {
+			return nil, err
+		}
+	}
 
 	// emit transaction for the delta (positive number)
-	if cur != nil && acc.Coins != cur.Coins {
-		delta := acc.Coins - cur.Coins
-		if delta < 0 {
-			delta = -delta
+	if delta != 0 {
+		abs := delta
+		if abs < 0 {
+			abs = -abs
 		}
-		if strings.TrimSpace(dataID) == "" {
-			dataID = fmt.Sprintf("setexact:%s:%d", coinID, time.Now().UnixNano())
+		if err := s.notify(ctx, tx, userID, coinID, coinType, dataID, float64(abs), time.Now().UTC(), time.Time{}); err != nil {
+			return nil, err
 		}
-		s.notify(ctx, userID, coinID, dataID, float64(delta), time.Now().UTC())
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Error("SetCoinsExact: commit failed", slog.String("error", err.Error()))
+		return nil, err
 	}
 
 	log.Info("SetCoinsExact: ok",
@@ -490,18 +845,38 @@ func (s *Store) SetCoinsExact(ctx context.Context, coinID string, coins int64, u
 }
 
 // Recharge increases balance and emits a transaction using caller-provided userID (UUID) and dataID.
-func (s *Store) Recharge(ctx context.Context, coinID string, amount int64, userID, dataID string) (*Account, error) {
+// The coins expire after s.DefaultCoinExpiry, if set.
+func (s *Store) Recharge(ctx context.Context, coinID, coinType string, amount int64, expectedVersion *int64, userID, dataID string) (*Account, error) {
+	return s.RechargeWithExpiry(ctx, coinID, coinType, amount, nil, expectedVersion, userID, dataID)
+}
+
+// RechargeWithExpiry is Recharge with an explicit expiry for the credited coins.
+// A nil expiresAt falls back to s.DefaultCoinExpiry.
+func (s *Store) RechargeWithExpiry(ctx context.Context, coinID, coinType string, amount int64, expiresAt *time.Time, expectedVersion *int64, userID, dataID string) (*Account, error) {
 	log := s.logger()
 	start := time.Now()
+	coinType = coinTypeOrDefault(coinType)
 	log.Info("Recharge: start",
 		slog.String("coinID", coinID),
+		slog.String("coinType", coinType),
 		slog.Int64("amount", amount),
+		slog.Any("expiresAt", expiresAt),
+		slog.Any("expectedVersion", expectedVersion),
 		slog.String("userID_in", userID),
 		slog.String("dataID_in", dataID),
 	)
 	if amount <= 0 {
 		return nil, errors.New("recharge: amount must be > 0")
 	}
+	// only an explicit expiry is part of the request; the default one moves with the clock
+	expiryKey := ""
+	if expiresAt != nil {
+		expiryKey = expiresAt.UTC().Format(time.RFC3339Nano)
+	}
+	expiresAt, err := s.defaultExpiry(expiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("recharge: %w", err)
+	}
 	if strings.TrimSpace(userID) == "" {
 		return nil, errors.New("userID is required (UUID)")
 	}
@@ -510,27 +885,85 @@ func (s *Store) Recharge(ctx context.Context, coinID string, amount int64, userI
 		return nil, err
 	}
 	userID = uid
+	keyed := strings.TrimSpace(dataID) != ""
+	if !keyed {
+		dataID = fmt.Sprintf("recharge:%s:%d", coinID, time.Now().UnixNano())
+	}
+
+	tx, err := s.conn(ctx).Begin(ctx)
+	if err != nil {
+		log.Error("Recharge: begin tx failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
 
-	if _, err := s.Pool.Exec(ctx, `
+	if keyed {
+		prior, err := s.claimIdempotency(ctx, tx, IdemOpRecharge, coinID, coinType, dataID, requestHash(IdemOpRecharge, coinID, coinType, amount, userID, expiryKey, versionKey(expectedVersion)))
+		if err != nil {
+			return nil, err
+		}
+		if prior != nil {
+			var acc Account
+			if err := json.Unmarshal(prior, &acc); err != nil {
+				return nil, err
+			}
+			return &acc, nil
+		}
+	}
+
+	locked, err := lockForCredit(ctx, tx, coinID, coinType, expectedVersion)
+	if err != nil {
+		log.Error("Recharge: select failed", slog.String("coinID", coinID), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if err := checkStatus(coinID, locked.status, false); err != nil {
+		return nil, err
+	}
+	var balance int64
+	if locked.shards > 0 {
+		if balance, err = s.creditShard(ctx, tx, coinID, coinType, locked.shards, amount); err != nil {
+			return nil, err
+		}
+	} else if err := tx.QueryRow(ctx, `
 		UPDATE public.coins
-		SET coins = coins + $2,
+		SET coins = coins + $3,
+		    version = version + 1,
 		    last_recharge_date = NOW()
-		WHERE id=$1
-	`, coinID, amount); err != nil {
+		WHERE id=$1 AND coin_type=$2
+		RETURNING coins
+	`, coinID, coinType, amount).Scan(&balance); err != nil {
 		log.Error("Recharge: update failed", slog.String("coinID", coinID), slog.String("error", err.Error()))
 		return nil, err
 	}
-	acc, err := s.GetAccount(ctx, coinID)
+	if err := s.insertLedger(ctx, tx, coinID, coinType, amount, balance, userID, dataID, LedgerKindRecharge); err != nil {
+		return nil, err
+	}
+	if err := s.createLot(ctx, tx, coinID, coinType, amount, expiresAt, dataID); err != nil {
+		return nil, err
+	}
+	acc, err := s.getAccount(ctx, tx, coinID, coinType)
 	if err != nil {
 		log.Error("Recharge: readback failed", slog.String("coinID", coinID), slog.String("error", err.Error()))
 		return nil, err
 	}
+	if keyed {
+		if err := s.completeIdempotency(ctx, tx, IdemOpRecharge, coinID, coinType, dataID, acc); err != nil {
+			return nil, err
+		}
+	}
 
 	// Notify (positive amount)
-	if strings.TrimSpace(dataID) == "" {
-		dataID = fmt.Sprintf("recharge:%s:%d", coinID, time.Now().UnixNano())
+	var expiry time.Time
+	if expiresAt != nil {
+		expiry = *expiresAt
+	}
+	if err := s.notify(ctx, tx, userID, coinID, coinType, dataID, float64(amount), time.Now().UTC(), expiry); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Error("Recharge: commit failed", slog.String("error", err.Error()))
+		return nil, err
 	}
-	s.notify(ctx, userID, coinID, dataID, float64(amount), time.Now().UTC())
 
 	log.Info("Recharge: ok",
 		slog.String("coinID", coinID),
@@ -540,67 +973,231 @@ func (s *Store) Recharge(ctx context.Context, coinID string, amount int64, userI
 	return acc, nil
 }
 
-// BatchRecharge increases balances for many coinIDs and emits per-id notifications using caller-provided userID (UUID) and baseDataID.
-func (s *Store) BatchRecharge(ctx context.Context, coinIDs []string, amount int64, userID, baseDataID string) (int64, error) {
+// BatchRechargeItem is one credit of a BatchRecharge.
+type BatchRechargeItem struct {
+	ID     string
+	Amount int64
+}
+
+// BatchRecharge item outcomes.
+const (
+	BatchCredited = "credited"
+	BatchNotFound = "not_found"
+	BatchRejected = "rejected"
+)
+
+// BatchRechargeResult is the outcome of one BatchRecharge item; Coins is the new balance of credited ids.
+type BatchRechargeResult struct {
+	ID     string `json:"id"`
+	Amount int64  `json:"amount"`
+	Status string `json:"status"` // credited | not_found | rejected
+	Coins  *int64 `json:"coins"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BatchRecharge credits many coinIDs, each by its own amount, and returns one result per item in
+// input order. Items with a non-positive amount, a repeated id or a closed account are rejected;
+// the rest are credited together. Only credited ids get a ledger entry and a notification, using
+// caller-provided userID (UUID) and baseDataID.
+func (s *Store) BatchRecharge(ctx context.Context, items []BatchRechargeItem, coinType, userID, baseDataID string) ([]*BatchRechargeResult, error) {
 	log := s.logger()
 	start := time.Now()
+	coinType = coinTypeOrDefault(coinType)
 	log.Info("BatchRecharge: start",
-		slog.Int("ids", len(coinIDs)),
-		slog.Int64("amount", amount),
+		slog.Int("items", len(items)),
+		slog.String("coinType", coinType),
 		slog.String("userID_in", userID),
 		slog.String("baseDataID_in", baseDataID),
 	)
-	if amount <= 0 {
-		return 0, errors.New("batchRecharge: amount must be > 0")
+	if len(items) == 0 {
+		return nil, errors.New("batchRecharge: no items")
 	}
 	if strings.TrimSpace(userID) == "" {
-		return 0, errors.New("userID is required (UUID)")
+		return nil, errors.New("userID is required (UUID)")
 	}
 	uid, err := canonicalUUID(userID)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	userID = uid
+	expiresAt, err := s.defaultExpiry(nil)
+	if err != nil {
+		return nil, err
+	}
 
-	tag, err := s.Pool.Exec(ctx, `
-		UPDATE public.coins
-		SET coins = coins + $2,
+	now := time.Now().UTC()
+	dataIDFor := func(cid string) string {
+		if strings.TrimSpace(baseDataID) == "" {
+			return fmt.Sprintf("batchrecharge:%s:%d", cid, now.UnixNano())
+		}
+		// make it unique-ish per id
+		return fmt.Sprintf("%s:%s", baseDataID, cid)
+	}
+
+	results := make([]*BatchRechargeResult, len(items))
+	byID := map[string]*BatchRechargeResult{}
+	var ids []string
+	var amounts []int64
+	for i, it := range items {
+		r := &BatchRechargeResult{ID: it.ID, Amount: it.Amount, Status: BatchRejected}
+		results[i] = r
+		switch {
+		case it.Amount <= 0:
+			r.Error = "amount must be > 0"
+		case byID[it.ID] != nil:
+			r.Error = "duplicate id in batch"
+		default:
+			r.Status = BatchNotFound
+			byID[it.ID] = r
+			ids = append(ids, it.ID)
+			amounts = append(amounts, it.Amount)
+		}
+	}
+
+	tx, err := s.conn(ctx).Begin(ctx)
+	if err != nil {
+		log.Error("BatchRecharge: begin tx failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err := unarchive(ctx, tx, ids...); err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(ctx, `
+		UPDATE public.coins c
+		SET coins = c.coins + v.amount,
+		    version = c.version + 1,
 		    last_recharge_date = NOW()
-		WHERE id = ANY($1)
-	`, coinIDs, amount)
+		FROM unnest($1::text[], $2::bigint[]) AS v(id, amount)
+		WHERE c.id = v.id AND c.coin_type = $3 AND c.status <> 'closed' AND c.shards = 0
+		RETURNING c.id, c.coins
+	`, ids, amounts, coinType)
 	if err != nil {
 		log.Error("BatchRecharge: update failed", slog.String("error", err.Error()))
-		return 0, err
+		return nil, err
+	}
+	for rows.Next() {
+		var id string
+		var coins int64
+		if err := rows.Scan(&id, &coins); err != nil {
+			rows.Close()
+			log.Error("BatchRecharge: scan failed", slog.String("error", err.Error()))
+			return nil, err
+		}
+		r := byID[id]
+		r.Status = BatchCredited
+		r.Coins = &coins
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Error("BatchRecharge: rows err", slog.String("error", err.Error()))
+		return nil, err
 	}
 
-	// Per-id notifications
-	now := time.Now().UTC()
-	for _, cid := range coinIDs {
-		dataID := baseDataID
-		if strings.TrimSpace(dataID) == "" {
-			dataID = fmt.Sprintf("batchrecharge:%s:%d", cid, now.UnixNano())
-		} else {
-			// make it unique-ish per id
-			dataID = fmt.Sprintf("%s:%s", baseDataID, cid)
+	// sharded balances are share-locked and credited one shard each
+	type shardedItem struct {
+		id     string
+		shards int
+	}
+	var sharded []shardedItem
+	rows, err = tx.Query(ctx, `
+		SELECT id, shards FROM public.coins
+		WHERE id = ANY($1) AND coin_type = $2 AND status <> 'closed' AND shards > 0
+		ORDER BY id
+		FOR SHARE
+	`, ids, coinType)
+	if err != nil {
+		log.Error("BatchRecharge: sharded lookup failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	for rows.Next() {
+		var it shardedItem
+		if err := rows.Scan(&it.id, &it.shards); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		sharded = append(sharded, it)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, it := range sharded {
+		r := byID[it.id]
+		coins, err := s.creditShard(ctx, tx, it.id, coinType, it.shards, r.Amount)
+		if err != nil {
+			return nil, err
 		}
-		s.notify(ctx, userID, cid, dataID, float64(amount), now)
+		r.Status = BatchCredited
+		r.Coins = &coins
+	}
+
+	// ids that weren't credited either don't hold coinType or are closed
+	rows, err = tx.Query(ctx, `
+		SELECT id FROM public.coins WHERE id = ANY($1) AND coin_type = $2 AND status = 'closed'
+	`, ids, coinType)
+	if err != nil {
+		log.Error("BatchRecharge: status lookup failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		byID[id].Status = BatchRejected
+		byID[id].Error = ErrAccountClosed.Error()
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var batchExpiry time.Time
+	if expiresAt != nil {
+		batchExpiry = *expiresAt
+	}
+	var credited int
+	for _, r := range results {
+		if r.Status != BatchCredited {
+			continue
+		}
+		credited++
+		if err := s.insertLedger(ctx, tx, r.ID, coinType, r.Amount, *r.Coins, userID, dataIDFor(r.ID), LedgerKindBatchRecharge); err != nil {
+			return nil, err
+		}
+		if err := s.createLot(ctx, tx, r.ID, coinType, r.Amount, expiresAt, dataIDFor(r.ID)); err != nil {
+			return nil, err
+		}
+		if err := s.notify(ctx, tx, userID, r.ID, coinType, dataIDFor(r.ID), float64(r.Amount), now, batchExpiry); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Error("BatchRecharge: commit failed", slog.String("error", err.Error()))
+		return nil, err
 	}
 
-	rows := tag.RowsAffected()
 	log.Info("BatchRecharge: ok",
-		slog.Int64("rowsAffected", rows),
+		slog.Int("credited", credited),
+		slog.Int("failed", len(items)-credited),
 		slog.Duration("dur", time.Since(start)),
 	)
-	return rows, nil
+	return results, nil
 }
 
 // Use decreases balance (depletion) and emits a transaction using caller-provided userID (UUID) and dataID.
-func (s *Store) Use(ctx context.Context, coinID string, amount int64, userID, dataID string) (*Account, error) {
+func (s *Store) Use(ctx context.Context, coinID, coinType string, amount int64, expectedVersion *int64, userID, dataID string) (*Account, error) {
 	log := s.logger()
 	start := time.Now()
+	coinType = coinTypeOrDefault(coinType)
 	log.Info("Use: start",
 		slog.String("coinID", coinID),
+		slog.String("coinType", coinType),
 		slog.Int64("amount", amount),
+		slog.Any("expectedVersion", expectedVersion),
 		slog.String("userID_in", userID),
 		slog.String("dataID_in", dataID),
 	)
@@ -615,49 +1212,100 @@ func (s *Store) Use(ctx context.Context, coinID string, amount int64, userID, da
 		return nil, err
 	}
 	userID = uid
+	keyed := strings.TrimSpace(dataID) != ""
+	if !keyed {
+		dataID = fmt.Sprintf("use:%s:%d", coinID, time.Now().UnixNano())
+	}
 
-	tx, err := s.Pool.Begin(ctx)
+	tx, err := s.conn(ctx).Begin(ctx)
 	if err != nil {
 		log.Error("Use: begin tx failed", slog.String("error", err.Error()))
 		return nil, err
 	}
 	defer func() { _ = tx.Rollback(ctx) }()
 
-	var coins int64
-	if err := tx.QueryRow(ctx, `
-		SELECT coins FROM public.coins WHERE id=$1 FOR UPDATE
-	`, coinID).Scan(&coins); err != nil {
+	if keyed {
+		prior, err := s.claimIdempotency(ctx, tx, IdemOpUse, coinID, coinType, dataID, requestHash(IdemOpUse, coinID, coinType, amount, userID, versionKey(expectedVersion)))
+		if err != nil {
+			return nil, err
+		}
+		if prior != nil {
+			var acc Account
+			if err := json.Unmarshal(prior, &acc); err != nil {
+				return nil, err
+			}
+			return &acc, nil
+		}
+	}
+
+	locked, err := lockAccount(ctx, tx, coinID, coinType, expectedVersion)
+	if err != nil {
 		log.Error("Use: select failed", slog.String("coinID", coinID), slog.String("error", err.Error()))
 		return nil, err
 	}
-	if coins < amount {
-		return nil, fmt.Errorf("use: insufficient balance (have %d, need %d)", coins, amount)
+	if err := checkStatus(coinID, locked.status, true); err != nil {
+		return nil, fmt.Errorf("use: %w", err)
+	}
+	if err := s.checkSpendLimits(ctx, tx, coinID, coinType, amount); err != nil {
+		return nil, fmt.Errorf("use: %w", err)
+	}
+	held, err := heldAmount(ctx, tx, coinID, coinType)
+	if err != nil {
+		log.Error("Use: held sum failed", slog.String("coinID", coinID), slog.String("error", err.Error()))
+		return nil, err
+	}
+	// a shared wallet member tops up from its parent before dipping into its credit limit
+	drawn, err := s.drawFromPool(ctx, tx, coinID, coinType, locked, amount-max(locked.coins-held, 0), userID, dataID)
+	if err != nil {
+		return nil, fmt.Errorf("use: %w", err)
+	}
+	coins := locked.coins
+	if coins-held+locked.creditLimit < amount {
+		return nil, fmt.Errorf("use: insufficient balance (have %d, held %d, credit limit %d, drawn from pool %d, need %d)", coins, held, locked.creditLimit, drawn, amount)
+	}
+	rest, err := s.drawShards(ctx, tx, coinID, coinType, locked, amount)
+	if err != nil {
+		return nil, err
 	}
 	if _, err := tx.Exec(ctx, `
 		UPDATE public.coins
-		SET coins = coins - $2,
+		SET coins = coins - $3,
+		    version = version + 1,
 		    last_usage_date = NOW()
-		WHERE id=$1
-	`, coinID, amount); err != nil {
+		WHERE id=$1 AND coin_type=$2
+	`, coinID, coinType, rest); err != nil {
 		log.Error("Use: update failed", slog.String("coinID", coinID), slog.String("error", err.Error()))
 		return nil, err
 	}
-	if err := tx.Commit(ctx); err != nil {
-		log.Error("Use: commit failed", slog.String("error", err.Error()))
+	// coins drawn from the pool were credited unlotted and are spent first
+	if err := s.consumeLots(ctx, tx, coinID, coinType, amount-drawn); err != nil {
 		return nil, err
 	}
-
-	acc, err := s.GetAccount(ctx, coinID)
+	if err := s.insertLedger(ctx, tx, coinID, coinType, -amount, coins-amount, userID, dataID, LedgerKindUse); err != nil {
+		return nil, err
+	}
+	if err := s.notifyOverdrawn(ctx, tx, userID, coinID, coinType, coins, coins-amount); err != nil {
+		return nil, err
+	}
+	acc, err := s.getAccount(ctx, tx, coinID, coinType)
 	if err != nil {
 		log.Error("Use: readback failed", slog.String("coinID", coinID), slog.String("error", err.Error()))
 		return nil, err
 	}
+	if keyed {
+		if err := s.completeIdempotency(ctx, tx, IdemOpUse, coinID, coinType, dataID, acc); err != nil {
+			return nil, err
+		}
+	}
 
 	// Notify (positive amount)
-	if strings.TrimSpace(dataID) == "" {
-		dataID = fmt.Sprintf("use:%s:%d", coinID, time.Now().UnixNano())
+	if err := s.notify(ctx, tx, userID, coinID, coinType, dataID, float64(amount), time.Now().UTC(), time.Time{}); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Error("Use: commit failed", slog.String("error", err.Error()))
+		return nil, err
 	}
-	s.notify(ctx, userID, coinID, dataID, float64(amount), time.Now().UTC())
 
 	log.Info("Use: ok",
 		slog.String("coinID", coinID),
@@ -667,14 +1315,25 @@ func (s *Store) Use(ctx context.Context, coinID string, amount int64, userID, da
 	return acc, nil
 }
 
-// Transfer moves coins between ids and emits two notifications using caller-provided userID (UUID) and dataID.
-func (s *Store) Transfer(ctx context.Context, fromID, toID string, amount int64, userID, dataID string) (*Account, *Account, error) {
+// transferResponse is the idempotent replay payload of Transfer.
+type transferResponse struct {
+	From *Account `json:"from"`
+	To   *Account `json:"to"`
+}
+
+// Transfer moves coins of one type between ids and emits two notifications using caller-provided userID (UUID) and dataID.
+// A non-nil expectedVersion is checked against the source account. The coins keep their
+// expiry: the receiver gets a lot for each lot they were drawn from.
+func (s *Store) Transfer(ctx context.Context, fromID, toID, coinType string, amount int64, expectedVersion *int64, userID, dataID string) (*Account, *Account, error) {
 	log := s.logger()
 	start := time.Now()
+	coinType = coinTypeOrDefault(coinType)
 	log.Info("Transfer: start",
 		slog.String("from", fromID),
 		slog.String("to", toID),
+		slog.String("coinType", coinType),
 		slog.Int64("amount", amount),
+		slog.Any("expectedVersion", expectedVersion),
 		slog.String("userID_in", userID),
 		slog.String("dataID_in", dataID),
 	)
@@ -690,74 +1349,144 @@ func (s *Store) Transfer(ctx context.Context, fromID, toID string, amount int64,
 	}
 	userID = uid
 
-	tx, err := s.Pool.Begin(ctx)
+	// Keep event ids distinct for the two legs
+	now := time.Now().UTC()
+	keyed := strings.TrimSpace(dataID) != ""
+	outDataID := dataID
+	inDataID := dataID
+	if strings.TrimSpace(outDataID) == "" {
+		outDataID = fmt.Sprintf("transfer:out:%s->%s:%d", fromID, toID, now.UnixNano())
+	}
+	if strings.TrimSpace(inDataID) == "" {
+		inDataID = fmt.Sprintf("transfer:in:%s->%s:%d", fromID, toID, now.UnixNano())
+	} else {
+		// suffix to avoid identical data ids for two events
+		inDataID = inDataID + ":in"
+		outDataID = outDataID + ":out"
+	}
+
+	tx, err := s.conn(ctx).Begin(ctx)
 	if err != nil {
 		log.Error("Transfer: begin tx failed", slog.String("error", err.Error()))
 		return nil, nil, err
 	}
 	defer func() { _ = tx.Rollback(ctx) }()
 
-	var fromCoins int64
-	if err := tx.QueryRow(ctx, `
-		SELECT coins FROM public.coins WHERE id=$1 FOR UPDATE
-	`, fromID).Scan(&fromCoins); err != nil {
+	if keyed {
+		prior, err := s.claimIdempotency(ctx, tx, IdemOpTransfer, fromID, coinType, dataID, requestHash(IdemOpTransfer, fromID, toID, coinType, amount, userID, versionKey(expectedVersion)))
+		if err != nil {
+			return nil, nil, err
+		}
+		if prior != nil {
+			var res transferResponse
+			if err := json.Unmarshal(prior, &res); err != nil {
+				return nil, nil, err
+			}
+			return res.From, res.To, nil
+		}
+	}
+
+	locked, err := lockAccount(ctx, tx, fromID, coinType, expectedVersion)
+	if err != nil {
 		log.Error("Transfer: select from failed", slog.String("from", fromID), slog.String("error", err.Error()))
 		return nil, nil, err
 	}
-	if fromCoins < amount {
+	fromCoins := locked.coins
+	if err := checkStatus(fromID, locked.status, true); err != nil {
+		return nil, nil, fmt.Errorf("transfer: %w", err)
+	}
+	if err := s.checkSpendLimits(ctx, tx, fromID, coinType, amount); err != nil {
+		return nil, nil, fmt.Errorf("transfer: %w", err)
+	}
+	fromHeld, err := heldAmount(ctx, tx, fromID, coinType)
+	if err != nil {
+		log.Error("Transfer: held sum failed", slog.String("from", fromID), slog.String("error", err.Error()))
+		return nil, nil, err
+	}
+	if fromCoins-fromHeld+locked.creditLimit < amount {
 		return nil, nil, fmt.Errorf("transfer: insufficient balance on %s", fromID)
 	}
+	rest, err := s.drawShards(ctx, tx, fromID, coinType, locked, amount)
+	if err != nil {
+		return nil, nil, err
+	}
 	if _, err := tx.Exec(ctx, `
 		UPDATE public.coins
-		SET coins = coins - $2,
+		SET coins = coins - $3,
+		    version = version + 1,
 		    last_usage_date = NOW()
-		WHERE id=$1
-	`, fromID, amount); err != nil {
+		WHERE id=$1 AND coin_type=$2
+	`, fromID, coinType, rest); err != nil {
 		log.Error("Transfer: debit failed", slog.String("from", fromID), slog.String("error", err.Error()))
 		return nil, nil, err
 	}
-	if _, err := tx.Exec(ctx, `
+	draws, err := s.takeLots(ctx, tx, fromID, coinType, amount)
+	if err != nil {
+		return nil, nil, err
+	}
+	toLocked, err := lockForCredit(ctx, tx, toID, coinType, nil)
+	if err != nil {
+		log.Error("Transfer: select to failed", slog.String("to", toID), slog.String("error", err.Error()))
+		return nil, nil, err
+	}
+	if err := checkStatus(toID, toLocked.status, false); err != nil {
+		return nil, nil, fmt.Errorf("transfer: %w", err)
+	}
+	var toCoins int64
+	if toLocked.shards > 0 {
+		if toCoins, err = s.creditShard(ctx, tx, toID, coinType, toLocked.shards, amount); err != nil {
+			return nil, nil, err
+		}
+	} else if err := tx.QueryRow(ctx, `
 		UPDATE public.coins
-		SET coins = coins + $2,
+		SET coins = coins + $3,
+		    version = version + 1,
 		    last_recharge_date = NOW()
-		WHERE id=$1
-	`, toID, amount); err != nil {
+		WHERE id=$1 AND coin_type=$2
+		RETURNING coins
+	`, toID, coinType, amount).Scan(&toCoins); err != nil {
 		log.Error("Transfer: credit failed", slog.String("to", toID), slog.String("error", err.Error()))
 		return nil, nil, err
 	}
-	if err := tx.Commit(ctx); err != nil {
-		log.Error("Transfer: commit failed", slog.String("error", err.Error()))
+	if err := s.carryLots(ctx, tx, toID, coinType, draws, inDataID); err != nil {
 		return nil, nil, err
 	}
-
-	from, err := s.GetAccount(ctx, fromID)
+	if err := s.insertLedger(ctx, tx, fromID, coinType, -amount, fromCoins-amount, userID, outDataID, LedgerKindTransferOut); err != nil {
+		return nil, nil, err
+	}
+	if err := s.insertLedger(ctx, tx, toID, coinType, amount, toCoins, userID, inDataID, LedgerKindTransferIn); err != nil {
+		return nil, nil, err
+	}
+	if err := s.notifyOverdrawn(ctx, tx, userID, fromID, coinType, fromCoins, fromCoins-amount); err != nil {
+		return nil, nil, err
+	}
+	from, err := s.getAccount(ctx, tx, fromID, coinType)
 	if err != nil {
 		log.Error("Transfer: readback from failed", slog.String("from", fromID), slog.String("error", err.Error()))
 		return nil, nil, err
 	}
-	to, err := s.GetAccount(ctx, toID)
+	to, err := s.getAccount(ctx, tx, toID, coinType)
 	if err != nil {
 		log.Error("Transfer: readback to failed", slog.String("to", toID), slog.String("error", err.Error()))
 		return nil, nil, err
 	}
+	if keyed {
+		if err := s.completeIdempotency(ctx, tx, IdemOpTransfer, fromID, coinType, dataID, transferResponse{From: from, To: to}); err != nil {
+			return nil, nil, err
+		}
+	}
 
-	// Notifications (both positive coinUsed), keep event ids distinct
-	now := time.Now().UTC()
-	outDataID := dataID
-	inDataID := dataID
-	if strings.TrimSpace(outDataID) == "" {
-		outDataID = fmt.Sprintf("transfer:out:%s->%s:%d", fromID, toID, now.UnixNano())
+	// Notifications (both positive coinUsed)
+	if err := s.notify(ctx, tx, userID, fromID, coinType, outDataID, float64(amount), now, time.Time{}); err != nil {
+		return nil, nil, err
 	}
-	if strings.TrimSpace(inDataID) == "" {
-		inDataID = fmt.Sprintf("transfer:in:%s->%s:%d", fromID, toID, now.UnixNano())
-	} else {
-		// suffix to avoid identical data ids for two events
-		inDataID = inDataID + ":in"
-		outDataID = outDataID + ":out"
+	if err := s.notify(ctx, tx, userID, toID, coinType, inDataID, float64(amount), now, time.Time{}); err != nil {
+		return nil, nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Error("Transfer: commit failed", slog.String("error", err.Error()))
+		return nil, nil, err
 	}
-
-	s.notify(ctx, userID, fromID, outDataID, float64(amount), now)
-	s.notify(ctx, userID, toID, inDataID, float64(amount), now)
 
 	log.Info("Transfer: ok",
 		slog.String("from", fromID),
@@ -768,17 +1497,25 @@ func (s *Store) Transfer(ctx context.Context, fromID, toID string, amount int64,
 	return from, to, nil
 }
 
-func (s *Store) TouchUsage(ctx context.Context, id string) (*Account, error) {
+func (s *Store) TouchUsage(ctx context.Context, id, coinType string, expectedVersion *int64) (*Account, error) {
 	log := s.logger()
 	start := time.Now()
-	log.Debug("TouchUsage: start", slog.String("id", id))
-	if _, err := s.Pool.Exec(ctx, `
-		UPDATE public.coins SET last_usage_date = NOW() WHERE id=$1
-	`, id); err != nil {
+	coinType = coinTypeOrDefault(coinType)
+	log.Debug("TouchUsage: start", slog.String("id", id), slog.String("coinType", coinType), slog.Any("expectedVersion", expectedVersion))
+	tag, err := s.conn(ctx).Exec(ctx, `
+		UPDATE public.coins SET last_usage_date = NOW(), version = version + 1
+		WHERE id=$1 AND coin_type=$2 AND ($3::bigint IS NULL OR version = $3::bigint)
+	`, id, coinType, expectedVersion)
+	if err != nil {
 		log.Error("TouchUsage: update failed", slog.String("id", id), slog.String("error", err.Error()))
 		return nil, err
 	}
-	acc, err := s.GetAccount(ctx, id)
+	if tag.RowsAffected() == 0 && expectedVersion != nil {
+		if exists, err := s.userExists(ctx, s.conn(ctx), id); err == nil && exists {
+			return nil, fmt.Errorf("touchUsage %s/%s: %w", id, coinType, ErrVersionConflict)
+		}
+	}
+	acc, err := s.getAccount(ctx, s.conn(ctx), id, coinType)
 	if err != nil {
 		log.Error("TouchUsage: readback failed", slog.String("id", id), slog.String("error", err.Error()))
 		return nil, err
@@ -0,0 +1,255 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"log/slog"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// --------------------------------------------
+// Personal data export and erasure
+// --------------------------------------------
+
+// ErrAccountNotClosed is returned when erasing an account that still has open balances.
+var ErrAccountNotClosed = errors.New("account is not closed")
+
+// AccountExport is everything stored under one account id.
+type AccountExport struct {
+	AccountID     string              `json:"accountId"`
+	ExportedAt    time.Time           `json:"exportedAt"`
+	Balances      []*Account          `json:"balances"`
+	Ledger        []*LedgerEntry      `json:"ledger"` // oldest first, every coin type
+	Lots          []*CoinLot          `json:"lots"`
+	Holds         []*Hold             `json:"holds"`
+	Notifications []*OutboxEntry      `json:"notifications"`
+	Schedules     []*RechargeSchedule `json:"rechargeSchedules"`
+	SpendLimits   []*SpendLimit       `json:"spendLimits"` // the account's own, not the defaults
+}
+
+// Erasure reports an erased account.
+type Erasure struct {
+	Pseudonym string `json:"pseudonym"` // the id that replaced the account id
+	Balances  int    `json:"balances"`  // balance rows renamed
+	Records   int64  `json:"records"`   // historical rows re-keyed (ledger, lots, holds, notifications, ...)
+}
+
+// erasedTables hold rows keyed by account_id that an erasure re-keys.
+var erasedTables = []string{
+	"coin_ledger", "coin_lots", "coin_holds", "tx_outbox", "coin_balance_snapshots",
+	"coin_spend_limits", "coin_recharge_schedules",
+}
+
+// dataIDTables hold data_ids that may embed an account id ("close:<id>:...", "transfer:out:<a>-><b>:...").
+var dataIDTables = []string{"coin_ledger", "coin_lots", "coin_holds", "tx_outbox"}
+
+// dataIDPattern matches id as one segment of a data_id. The groups keep the delimiters.
+func dataIDPattern(id string) string {
+	return `(^|[:>])` + regexp.QuoteMeta(id) + `($|:|->)`
+}
+
+func scanSpendLimit(row pgx.Row) (*SpendLimit, error) {
+	var l SpendLimit
+	if err := row.Scan(&l.AccountID, &l.CoinType, &l.WindowSeconds, &l.MaxAmount, &l.MaxCount); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// collectRows runs a query and scans every row with scan.
+func collectRows[T any](ctx context.Context, q querier, scan func(pgx.Row) (*T, error), sql string, args ...any) ([]*T, error) {
+	rows, err := q.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []*T{}
+	for rows.Next() {
+		v, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+// ExportAccount collects everything stored under account id: its balances with metadata
+// and labels, its whole ledger, lots, holds, notifications, recharge schedules and spend
+// limits. It reads one consistent snapshot from the primary. It returns nil if nothing is
+// stored under id.
+func (s *Store) ExportAccount(ctx context.Context, id string) (*AccountExport, error) {
+	log := s.logger()
+	start := time.Now()
+	log.Info("ExportAccount: start", slog.String("id", id))
+
+	tx, err := s.Pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		log.Error("ExportAccount: begin tx failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	out := &AccountExport{AccountID: id, ExportedAt: time.Now().UTC()}
+	out.Balances, err = s.listBalances(ctx, tx, id)
+	if err == nil && len(out.Balances) == 0 {
+		// an archived account is exported as archived, without restoring it
+		out.Balances, err = collectRows(ctx, tx, scanAccount, `
+			SELECT `+accountColumns+`
+			FROM public.archived_coins a, jsonb_populate_record(NULL::public.coins, a.row) c
+			WHERE a.id=$1 ORDER BY c.coin_type
+		`, id)
+	}
+	if err == nil {
+		out.Ledger, err = collectRows(ctx, tx, scanLedgerEntry, `SELECT `+ledgerColumns+` FROM public.coin_ledger WHERE account_id=$1 ORDER BY id`, id)
+	}
+	if err == nil {
+		out.Lots, err = collectRows(ctx, tx, scanLot, `SELECT `+lotColumns+` FROM public.coin_lots WHERE account_id=$1 ORDER BY id`, id)
+	}
+	if err == nil {
+		out.Holds, err = collectRows(ctx, tx, scanHold, `SELECT `+holdColumns+` FROM public.coin_holds WHERE account_id=$1 ORDER BY created_at, id`, id)
+	}
+	if err == nil {
+		out.Notifications, err = collectRows(ctx, tx, scanOutbox, `SELECT `+outboxColumns+` FROM public.tx_outbox WHERE account_id=$1 ORDER BY id`, id)
+	}
+	if err == nil {
+		out.Schedules, err = collectRows(ctx, tx, scanSchedule, `SELECT `+scheduleColumns+` FROM public.coin_recharge_schedules WHERE account_id=$1 ORDER BY created_at, id`, id)
+	}
+	if err == nil {
+		out.SpendLimits, err = collectRows(ctx, tx, scanSpendLimit, `
+			SELECT account_id, coin_type, window_seconds, max_amount, max_count FROM public.coin_spend_limits
+			WHERE account_id=$1 ORDER BY coin_type, window_seconds
+		`, id)
+	}
+	if err != nil {
+		log.Error("ExportAccount: query failed", slog.String("id", id), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if out.Balances == nil {
+		out.Balances = []*Account{}
+	}
+	if out.empty() {
+		log.Info("ExportAccount: not found", slog.String("id", id), slog.Duration("dur", time.Since(start)))
+		return nil, nil
+	}
+	log.Info("ExportAccount: ok", slog.String("id", id), slog.Int("balances", len(out.Balances)), slog.Int("ledger", len(out.Ledger)), slog.Duration("dur", time.Since(start)))
+	return out, nil
+}
+
+func (e *AccountExport) empty() bool {
+	return len(e.Balances)+len(e.Ledger)+len(e.Lots)+len(e.Holds)+len(e.Notifications)+len(e.Schedules)+len(e.SpendLimits) == 0
+}
+
+// EraseAccount anonymizes account id for good: its balance rows and every historical row
+// keyed by it (ledger, lots, holds, notifications, snapshots, spend limits, schedules) are
+// moved to a random pseudonym, and so are the data_ids and audit entries that name it. Its
+// metadata and labels are cleared, its schedules disabled and its idempotency keys dropped.
+// Nothing is deleted, so totals such as SumCoins and SumCoinsAsOf don't change. Every balance
+// must be closed first (CloseAccount sweeps what is left); a deleted account's history can be
+// erased too.
+func (s *Store) EraseAccount(ctx context.Context, id string) (*Erasure, error) {
+	log := s.logger()
+	start := time.Now()
+	log.Info("EraseAccount: start", slog.String("id", id))
+
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		log.Error("EraseAccount: begin tx failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err := unarchive(ctx, tx, id); err != nil {
+		return nil, err
+	}
+	var open, balances int
+	if err := tx.QueryRow(ctx, `
+		WITH locked AS (SELECT status FROM public.coins WHERE id=$1 FOR UPDATE)
+		SELECT COUNT(*) FILTER (WHERE status <> 'closed'), COUNT(*) FROM locked
+	`, id).Scan(&open, &balances); err != nil {
+		log.Error("EraseAccount: select failed", slog.String("id", id), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if open > 0 {
+		return nil, fmt.Errorf("erase: %s: %w", id, ErrAccountNotClosed)
+	}
+	if balances == 0 {
+		var seen bool
+		if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM public.coin_ledger WHERE account_id=$1)`, id).Scan(&seen); err != nil {
+			return nil, err
+		}
+		if !seen {
+			return nil, fmt.Errorf("erase: account %s not found", id)
+		}
+	}
+
+	out := &Erasure{Pseudonym: "erased-" + uuid.NewString(), Balances: balances}
+	if _, err := tx.Exec(ctx, `
+		UPDATE public.coins SET id=$2, metadata='{}'::jsonb, labels='{}'::jsonb, version = version + 1 WHERE id=$1
+	`, id, out.Pseudonym); err != nil {
+		log.Error("EraseAccount: rename failed", slog.String("id", id), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE public.coins SET parent_id=$2 WHERE parent_id=$1`, id, out.Pseudonym); err != nil {
+		return nil, err
+	}
+	for _, table := range erasedTables {
+		tag, err := tx.Exec(ctx, `UPDATE public.`+table+` SET account_id=$2 WHERE account_id=$1`, id, out.Pseudonym)
+		if err != nil {
+			log.Error("EraseAccount: re-key failed", slog.String("table", table), slog.String("error", err.Error()))
+			return nil, err
+		}
+		out.Records += tag.RowsAffected()
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE public.coin_recharge_schedules SET enabled=FALSE, updated_at=NOW() WHERE account_id=$1 AND enabled
+	`, out.Pseudonym); err != nil {
+		return nil, err
+	}
+	// data_ids are scanned in full; erasures are rare
+	pattern := dataIDPattern(id)
+	for _, table := range dataIDTables {
+		if _, err := tx.Exec(ctx, `
+			UPDATE public.`+table+` SET data_id = regexp_replace(data_id, $1, '\1' || $2 || '\2', 'g') WHERE data_id ~ $1
+		`, pattern, out.Pseudonym); err != nil {
+			log.Error("EraseAccount: data_id scrub failed", slog.String("table", table), slog.String("error", err.Error()))
+			return nil, err
+		}
+	}
+	// stored responses and audit documents name the account as a JSON string
+	if _, err := tx.Exec(ctx, `DELETE FROM public.idempotency_keys WHERE account_id=$1`, id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `
+		WITH j AS (SELECT to_jsonb($1::text)::text AS old, to_jsonb($2::text)::text AS new)
+		UPDATE public.idempotency_keys SET response = replace(response::text, j.old, j.new)::jsonb
+		FROM j WHERE strpos(response::text, j.old) > 0
+	`, id, out.Pseudonym); err != nil {
+		log.Error("EraseAccount: idempotency scrub failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `
+		WITH j AS (SELECT to_jsonb($1::text)::text AS old, to_jsonb($2::text)::text AS new)
+		UPDATE public.admin_audit
+		SET args = replace(args::text, j.old, j.new)::jsonb,
+		    before = replace(before::text, j.old, j.new)::jsonb,
+		    after = replace(after::text, j.old, j.new)::jsonb
+		FROM j
+		WHERE strpos(args::text, j.old) > 0 OR strpos(before::text, j.old) > 0 OR strpos(after::text, j.old) > 0
+	`, id, out.Pseudonym); err != nil {
+		log.Error("EraseAccount: audit scrub failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Error("EraseAccount: commit failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	log.Info("EraseAccount: ok", slog.String("pseudonym", out.Pseudonym), slog.Int("balances", out.Balances), slog.Int64("records", out.Records), slog.Duration("dur", time.Since(start)))
+	return out, nil
+}
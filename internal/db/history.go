@@ -0,0 +1,145 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"log/slog"
+)
+
+// --------------------------------------------
+// Point-in-time balances (snapshots + ledger)
+// --------------------------------------------
+
+// GetAccountAsOf reconstructs the balance of one coin type of an account at time at:
+// the latest snapshot taken by then plus the ledger deltas recorded after it.
+// Only ID, CoinType, Coins and Available are set. It returns nil if the account had
+// no recorded balance at that time.
+func (s *Store) GetAccountAsOf(ctx context.Context, id, coinType string, at time.Time) (*Account, error) {
+	log := s.logger()
+	start := time.Now()
+	coinType = coinTypeOrDefault(coinType)
+	log.Debug("GetAccountAsOf: query", slog.String("id", id), slog.String("coinType", coinType), slog.Time("at", at))
+
+	var (
+		snapCoins *int64
+		delta     int64
+		entries   int64
+	)
+	err := s.reader(ctx).QueryRow(ctx, `
+		WITH snap AS (
+			SELECT coins, ledger_id FROM public.coin_balance_snapshots
+			WHERE account_id=$1 AND coin_type=$2 AND taken_at <= $3
+			ORDER BY taken_at DESC
+			LIMIT 1
+		)
+		SELECT (SELECT coins FROM snap), COALESCE(SUM(l.delta), 0), COUNT(l.id)
+		FROM public.coin_ledger l
+		WHERE l.account_id=$1 AND l.coin_type=$2 AND l.created_at <= $3
+		  AND l.id > COALESCE((SELECT ledger_id FROM snap), 0)
+	`, id, coinType, at).Scan(&snapCoins, &delta, &entries)
+	if err != nil {
+		log.Error("GetAccountAsOf: query failed", slog.String("id", id), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if snapCoins == nil && entries == 0 {
+		log.Debug("GetAccountAsOf: no history", slog.String("id", id), slog.Duration("dur", time.Since(start)))
+		return nil, nil
+	}
+	coins := delta
+	if snapCoins != nil {
+		coins += *snapCoins
+	}
+	log.Debug("GetAccountAsOf: ok", slog.String("id", id), slog.Int64("coins", coins), slog.Duration("dur", time.Since(start)))
+	return &Account{ID: id, CoinType: coinType, Coins: coins, Available: coins}, nil
+}
+
+// SumCoinsAsOf returns the total balance of a coin type across all accounts at time at.
+func (s *Store) SumCoinsAsOf(ctx context.Context, coinType string, at time.Time) (int64, error) {
+	log := s.logger()
+	start := time.Now()
+	coinType = coinTypeOrDefault(coinType)
+	var sum int64
+	err := s.reader(ctx).QueryRow(ctx, `
+		WITH snap AS (
+			SELECT DISTINCT ON (account_id) account_id, coins, ledger_id
+			FROM public.coin_balance_snapshots
+			WHERE coin_type=$1 AND taken_at <= $2
+			ORDER BY account_id, taken_at DESC
+		)
+		SELECT COALESCE((SELECT SUM(coins) FROM snap), 0)::bigint
+		     + COALESCE((
+				SELECT SUM(l.delta)
+				FROM public.coin_ledger l
+				LEFT JOIN snap ON snap.account_id = l.account_id
+				WHERE l.coin_type=$1 AND l.created_at <= $2
+				  AND l.id > COALESCE(snap.ledger_id, 0)
+			), 0)::bigint
+	`, coinType, at).Scan(&sum)
+	if err != nil {
+		log.Error("SumCoinsAsOf: failed", slog.String("coinType", coinType), slog.String("error", err.Error()))
+		return 0, err
+	}
+	log.Debug("SumCoinsAsOf: ok", slog.String("coinType", coinType), slog.Time("at", at), slog.Int64("sum", sum), slog.Duration("dur", time.Since(start)))
+	return sum, nil
+}
+
+// SnapshotBalances records a snapshot of every balance whose ledger advanced since its
+// last snapshot and returns how many were written.
+func (s *Store) SnapshotBalances(ctx context.Context) (int64, error) {
+	log := s.logger()
+	start := time.Now()
+	// balance_after of an account's newest entry is its balance through that entry: entries
+	// of one account are written under its row lock, so ids follow the order they applied in.
+	// Only entries past the newest snapshot are scanned; an entry that commits late is still
+	// counted by GetAccountAsOf, it just waits for the account's next change to be snapshotted.
+	// Sharded balances are skipped: their credits run concurrently, so balance_after isn't in
+	// id order. ShardAccount snapshots them exactly when sharding changes.
+	tag, err := s.Pool.Exec(ctx, `
+		INSERT INTO public.coin_balance_snapshots (account_id, coin_type, taken_at, coins, ledger_id)
+		SELECT l.account_id, l.coin_type, NOW(), l.balance_after, l.id
+		FROM (
+			SELECT DISTINCT ON (account_id, coin_type) id, account_id, coin_type, balance_after
+			FROM public.coin_ledger
+			WHERE id > (SELECT COALESCE(MAX(ledger_id), 0) FROM public.coin_balance_snapshots)
+			ORDER BY account_id, coin_type, id DESC
+		) l
+		LEFT JOIN LATERAL (
+			SELECT ledger_id FROM public.coin_balance_snapshots s
+			WHERE s.account_id = l.account_id AND s.coin_type = l.coin_type
+			ORDER BY taken_at DESC
+			LIMIT 1
+		) last ON true
+		WHERE (last.ledger_id IS NULL OR l.id > last.ledger_id)
+		  AND NOT EXISTS (SELECT 1 FROM public.coin_shards sh WHERE sh.account_id = l.account_id AND sh.coin_type = l.coin_type)
+		ON CONFLICT DO NOTHING
+	`)
+	if err != nil {
+		log.Error("SnapshotBalances: failed", slog.String("error", err.Error()))
+		return 0, err
+	}
+	n := tag.RowsAffected()
+	if n > 0 {
+		log.Info("SnapshotBalances: ok", slog.Int64("snapshots", n), slog.Duration("dur", time.Since(start)))
+	}
+	return n, nil
+}
+
+// RunBalanceSnapshotter calls SnapshotBalances every interval until ctx is cancelled.
+func (s *Store) RunBalanceSnapshotter(ctx context.Context, interval time.Duration) {
+	log := s.logger()
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("balance snapshotter: stopped")
+			return
+		case <-t.C:
+			_, _ = s.SnapshotBalances(ctx)
+		}
+	}
+}
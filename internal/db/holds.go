@@ -0,0 +1,405 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"log/slog"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// --------------------------------------------
+// Balance holds (authorize / capture / void)
+// --------------------------------------------
+
+// DefaultHoldTTL applies when Authorize is called without a TTL.
+const DefaultHoldTTL = 15 * time.Minute
+
+// ErrHoldNotActive is returned when capturing or voiding a hold that is no longer active.
+var ErrHoldNotActive = errors.New("hold is not active")
+
+const holdColumns = `id, account_id, coin_type, amount, captured, status, user_id, data_id, expires_at, created_at, updated_at`
+
+func scanHold(row pgx.Row) (*Hold, error) {
+	var h Hold
+	if err := row.Scan(&h.ID, &h.AccountID, &h.CoinType, &h.Amount, &h.Captured, &h.Status, &h.UserID, &h.DataID, &h.ExpiresAt, &h.CreatedAt, &h.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// heldAmount sums the active, unexpired holds on one coin type of an account.
+// Callers lock the account row first.
+func heldAmount(ctx context.Context, tx pgx.Tx, accountID, coinType string) (int64, error) {
+	var held int64
+	err := tx.QueryRow(ctx, `
+		SELECT COALESCE(SUM(amount), 0) FROM public.coin_holds
+		WHERE account_id=$1 AND coin_type=$2 AND status='active' AND expires_at > NOW()
+	`, accountID, coinType).Scan(&held)
+	return held, err
+}
+
+func (s *Store) GetHold(ctx context.Context, id string) (*Hold, error) {
+	return s.getHold(ctx, s.reader(ctx), id)
+}
+
+func (s *Store) getHold(ctx context.Context, q querier, id string) (*Hold, error) {
+	log := s.logger()
+	h, err := scanHold(q.QueryRow(ctx, `SELECT `+holdColumns+` FROM public.coin_holds WHERE id=$1`, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		log.Error("GetHold: failed", slog.String("id", id), slog.String("error", err.Error()))
+		return nil, err
+	}
+	return h, nil
+}
+
+// bumpVersion bumps the version of a balance whose available amount a hold changed.
+func bumpVersion(ctx context.Context, tx pgx.Tx, id, coinType string) error {
+	_, err := tx.Exec(ctx, `UPDATE public.coins SET version = version + 1 WHERE id=$1 AND coin_type=$2`, id, coinType)
+	return err
+}
+
+// Authorize reserves amount on an account for ttl. The hold lowers the available balance
+// (what Use and Transfer may spend) but not the total balance, and bumps the version. A
+// retry with the same dataID returns the original hold instead of placing a second one.
+func (s *Store) Authorize(ctx context.Context, coinID, coinType string, amount int64, ttl time.Duration, expectedVersion *int64, userID, dataID string) (*Hold, error) {
+	log := s.logger()
+	start := time.Now()
+	coinType = coinTypeOrDefault(coinType)
+	log.Info("Authorize: start",
+		slog.String("coinID", coinID),
+		slog.String("coinType", coinType),
+		slog.Int64("amount", amount),
+		slog.Duration("ttl", ttl),
+		slog.Any("expectedVersion", expectedVersion),
+		slog.String("userID_in", userID),
+		slog.String("dataID_in", dataID),
+	)
+	if amount <= 0 {
+		return nil, errors.New("authorize: amount must be > 0")
+	}
+	if ttl <= 0 {
+		ttl = DefaultHoldTTL
+	}
+	if strings.TrimSpace(userID) == "" {
+		return nil, errors.New("userID is required (UUID)")
+	}
+	uid, err := canonicalUUID(userID)
+	if err != nil {
+		return nil, err
+	}
+	userID = uid
+	keyed := strings.TrimSpace(dataID) != ""
+
+	tx, err := s.conn(ctx).Begin(ctx)
+	if err != nil {
+		log.Error("Authorize: begin tx failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if keyed {
+		prior, err := s.claimIdempotency(ctx, tx, IdemOpAuthorize, coinID, coinType, dataID, requestHash(IdemOpAuthorize, coinID, coinType, amount, ttl.Milliseconds(), userID, versionKey(expectedVersion)))
+		if err != nil {
+			return nil, err
+		}
+		if prior != nil {
+			var h Hold
+			if err := json.Unmarshal(prior, &h); err != nil {
+				return nil, err
+			}
+			return &h, nil
+		}
+	}
+
+	locked, err := lockAccount(ctx, tx, coinID, coinType, expectedVersion)
+	if err != nil {
+		log.Error("Authorize: select failed", slog.String("coinID", coinID), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if err := checkStatus(coinID, locked.status, true); err != nil {
+		return nil, fmt.Errorf("authorize: %w", err)
+	}
+	held, err := heldAmount(ctx, tx, coinID, coinType)
+	if err != nil {
+		log.Error("Authorize: held sum failed", slog.String("coinID", coinID), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if available := locked.coins - held + locked.creditLimit; available < amount {
+		return nil, fmt.Errorf("authorize: insufficient balance (available %d, need %d)", available, amount)
+	}
+
+	h, err := scanHold(tx.QueryRow(ctx, `
+		INSERT INTO public.coin_holds (id, account_id, coin_type, amount, user_id, data_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW() + make_interval(secs => $7))
+		RETURNING `+holdColumns,
+		uuid.NewString(), coinID, coinType, amount, userID, dataID, ttl.Seconds()))
+	if err != nil {
+		log.Error("Authorize: insert failed", slog.String("coinID", coinID), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if err := bumpVersion(ctx, tx, coinID, coinType); err != nil {
+		log.Error("Authorize: version bump failed", slog.String("coinID", coinID), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if keyed {
+		if err := s.completeIdempotency(ctx, tx, IdemOpAuthorize, coinID, coinType, dataID, h); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Error("Authorize: commit failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	log.Info("Authorize: ok",
+		slog.String("coinID", coinID),
+		slog.String("holdID", h.ID),
+		slog.Time("expiresAt", h.ExpiresAt),
+		slog.Duration("dur", time.Since(start)),
+	)
+	return h, nil
+}
+
+// Capture turns an active hold into a debit. A nil amount captures the full hold; a smaller
+// amount captures part of it and releases the rest. A non-nil expectedVersion is checked
+// against the held account.
+func (s *Store) Capture(ctx context.Context, holdID string, amount, expectedVersion *int64, userID, dataID string) (*Hold, *Account, error) {
+	log := s.logger()
+	start := time.Now()
+	log.Info("Capture: start",
+		slog.String("holdID", holdID),
+		slog.Any("amount", amount),
+		slog.Any("expectedVersion", expectedVersion),
+		slog.String("userID_in", userID),
+		slog.String("dataID_in", dataID),
+	)
+	if strings.TrimSpace(userID) == "" {
+		return nil, nil, errors.New("userID is required (UUID)")
+	}
+	uid, err := canonicalUUID(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	userID = uid
+	if strings.TrimSpace(dataID) == "" {
+		dataID = fmt.Sprintf("capture:%s", holdID)
+	}
+
+	tx, err := s.conn(ctx).Begin(ctx)
+	if err != nil {
+		log.Error("Capture: begin tx failed", slog.String("error", err.Error()))
+		return nil, nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// lock the account before the hold, in the same order as Authorize, Use and Transfer
+	var accountID, coinType string
+	if err := tx.QueryRow(ctx, `SELECT account_id, coin_type FROM public.coin_holds WHERE id=$1`, holdID).Scan(&accountID, &coinType); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil, fmt.Errorf("capture: hold %s not found", holdID)
+		}
+		log.Error("Capture: select hold failed", slog.String("holdID", holdID), slog.String("error", err.Error()))
+		return nil, nil, err
+	}
+	locked, err := lockAccount(ctx, tx, accountID, coinType, expectedVersion)
+	if err != nil {
+		log.Error("Capture: select failed", slog.String("coinID", accountID), slog.String("error", err.Error()))
+		return nil, nil, err
+	}
+	h, err := scanHold(tx.QueryRow(ctx, `SELECT `+holdColumns+` FROM public.coin_holds WHERE id=$1 FOR UPDATE`, holdID))
+	if err != nil {
+		log.Error("Capture: select hold failed", slog.String("holdID", holdID), slog.String("error", err.Error()))
+		return nil, nil, err
+	}
+	if h.Status != HoldActive || !h.ExpiresAt.After(time.Now()) {
+		return nil, nil, fmt.Errorf("capture: hold %s (%s): %w", holdID, h.Status, ErrHoldNotActive)
+	}
+	amt := h.Amount
+	if amount != nil {
+		amt = *amount
+	}
+	if amt <= 0 || amt > h.Amount {
+		return nil, nil, fmt.Errorf("capture: amount must be > 0 and <= %d", h.Amount)
+	}
+
+	coins := locked.coins
+	if err := checkStatus(h.AccountID, locked.status, true); err != nil {
+		return nil, nil, fmt.Errorf("capture: %w", err)
+	}
+	if err := s.checkSpendLimits(ctx, tx, h.AccountID, h.CoinType, amt); err != nil {
+		return nil, nil, fmt.Errorf("capture: %w", err)
+	}
+	// the hold reserved amt, but setCoins may have lowered the balance underneath it and
+	// the account's other holds still have first claim on what is left
+	held, err := heldAmount(ctx, tx, h.AccountID, h.CoinType)
+	if err != nil {
+		log.Error("Capture: held sum failed", slog.String("coinID", h.AccountID), slog.String("error", err.Error()))
+		return nil, nil, err
+	}
+	if other := held - h.Amount; coins-other+locked.creditLimit < amt {
+		return nil, nil, fmt.Errorf("capture: insufficient balance (have %d, held elsewhere %d, need %d)", coins, other, amt)
+	}
+	rest, err := s.drawShards(ctx, tx, h.AccountID, h.CoinType, locked, amt)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE public.coins
+		SET coins = coins - $3,
+		    version = version + 1,
+		    last_usage_date = NOW()
+		WHERE id=$1 AND coin_type=$2
+	`, h.AccountID, h.CoinType, rest); err != nil {
+		log.Error("Capture: debit failed", slog.String("coinID", h.AccountID), slog.String("error", err.Error()))
+		return nil, nil, err
+	}
+	if err := s.consumeLots(ctx, tx, h.AccountID, h.CoinType, amt); err != nil {
+		return nil, nil, err
+	}
+	coins -= amt
+	h, err = scanHold(tx.QueryRow(ctx, `
+		UPDATE public.coin_holds
+		SET status='captured', captured=$2, updated_at=NOW()
+		WHERE id=$1
+		RETURNING `+holdColumns, holdID, amt))
+	if err != nil {
+		log.Error("Capture: update hold failed", slog.String("holdID", holdID), slog.String("error", err.Error()))
+		return nil, nil, err
+	}
+	if err := s.insertLedger(ctx, tx, h.AccountID, h.CoinType, -amt, coins, userID, dataID, LedgerKindCapture); err != nil {
+		return nil, nil, err
+	}
+	if err := s.notifyOverdrawn(ctx, tx, userID, h.AccountID, h.CoinType, coins+amt, coins); err != nil {
+		return nil, nil, err
+	}
+	acc, err := s.getAccount(ctx, tx, h.AccountID, h.CoinType)
+	if err != nil {
+		log.Error("Capture: readback failed", slog.String("coinID", h.AccountID), slog.String("error", err.Error()))
+		return nil, nil, err
+	}
+
+	// Notify (positive amount)
+	if err := s.notify(ctx, tx, userID, h.AccountID, h.CoinType, dataID, float64(amt), time.Now().UTC(), time.Time{}); err != nil {
+		return nil, nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Error("Capture: commit failed", slog.String("error", err.Error()))
+		return nil, nil, err
+	}
+
+	log.Info("Capture: ok",
+		slog.String("holdID", holdID),
+		slog.String("coinID", h.AccountID),
+		slog.Int64("captured", amt),
+		slog.Int64("coins", acc.Coins),
+		slog.Duration("dur", time.Since(start)),
+	)
+	return h, acc, nil
+}
+
+// Void releases an active hold without debiting the account, and bumps its version. A
+// non-nil expectedVersion is checked against the held account.
+func (s *Store) Void(ctx context.Context, holdID string, expectedVersion *int64) (*Hold, error) {
+	log := s.logger()
+	start := time.Now()
+	log.Info("Void: start", slog.String("holdID", holdID), slog.Any("expectedVersion", expectedVersion))
+
+	tx, err := s.conn(ctx).Begin(ctx)
+	if err != nil {
+		log.Error("Void: begin tx failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// lock the account before the hold, as Capture does
+	var accountID, coinType string
+	if err := tx.QueryRow(ctx, `SELECT account_id, coin_type FROM public.coin_holds WHERE id=$1`, holdID).Scan(&accountID, &coinType); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("void: hold %s not found", holdID)
+		}
+		log.Error("Void: select hold failed", slog.String("holdID", holdID), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if _, err := lockAccount(ctx, tx, accountID, coinType, expectedVersion); err != nil {
+		log.Error("Void: select failed", slog.String("coinID", accountID), slog.String("error", err.Error()))
+		return nil, err
+	}
+	h, err := scanHold(tx.QueryRow(ctx, `
+		UPDATE public.coin_holds
+		SET status='voided', updated_at=NOW()
+		WHERE id=$1 AND status='active'
+		RETURNING `+holdColumns, holdID))
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Error("Void: update failed", slog.String("holdID", holdID), slog.String("error", err.Error()))
+			return nil, err
+		}
+		cur, gerr := s.getHold(ctx, tx, holdID)
+		if gerr != nil {
+			return nil, gerr
+		}
+		if cur == nil {
+			return nil, fmt.Errorf("void: hold %s not found", holdID)
+		}
+		return nil, fmt.Errorf("void: hold %s (%s): %w", holdID, cur.Status, ErrHoldNotActive)
+	}
+	if err := bumpVersion(ctx, tx, accountID, coinType); err != nil {
+		log.Error("Void: version bump failed", slog.String("coinID", accountID), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Error("Void: commit failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	log.Info("Void: ok", slog.String("holdID", holdID), slog.Duration("dur", time.Since(start)))
+	return h, nil
+}
+
+// ExpireHolds marks active holds past their expiry as expired and returns how many were released.
+func (s *Store) ExpireHolds(ctx context.Context) (int64, error) {
+	log := s.logger()
+	start := time.Now()
+	tag, err := s.Pool.Exec(ctx, `
+		UPDATE public.coin_holds
+		SET status='expired', updated_at=NOW()
+		WHERE status='active' AND expires_at <= NOW()
+	`)
+	if err != nil {
+		log.Error("ExpireHolds: failed", slog.String("error", err.Error()))
+		return 0, err
+	}
+	n := tag.RowsAffected()
+	if n > 0 {
+		log.Info("ExpireHolds: released", slog.Int64("count", n), slog.Duration("dur", time.Since(start)))
+	}
+	return n, nil
+}
+
+// RunHoldSweeper calls ExpireHolds every interval until ctx is cancelled.
+func (s *Store) RunHoldSweeper(ctx context.Context, interval time.Duration) {
+	log := s.logger()
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("hold sweeper: stopped")
+			return
+		case <-t.C:
+			_, _ = s.ExpireHolds(ctx)
+		}
+	}
+}
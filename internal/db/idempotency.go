@@ -0,0 +1,100 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// --------------------------------------------
+// Idempotency (keyed by operation, account, coin type and dataID)
+// --------------------------------------------
+
+// Idempotent operation names stored in public.idempotency_keys.
+const (
+	IdemOpRecharge  = "recharge"
+	IdemOpUse       = "use"
+	IdemOpTransfer  = "transfer"
+	IdemOpSet       = "set"
+	IdemOpAuthorize = "authorize"
+)
+
+// ErrIdempotencyConflict is returned when a dataID is reused for the same operation
+// and account but with a different payload.
+var ErrIdempotencyConflict = errors.New("idempotency conflict: dataId already used with a different payload")
+
+// requestHash fingerprints the parts of a request that must match on a retry. The parts
+// are hashed as a JSON array, so adjacent strings can't run into each other ("u2", "COIN"
+// and "u2C", "OIN" differ). Parts are strings, numbers and slices of them.
+func requestHash(parts ...any) string {
+	b, _ := json.Marshal(parts)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// versionKey renders an optional expected version for requestHash.
+func versionKey(v *int64) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(*v)
+}
+
+// claimIdempotency reserves (op, accountID, coinType, dataID) inside tx.
+// It returns the stored response of an earlier request with the same key, or nil if this
+// request is the first one. A concurrent first request holds the key until it commits,
+// so the INSERT below blocks instead of racing it.
+func (s *Store) claimIdempotency(ctx context.Context, tx pgx.Tx, op, accountID, coinType, dataID, hash string) ([]byte, error) {
+	log := s.logger()
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO public.idempotency_keys (operation, account_id, coin_type, data_id, request_hash)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (operation, account_id, coin_type, data_id) DO NOTHING
+	`, op, accountID, coinType, dataID, hash)
+	if err != nil {
+		log.Error("claimIdempotency: insert failed", slog.String("op", op), slog.String("dataID", dataID), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if tag.RowsAffected() > 0 {
+		return nil, nil
+	}
+
+	var storedHash string
+	var response []byte
+	if err := tx.QueryRow(ctx, `
+		SELECT request_hash, response FROM public.idempotency_keys
+		WHERE operation=$1 AND account_id=$2 AND coin_type=$3 AND data_id=$4
+	`, op, accountID, coinType, dataID).Scan(&storedHash, &response); err != nil {
+		log.Error("claimIdempotency: select failed", slog.String("op", op), slog.String("dataID", dataID), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if storedHash != hash {
+		log.Warn("claimIdempotency: payload mismatch", slog.String("op", op), slog.String("accountID", accountID), slog.String("dataID", dataID))
+		return nil, fmt.Errorf("%s %s: %w", op, dataID, ErrIdempotencyConflict)
+	}
+	log.Info("claimIdempotency: replay", slog.String("op", op), slog.String("accountID", accountID), slog.String("dataID", dataID))
+	return response, nil
+}
+
+// completeIdempotency stores the response for a key claimed earlier in the same tx.
+func (s *Store) completeIdempotency(ctx context.Context, tx pgx.Tx, op, accountID, coinType, dataID string, response any) error {
+	b, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE public.idempotency_keys SET response=$5
+		WHERE operation=$1 AND account_id=$2 AND coin_type=$3 AND data_id=$4
+	`, op, accountID, coinType, dataID, b); err != nil {
+		s.logger().Error("completeIdempotency: update failed", slog.String("op", op), slog.String("dataID", dataID), slog.String("error", err.Error()))
+		return err
+	}
+	return nil
+}
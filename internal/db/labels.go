@@ -0,0 +1,180 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"log/slog"
+)
+
+// --------------------------------------------
+// Account metadata & label selectors
+// --------------------------------------------
+
+var labelPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9_./-]*[A-Za-z0-9])?$`)
+
+// validateLabels checks that keys (and non-empty values) are plain words, so they can't
+// clash with the selector syntax.
+func validateLabels(labels map[string]string) error {
+	for k, v := range labels {
+		if len(k) > 63 || !labelPattern.MatchString(k) {
+			return fmt.Errorf("invalid label key %q", k)
+		}
+		if v != "" && (len(v) > 63 || !labelPattern.MatchString(v)) {
+			return fmt.Errorf("invalid value %q for label %q", v, k)
+		}
+	}
+	return nil
+}
+
+// Label selector operators.
+const (
+	LabelEquals    = "="
+	LabelNotEquals = "!="
+	LabelExists    = "exists"
+	LabelNotExists = "!exists"
+)
+
+// LabelRequirement is one comma-separated term of a label selector.
+type LabelRequirement struct {
+	Key   string
+	Op    string
+	Value string
+}
+
+// LabelSelector matches accounts whose labels satisfy every requirement.
+type LabelSelector []LabelRequirement
+
+// ParseLabelSelector parses selectors like "plan=pro,region!=eu,owner,!trial":
+// key=value (or key==value), key!=value (also matches accounts without key),
+// key (has the label) and !key (lacks it).
+func ParseLabelSelector(s string) (LabelSelector, error) {
+	var sel LabelSelector
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		var r LabelRequirement
+		switch {
+		case strings.Contains(term, "!="):
+			k, v, _ := strings.Cut(term, "!=")
+			r = LabelRequirement{Key: strings.TrimSpace(k), Op: LabelNotEquals, Value: strings.TrimSpace(v)}
+		case strings.Contains(term, "="):
+			k, v, _ := strings.Cut(term, "=")
+			r = LabelRequirement{Key: strings.TrimSpace(k), Op: LabelEquals, Value: strings.TrimPrefix(strings.TrimSpace(v), "=")}
+			r.Value = strings.TrimSpace(r.Value)
+		case strings.HasPrefix(term, "!"):
+			r = LabelRequirement{Key: strings.TrimSpace(term[1:]), Op: LabelNotExists}
+		default:
+			r = LabelRequirement{Key: term, Op: LabelExists}
+		}
+		if !labelPattern.MatchString(r.Key) {
+			return nil, fmt.Errorf("label selector %q: invalid key %q", s, r.Key)
+		}
+		if r.Value != "" && !labelPattern.MatchString(r.Value) {
+			return nil, fmt.Errorf("label selector %q: invalid value %q", s, r.Value)
+		}
+		sel = append(sel, r)
+	}
+	return sel, nil
+}
+
+// where renders the selector as SQL conditions on alias c, numbering parameters from next.
+func (sel LabelSelector) where(next int) (string, []any) {
+	var sb strings.Builder
+	var args []any
+	for _, r := range sel {
+		switch r.Op {
+		case LabelEquals:
+			fmt.Fprintf(&sb, " AND c.labels @> jsonb_build_object($%d::text, $%d::text)", next, next+1)
+			args = append(args, r.Key, r.Value)
+			next += 2
+		case LabelNotEquals:
+			fmt.Fprintf(&sb, " AND NOT c.labels @> jsonb_build_object($%d::text, $%d::text)", next, next+1)
+			args = append(args, r.Key, r.Value)
+			next += 2
+		case LabelExists:
+			fmt.Fprintf(&sb, " AND c.labels ? $%d::text", next)
+			args = append(args, r.Key)
+			next++
+		case LabelNotExists:
+			fmt.Fprintf(&sb, " AND NOT c.labels ? $%d::text", next)
+			args = append(args, r.Key)
+			next++
+		}
+	}
+	return sb.String(), args
+}
+
+// jsonArg encodes v for a ::jsonb parameter; a nil map stays NULL.
+func jsonArg[M ~map[string]V, V any](v M) (*string, error) {
+	if v == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	s := string(b)
+	return &s, nil
+}
+
+// UpdateAccountMetadata replaces the metadata and/or labels of every coin type of an account.
+// A nil map leaves that part alone; with merge the given keys are added to or overwrite the
+// existing ones instead of replacing them all. A non-nil expectedVersion is checked against
+// the default coin type balance.
+func (s *Store) UpdateAccountMetadata(ctx context.Context, id string, metadata map[string]any, labels map[string]string, merge bool, expectedVersion *int64) ([]*Account, error) {
+	log := s.logger()
+	start := time.Now()
+	log.Info("UpdateAccountMetadata: start", slog.String("id", id), slog.Bool("merge", merge), slog.Any("expectedVersion", expectedVersion))
+	if metadata == nil && labels == nil {
+		return nil, errors.New("updateMetadata: nothing to update")
+	}
+	if err := validateLabels(labels); err != nil {
+		return nil, fmt.Errorf("updateMetadata: %w", err)
+	}
+	metaJSON, err := jsonArg(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("updateMetadata: %w", err)
+	}
+	labelsJSON, err := jsonArg(labels)
+	if err != nil {
+		return nil, fmt.Errorf("updateMetadata: %w", err)
+	}
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		log.Error("UpdateAccountMetadata: begin tx failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if err := checkAccountVersion(ctx, tx, id, expectedVersion); err != nil {
+		return nil, err
+	}
+	tag, err := tx.Exec(ctx, `
+		UPDATE public.coins
+		SET metadata = CASE WHEN $2::jsonb IS NULL THEN metadata WHEN $4 THEN metadata || $2::jsonb ELSE $2::jsonb END,
+		    labels = CASE WHEN $3::jsonb IS NULL THEN labels WHEN $4 THEN labels || $3::jsonb ELSE $3::jsonb END,
+		    version = version + 1
+		WHERE id=$1
+	`, id, metaJSON, labelsJSON, merge)
+	if err != nil {
+		log.Error("UpdateAccountMetadata: update failed", slog.String("id", id), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, fmt.Errorf("updateMetadata: account %s not found", id)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Error("UpdateAccountMetadata: commit failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	log.Info("UpdateAccountMetadata: ok", slog.String("id", id), slog.Duration("dur", time.Since(start)))
+	return s.listBalances(ctx, s.Pool, id)
+}
@@ -0,0 +1,84 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// --------------------------------------------
+// Coin ledger (append-only balance history)
+// --------------------------------------------
+
+// insertLedger appends a ledger row inside the caller's transaction so it commits
+// (or rolls back) together with the balance change it describes.
+func (s *Store) insertLedger(ctx context.Context, tx pgx.Tx, accountID, coinType string, delta, balanceAfter int64, userID, dataID, kind string) error {
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO public.coin_ledger (account_id, coin_type, delta, balance_after, user_id, data_id, kind)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, accountID, coinType, delta, balanceAfter, userID, dataID, kind); err != nil {
+		s.logger().Error("insertLedger: failed",
+			slog.String("accountID", accountID),
+			slog.String("kind", kind),
+			slog.String("error", err.Error()),
+		)
+		return err
+	}
+	return nil
+}
+
+const ledgerColumns = `id, account_id, coin_type, delta, balance_after, user_id, data_id, kind, reverses_id, created_at`
+
+func scanLedgerEntry(row pgx.Row) (*LedgerEntry, error) {
+	var e LedgerEntry
+	if err := row.Scan(&e.ID, &e.AccountID, &e.CoinType, &e.Delta, &e.BalanceAfter, &e.UserID, &e.DataID, &e.Kind, &e.ReversesID, &e.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// ListLedger returns ledger entries for one coin type of an account, newest first.
+// after is the id of the last entry of the previous page (0 for the first page).
+func (s *Store) ListLedger(ctx context.Context, accountID, coinType string, first int, after int64) ([]*LedgerEntry, error) {
+	log := s.logger()
+	start := time.Now()
+	if first <= 0 {
+		first = 50
+	}
+	if first > 200 {
+		first = 200
+	}
+	coinType = coinTypeOrDefault(coinType)
+	log.Debug("ListLedger: query", slog.String("accountID", accountID), slog.String("coinType", coinType), slog.Int("first", first), slog.Int64("after", after))
+	rows, err := s.reader(ctx).Query(ctx, `
+		SELECT `+ledgerColumns+`
+		FROM public.coin_ledger
+		WHERE account_id=$1 AND coin_type=$2 AND ($3::bigint = 0 OR id < $3::bigint)
+		ORDER BY id DESC
+		LIMIT $4
+	`, accountID, coinType, after, first)
+	if err != nil {
+		log.Error("ListLedger: query failed", slog.String("accountID", accountID), slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*LedgerEntry
+	for rows.Next() {
+		e, err := scanLedgerEntry(rows)
+		if err != nil {
+			log.Error("ListLedger: scan failed", slog.String("error", err.Error()))
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error("ListLedger: rows err", slog.String("error", err.Error()))
+		return nil, err
+	}
+	log.Debug("ListLedger: ok", slog.String("accountID", accountID), slog.Int("count", len(out)), slog.Duration("dur", time.Since(start)))
+	return out, nil
+}
@@ -0,0 +1,246 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// --------------------------------------------
+// Account lifecycle (active / frozen / closed)
+// --------------------------------------------
+
+// ErrBalanceNotZero is returned when closing an account that still holds coins without a sweep target.
+var ErrBalanceNotZero = errors.New("balance is not zero")
+
+// FreezeAccount blocks every debit of an account (all coin types) until it is unfrozen.
+// Freezing a frozen account is a no-op. A non-nil expectedVersion is checked against the
+// default coin type balance.
+func (s *Store) FreezeAccount(ctx context.Context, id string, expectedVersion *int64) ([]*Account, error) {
+	return s.changeStatus(ctx, "FreezeAccount", id, StatusActive, StatusFrozen, expectedVersion)
+}
+
+// UnfreezeAccount makes a frozen account active again. Unfreezing an active account is a no-op.
+func (s *Store) UnfreezeAccount(ctx context.Context, id string, expectedVersion *int64) ([]*Account, error) {
+	return s.changeStatus(ctx, "UnfreezeAccount", id, StatusFrozen, StatusActive, expectedVersion)
+}
+
+func (s *Store) changeStatus(ctx context.Context, op, id, from, to string, expectedVersion *int64) ([]*Account, error) {
+	log := s.logger()
+	start := time.Now()
+	log.Info(op+": start", slog.String("id", id), slog.Any("expectedVersion", expectedVersion))
+	tx, err := s.conn(ctx).Begin(ctx)
+	if err != nil {
+		log.Error(op+": begin tx failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err := unarchive(ctx, tx, id); err != nil {
+		return nil, err
+	}
+	if err := checkAccountVersion(ctx, tx, id, expectedVersion); err != nil {
+		return nil, err
+	}
+	tag, err := tx.Exec(ctx, `
+		UPDATE public.coins SET status=$3, version = version + 1 WHERE id=$1 AND status=$2
+	`, id, from, to)
+	if err != nil {
+		log.Error(op+": update failed", slog.String("id", id), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		var cur string
+		err := tx.QueryRow(ctx, `SELECT status FROM public.coins WHERE id=$1 LIMIT 1`, id).Scan(&cur)
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, fmt.Errorf("%s: account %s not found", op, id)
+		case err != nil:
+			return nil, err
+		case cur == StatusClosed:
+			return nil, fmt.Errorf("%s: %s: %w", op, id, ErrAccountClosed)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Error(op+": commit failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	log.Info(op+": ok", slog.String("id", id), slog.String("status", to), slog.Duration("dur", time.Since(start)))
+	return s.listBalances(ctx, s.conn(ctx), id)
+}
+
+// CloseAccount closes every coin type balance of an account for good. Active holds are
+// voided. Remaining coins are moved to sweepTo, which must hold the same coin types;
+// with an empty sweepTo every balance must already be zero.
+// userID (UUID) and dataID are recorded on the sweep transfers. A non-nil expectedVersion
+// is checked against the default coin type balance.
+func (s *Store) CloseAccount(ctx context.Context, id, sweepTo string, expectedVersion *int64, userID, dataID string) ([]*Account, error) {
+	log := s.logger()
+	start := time.Now()
+	log.Info("CloseAccount: start",
+		slog.String("id", id),
+		slog.String("sweepTo", sweepTo),
+		slog.Any("expectedVersion", expectedVersion),
+		slog.String("userID_in", userID),
+		slog.String("dataID_in", dataID),
+	)
+	sweepTo = strings.TrimSpace(sweepTo)
+	if sweepTo == id {
+		return nil, errors.New("close: sweepTo must be another account")
+	}
+	if sweepTo != "" {
+		if strings.TrimSpace(userID) == "" {
+			return nil, errors.New("userID is required (UUID)")
+		}
+		uid, err := canonicalUUID(userID)
+		if err != nil {
+			return nil, err
+		}
+		userID = uid
+	}
+	now := time.Now().UTC()
+	if strings.TrimSpace(dataID) == "" {
+		dataID = fmt.Sprintf("close:%s:%d", id, now.UnixNano())
+	}
+
+	tx, err := s.conn(ctx).Begin(ctx)
+	if err != nil {
+		log.Error("CloseAccount: begin tx failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err := unarchive(ctx, tx, id); err != nil {
+		return nil, err
+	}
+	if err := checkAccountVersion(ctx, tx, id, expectedVersion); err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(ctx, `
+		SELECT coin_type, coins, status FROM public.coins WHERE id=$1 ORDER BY coin_type FOR UPDATE
+	`, id)
+	if err != nil {
+		log.Error("CloseAccount: select failed", slog.String("id", id), slog.String("error", err.Error()))
+		return nil, err
+	}
+	balances := map[string]int64{}
+	var coinTypes []string
+	closed := false
+	for rows.Next() {
+		var coinType, status string
+		var coins int64
+		if err := rows.Scan(&coinType, &coins, &status); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		closed = closed || status == StatusClosed
+		balances[coinType] = coins
+		coinTypes = append(coinTypes, coinType)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(coinTypes) == 0 {
+		return nil, fmt.Errorf("close: account %s not found", id)
+	}
+	// summed once the rows are locked, so no credit to a shard is in flight
+	sharded, err := shardTotals(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	for coinType, coins := range sharded {
+		balances[coinType] += coins
+	}
+	if closed {
+		return nil, fmt.Errorf("close: %s: %w", id, ErrAccountClosed)
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE public.coin_holds SET status='voided', updated_at=NOW()
+		WHERE account_id=$1 AND status='active'
+	`, id); err != nil {
+		log.Error("CloseAccount: void holds failed", slog.String("id", id), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	for _, coinType := range coinTypes {
+		coins := balances[coinType]
+		if coins == 0 {
+			continue
+		}
+		if sweepTo == "" || coins < 0 {
+			return nil, fmt.Errorf("close: %s %s balance is %d: %w", id, coinType, coins, ErrBalanceNotZero)
+		}
+		to, err := lockForCredit(ctx, tx, sweepTo, coinType, nil)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, fmt.Errorf("close: sweep target %s has no %s balance", sweepTo, coinType)
+			}
+			log.Error("CloseAccount: sweep lock failed", slog.String("to", sweepTo), slog.String("error", err.Error()))
+			return nil, err
+		}
+		if err := checkStatus(sweepTo, to.status, false); err != nil {
+			return nil, fmt.Errorf("close: %w", err)
+		}
+		var toCoins int64
+		if to.shards > 0 {
+			if toCoins, err = s.creditShard(ctx, tx, sweepTo, coinType, to.shards, coins); err != nil {
+				return nil, err
+			}
+		} else if err := tx.QueryRow(ctx, `
+			UPDATE public.coins
+			SET coins = coins + $3,
+			    version = version + 1,
+			    last_recharge_date = NOW()
+			WHERE id=$1 AND coin_type=$2
+			RETURNING coins
+		`, sweepTo, coinType, coins).Scan(&toCoins); err != nil {
+			log.Error("CloseAccount: sweep credit failed", slog.String("to", sweepTo), slog.String("error", err.Error()))
+			return nil, err
+		}
+		draws, err := s.takeLots(ctx, tx, id, coinType, coins)
+		if err != nil {
+			return nil, err
+		}
+		outDataID, inDataID := dataID+":out", dataID+":in"
+		if err := s.carryLots(ctx, tx, sweepTo, coinType, draws, inDataID); err != nil {
+			return nil, err
+		}
+		if err := s.insertLedger(ctx, tx, id, coinType, -coins, 0, userID, outDataID, LedgerKindTransferOut); err != nil {
+			return nil, err
+		}
+		if err := s.insertLedger(ctx, tx, sweepTo, coinType, coins, toCoins, userID, inDataID, LedgerKindTransferIn); err != nil {
+			return nil, err
+		}
+		if err := s.notify(ctx, tx, userID, id, coinType, outDataID, float64(coins), now, time.Time{}); err != nil {
+			return nil, err
+		}
+		if err := s.notify(ctx, tx, userID, sweepTo, coinType, inDataID, float64(coins), now, time.Time{}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zeroShards(ctx, tx, id, ""); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE public.coins SET status='closed', coins=0, version = version + 1 WHERE id=$1
+	`, id); err != nil {
+		log.Error("CloseAccount: update failed", slog.String("id", id), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Error("CloseAccount: commit failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	log.Info("CloseAccount: ok", slog.String("id", id), slog.String("sweepTo", sweepTo), slog.Duration("dur", time.Since(start)))
+	return s.listBalances(ctx, s.conn(ctx), id)
+}
@@ -0,0 +1,327 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	"time"
+
+	"log/slog"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// --------------------------------------------
+// Expiring coin lots (FIFO by expiry)
+// --------------------------------------------
+
+// SystemUserID is the actor recorded for changes made by background jobs.
+var SystemUserID = uuid.Nil.String()
+
+const lotColumns = `id, account_id, coin_type, amount, remaining, expires_at, data_id, created_at`
+
+func scanLot(row pgx.Row) (*CoinLot, error) {
+	var l CoinLot
+	if err := row.Scan(&l.ID, &l.AccountID, &l.CoinType, &l.Amount, &l.Remaining, &l.ExpiresAt, &l.DataID, &l.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// createLot records the lot a credit came from. A nil expiresAt never expires.
+func (s *Store) createLot(ctx context.Context, tx pgx.Tx, accountID, coinType string, amount int64, expiresAt *time.Time, dataID string) error {
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO public.coin_lots (account_id, coin_type, amount, remaining, expires_at, data_id)
+		VALUES ($1, $2, $3, $3, $4, $5)
+	`, accountID, coinType, amount, expiresAt, dataID); err != nil {
+		s.logger().Error("createLot: failed", slog.String("accountID", accountID), slog.String("error", err.Error()))
+		return err
+	}
+	return nil
+}
+
+// lotDraw is the part of one lot a debit used up.
+type lotDraw struct {
+	amount    int64
+	expiresAt *time.Time
+}
+
+// consumeLots draws amount from the account's lots, soonest-to-expire first and
+// never-expiring lots last. Lots past their expiry are left for ExpireLots to burn.
+// Anything beyond the lotted total comes out of the unlotted part of the balance (legacy
+// coins and lots created before expiry was tracked).
+// Callers hold the account row lock, which serializes every lot change for the account.
+func (s *Store) consumeLots(ctx context.Context, tx pgx.Tx, accountID, coinType string, amount int64) error {
+	_, err := s.takeLots(ctx, tx, accountID, coinType, amount)
+	return err
+}
+
+// takeLots is consumeLots returning what it drew from each lot.
+func (s *Store) takeLots(ctx context.Context, tx pgx.Tx, accountID, coinType string, amount int64) ([]*lotDraw, error) {
+	draws, err := collectRows(ctx, tx, func(row pgx.Row) (*lotDraw, error) {
+		var d lotDraw
+		return &d, row.Scan(&d.amount, &d.expiresAt)
+	}, `
+		UPDATE public.coin_lots l
+		SET remaining = l.remaining - LEAST(l.remaining, $3 - o.before)
+		FROM (
+			SELECT id, remaining, SUM(remaining) OVER (ORDER BY expires_at NULLS LAST, id) - remaining AS before
+			FROM public.coin_lots
+			WHERE account_id = $1 AND coin_type = $2 AND remaining > 0
+			  AND (expires_at IS NULL OR expires_at > NOW())
+		) o
+		WHERE l.id = o.id AND o.before < $3
+		RETURNING LEAST(o.remaining, $3 - o.before), l.expires_at
+	`, accountID, coinType, amount)
+	if err != nil {
+		s.logger().Error("consumeLots: failed", slog.String("accountID", accountID), slog.String("error", err.Error()))
+		return nil, err
+	}
+	return draws, nil
+}
+
+// carryLots gives accountID a lot for each of draws, so coins moved off another account
+// keep the expiry they had there. Coins drawn from the unlotted part move unlotted.
+func (s *Store) carryLots(ctx context.Context, tx pgx.Tx, accountID, coinType string, draws []*lotDraw, dataID string) error {
+	for _, d := range draws {
+		if err := s.createLot(ctx, tx, accountID, coinType, d.amount, d.expiresAt, dataID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shareDraws splits the draws of several debits between credits of the given amounts, in
+// proportion to them, so that each credit carries its share of every expiry. What a draw
+// doesn't split evenly goes to the first credits with room left.
+func shareDraws(draws []*lotDraw, credits []int64) [][]*lotDraw {
+	var total int64
+	for _, c := range credits {
+		total += c
+	}
+	room := append([]int64(nil), credits...)
+	out := make([][]*lotDraw, len(credits))
+	give := func(j int, n int64, expiresAt *time.Time) {
+		if n > 0 {
+			out[j] = append(out[j], &lotDraw{amount: n, expiresAt: expiresAt})
+			room[j] -= n
+		}
+	}
+	for _, d := range draws {
+		left := d.amount
+		for j, c := range credits {
+			// d.amount*c/total without overflow; d.amount <= total, so the quotient fits
+			hi, lo := bits.Mul64(uint64(d.amount), uint64(c))
+			q, _ := bits.Div64(hi, lo, uint64(total))
+			n := min(int64(q), room[j], left)
+			give(j, n, d.expiresAt)
+			left -= n
+		}
+		for j := 0; left > 0 && j < len(credits); j++ {
+			n := min(left, room[j])
+			give(j, n, d.expiresAt)
+			left -= n
+		}
+	}
+	return out
+}
+
+// defaultExpiry resolves the expiry of a new lot from an explicit value or s.DefaultCoinExpiry.
+func (s *Store) defaultExpiry(expiresAt *time.Time) (*time.Time, error) {
+	return lotExpiry(expiresAt, s.DefaultCoinExpiry)
+}
+
+// lotExpiry returns expiresAt if set (it must be in the future), else now + def, else nil.
+func lotExpiry(expiresAt *time.Time, def time.Duration) (*time.Time, error) {
+	if expiresAt != nil {
+		if !expiresAt.After(time.Now()) {
+			return nil, fmt.Errorf("expiresAt must be in the future")
+		}
+		t := expiresAt.UTC()
+		return &t, nil
+	}
+	if def > 0 {
+		t := time.Now().UTC().Add(def)
+		return &t, nil
+	}
+	return nil, nil
+}
+
+// ListUpcomingExpirations returns the unspent lots of one coin type of an account that will
+// expire, soonest first. A non-nil before limits the result to lots expiring until then.
+func (s *Store) ListUpcomingExpirations(ctx context.Context, accountID, coinType string, before *time.Time) ([]*CoinLot, error) {
+	log := s.logger()
+	start := time.Now()
+	coinType = coinTypeOrDefault(coinType)
+	log.Debug("ListUpcomingExpirations: query", slog.String("accountID", accountID), slog.String("coinType", coinType), slog.Any("before", before))
+	rows, err := s.reader(ctx).Query(ctx, `
+		SELECT `+lotColumns+`
+		FROM public.coin_lots
+		WHERE account_id=$1 AND coin_type=$2 AND remaining > 0
+		  AND expires_at IS NOT NULL AND expires_at > NOW()
+		  AND ($3::timestamptz IS NULL OR expires_at <= $3::timestamptz)
+		ORDER BY expires_at, id
+	`, accountID, coinType, before)
+	if err != nil {
+		log.Error("ListUpcomingExpirations: query failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*CoinLot
+	for rows.Next() {
+		l, err := scanLot(rows)
+		if err != nil {
+			log.Error("ListUpcomingExpirations: scan failed", slog.String("error", err.Error()))
+			return nil, err
+		}
+		out = append(out, l)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error("ListUpcomingExpirations: rows err", slog.String("error", err.Error()))
+		return nil, err
+	}
+	log.Debug("ListUpcomingExpirations: ok", slog.Int("count", len(out)), slog.Duration("dur", time.Since(start)))
+	return out, nil
+}
+
+// ExpireLots burns the unspent remainder of every expired lot and returns the number of
+// coins burned. Each burn is written to the ledger and notified with the lot's expiry date.
+// Accounts whose lots have been overdue longest go first, 500 per call.
+func (s *Store) ExpireLots(ctx context.Context) (int64, error) {
+	log := s.logger()
+	start := time.Now()
+	rows, err := s.Pool.Query(ctx, `
+		SELECT account_id, coin_type FROM public.coin_lots
+		WHERE remaining > 0 AND expires_at <= NOW()
+		GROUP BY account_id, coin_type
+		ORDER BY MIN(expires_at), account_id, coin_type
+		LIMIT 500
+	`)
+	if err != nil {
+		log.Error("ExpireLots: query failed", slog.String("error", err.Error()))
+		return 0, err
+	}
+	type balanceKey struct{ id, coinType string }
+	var accounts []balanceKey
+	for rows.Next() {
+		var k balanceKey
+		if err := rows.Scan(&k.id, &k.coinType); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		accounts = append(accounts, k)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, k := range accounts {
+		n, err := s.expireAccountLots(ctx, k.id, k.coinType)
+		if err != nil {
+			log.Error("ExpireLots: account failed", slog.String("accountID", k.id), slog.String("coinType", k.coinType), slog.String("error", err.Error()))
+			continue
+		}
+		total += n
+	}
+	if total > 0 {
+		log.Info("ExpireLots: burned", slog.Int("accounts", len(accounts)), slog.Int64("coins", total), slog.Duration("dur", time.Since(start)))
+	}
+	return total, nil
+}
+
+func (s *Store) expireAccountLots(ctx context.Context, accountID, coinType string) (int64, error) {
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	locked, err := lockAccount(ctx, tx, accountID, coinType, nil)
+	if err != nil {
+		return 0, err
+	}
+	coins := locked.coins
+	rows, err := tx.Query(ctx, `
+		SELECT id, remaining, expires_at FROM public.coin_lots
+		WHERE account_id=$1 AND coin_type=$2 AND remaining > 0 AND expires_at <= NOW()
+		ORDER BY expires_at, id
+	`, accountID, coinType)
+	if err != nil {
+		return 0, err
+	}
+	type burn struct {
+		lotID     int64
+		amount    int64
+		expiresAt time.Time
+	}
+	var burns []burn
+	for rows.Next() {
+		var b burn
+		if err := rows.Scan(&b.lotID, &b.amount, &b.expiresAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		burns = append(burns, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, b := range burns {
+		// never burn below zero; a setCoins may have lowered the balance without touching lots
+		amt := min(b.amount, coins)
+		if _, err := tx.Exec(ctx, `UPDATE public.coin_lots SET remaining = 0 WHERE id=$1`, b.lotID); err != nil {
+			return 0, err
+		}
+		if amt <= 0 {
+			continue
+		}
+		coins -= amt
+		total += amt
+		dataID := fmt.Sprintf("expire:%d", b.lotID)
+		if err := s.insertLedger(ctx, tx, accountID, coinType, -amt, coins, SystemUserID, dataID, LedgerKindExpire); err != nil {
+			return 0, err
+		}
+		if err := s.notify(ctx, tx, SystemUserID, accountID, coinType, dataID, float64(amt), time.Now().UTC(), b.expiresAt); err != nil {
+			return 0, err
+		}
+	}
+	if total > 0 {
+		rest, err := s.drawShards(ctx, tx, accountID, coinType, locked, total)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE public.coins SET coins = coins - $3, version = version + 1 WHERE id=$1 AND coin_type=$2`, accountID, coinType, rest); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// RunLotExpirer calls ExpireLots every interval until ctx is cancelled.
+func (s *Store) RunLotExpirer(ctx context.Context, interval time.Duration) {
+	log := s.logger()
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("lot expirer: stopped")
+			return
+		case <-t.C:
+			_, _ = s.ExpireLots(ctx)
+		}
+	}
+}
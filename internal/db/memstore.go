@@ -0,0 +1,889 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// --------------------------------------------
+// In-memory AccountStore
+// --------------------------------------------
+
+// MemoryStore keeps the tables of Store in process memory, with the same validation,
+// errors, ledger, lots, idempotency and outbox behaviour. It is safe for concurrent use:
+// every operation runs under one lock, and a failing one is undone as a whole, like a
+// rolled back transaction. Nothing is persisted; it is meant for tests and local runs.
+type MemoryStore struct {
+	Notifier TxNotifier    // optional; nil means notifications disabled
+	Alerts   AlertNotifier // optional; nil means account alerts are only logged
+	Logger   *slog.Logger
+
+	// DefaultCoinExpiry is the lifetime of recharged coins when the caller gives no expiry.
+	// Zero means recharged coins never expire.
+	DefaultCoinExpiry time.Duration
+
+	mu        sync.Mutex
+	byID      map[string]map[string]*Account // id -> coin type -> balance
+	ledger    []*LedgerEntry
+	ledgerBy  map[memKey][]*LedgerEntry
+	lots      map[memKey][]*CoinLot
+	holds     map[string]*Hold
+	holdsBy   map[memKey][]*Hold
+	outbox    []*OutboxEntry
+	inFlight  map[int64]bool // outbox entries claimed by a dispatch pass
+	idem      map[idemKey]*idemRecord
+	limits    map[spendKey]*SpendLimit
+	snapshots map[memKey][]*balanceSnapshot // oldest first
+	schedules map[string]*RechargeSchedule
+	audit     []*AuditEntry         // oldest first
+	archived  map[string][]*Account // id -> archived balances, like public.archived_coins
+
+	ledgerSeq, lotSeq, outboxSeq int64
+	auditSeq                     int64
+	snapshotLedgerMax            int64 // newest ledger id covered by any snapshot
+
+	// schedulerMu is held by the FireDueSchedules pass, like the scheduler advisory lock.
+	schedulerMu sync.Mutex
+}
+
+type memKey struct{ id, coinType string }
+
+type idemKey struct{ op, accountID, coinType, dataID string }
+
+type idemRecord struct {
+	hash     string
+	response []byte
+}
+
+type spendKey struct {
+	accountID, coinType string
+	window              int64
+}
+
+type balanceSnapshot struct {
+	takenAt  time.Time
+	coins    int64
+	ledgerID int64
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		byID:      map[string]map[string]*Account{},
+		ledgerBy:  map[memKey][]*LedgerEntry{},
+		lots:      map[memKey][]*CoinLot{},
+		holds:     map[string]*Hold{},
+		holdsBy:   map[memKey][]*Hold{},
+		inFlight:  map[int64]bool{},
+		idem:      map[idemKey]*idemRecord{},
+		limits:    map[spendKey]*SpendLimit{},
+		snapshots: map[memKey][]*balanceSnapshot{},
+		schedules: map[string]*RechargeSchedule{},
+		archived:  map[string][]*Account{},
+	}
+}
+
+// logger returns a usable logger.
+func (m *MemoryStore) logger() *slog.Logger {
+	if m == nil || m.Logger == nil {
+		return slog.Default()
+	}
+	return m.Logger
+}
+
+// Close is a no-op; it exists to satisfy AccountStore.
+func (m *MemoryStore) Close() {}
+
+// memTx is one operation on a MemoryStore. It records how to undo every change it makes.
+type memTx struct {
+	m    *MemoryStore
+	now  time.Time // the operation's NOW(), truncated to Postgres precision
+	undo []func()
+}
+
+// atomic runs fn under the store lock and reverts its changes if it fails.
+func (m *MemoryStore) atomic(fn func(tx *memTx) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tx := &memTx{m: m, now: time.Now().UTC().Truncate(time.Microsecond)}
+	if err := fn(tx); err != nil {
+		for i := len(tx.undo) - 1; i >= 0; i-- {
+			tx.undo[i]()
+		}
+		return err
+	}
+	return nil
+}
+
+// timestamp returns a fresh pointer to tx.now for a date column.
+func (tx *memTx) timestamp() *time.Time {
+	t := tx.now
+	return &t
+}
+
+// saveRow restores *row to its current value if tx is rolled back. Call it before changing a row.
+func saveRow[T any](tx *memTx, row *T) {
+	prev := *row
+	tx.undo = append(tx.undo, func() { *row = prev })
+}
+
+// setEntry sets mp[k] = v and restores the previous entry, or its absence, on rollback.
+func setEntry[K comparable, V any](tx *memTx, mp map[K]V, k K, v V) {
+	prev, had := mp[k]
+	mp[k] = v
+	tx.undo = append(tx.undo, func() {
+		if had {
+			mp[k] = prev
+		} else {
+			delete(mp, k)
+		}
+	})
+}
+
+// deleteEntry removes mp[k] and puts it back on rollback.
+func deleteEntry[K comparable, V any](tx *memTx, mp map[K]V, k K) {
+	prev, had := mp[k]
+	if !had {
+		return
+	}
+	delete(mp, k)
+	tx.undo = append(tx.undo, func() { mp[k] = prev })
+}
+
+// appendRow appends v to *s and truncates it again on rollback.
+func appendRow[T any](tx *memTx, s *[]T, v T) {
+	n := len(*s)
+	*s = append(*s, v)
+	tx.undo = append(tx.undo, func() { *s = (*s)[:n] })
+}
+
+// memUserID validates a required actor UUID like the Store mutations do.
+func memUserID(userID string) (string, error) {
+	if strings.TrimSpace(userID) == "" {
+		return "", errors.New("userID is required (UUID)")
+	}
+	return canonicalUUID(userID)
+}
+
+// normalizeJSON round-trips v through JSON, so stored metadata reads back the way it does
+// from a jsonb column (numbers as float64 and so on).
+func normalizeJSON[M ~map[string]V, V any](v M) (M, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out M
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, err
+	}
+	if out == nil {
+		out = M{}
+	}
+	return out, nil
+}
+
+// --------------------------------------------
+// Row access
+// --------------------------------------------
+
+func (tx *memTx) get(id, coinType string) *Account {
+	return tx.m.byID[id][coinType]
+}
+
+// restored is get that restores the account first if it was archived.
+func (tx *memTx) restored(id, coinType string) *Account {
+	tx.unarchive(id)
+	return tx.get(id, coinType)
+}
+
+// unarchive moves the archived balances of id back, if there are any, like unarchive.
+func (tx *memTx) unarchive(id string) {
+	rows := tx.m.archived[id]
+	if rows == nil {
+		return
+	}
+	deleteEntry(tx, tx.m.archived, id)
+	for _, a := range rows {
+		tx.insertAccount(a)
+	}
+}
+
+// lock returns the balance row to change, restoring the account if it was archived. Like
+// lockAccount it fails with pgx.ErrNoRows if the row doesn't exist and with
+// ErrVersionConflict if expectedVersion doesn't match.
+func (tx *memTx) lock(id, coinType string, expectedVersion *int64) (*Account, error) {
+	a := tx.restored(id, coinType)
+	if a == nil {
+		return nil, pgx.ErrNoRows
+	}
+	if expectedVersion != nil && *expectedVersion != a.Version {
+		return nil, fmt.Errorf("%s/%s: expected version %d, have %d: %w", id, coinType, *expectedVersion, a.Version, ErrVersionConflict)
+	}
+	return a, nil
+}
+
+// checkAccountVersion checks a non-nil expectedVersion for a change to every coin type of
+// an account, like checkAccountVersion.
+func (tx *memTx) checkAccountVersion(id string, expectedVersion *int64) error {
+	if expectedVersion == nil {
+		return nil
+	}
+	if _, err := tx.lock(id, DefaultCoinType, expectedVersion); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	return nil
+}
+
+// balancesOf returns every balance row of an account, ordered by coin type.
+func (tx *memTx) balancesOf(id string) []*Account {
+	rows := slices.Collect(maps.Values(tx.m.byID[id]))
+	slices.SortFunc(rows, func(a, b *Account) int { return strings.Compare(a.CoinType, b.CoinType) })
+	return rows
+}
+
+// each calls fn for every balance row, in no particular order.
+func (tx *memTx) each(fn func(a *Account)) {
+	for _, types := range tx.m.byID {
+		for _, a := range types {
+			fn(a)
+		}
+	}
+}
+
+func (tx *memTx) insertAccount(a *Account) {
+	types := tx.m.byID[a.ID]
+	if types == nil {
+		types = map[string]*Account{}
+		setEntry(tx, tx.m.byID, a.ID, types)
+	}
+	setEntry(tx, types, a.CoinType, a)
+}
+
+// held sums the active, unexpired holds on one coin type of an account.
+func (tx *memTx) held(id, coinType string) int64 {
+	var held int64
+	for _, h := range tx.m.holdsBy[memKey{id, coinType}] {
+		if h.Status == HoldActive && h.ExpiresAt.After(tx.now) {
+			held += h.Amount
+		}
+	}
+	return held
+}
+
+// view copies a balance row for a caller, with Held and Available computed as scanAccount does.
+func (tx *memTx) view(a *Account) *Account {
+	if a == nil {
+		return nil
+	}
+	out := *a
+	out.Metadata = maps.Clone(a.Metadata)
+	out.Labels = maps.Clone(a.Labels)
+	out.Held = tx.held(a.ID, a.CoinType)
+	out.Available = out.Coins - out.Held + out.CreditLimit
+	return &out
+}
+
+func (tx *memTx) views(rows []*Account) []*Account {
+	var out []*Account
+	for _, a := range rows {
+		out = append(out, tx.view(a))
+	}
+	return out
+}
+
+// insertLedger appends a ledger entry, as Store.insertLedger does inside its transaction.
+func (tx *memTx) insertLedger(accountID, coinType string, delta, balanceAfter int64, userID, dataID, kind string, reversesID *int64) *LedgerEntry {
+	m := tx.m
+	m.ledgerSeq++
+	e := &LedgerEntry{
+		ID:           m.ledgerSeq,
+		AccountID:    accountID,
+		CoinType:     coinType,
+		Delta:        delta,
+		BalanceAfter: balanceAfter,
+		UserID:       userID,
+		DataID:       dataID,
+		Kind:         kind,
+		ReversesID:   reversesID,
+		CreatedAt:    tx.now,
+	}
+	appendRow(tx, &m.ledger, e)
+	k := memKey{accountID, coinType}
+	setEntry(tx, m.ledgerBy, k, append(m.ledgerBy[k], e))
+	return e
+}
+
+// createLot records the lot a credit came from. A nil expiresAt never expires.
+func (tx *memTx) createLot(accountID, coinType string, amount int64, expiresAt *time.Time, dataID string) {
+	m := tx.m
+	m.lotSeq++
+	var exp *time.Time
+	if expiresAt != nil {
+		t := expiresAt.UTC().Truncate(time.Microsecond)
+		exp = &t
+	}
+	k := memKey{accountID, coinType}
+	setEntry(tx, m.lots, k, append(m.lots[k], &CoinLot{
+		ID:        m.lotSeq,
+		AccountID: accountID,
+		CoinType:  coinType,
+		Amount:    amount,
+		Remaining: amount,
+		ExpiresAt: exp,
+		DataID:    dataID,
+		CreatedAt: tx.now,
+	}))
+}
+
+// lotOrder sorts lots soonest-to-expire first and never-expiring lots last.
+func lotOrder(a, b *CoinLot) int {
+	switch {
+	case a.ExpiresAt == nil && b.ExpiresAt != nil:
+		return 1
+	case a.ExpiresAt != nil && b.ExpiresAt == nil:
+		return -1
+	case a.ExpiresAt != nil && !a.ExpiresAt.Equal(*b.ExpiresAt):
+		return a.ExpiresAt.Compare(*b.ExpiresAt)
+	}
+	return cmp.Compare(a.ID, b.ID)
+}
+
+// consumeLots draws amount from the account's lots in lotOrder, like Store.consumeLots,
+// and returns what it drew from each lot.
+func (tx *memTx) consumeLots(accountID, coinType string, amount int64) []*lotDraw {
+	lots := slices.Clone(tx.m.lots[memKey{accountID, coinType}])
+	slices.SortFunc(lots, lotOrder)
+	var draws []*lotDraw
+	for _, l := range lots {
+		if amount <= 0 {
+			break
+		}
+		if l.Remaining <= 0 || l.ExpiresAt != nil && !l.ExpiresAt.After(tx.now) {
+			continue
+		}
+		take := min(l.Remaining, amount)
+		saveRow(tx, l)
+		l.Remaining -= take
+		amount -= take
+		draws = append(draws, &lotDraw{amount: take, expiresAt: l.ExpiresAt})
+	}
+	return draws
+}
+
+// carryLots gives accountID a lot for each of draws, like Store.carryLots.
+func (tx *memTx) carryLots(accountID, coinType string, draws []*lotDraw, dataID string) {
+	for _, d := range draws {
+		tx.createLot(accountID, coinType, d.amount, d.expiresAt, dataID)
+	}
+}
+
+// notify queues a transaction notification with the operation, like Store.notify.
+func (tx *memTx) notify(userID, coinID, coinType, dataID string, coinUsed float64, when, expiry time.Time) {
+	if tx.m.Notifier == nil {
+		return
+	}
+	tx.enqueue(OutboxTopicTransaction, userID, coinID, coinType, dataID, coinUsed, when, expiry)
+}
+
+// enqueue appends an outbox entry of topic with the operation.
+func (tx *memTx) enqueue(topic, userID, coinID, coinType, dataID string, coinUsed float64, when, expiry time.Time) {
+	m := tx.m
+	m.outboxSeq++
+	appendRow(tx, &m.outbox, &OutboxEntry{
+		ID:            m.outboxSeq,
+		AccountID:     coinID,
+		CoinType:      coinType,
+		UserID:        userID,
+		DataID:        dataID,
+		CoinUsed:      coinUsed,
+		OccurredAt:    when.UTC(),
+		Status:        OutboxPending,
+		NextAttemptAt: tx.now,
+		CreatedAt:     tx.now,
+		Expiry:        nullTime(expiry),
+		Topic:         topic,
+	})
+}
+
+// notifyOverdrawn queues an overdraft alert when a debit takes a balance below zero, like
+// Store.notifyOverdrawn.
+func (tx *memTx) notifyOverdrawn(userID, accountID, coinType string, before, after int64) {
+	if before < 0 || after >= 0 {
+		return
+	}
+	now := time.Now().UTC()
+	tx.m.logger().Warn("account overdrawn",
+		slog.String("accountID", accountID),
+		slog.String("coinType", coinType),
+		slog.Int64("coins", after),
+	)
+	if tx.m.Alerts == nil {
+		return
+	}
+	dataID := fmt.Sprintf("overdrawn:%s:%d", accountID, now.UnixNano())
+	tx.enqueue(OutboxTopicOverdrawn, userID, accountID, coinType, dataID, float64(-after), now, time.Time{})
+}
+
+// claimIdempotency reserves (op, accountID, coinType, dataID), like Store.claimIdempotency.
+func (tx *memTx) claimIdempotency(op, accountID, coinType, dataID, hash string) ([]byte, error) {
+	k := idemKey{op, accountID, coinType, dataID}
+	rec := tx.m.idem[k]
+	if rec == nil {
+		setEntry(tx, tx.m.idem, k, &idemRecord{hash: hash})
+		return nil, nil
+	}
+	if rec.hash != hash {
+		return nil, fmt.Errorf("%s %s: %w", op, dataID, ErrIdempotencyConflict)
+	}
+	return rec.response, nil
+}
+
+// completeIdempotency stores the response for a key claimed earlier in the same operation.
+func (tx *memTx) completeIdempotency(op, accountID, coinType, dataID string, response any) error {
+	b, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	rec := tx.m.idem[idemKey{op, accountID, coinType, dataID}]
+	saveRow(tx, rec)
+	rec.response = b
+	return nil
+}
+
+// spendLimits returns the limits in effect for one coin type of an account, ordered by window.
+func (tx *memTx) spendLimits(accountID, coinType string) []*SpendLimit {
+	byWindow := map[int64]*SpendLimit{}
+	for k, l := range tx.m.limits {
+		if k.coinType != coinType || (k.accountID != accountID && k.accountID != "") {
+			continue
+		}
+		// an account's own row wins over the '' default
+		if cur := byWindow[k.window]; cur == nil || k.accountID > cur.AccountID {
+			byWindow[k.window] = l
+		}
+	}
+	out := []*SpendLimit{}
+	for _, w := range slices.Sorted(maps.Keys(byWindow)) {
+		l := *byWindow[w]
+		out = append(out, &l)
+	}
+	return out
+}
+
+// checkSpendLimits fails if debiting amount now would break a limit in effect for the account.
+func (tx *memTx) checkSpendLimits(accountID, coinType string, amount int64) error {
+	entries := tx.m.ledgerBy[memKey{accountID, coinType}]
+	for _, l := range tx.spendLimits(accountID, coinType) {
+		if l.MaxAmount == nil && l.MaxCount == nil {
+			continue
+		}
+		window := time.Duration(l.WindowSeconds) * time.Second
+		since := tx.now.Add(-window)
+		var spent, count int64
+		for i := len(entries) - 1; i >= 0 && entries[i].CreatedAt.After(since); i-- {
+			if slices.Contains(debitKinds, entries[i].Kind) {
+				spent -= entries[i].Delta
+				count++
+			}
+		}
+		if l.MaxAmount != nil && spent+amount > *l.MaxAmount {
+			return fmt.Errorf("%s: %d spent in %s, %d more would exceed %d: %w", accountID, spent, window, amount, *l.MaxAmount, ErrSpendLimitExceeded)
+		}
+		if l.MaxCount != nil && count+1 > *l.MaxCount {
+			return fmt.Errorf("%s: %d debits in %s, limit %d: %w", accountID, count, window, *l.MaxCount, ErrSpendLimitExceeded)
+		}
+	}
+	return nil
+}
+
+// drawFromPool moves up to need coins from a member's parent into the member, with the
+// caps and records of Store.drawFromPool. It returns the amount moved.
+func (tx *memTx) drawFromPool(member *Account, need int64, userID, dataID string) (int64, error) {
+	if member.ParentID == nil || need <= 0 {
+		return 0, nil
+	}
+	if member.PoolAllowance != nil {
+		need = min(need, *member.PoolAllowance)
+	}
+	if need <= 0 {
+		return 0, nil
+	}
+	parent := tx.get(*member.ParentID, member.CoinType)
+	if parent == nil || parent.Status != StatusActive {
+		return 0, nil
+	}
+	draw := min(need, parent.Coins-tx.held(parent.ID, parent.CoinType))
+	if draw <= 0 {
+		return 0, nil
+	}
+	if err := tx.checkSpendLimits(parent.ID, parent.CoinType, draw); err != nil {
+		return 0, fmt.Errorf("shared wallet %s: %w", parent.ID, err)
+	}
+	saveRow(tx, parent)
+	parent.Coins -= draw
+	parent.Version++
+	parent.LastUsageDate = tx.timestamp()
+	tx.consumeLots(parent.ID, parent.CoinType, draw)
+	saveRow(tx, member)
+	member.Coins += draw
+	if member.PoolAllowance != nil {
+		left := *member.PoolAllowance - draw
+		member.PoolAllowance = &left
+	}
+	poolDataID := dataID + ":pool"
+	tx.insertLedger(parent.ID, parent.CoinType, -draw, parent.Coins, userID, poolDataID, LedgerKindPoolDraw, nil)
+	tx.insertLedger(member.ID, member.CoinType, draw, member.Coins, userID, poolDataID, LedgerKindPoolCredit, nil)
+	tx.notify(userID, parent.ID, parent.CoinType, poolDataID, float64(draw), time.Now().UTC(), time.Time{})
+	return draw, nil
+}
+
+// --------------------------------------------
+// Reads
+// --------------------------------------------
+
+// GetAccount returns the balance of one coin type of an account (DefaultCoinType if empty).
+func (m *MemoryStore) GetAccount(ctx context.Context, id, coinType string) (*Account, error) {
+	var acc *Account
+	_ = m.atomic(func(tx *memTx) error {
+		acc = tx.view(tx.restored(id, coinTypeOrDefault(coinType)))
+		return nil
+	})
+	return acc, nil
+}
+
+// GetAccounts returns the coinType balances of the given ids that exist, ordered by id.
+func (m *MemoryStore) GetAccounts(ctx context.Context, ids []string, coinType string) ([]*Account, error) {
+	coinType = coinTypeOrDefault(coinType)
+	out := []*Account{}
+	_ = m.atomic(func(tx *memTx) error {
+		for _, id := range slices.Compact(slices.Sorted(slices.Values(ids))) {
+			if a := tx.get(id, coinType); a != nil {
+				out = append(out, tx.view(a))
+			}
+		}
+		return nil
+	})
+	return out, nil
+}
+
+// ListBalances returns every coin type balance of an account, ordered by coin type.
+func (m *MemoryStore) ListBalances(ctx context.Context, id string) ([]*Account, error) {
+	var out []*Account
+	_ = m.atomic(func(tx *memTx) error {
+		tx.unarchive(id)
+		out = tx.views(tx.balancesOf(id))
+		return nil
+	})
+	return out, nil
+}
+
+// memListing is the in-memory form of accountListing: a filter, a total order and how to
+// place a cursor in that order.
+type memListing struct {
+	name   string
+	match  func(a *Account) bool
+	order  func(a, b *Account) int
+	probe  func(c *pageCursor) *Account // a row sorting where the cursor points
+	cursor func(a *Account) pageCursor
+}
+
+// byIDAndType breaks ties of every listing order.
+func byIDAndType(a, b *Account) int {
+	if c := strings.Compare(a.ID, b.ID); c != 0 {
+		return c
+	}
+	return strings.Compare(a.CoinType, b.CoinType)
+}
+
+// compareTime orders times with nil first, like COALESCE(t, '-infinity').
+func compareTime(a, b *time.Time) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	return a.Compare(*b)
+}
+
+func (m *MemoryStore) pageAccounts(l memListing, page PageArgs) (*AccountPage, error) {
+	first := page.First
+	switch {
+	case first == 0:
+		first = DefaultPageSize
+	case first < 0 || first > MaxPageSize:
+		return nil, fmt.Errorf("%s: first must be between 1 and %d", l.name, MaxPageSize)
+	}
+	var after *Account
+	if page.After != "" {
+		c, err := decodeCursor(l.name, page.After)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", l.name, err)
+		}
+		after = l.probe(c)
+		after.ID, after.CoinType = c.ID, c.CoinType
+	}
+	out := &AccountPage{Accounts: []*Account{}, Cursors: []string{}, HasPreviousPage: page.After != ""}
+	_ = m.atomic(func(tx *memTx) error {
+		var rows []*Account
+		tx.each(func(a *Account) {
+			if l.match(a) && (after == nil || l.order(a, after) > 0) {
+				rows = append(rows, a)
+			}
+		})
+		slices.SortFunc(rows, l.order)
+		if len(rows) > first {
+			rows = rows[:first]
+			out.HasNextPage = true
+		}
+		for _, a := range rows {
+			out.Accounts = append(out.Accounts, tx.view(a))
+		}
+		return nil
+	})
+	for _, a := range out.Accounts {
+		c := l.cursor(a)
+		c.K, c.ID, c.CoinType = l.name, a.ID, a.CoinType
+		out.Cursors = append(out.Cursors, c.encode())
+	}
+	out.count = func(ctx context.Context) (int64, error) {
+		var n int64
+		_ = m.atomic(func(tx *memTx) error {
+			tx.each(func(a *Account) {
+				if l.match(a) {
+					n++
+				}
+			})
+			return nil
+		})
+		return n, nil
+	}
+	return out, nil
+}
+
+// matches reports whether labels satisfy every requirement of the selector.
+func (sel LabelSelector) matches(labels map[string]string) bool {
+	for _, r := range sel {
+		v, ok := labels[r.Key]
+		switch r.Op {
+		case LabelEquals:
+			if !ok || v != r.Value {
+				return false
+			}
+		case LabelNotEquals:
+			if ok && v == r.Value {
+				return false
+			}
+		case LabelExists:
+			if !ok {
+				return false
+			}
+		case LabelNotExists:
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ListAccounts pages through the balances that match f, ordered by id and coin type.
+func (m *MemoryStore) ListAccounts(ctx context.Context, page PageArgs, f AccountFilter) (*AccountPage, error) {
+	return m.pageAccounts(memListing{
+		name:   "ListAccounts",
+		match:  func(a *Account) bool { return (f.Status == "" || a.Status == f.Status) && f.Labels.matches(a.Labels) },
+		order:  byIDAndType,
+		probe:  func(c *pageCursor) *Account { return &Account{} },
+		cursor: func(a *Account) pageCursor { return pageCursor{} },
+	}, page)
+}
+
+// ListAccountsByCoinsRange pages through the balances within [min, max], richest first.
+func (m *MemoryStore) ListAccountsByCoinsRange(ctx context.Context, min, max *int64, page PageArgs) (*AccountPage, error) {
+	return m.pageAccounts(memListing{
+		name:  "ListAccountsByCoinsRange",
+		match: func(a *Account) bool { return (min == nil || a.Coins >= *min) && (max == nil || a.Coins <= *max) },
+		order: func(a, b *Account) int {
+			if c := cmp.Compare(b.Coins, a.Coins); c != 0 {
+				return c
+			}
+			return byIDAndType(a, b)
+		},
+		probe:  func(c *pageCursor) *Account { return &Account{Coins: c.Coins} },
+		cursor: func(a *Account) pageCursor { return pageCursor{Coins: a.Coins} },
+	}, page)
+}
+
+// ListRecentRecharges pages through the balances recharged since the given time, most recent first.
+func (m *MemoryStore) ListRecentRecharges(ctx context.Context, since time.Time, page PageArgs) (*AccountPage, error) {
+	return m.pageAccounts(memListing{
+		name:  "ListRecentRecharges",
+		match: func(a *Account) bool { return a.LastRechargeDate != nil && !a.LastRechargeDate.Before(since) },
+		order: func(a, b *Account) int {
+			if c := compareTime(b.LastRechargeDate, a.LastRechargeDate); c != 0 {
+				return c
+			}
+			return byIDAndType(a, b)
+		},
+		probe:  func(c *pageCursor) *Account { return &Account{LastRechargeDate: c.At} },
+		cursor: func(a *Account) pageCursor { return pageCursor{At: a.LastRechargeDate} },
+	}, page)
+}
+
+// ListInactiveSince pages through the balances not used since before (or never), least recently used first.
+func (m *MemoryStore) ListInactiveSince(ctx context.Context, before time.Time, page PageArgs) (*AccountPage, error) {
+	return m.pageAccounts(memListing{
+		name:  "ListInactiveSince",
+		match: func(a *Account) bool { return a.LastUsageDate == nil || a.LastUsageDate.Before(before) },
+		order: func(a, b *Account) int {
+			if c := compareTime(a.LastUsageDate, b.LastUsageDate); c != 0 {
+				return c
+			}
+			return byIDAndType(a, b)
+		},
+		probe:  func(c *pageCursor) *Account { return &Account{LastUsageDate: c.At} },
+		cursor: func(a *Account) pageCursor { return pageCursor{At: a.LastUsageDate} },
+	}, page)
+}
+
+// ListOverdrawnAccounts returns the balances below zero, most overdrawn first.
+// An empty coinType lists every coin type.
+func (m *MemoryStore) ListOverdrawnAccounts(ctx context.Context, coinType string) ([]*Account, error) {
+	var out []*Account
+	_ = m.atomic(func(tx *memTx) error {
+		var rows []*Account
+		tx.each(func(a *Account) {
+			if a.Coins < 0 && (coinType == "" || a.CoinType == coinType) {
+				rows = append(rows, a)
+			}
+		})
+		slices.SortFunc(rows, func(a, b *Account) int {
+			if c := cmp.Compare(a.Coins, b.Coins); c != 0 {
+				return c
+			}
+			return byIDAndType(a, b)
+		})
+		out = tx.views(rows)
+		return nil
+	})
+	return out, nil
+}
+
+// CountAccounts counts distinct account ids, whatever coin types they hold, archived or not.
+func (m *MemoryStore) CountAccounts(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return int64(len(m.byID) + len(m.archived)), nil
+}
+
+// SumCoins sums the balances of one coin type (DefaultCoinType if empty), archived ones included.
+func (m *MemoryStore) SumCoins(ctx context.Context, coinType string) (int64, error) {
+	coinType = coinTypeOrDefault(coinType)
+	var sum int64
+	_ = m.atomic(func(tx *memTx) error {
+		tx.each(func(a *Account) {
+			if a.CoinType == coinType {
+				sum += a.Coins
+			}
+		})
+		for _, rows := range m.archived {
+			for _, a := range rows {
+				if a.CoinType == coinType {
+					sum += a.Coins
+				}
+			}
+		}
+		return nil
+	})
+	return sum, nil
+}
+
+func (m *MemoryStore) UserExists(ctx context.Context, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.byID[id]) > 0 || len(m.archived[id]) > 0, nil
+}
+
+// ListMembers returns the coinType balances of the accounts directly below parentID, ordered by id.
+func (m *MemoryStore) ListMembers(ctx context.Context, parentID, coinType string) ([]*Account, error) {
+	coinType = coinTypeOrDefault(coinType)
+	out := []*Account{}
+	_ = m.atomic(func(tx *memTx) error {
+		var rows []*Account
+		tx.each(func(a *Account) {
+			if a.ParentID != nil && *a.ParentID == parentID && a.CoinType == coinType {
+				rows = append(rows, a)
+			}
+		})
+		slices.SortFunc(rows, byIDAndType)
+		for _, a := range rows {
+			out = append(out, tx.view(a))
+		}
+		return nil
+	})
+	return out, nil
+}
+
+// GetHierarchyTotals sums one coin type over rootID and every account below it, at any depth.
+// It returns nil if rootID holds no coinType balance and has no members that do.
+func (m *MemoryStore) GetHierarchyTotals(ctx context.Context, rootID, coinType string) (*HierarchyTotals, error) {
+	coinType = coinTypeOrDefault(coinType)
+	t := HierarchyTotals{RootID: rootID, CoinType: coinType}
+	_ = m.atomic(func(tx *memTx) error {
+		children := map[string][]string{}
+		for id, types := range tx.m.byID {
+			for _, a := range types {
+				if a.ParentID != nil {
+					children[*a.ParentID] = append(children[*a.ParentID], id)
+					break
+				}
+			}
+		}
+		level := []string{rootID}
+		seen := map[string]bool{rootID: true}
+		for depth := int64(0); len(level) > 0 && depth <= maxHierarchyDepth; depth++ {
+			var next []string
+			for _, id := range level {
+				if a := tx.get(id, coinType); a != nil {
+					t.Accounts++
+					t.Depth = depth
+					t.Coins += a.Coins
+					t.Held += tx.held(id, coinType)
+				}
+				for _, c := range children[id] {
+					if !seen[c] {
+						seen[c] = true
+						next = append(next, c)
+					}
+				}
+			}
+			level = next
+		}
+		return nil
+	})
+	if t.Accounts == 0 {
+		return nil, nil
+	}
+	t.Available = t.Coins - t.Held
+	return &t, nil
+}
+
+// sameJSON reports whether two maps hold the same JSON document.
+func sameJSON[M ~map[string]V, V any](a, b M) bool {
+	return reflect.DeepEqual(a, b) || (len(a) == 0 && len(b) == 0)
+}
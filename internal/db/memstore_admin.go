@@ -0,0 +1,419 @@
+package db
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// --------------------------------------------
+// In-memory AccountStore: admin audit trail
+// --------------------------------------------
+
+func (f AuditFilter) matches(e *AuditEntry) bool {
+	return (f.Operation == "" || e.Operation == f.Operation) &&
+		(f.Caller == "" || e.Caller == f.Caller) &&
+		(f.Outcome == "" || e.Outcome == f.Outcome) &&
+		(f.Since == nil || !e.CreatedAt.Before(*f.Since)) &&
+		(f.Until == nil || e.CreatedAt.Before(*f.Until))
+}
+
+// compactJSON validates a JSON document like a jsonb column would, keeping nil as NULL.
+func compactJSON(v json.RawMessage) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	var b bytes.Buffer
+	if err := json.Compact(&b, v); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+// cloneAudit copies an entry so callers can't reach the stored one.
+func cloneAudit(e *AuditEntry) *AuditEntry {
+	c := clonePtr(e)
+	c.Args, c.Before, c.After = slices.Clone(e.Args), slices.Clone(e.Before), slices.Clone(e.After)
+	c.FinishedAt = clonePtr(e.FinishedAt)
+	return c
+}
+
+// RunAudited runs fn, an admin mutation, with an audit entry, like Store.RunAudited. The
+// in-memory store has no transaction spanning several calls, so the states before and after
+// are read right around fn rather than together with it.
+func (m *MemoryStore) RunAudited(ctx context.Context, e *AuditEntry, state func(context.Context) (any, error), fn func(context.Context) (any, error)) (any, error) {
+	pending := *e
+	if state != nil {
+		before, err := auditState(ctx, state)
+		if err != nil {
+			return nil, fmt.Errorf("audit: %w", err)
+		}
+		pending.Before = before
+	}
+	entry, err := m.StartAudit(ctx, &pending)
+	if err != nil {
+		return nil, fmt.Errorf("audit: %w", err)
+	}
+	res, opErr := fn(ctx)
+	var after json.RawMessage
+	if state != nil {
+		after, _ = auditState(ctx, state)
+	}
+	_ = m.FinishAudit(ctx, entry.ID, after, opErr)
+	return res, opErr
+}
+
+// StartAudit records an admin mutation that is about to run, as pending, and returns the
+// stored entry. Callers should not run the mutation if this fails.
+func (m *MemoryStore) StartAudit(ctx context.Context, e *AuditEntry) (*AuditEntry, error) {
+	args := e.Args
+	if args == nil {
+		args = json.RawMessage(`{}`)
+	}
+	args, err := compactJSON(args)
+	if err != nil {
+		return nil, fmt.Errorf("audit args: %w", err)
+	}
+	before, err := compactJSON(e.Before)
+	if err != nil {
+		return nil, fmt.Errorf("audit before: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.auditSeq++
+	row := &AuditEntry{
+		ID:        m.auditSeq,
+		Operation: e.Operation,
+		Caller:    e.Caller,
+		Verified:  e.Verified,
+		SourceIP:  e.SourceIP,
+		Args:      args,
+		Before:    before,
+		Outcome:   AuditPending,
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+	m.audit = append(m.audit, row)
+	return cloneAudit(row), nil
+}
+
+// FinishAudit completes a pending audit entry with the state after the mutation and its outcome.
+func (m *MemoryStore) FinishAudit(ctx context.Context, id int64, after json.RawMessage, opErr error) error {
+	after, err := compactJSON(after)
+	if err != nil {
+		return fmt.Errorf("audit after: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, found := slices.BinarySearchFunc(m.audit, id, func(e *AuditEntry, id int64) int { return cmp.Compare(e.ID, id) })
+	if !found || m.audit[i].Outcome != AuditPending {
+		return nil
+	}
+	e := m.audit[i]
+	e.After, e.Outcome, e.Error = after, AuditOK, ""
+	if opErr != nil {
+		e.Outcome, e.Error = AuditError, opErr.Error()
+	}
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	e.FinishedAt = &now
+	return nil
+}
+
+// ListAuditLog pages through the audit entries that match f, newest first.
+func (m *MemoryStore) ListAuditLog(ctx context.Context, f AuditFilter, page PageArgs) (*AuditPage, error) {
+	first := page.First
+	switch {
+	case first == 0:
+		first = DefaultPageSize
+	case first < 0 || first > MaxPageSize:
+		return nil, fmt.Errorf("auditLog: first must be between 1 and %d", MaxPageSize)
+	}
+	var afterID *int64
+	if page.After != "" {
+		id, err := auditCursor(page.After)
+		if err != nil {
+			return nil, fmt.Errorf("auditLog: %w", err)
+		}
+		afterID = &id
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := &AuditPage{Entries: []*AuditEntry{}, Cursors: []string{}, HasPreviousPage: afterID != nil}
+	for i := len(m.audit) - 1; i >= 0; i-- {
+		e := m.audit[i]
+		if (afterID != nil && e.ID >= *afterID) || !f.matches(e) {
+			continue
+		}
+		if len(out.Entries) == first {
+			out.HasNextPage = true
+			break
+		}
+		out.Entries = append(out.Entries, cloneAudit(e))
+		out.Cursors = append(out.Cursors, auditCursorOf(e))
+	}
+	return out, nil
+}
+
+// ExportAuditLog writes the audit entries that match f to w as newline-delimited JSON,
+// oldest first, and returns how many it wrote.
+func (m *MemoryStore) ExportAuditLog(ctx context.Context, f AuditFilter, w io.Writer) (int64, error) {
+	m.mu.Lock()
+	var rows []*AuditEntry
+	for _, e := range m.audit {
+		if f.matches(e) {
+			rows = append(rows, cloneAudit(e))
+		}
+	}
+	m.mu.Unlock()
+
+	enc := json.NewEncoder(w)
+	var n int64
+	for _, e := range rows {
+		if err := enc.Encode(e); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// --------------------------------------------
+// In-memory AccountStore: personal data export and erasure
+// --------------------------------------------
+
+// ExportAccount collects everything stored under account id, like Store.ExportAccount.
+// It returns nil if nothing is stored under id.
+func (m *MemoryStore) ExportAccount(ctx context.Context, id string) (*AccountExport, error) {
+	out := &AccountExport{
+		AccountID:     id,
+		ExportedAt:    time.Now().UTC(),
+		Ledger:        []*LedgerEntry{},
+		Lots:          []*CoinLot{},
+		Holds:         []*Hold{},
+		Notifications: []*OutboxEntry{},
+		Schedules:     []*RechargeSchedule{},
+		SpendLimits:   []*SpendLimit{},
+	}
+	_ = m.atomic(func(tx *memTx) error {
+		out.Balances = tx.views(tx.balancesOf(id))
+		if len(out.Balances) == 0 {
+			out.Balances = tx.views(m.archived[id])
+		}
+		for _, e := range m.ledger {
+			if e.AccountID == id {
+				out.Ledger = append(out.Ledger, clonePtr(e))
+			}
+		}
+		for k, lots := range m.lots {
+			if k.id == id {
+				for _, l := range lots {
+					out.Lots = append(out.Lots, clonePtr(l))
+				}
+			}
+		}
+		for _, h := range m.holds {
+			if h.AccountID == id {
+				out.Holds = append(out.Holds, clonePtr(h))
+			}
+		}
+		for _, e := range m.outbox {
+			if e.AccountID == id {
+				out.Notifications = append(out.Notifications, clonePtr(e))
+			}
+		}
+		for _, sc := range m.schedules {
+			if sc.AccountID == id {
+				out.Schedules = append(out.Schedules, copySchedule(sc))
+			}
+		}
+		for k, l := range m.limits {
+			if k.accountID == id {
+				out.SpendLimits = append(out.SpendLimits, clonePtr(l))
+			}
+		}
+		return nil
+	})
+	if out.Balances == nil {
+		out.Balances = []*Account{}
+	}
+	slices.SortFunc(out.Lots, func(a, b *CoinLot) int { return cmp.Compare(a.ID, b.ID) })
+	slices.SortFunc(out.Holds, func(a, b *Hold) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), strings.Compare(a.ID, b.ID))
+	})
+	slices.SortFunc(out.Schedules, func(a, b *RechargeSchedule) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), strings.Compare(a.ID, b.ID))
+	})
+	slices.SortFunc(out.SpendLimits, func(a, b *SpendLimit) int {
+		return cmp.Or(strings.Compare(a.CoinType, b.CoinType), cmp.Compare(a.WindowSeconds, b.WindowSeconds))
+	})
+	if out.empty() {
+		return nil, nil
+	}
+	return out, nil
+}
+
+// EraseAccount anonymizes account id for good, with the rules of Store.EraseAccount.
+func (m *MemoryStore) EraseAccount(ctx context.Context, id string) (*Erasure, error) {
+	out := &Erasure{Pseudonym: "erased-" + uuid.NewString()}
+	oldJSON, _ := json.Marshal(id)
+	newJSON, _ := json.Marshal(out.Pseudonym)
+	dataIDs := regexp.MustCompile(dataIDPattern(id))
+	scrub := func(dataID string) string {
+		return dataIDs.ReplaceAllString(dataID, "${1}"+out.Pseudonym+"${2}")
+	}
+
+	err := m.atomic(func(tx *memTx) error {
+		tx.unarchive(id)
+		rows := tx.balancesOf(id)
+		for _, a := range rows {
+			if a.Status != StatusClosed {
+				return fmt.Errorf("erase: %s: %w", id, ErrAccountNotClosed)
+			}
+		}
+		if len(rows) == 0 && !slices.ContainsFunc(m.ledger, func(e *LedgerEntry) bool { return e.AccountID == id }) {
+			return fmt.Errorf("erase: account %s not found", id)
+		}
+		out.Balances = len(rows)
+
+		if len(rows) > 0 {
+			deleteEntry(tx, m.byID, id)
+			setEntry(tx, m.byID, out.Pseudonym, map[string]*Account{})
+			for _, a := range rows {
+				saveRow(tx, a)
+				a.ID = out.Pseudonym
+				a.Metadata, a.Labels = map[string]any{}, map[string]string{}
+				a.Version++
+				m.byID[out.Pseudonym][a.CoinType] = a
+			}
+		}
+		tx.each(func(a *Account) {
+			if a.ParentID != nil && *a.ParentID == id {
+				saveRow(tx, a)
+				a.ParentID = clonePtr(&out.Pseudonym)
+			}
+		})
+
+		for _, e := range m.ledger {
+			if e.AccountID == id {
+				saveRow(tx, e)
+				e.AccountID = out.Pseudonym
+				out.Records++
+			}
+		}
+		for _, l := range m.lots {
+			for _, lot := range l {
+				if lot.AccountID == id {
+					saveRow(tx, lot)
+					lot.AccountID = out.Pseudonym
+					out.Records++
+				}
+			}
+		}
+		for _, h := range m.holds {
+			if h.AccountID == id {
+				saveRow(tx, h)
+				h.AccountID = out.Pseudonym
+				out.Records++
+			}
+		}
+		for _, e := range m.outbox {
+			if e.AccountID == id {
+				saveRow(tx, e)
+				e.AccountID = out.Pseudonym
+				out.Records++
+			}
+		}
+		for _, sc := range m.schedules {
+			if sc.AccountID == id {
+				saveRow(tx, sc)
+				sc.AccountID, sc.Enabled, sc.UpdatedAt = out.Pseudonym, false, tx.now
+				out.Records++
+			}
+		}
+		rekey(tx, m.ledgerBy, id, out.Pseudonym, nil)
+		rekey(tx, m.lots, id, out.Pseudonym, nil)
+		rekey(tx, m.holdsBy, id, out.Pseudonym, nil)
+		rekey(tx, m.snapshots, id, out.Pseudonym, func(n int) { out.Records += int64(n) })
+		for k, l := range m.limits {
+			if k.accountID == id {
+				deleteEntry(tx, m.limits, k)
+				c := *l
+				c.AccountID = out.Pseudonym
+				setEntry(tx, m.limits, spendKey{out.Pseudonym, k.coinType, k.window}, &c)
+				out.Records++
+			}
+		}
+
+		for _, e := range m.ledger {
+			if d := scrub(e.DataID); d != e.DataID {
+				saveRow(tx, e)
+				e.DataID = d
+			}
+		}
+		for _, l := range m.lots {
+			for _, lot := range l {
+				if d := scrub(lot.DataID); d != lot.DataID {
+					saveRow(tx, lot)
+					lot.DataID = d
+				}
+			}
+		}
+		for _, h := range m.holds {
+			if d := scrub(h.DataID); d != h.DataID {
+				saveRow(tx, h)
+				h.DataID = d
+			}
+		}
+		for _, e := range m.outbox {
+			if d := scrub(e.DataID); d != e.DataID {
+				saveRow(tx, e)
+				e.DataID = d
+			}
+		}
+
+		for k, rec := range m.idem {
+			if k.accountID == id {
+				deleteEntry(tx, m.idem, k)
+			} else if bytes.Contains(rec.response, oldJSON) {
+				saveRow(tx, rec)
+				rec.response = bytes.ReplaceAll(rec.response, oldJSON, newJSON)
+			}
+		}
+		for _, e := range m.audit {
+			if bytes.Contains(e.Args, oldJSON) || bytes.Contains(e.Before, oldJSON) || bytes.Contains(e.After, oldJSON) {
+				saveRow(tx, e)
+				e.Args = bytes.ReplaceAll(e.Args, oldJSON, newJSON)
+				e.Before = bytes.ReplaceAll(e.Before, oldJSON, newJSON)
+				e.After = bytes.ReplaceAll(e.After, oldJSON, newJSON)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// rekey moves the per-balance entries of account id in mp to account to. counted, if set,
+// is told how many rows moved.
+func rekey[V any](tx *memTx, mp map[memKey][]V, id, to string, counted func(n int)) {
+	for k, rows := range mp {
+		if k.id != id {
+			continue
+		}
+		deleteEntry(tx, mp, k)
+		setEntry(tx, mp, memKey{to, k.coinType}, rows)
+		if counted != nil {
+			counted(len(rows))
+		}
+	}
+}
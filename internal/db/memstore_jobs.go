@@ -0,0 +1,855 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"log/slog"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// --------------------------------------------
+// In-memory AccountStore: holds, lots, history, schedules, outbox and archival
+// --------------------------------------------
+
+func (m *MemoryStore) GetHold(ctx context.Context, id string) (*Hold, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return clonePtr(m.holds[id]), nil
+}
+
+// Authorize reserves amount on an account for ttl, like Store.Authorize.
+func (m *MemoryStore) Authorize(ctx context.Context, coinID, coinType string, amount int64, ttl time.Duration, expectedVersion *int64, userID, dataID string) (*Hold, error) {
+	coinType = coinTypeOrDefault(coinType)
+	if amount <= 0 {
+		return nil, errors.New("authorize: amount must be > 0")
+	}
+	if ttl <= 0 {
+		ttl = DefaultHoldTTL
+	}
+	userID, err := memUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	keyed := strings.TrimSpace(dataID) != ""
+
+	var h *Hold
+	err = m.atomic(func(tx *memTx) error {
+		if keyed {
+			prior, err := tx.claimIdempotency(IdemOpAuthorize, coinID, coinType, dataID, requestHash(IdemOpAuthorize, coinID, coinType, amount, ttl.Milliseconds(), userID, versionKey(expectedVersion)))
+			if err != nil {
+				return err
+			}
+			if prior != nil {
+				return json.Unmarshal(prior, &h)
+			}
+		}
+		a, err := tx.lock(coinID, coinType, expectedVersion)
+		if err != nil {
+			return err
+		}
+		if err := checkStatus(coinID, a.Status, true); err != nil {
+			return fmt.Errorf("authorize: %w", err)
+		}
+		if available := a.Coins - tx.held(coinID, coinType) + a.CreditLimit; available < amount {
+			return fmt.Errorf("authorize: insufficient balance (available %d, need %d)", available, amount)
+		}
+		h = &Hold{
+			ID:        uuid.NewString(),
+			AccountID: coinID,
+			CoinType:  coinType,
+			Amount:    amount,
+			Status:    HoldActive,
+			UserID:    userID,
+			DataID:    dataID,
+			ExpiresAt: tx.now.Add(ttl).Truncate(time.Microsecond),
+			CreatedAt: tx.now,
+			UpdatedAt: tx.now,
+		}
+		setEntry(tx, tx.m.holds, h.ID, h)
+		k := memKey{coinID, coinType}
+		setEntry(tx, tx.m.holdsBy, k, append(tx.m.holdsBy[k], h))
+		saveRow(tx, a)
+		a.Version++
+		h = clonePtr(h)
+		if keyed {
+			return tx.completeIdempotency(IdemOpAuthorize, coinID, coinType, dataID, h)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Capture turns an active hold into a debit, like Store.Capture.
+func (m *MemoryStore) Capture(ctx context.Context, holdID string, amount, expectedVersion *int64, userID, dataID string) (*Hold, *Account, error) {
+	userID, err := memUserID(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if strings.TrimSpace(dataID) == "" {
+		dataID = fmt.Sprintf("capture:%s", holdID)
+	}
+
+	var h *Hold
+	var acc *Account
+	err = m.atomic(func(tx *memTx) error {
+		cur := tx.m.holds[holdID]
+		if cur == nil {
+			return fmt.Errorf("capture: hold %s not found", holdID)
+		}
+		if cur.Status != HoldActive || !cur.ExpiresAt.After(tx.now) {
+			return fmt.Errorf("capture: hold %s (%s): %w", holdID, cur.Status, ErrHoldNotActive)
+		}
+		amt := cur.Amount
+		if amount != nil {
+			amt = *amount
+		}
+		if amt <= 0 || amt > cur.Amount {
+			return fmt.Errorf("capture: amount must be > 0 and <= %d", cur.Amount)
+		}
+
+		a, err := tx.lock(cur.AccountID, cur.CoinType, expectedVersion)
+		if err != nil {
+			return err
+		}
+		coins := a.Coins
+		if err := checkStatus(cur.AccountID, a.Status, true); err != nil {
+			return fmt.Errorf("capture: %w", err)
+		}
+		if err := tx.checkSpendLimits(cur.AccountID, cur.CoinType, amt); err != nil {
+			return fmt.Errorf("capture: %w", err)
+		}
+		// the hold reserved amt, but setCoins may have lowered the balance underneath it and
+		// the account's other holds still have first claim on what is left
+		if other := tx.held(cur.AccountID, cur.CoinType) - cur.Amount; coins-other+a.CreditLimit < amt {
+			return fmt.Errorf("capture: insufficient balance (have %d, held elsewhere %d, need %d)", coins, other, amt)
+		}
+		saveRow(tx, a)
+		a.Coins -= amt
+		a.Version++
+		a.LastUsageDate = tx.timestamp()
+		tx.consumeLots(cur.AccountID, cur.CoinType, amt)
+		saveRow(tx, cur)
+		cur.Status = HoldCaptured
+		cur.Captured = amt
+		cur.UpdatedAt = tx.now
+		tx.insertLedger(cur.AccountID, cur.CoinType, -amt, a.Coins, userID, dataID, LedgerKindCapture, nil)
+		tx.notifyOverdrawn(userID, cur.AccountID, cur.CoinType, coins, a.Coins)
+		h, acc = clonePtr(cur), tx.view(a)
+		tx.notify(userID, cur.AccountID, cur.CoinType, dataID, float64(amt), time.Now().UTC(), time.Time{})
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, acc, nil
+}
+
+// Void releases an active hold without debiting the account, like Store.Void.
+func (m *MemoryStore) Void(ctx context.Context, holdID string, expectedVersion *int64) (*Hold, error) {
+	var h *Hold
+	err := m.atomic(func(tx *memTx) error {
+		cur := tx.m.holds[holdID]
+		if cur == nil {
+			return fmt.Errorf("void: hold %s not found", holdID)
+		}
+		a, err := tx.lock(cur.AccountID, cur.CoinType, expectedVersion)
+		if err != nil {
+			return err
+		}
+		if cur.Status != HoldActive {
+			return fmt.Errorf("void: hold %s (%s): %w", holdID, cur.Status, ErrHoldNotActive)
+		}
+		saveRow(tx, a)
+		a.Version++
+		saveRow(tx, cur)
+		cur.Status = HoldVoided
+		cur.UpdatedAt = tx.now
+		h = clonePtr(cur)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// ExpireHolds marks active holds past their expiry as expired and returns how many were released.
+func (m *MemoryStore) ExpireHolds(ctx context.Context) (int64, error) {
+	var n int64
+	_ = m.atomic(func(tx *memTx) error {
+		for _, h := range tx.m.holds {
+			if h.Status == HoldActive && !h.ExpiresAt.After(tx.now) {
+				saveRow(tx, h)
+				h.Status = HoldExpired
+				h.UpdatedAt = tx.now
+				n++
+			}
+		}
+		return nil
+	})
+	if n > 0 {
+		m.logger().Info("ExpireHolds: released", slog.Int64("count", n))
+	}
+	return n, nil
+}
+
+// RunHoldSweeper calls ExpireHolds every interval until ctx is cancelled.
+func (m *MemoryStore) RunHoldSweeper(ctx context.Context, interval time.Duration) {
+	m.every(ctx, interval, time.Minute, "hold sweeper", func() { _, _ = m.ExpireHolds(ctx) })
+}
+
+// every calls fn every interval (def if not positive) until ctx is cancelled.
+func (m *MemoryStore) every(ctx context.Context, interval, def time.Duration, name string, fn func()) {
+	if interval <= 0 {
+		interval = def
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			m.logger().Info(name + ": stopped")
+			return
+		case <-t.C:
+			fn()
+		}
+	}
+}
+
+// ListLedger returns ledger entries of one coin type of an account, newest first.
+// after is the id of the last entry of the previous page (0 for the first page).
+func (m *MemoryStore) ListLedger(ctx context.Context, accountID, coinType string, first int, after int64) ([]*LedgerEntry, error) {
+	if first <= 0 {
+		first = 50
+	}
+	if first > 200 {
+		first = 200
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := m.ledgerBy[memKey{accountID, coinTypeOrDefault(coinType)}]
+	var out []*LedgerEntry
+	for i := len(entries) - 1; i >= 0 && len(out) < first; i-- {
+		if after == 0 || entries[i].ID < after {
+			out = append(out, clonePtr(entries[i]))
+		}
+	}
+	return out, nil
+}
+
+// ListUpcomingExpirations returns the unspent lots of one coin type of an account that will
+// expire, soonest first. A non-nil before limits the result to lots expiring until then.
+func (m *MemoryStore) ListUpcomingExpirations(ctx context.Context, accountID, coinType string, before *time.Time) ([]*CoinLot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	var out []*CoinLot
+	for _, l := range m.lots[memKey{accountID, coinTypeOrDefault(coinType)}] {
+		if l.Remaining > 0 && l.ExpiresAt != nil && l.ExpiresAt.After(now) && (before == nil || !l.ExpiresAt.After(*before)) {
+			out = append(out, clonePtr(l))
+		}
+	}
+	slices.SortFunc(out, lotOrder)
+	return out, nil
+}
+
+// ExpireLots burns the unspent remainder of every expired lot and returns the number of
+// coins burned, like Store.ExpireLots.
+func (m *MemoryStore) ExpireLots(ctx context.Context) (int64, error) {
+	var total int64
+	_ = m.atomic(func(tx *memTx) error {
+		for k, lots := range tx.m.lots {
+			a := tx.get(k.id, k.coinType)
+			if a == nil {
+				continue
+			}
+			var expired []*CoinLot
+			for _, l := range lots {
+				if l.Remaining > 0 && l.ExpiresAt != nil && !l.ExpiresAt.After(tx.now) {
+					expired = append(expired, l)
+				}
+			}
+			slices.SortFunc(expired, lotOrder)
+			coins := a.Coins
+			for _, l := range expired {
+				// never burn below zero; a setCoins may have lowered the balance without touching lots
+				amt := min(l.Remaining, coins)
+				saveRow(tx, l)
+				l.Remaining = 0
+				if amt <= 0 {
+					continue
+				}
+				coins -= amt
+				dataID := fmt.Sprintf("expire:%d", l.ID)
+				tx.insertLedger(k.id, k.coinType, -amt, coins, SystemUserID, dataID, LedgerKindExpire, nil)
+				tx.notify(SystemUserID, k.id, k.coinType, dataID, float64(amt), time.Now().UTC(), *l.ExpiresAt)
+			}
+			if coins != a.Coins {
+				total += a.Coins - coins
+				saveRow(tx, a)
+				a.Coins = coins
+				a.Version++
+			}
+		}
+		return nil
+	})
+	if total > 0 {
+		m.logger().Info("ExpireLots: burned", slog.Int64("coins", total))
+	}
+	return total, nil
+}
+
+// RunLotExpirer calls ExpireLots every interval until ctx is cancelled.
+func (m *MemoryStore) RunLotExpirer(ctx context.Context, interval time.Duration) {
+	m.every(ctx, interval, time.Minute, "lot expirer", func() { _, _ = m.ExpireLots(ctx) })
+}
+
+// asOf returns the balance of one key at time at from its latest snapshot and later ledger
+// entries, and whether anything was recorded by then. Callers hold m.mu.
+func (m *MemoryStore) asOf(k memKey, at time.Time) (int64, bool) {
+	var coins, from int64
+	found := false
+	for _, s := range m.snapshots[k] {
+		if !s.takenAt.After(at) {
+			coins, from, found = s.coins, s.ledgerID, true
+		}
+	}
+	for _, e := range m.ledgerBy[k] {
+		if e.ID > from && !e.CreatedAt.After(at) {
+			coins += e.Delta
+			found = true
+		}
+	}
+	return coins, found
+}
+
+// GetAccountAsOf reconstructs the balance of one coin type of an account at time at, like
+// Store.GetAccountAsOf.
+func (m *MemoryStore) GetAccountAsOf(ctx context.Context, id, coinType string, at time.Time) (*Account, error) {
+	coinType = coinTypeOrDefault(coinType)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	coins, ok := m.asOf(memKey{id, coinType}, at)
+	if !ok {
+		return nil, nil
+	}
+	return &Account{ID: id, CoinType: coinType, Coins: coins, Available: coins}, nil
+}
+
+// SumCoinsAsOf returns the total balance of a coin type across all accounts at time at.
+func (m *MemoryStore) SumCoinsAsOf(ctx context.Context, coinType string, at time.Time) (int64, error) {
+	coinType = coinTypeOrDefault(coinType)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var sum int64
+	// every snapshot is taken from a ledger entry, so the ledger keys cover them all
+	for k := range m.ledgerBy {
+		if k.coinType == coinType {
+			coins, _ := m.asOf(k, at)
+			sum += coins
+		}
+	}
+	return sum, nil
+}
+
+// SnapshotBalances records a snapshot of every balance whose ledger advanced since its
+// last snapshot and returns how many were written.
+func (m *MemoryStore) SnapshotBalances(ctx context.Context) (int64, error) {
+	var n int64
+	_ = m.atomic(func(tx *memTx) error {
+		m := tx.m
+		newest := map[memKey]*LedgerEntry{}
+		for _, e := range m.ledger {
+			if e.ID > m.snapshotLedgerMax {
+				newest[memKey{e.AccountID, e.CoinType}] = e
+			}
+		}
+		for k, e := range newest {
+			snaps := m.snapshots[k]
+			if len(snaps) > 0 && snaps[len(snaps)-1].ledgerID >= e.ID {
+				continue
+			}
+			setEntry(tx, m.snapshots, k, append(snaps, &balanceSnapshot{takenAt: tx.now, coins: e.BalanceAfter, ledgerID: e.ID}))
+			saveRow(tx, &m.snapshotLedgerMax)
+			m.snapshotLedgerMax = max(m.snapshotLedgerMax, e.ID)
+			n++
+		}
+		return nil
+	})
+	if n > 0 {
+		m.logger().Info("SnapshotBalances: recorded", slog.Int64("count", n))
+	}
+	return n, nil
+}
+
+// RunBalanceSnapshotter calls SnapshotBalances every interval until ctx is cancelled.
+func (m *MemoryStore) RunBalanceSnapshotter(ctx context.Context, interval time.Duration) {
+	m.every(ctx, interval, time.Hour, "balance snapshotter", func() { _, _ = m.SnapshotBalances(ctx) })
+}
+
+// copySchedule copies a schedule row for a caller.
+func copySchedule(sc *RechargeSchedule) *RechargeSchedule {
+	if sc == nil {
+		return nil
+	}
+	out := *sc
+	out.Cron, out.IntervalSeconds = clonePtr(sc.Cron), clonePtr(sc.IntervalSeconds)
+	out.LastRunAt, out.LastError = clonePtr(sc.LastRunAt), clonePtr(sc.LastError)
+	return &out
+}
+
+// CreateRechargeSchedule recharges one coin type of an account by amount on a cron expression
+// or every interval, like Store.CreateRechargeSchedule.
+func (m *MemoryStore) CreateRechargeSchedule(ctx context.Context, accountID, coinType string, amount int64, cron string, interval time.Duration, startAt *time.Time, expectedVersion *int64, userID string) (*RechargeSchedule, error) {
+	coinType = coinTypeOrDefault(coinType)
+	if amount <= 0 {
+		return nil, errors.New("schedule: amount must be > 0")
+	}
+	uid, err := canonicalUUID(userID)
+	if err != nil {
+		return nil, err
+	}
+	sc := &RechargeSchedule{ID: uuid.NewString(), AccountID: accountID, CoinType: coinType, Amount: amount, UserID: uid, Enabled: true}
+	if err := sc.setTiming(cron, interval); err != nil {
+		return nil, err
+	}
+	if startAt != nil {
+		sc.NextRunAt = startAt.UTC()
+	} else if sc.NextRunAt, err = sc.nextRun(time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	if sc.NextRunAt.IsZero() {
+		return nil, fmt.Errorf("schedule: cron %q never fires", *sc.Cron)
+	}
+	sc.NextRunAt = sc.NextRunAt.Truncate(time.Microsecond)
+
+	err = m.atomic(func(tx *memTx) error {
+		if err := tx.checkScheduleVersion(&RechargeSchedule{AccountID: accountID, CoinType: coinType}, expectedVersion); err != nil {
+			return err
+		}
+		sc.CreatedAt, sc.UpdatedAt = tx.now, tx.now
+		setEntry(tx, tx.m.schedules, sc.ID, sc)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copySchedule(sc), nil
+}
+
+// UpdateRechargeSchedule changes the non-nil fields of a schedule, like Store.UpdateRechargeSchedule.
+func (m *MemoryStore) UpdateRechargeSchedule(ctx context.Context, id string, amount *int64, cron *string, interval *time.Duration, enabled *bool, expectedVersion *int64) (*RechargeSchedule, error) {
+	var out *RechargeSchedule
+	err := m.atomic(func(tx *memTx) error {
+		cur := tx.m.schedules[id]
+		if cur == nil {
+			return fmt.Errorf("schedule %s not found", id)
+		}
+		if expectedVersion != nil {
+			if err := tx.checkScheduleVersion(cur, expectedVersion); err != nil {
+				return err
+			}
+		}
+		// work on a copy, so a failed validation leaves the row alone
+		sc := copySchedule(cur)
+		reschedule := false
+		if amount != nil {
+			if *amount <= 0 {
+				return errors.New("schedule: amount must be > 0")
+			}
+			sc.Amount = *amount
+		}
+		if cron != nil || interval != nil {
+			var c string
+			var d time.Duration
+			if cron != nil {
+				c = *cron
+			}
+			if interval != nil {
+				d = *interval
+			}
+			if err := sc.setTiming(c, d); err != nil {
+				return err
+			}
+			reschedule = true
+		}
+		if enabled != nil {
+			reschedule = reschedule || (*enabled && !sc.Enabled)
+			sc.Enabled = *enabled
+		}
+		if reschedule {
+			var err error
+			sc.NextRunAt = time.Time{}
+			if sc.NextRunAt, err = sc.nextRun(time.Now().UTC()); err != nil {
+				return err
+			}
+			if sc.NextRunAt.IsZero() {
+				return fmt.Errorf("schedule: cron %q never fires", *sc.Cron)
+			}
+			sc.NextRunAt = sc.NextRunAt.Truncate(time.Microsecond)
+		}
+		sc.UpdatedAt = tx.now
+		saveRow(tx, cur)
+		*cur = *sc
+		out = copySchedule(cur)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DeleteRechargeSchedule removes a schedule and reports whether it existed.
+func (m *MemoryStore) DeleteRechargeSchedule(ctx context.Context, id string, expectedVersion *int64) (bool, error) {
+	ok := false
+	err := m.atomic(func(tx *memTx) error {
+		sc := tx.m.schedules[id]
+		if sc == nil {
+			return nil
+		}
+		if expectedVersion != nil {
+			if err := tx.checkScheduleVersion(sc, expectedVersion); err != nil {
+				return err
+			}
+		}
+		deleteEntry(tx, tx.m.schedules, id)
+		ok = true
+		return nil
+	})
+	return ok, err
+}
+
+// checkScheduleVersion checks expectedVersion against the balance sc recharges, like
+// checkScheduleVersion.
+func (tx *memTx) checkScheduleVersion(sc *RechargeSchedule, expectedVersion *int64) error {
+	if _, err := tx.lock(sc.AccountID, sc.CoinType, expectedVersion); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("schedule: account %s/%s not found", sc.AccountID, sc.CoinType)
+		}
+		return err
+	}
+	return nil
+}
+
+// GetRechargeSchedule returns a schedule, or nil if it doesn't exist.
+func (m *MemoryStore) GetRechargeSchedule(ctx context.Context, id string) (*RechargeSchedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return copySchedule(m.schedules[id]), nil
+}
+
+// ListRechargeSchedules returns the schedules of an account (all if accountID is empty), soonest first.
+func (m *MemoryStore) ListRechargeSchedules(ctx context.Context, accountID string) ([]*RechargeSchedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := []*RechargeSchedule{}
+	for _, sc := range m.schedules {
+		if accountID == "" || sc.AccountID == accountID {
+			out = append(out, copySchedule(sc))
+		}
+	}
+	slices.SortFunc(out, scheduleOrder)
+	return out, nil
+}
+
+// scheduleOrder sorts schedules soonest first.
+func scheduleOrder(a, b *RechargeSchedule) int {
+	if c := a.NextRunAt.Compare(b.NextRunAt); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.ID, b.ID)
+}
+
+// FireDueSchedules recharges up to batch due schedules and returns how many fired, like
+// Store.FireDueSchedules. A pass already running makes concurrent calls return 0.
+func (m *MemoryStore) FireDueSchedules(ctx context.Context, batch int) (int, error) {
+	if batch <= 0 {
+		batch = 100
+	}
+	if !m.schedulerMu.TryLock() {
+		m.logger().Debug("scheduler: another pass is running")
+		return 0, nil
+	}
+	defer m.schedulerMu.Unlock()
+
+	var due []*RechargeSchedule
+	m.mu.Lock()
+	now := time.Now()
+	for _, sc := range m.schedules {
+		if sc.Enabled && !sc.NextRunAt.After(now) {
+			due = append(due, copySchedule(sc))
+		}
+	}
+	m.mu.Unlock()
+	slices.SortFunc(due, scheduleOrder)
+	if len(due) > batch {
+		due = due[:batch]
+	}
+
+	fired := 0
+	for _, sc := range due {
+		dataID := fmt.Sprintf("schedule:%s:%d", sc.ID, sc.NextRunAt.Unix())
+		var lastErr *string
+		if _, err := m.Recharge(ctx, sc.AccountID, sc.CoinType, sc.Amount, nil, sc.UserID, dataID); err != nil {
+			if ctx.Err() != nil {
+				return fired, ctx.Err()
+			}
+			msg := err.Error()
+			lastErr = &msg
+		} else {
+			fired++
+		}
+		enabled := true
+		next, err := sc.nextRun(time.Now().UTC())
+		if err != nil || next.IsZero() {
+			// nothing left to fire; keep the row for inspection
+			enabled, next = false, sc.NextRunAt
+		}
+		_ = m.atomic(func(tx *memTx) error {
+			cur := tx.m.schedules[sc.ID]
+			if cur == nil || !cur.NextRunAt.Equal(sc.NextRunAt) {
+				return nil
+			}
+			saveRow(tx, cur)
+			cur.NextRunAt, cur.Enabled = next.Truncate(time.Microsecond), enabled
+			cur.LastRunAt, cur.LastError, cur.UpdatedAt = tx.timestamp(), lastErr, tx.now
+			return nil
+		})
+	}
+	if len(due) > 0 {
+		m.logger().Info("scheduler: pass done", slog.Int("due", len(due)), slog.Int("fired", fired))
+	}
+	return fired, nil
+}
+
+// RunRechargeScheduler fires due schedules every interval (default 1m) until ctx is cancelled.
+func (m *MemoryStore) RunRechargeScheduler(ctx context.Context, interval time.Duration) {
+	m.every(ctx, interval, time.Minute, "recharge scheduler", func() {
+		if _, err := m.FireDueSchedules(ctx, 0); err != nil && !errors.Is(err, context.Canceled) {
+			m.logger().Error("scheduler: pass failed", slog.String("error", err.Error()))
+		}
+	})
+}
+
+// DispatchOutbox runs a single delivery pass and returns how many entries were attempted,
+// keeping per-account order like Store.DispatchOutbox. Entries are claimed under the store
+// lock and delivered without it.
+func (m *MemoryStore) DispatchOutbox(ctx context.Context, cfg OutboxConfig) (int, error) {
+	cfg = cfg.withDefaults()
+	if m.Notifier == nil && m.Alerts == nil {
+		return 0, errors.New("outbox: notifier is nil")
+	}
+
+	var batch []*OutboxEntry
+	m.mu.Lock()
+	now := time.Now()
+	blocked := map[string]bool{}
+	for _, e := range m.outbox {
+		if len(batch) == cfg.BatchSize {
+			break
+		}
+		if e.Status != OutboxPending {
+			continue
+		}
+		// only the oldest pending entry of each account is eligible
+		first := !blocked[e.AccountID]
+		blocked[e.AccountID] = true
+		if first && !m.inFlight[e.ID] && !e.NextAttemptAt.After(now) {
+			m.inFlight[e.ID] = true
+			batch = append(batch, clonePtr(e))
+		}
+	}
+	m.mu.Unlock()
+
+	type result struct {
+		e   *OutboxEntry
+		err error
+	}
+	results := make([]result, 0, len(batch))
+	for _, e := range batch {
+		sendCtx, cancel := context.WithTimeout(ctx, cfg.SendTimeout)
+		sendErr := deliver(sendCtx, m.Notifier, m.Alerts, e)
+		cancel()
+		results = append(results, result{e, sendErr})
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now = time.Now().UTC().Truncate(time.Microsecond)
+	byID := map[int64]*OutboxEntry{}
+	for _, e := range m.outbox {
+		if m.inFlight[e.ID] {
+			byID[e.ID] = e
+		}
+	}
+	for _, r := range results {
+		delete(m.inFlight, r.e.ID)
+		e := byID[r.e.ID]
+		e.Attempts++
+		if r.err == nil {
+			e.Status, e.SentAt, e.LastError = OutboxSent, &now, ""
+			continue
+		}
+		if e.Attempts >= cfg.MaxAttempts {
+			e.Status = OutboxDead
+		}
+		e.LastError = r.err.Error()
+		e.NextAttemptAt = now.Add(cfg.backoff(e.Attempts))
+		m.logger().Warn("outbox: delivery failed",
+			slog.Int64("id", e.ID),
+			slog.Int("attempts", e.Attempts),
+			slog.String("status", e.Status),
+			slog.String("error", r.err.Error()),
+		)
+	}
+	return len(batch), nil
+}
+
+// RunOutboxDispatcher drains the outbox to m.Notifier until ctx is cancelled.
+func (m *MemoryStore) RunOutboxDispatcher(ctx context.Context, cfg OutboxConfig) {
+	log := m.logger()
+	cfg = cfg.withDefaults()
+	for {
+		n, err := m.DispatchOutbox(ctx, cfg)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.Error("outbox: dispatch failed", slog.String("error", err.Error()))
+		}
+		if n > 0 && err == nil {
+			continue // more may be ready (e.g. the next entry of the same account)
+		}
+		select {
+		case <-ctx.Done():
+			log.Info("outbox: dispatcher stopped")
+			return
+		case <-time.After(cfg.PollInterval):
+		}
+	}
+}
+
+// ListOutbox returns outbox entries, oldest first, optionally filtered by status.
+// after is the id of the last entry of the previous page (0 for the first page).
+func (m *MemoryStore) ListOutbox(ctx context.Context, status string, first int, after int64) ([]*OutboxEntry, error) {
+	if first <= 0 {
+		first = 50
+	}
+	if first > 200 {
+		first = 200
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []*OutboxEntry
+	for _, e := range m.outbox {
+		if len(out) == first {
+			break
+		}
+		if (status == "" || e.Status == status) && e.ID > after {
+			out = append(out, clonePtr(e))
+		}
+	}
+	return out, nil
+}
+
+// ReplayOutbox puts a dead or stuck entry back into the queue for immediate delivery.
+// Returns nil if the entry does not exist or was already sent.
+func (m *MemoryStore) ReplayOutbox(ctx context.Context, id int64) (*OutboxEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, found := slices.BinarySearchFunc(m.outbox, id, func(e *OutboxEntry, id int64) int { return cmp.Compare(e.ID, id) })
+	if !found || m.outbox[i].Status == OutboxSent {
+		return nil, nil
+	}
+	e := m.outbox[i]
+	e.Status, e.Attempts, e.LastError = OutboxPending, 0, ""
+	e.NextAttemptAt = time.Now().UTC().Truncate(time.Microsecond)
+	return clonePtr(e), nil
+}
+
+// ArchiveDormant moves dormant accounts out of the live balances, with the rules of
+// Store.ArchiveDormant.
+func (m *MemoryStore) ArchiveDormant(ctx context.Context, cfg ArchiveConfig) (*ArchiveReport, error) {
+	cfg, err := cfg.validate()
+	if err != nil {
+		return nil, err
+	}
+	out := &ArchiveReport{DryRun: cfg.DryRun, Balances: []*Account{}}
+	_ = m.atomic(func(tx *memTx) error {
+		out.Cutoff = tx.now.Add(-cfg.InactiveFor)
+		// accounts kept whatever their balances: recent ledger entries, members, active holds,
+		// enabled schedules and unspent expiring lots, which the lot expirer would restore
+		kept := map[string]bool{}
+		for k, entries := range m.ledgerBy {
+			if n := len(entries); n > 0 && !entries[n-1].CreatedAt.Before(out.Cutoff) {
+				kept[k.id] = true
+			}
+		}
+		tx.each(func(a *Account) {
+			if a.ParentID != nil {
+				kept[*a.ParentID] = true
+			}
+		})
+		for _, h := range m.holds {
+			if h.Status == HoldActive {
+				kept[h.AccountID] = true
+			}
+		}
+		for _, sc := range m.schedules {
+			if sc.Enabled {
+				kept[sc.AccountID] = true
+			}
+		}
+		for k, lots := range m.lots {
+			if slices.ContainsFunc(lots, func(l *CoinLot) bool { return l.Remaining > 0 && l.ExpiresAt != nil }) {
+				kept[k.id] = true
+			}
+		}
+		active := func(a *Account) bool {
+			last := a.CreatedAt
+			for _, t := range []*time.Time{a.LastUsageDate, a.LastRechargeDate} {
+				if compareTime(t, last) > 0 {
+					last = t
+				}
+			}
+			return a.Coins < 0 || a.Coins > cfg.MaxCoins || a.ParentID != nil || (last != nil && !last.Before(out.Cutoff))
+		}
+		for _, id := range slices.Sorted(maps.Keys(m.byID)) {
+			if out.Accounts == cfg.Batch {
+				break
+			}
+			rows := tx.balancesOf(id)
+			if kept[id] || slices.ContainsFunc(rows, active) {
+				continue
+			}
+			out.Accounts++
+			out.Balances = append(out.Balances, tx.views(rows)...)
+			if !cfg.DryRun {
+				deleteEntry(tx, m.byID, id)
+				setEntry(tx, m.archived, id, rows)
+			}
+		}
+		return nil
+	})
+	for _, b := range out.Balances {
+		out.Coins += b.Coins
+	}
+	m.logger().Info("ArchiveDormant: ok", slog.Bool("dryRun", cfg.DryRun), slog.Int("accounts", out.Accounts), slog.Int64("coins", out.Coins))
+	return out, nil
+}
+
+// RunArchiver calls ArchiveDormant with cfg every interval until ctx is cancelled.
+func (m *MemoryStore) RunArchiver(ctx context.Context, cfg ArchiveConfig, interval time.Duration) {
+	m.every(ctx, interval, 24*time.Hour, "archiver", func() { _, _ = m.ArchiveDormant(ctx, cfg) })
+}
@@ -0,0 +1,1125 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// --------------------------------------------
+// In-memory AccountStore: account and balance mutations
+// --------------------------------------------
+
+// clonePtr copies the value behind p, so a stored row doesn't alias the caller's variable.
+func clonePtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+// CreateAccount opens the coinType balance of an account (DefaultCoinType if empty).
+// Creating an existing balance is a no-op that returns it unchanged. Non-nil metadata or
+// labels replace those of every coin type of the account.
+func (m *MemoryStore) CreateAccount(ctx context.Context, id, coinType string, coins *int64, metadata map[string]any, labels map[string]string) (*Account, error) {
+	initial := int64(0)
+	if coins != nil {
+		initial = *coins
+	}
+	coinType = coinTypeOrDefault(coinType)
+	if err := validateLabels(labels); err != nil {
+		return nil, fmt.Errorf("create: %w", err)
+	}
+	var meta map[string]any
+	if metadata != nil {
+		var err error
+		if meta, err = normalizeJSON(metadata); err != nil {
+			return nil, fmt.Errorf("create: %w", err)
+		}
+	}
+	lbls := maps.Clone(labels)
+
+	var acc *Account
+	err := m.atomic(func(tx *memTx) error {
+		tx.unarchive(id)
+		// a new coin type joins the account in its current status, with its metadata, labels and parent
+		status := StatusActive
+		curMeta, curLabels := map[string]any{}, map[string]string{}
+		var parentID *string
+		if rows := tx.balancesOf(id); len(rows) > 0 {
+			status, curMeta, curLabels, parentID = rows[0].Status, rows[0].Metadata, rows[0].Labels, rows[0].ParentID
+		}
+		if status == StatusClosed {
+			return fmt.Errorf("create: %s: %w", id, ErrAccountClosed)
+		}
+		if meta == nil {
+			meta = curMeta
+		}
+		if lbls == nil {
+			lbls = curLabels
+		}
+		inserted := tx.get(id, coinType) == nil
+		if inserted {
+			tx.insertAccount(&Account{
+				ID:        id,
+				CoinType:  coinType,
+				Coins:     initial,
+				Version:   1,
+				Status:    status,
+				Metadata:  meta,
+				Labels:    lbls,
+				ParentID:  parentID,
+				CreatedAt: tx.timestamp(),
+			})
+		}
+		if metadata != nil || labels != nil {
+			for _, a := range tx.balancesOf(id) {
+				if !sameJSON(a.Metadata, meta) || !sameJSON(a.Labels, lbls) {
+					saveRow(tx, a)
+					a.Metadata, a.Labels = meta, lbls
+					a.Version++
+				}
+			}
+		}
+		if inserted && initial != 0 {
+			tx.insertLedger(id, coinType, initial, initial, "", "", LedgerKindCreate, nil)
+		}
+		acc = tx.view(tx.get(id, coinType))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// DeleteAccount removes every coin type balance of an account.
+// A non-nil expectedVersion must match the version of the DefaultCoinType balance.
+func (m *MemoryStore) DeleteAccount(ctx context.Context, id string, expectedVersion *int64) (bool, error) {
+	deleted := false
+	err := m.atomic(func(tx *memTx) error {
+		tx.unarchive(id)
+		if err := tx.checkAccountVersion(id, expectedVersion); err != nil {
+			return err
+		}
+		rows := tx.balancesOf(id)
+		if len(rows) == 0 {
+			return nil
+		}
+		deleteEntry(tx, tx.m.byID, id)
+		// members of a deleted shared wallet fall back to their own balances
+		tx.each(func(a *Account) {
+			if a.ParentID != nil && *a.ParentID == id {
+				saveRow(tx, a)
+				a.ParentID = nil
+				a.Version++
+			}
+		})
+		// zero the balances in the ledger so point-in-time lookups see the deletion
+		for _, a := range rows {
+			if a.Coins != 0 {
+				tx.insertLedger(id, a.CoinType, -a.Coins, 0, "", "", LedgerKindDelete, nil)
+			}
+		}
+		deleted = true
+		return nil
+	})
+	return deleted, err
+}
+
+// SetCoinsExact sets the balance to an exact value and emits a transaction using the caller-provided userID (UUID) and dataID.
+// A non-nil expectedVersion must match the account's version. A raise expires like a recharge.
+func (m *MemoryStore) SetCoinsExact(ctx context.Context, coinID, coinType string, coins int64, expectedVersion *int64, userID, dataID string) (*Account, error) {
+	coinType = coinTypeOrDefault(coinType)
+	userID, err := memUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt, err := lotExpiry(nil, m.DefaultCoinExpiry)
+	if err != nil {
+		return nil, err
+	}
+	keyed := strings.TrimSpace(dataID) != ""
+	if !keyed {
+		dataID = fmt.Sprintf("setexact:%s:%d", coinID, time.Now().UnixNano())
+	}
+
+	var acc *Account
+	err = m.atomic(func(tx *memTx) error {
+		if keyed {
+			prior, err := tx.claimIdempotency(IdemOpSet, coinID, coinType, dataID, requestHash(IdemOpSet, coinID, coinType, coins, userID, versionKey(expectedVersion)))
+			if err != nil {
+				return err
+			}
+			if prior != nil {
+				return json.Unmarshal(prior, &acc)
+			}
+		}
+		a, err := tx.lock(coinID, coinType, expectedVersion)
+		if err != nil {
+			return err
+		}
+		if err := checkStatus(coinID, a.Status, false); err != nil {
+			return err
+		}
+		delta := coins - a.Coins
+		saveRow(tx, a)
+		a.Coins = coins
+		a.Version++
+		if delta < 0 {
+			tx.consumeLots(coinID, coinType, -delta)
+		}
+		if delta > 0 {
+			tx.createLot(coinID, coinType, delta, expiresAt, dataID)
+		}
+		if delta != 0 {
+			tx.insertLedger(coinID, coinType, delta, coins, userID, dataID, LedgerKindSet, nil)
+		}
+		acc = tx.view(a)
+		if keyed {
+			if err := tx.completeIdempotency(IdemOpSet, coinID, coinType, dataID, acc); err != nil {
+				return err
+			}
+		}
+		if delta != 0 {
+			tx.notify(userID, coinID, coinType, dataID, float64(max(delta, -delta)), time.Now().UTC(), time.Time{})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// Recharge increases balance and emits a transaction using caller-provided userID (UUID) and dataID.
+// The coins expire after m.DefaultCoinExpiry, if set.
+func (m *MemoryStore) Recharge(ctx context.Context, coinID, coinType string, amount int64, expectedVersion *int64, userID, dataID string) (*Account, error) {
+	return m.RechargeWithExpiry(ctx, coinID, coinType, amount, nil, expectedVersion, userID, dataID)
+}
+
+// RechargeWithExpiry is Recharge with an explicit expiry for the credited coins.
+// A nil expiresAt falls back to m.DefaultCoinExpiry.
+func (m *MemoryStore) RechargeWithExpiry(ctx context.Context, coinID, coinType string, amount int64, expiresAt *time.Time, expectedVersion *int64, userID, dataID string) (*Account, error) {
+	coinType = coinTypeOrDefault(coinType)
+	if amount <= 0 {
+		return nil, errors.New("recharge: amount must be > 0")
+	}
+	// only an explicit expiry is part of the request; the default one moves with the clock
+	expiryKey := ""
+	if expiresAt != nil {
+		expiryKey = expiresAt.UTC().Format(time.RFC3339Nano)
+	}
+	expiresAt, err := lotExpiry(expiresAt, m.DefaultCoinExpiry)
+	if err != nil {
+		return nil, fmt.Errorf("recharge: %w", err)
+	}
+	userID, err = memUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	keyed := strings.TrimSpace(dataID) != ""
+	if !keyed {
+		dataID = fmt.Sprintf("recharge:%s:%d", coinID, time.Now().UnixNano())
+	}
+
+	var acc *Account
+	err = m.atomic(func(tx *memTx) error {
+		if keyed {
+			prior, err := tx.claimIdempotency(IdemOpRecharge, coinID, coinType, dataID, requestHash(IdemOpRecharge, coinID, coinType, amount, userID, expiryKey, versionKey(expectedVersion)))
+			if err != nil {
+				return err
+			}
+			if prior != nil {
+				return json.Unmarshal(prior, &acc)
+			}
+		}
+		a, err := tx.lock(coinID, coinType, expectedVersion)
+		if err != nil {
+			return err
+		}
+		if err := checkStatus(coinID, a.Status, false); err != nil {
+			return err
+		}
+		saveRow(tx, a)
+		a.Coins += amount
+		a.Version++
+		a.LastRechargeDate = tx.timestamp()
+		tx.insertLedger(coinID, coinType, amount, a.Coins, userID, dataID, LedgerKindRecharge, nil)
+		tx.createLot(coinID, coinType, amount, expiresAt, dataID)
+		acc = tx.view(a)
+		if keyed {
+			if err := tx.completeIdempotency(IdemOpRecharge, coinID, coinType, dataID, acc); err != nil {
+				return err
+			}
+		}
+		var expiry time.Time
+		if expiresAt != nil {
+			expiry = *expiresAt
+		}
+		tx.notify(userID, coinID, coinType, dataID, float64(amount), time.Now().UTC(), expiry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// BatchRecharge credits many coinIDs, each by its own amount, and returns one result per item in
+// input order, with the same rules as Store.BatchRecharge.
+func (m *MemoryStore) BatchRecharge(ctx context.Context, items []BatchRechargeItem, coinType, userID, baseDataID string) ([]*BatchRechargeResult, error) {
+	coinType = coinTypeOrDefault(coinType)
+	if len(items) == 0 {
+		return nil, errors.New("batchRecharge: no items")
+	}
+	userID, err := memUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt, err := lotExpiry(nil, m.DefaultCoinExpiry)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	dataIDFor := func(cid string) string {
+		if strings.TrimSpace(baseDataID) == "" {
+			return fmt.Sprintf("batchrecharge:%s:%d", cid, now.UnixNano())
+		}
+		return fmt.Sprintf("%s:%s", baseDataID, cid)
+	}
+
+	results := make([]*BatchRechargeResult, len(items))
+	seen := map[string]bool{}
+	for i, it := range items {
+		r := &BatchRechargeResult{ID: it.ID, Amount: it.Amount, Status: BatchRejected}
+		results[i] = r
+		switch {
+		case it.Amount <= 0:
+			r.Error = "amount must be > 0"
+		case seen[it.ID]:
+			r.Error = "duplicate id in batch"
+		default:
+			r.Status = BatchNotFound
+			seen[it.ID] = true
+		}
+	}
+
+	var batchExpiry time.Time
+	if expiresAt != nil {
+		batchExpiry = *expiresAt
+	}
+	_ = m.atomic(func(tx *memTx) error {
+		for _, r := range results {
+			if r.Status != BatchNotFound {
+				continue
+			}
+			a := tx.restored(r.ID, coinType)
+			switch {
+			case a == nil:
+				continue
+			case a.Status == StatusClosed:
+				r.Status = BatchRejected
+				r.Error = ErrAccountClosed.Error()
+				continue
+			}
+			saveRow(tx, a)
+			a.Coins += r.Amount
+			a.Version++
+			a.LastRechargeDate = tx.timestamp()
+			coins := a.Coins
+			r.Status = BatchCredited
+			r.Coins = &coins
+			tx.insertLedger(r.ID, coinType, r.Amount, coins, userID, dataIDFor(r.ID), LedgerKindBatchRecharge, nil)
+			tx.createLot(r.ID, coinType, r.Amount, expiresAt, dataIDFor(r.ID))
+			tx.notify(userID, r.ID, coinType, dataIDFor(r.ID), float64(r.Amount), now, batchExpiry)
+		}
+		return nil
+	})
+	return results, nil
+}
+
+// Use decreases balance (depletion) and emits a transaction using caller-provided userID (UUID) and dataID.
+func (m *MemoryStore) Use(ctx context.Context, coinID, coinType string, amount int64, expectedVersion *int64, userID, dataID string) (*Account, error) {
+	coinType = coinTypeOrDefault(coinType)
+	if amount <= 0 {
+		return nil, errors.New("use: amount must be > 0")
+	}
+	userID, err := memUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	keyed := strings.TrimSpace(dataID) != ""
+	if !keyed {
+		dataID = fmt.Sprintf("use:%s:%d", coinID, time.Now().UnixNano())
+	}
+
+	var acc *Account
+	err = m.atomic(func(tx *memTx) error {
+		if keyed {
+			prior, err := tx.claimIdempotency(IdemOpUse, coinID, coinType, dataID, requestHash(IdemOpUse, coinID, coinType, amount, userID, versionKey(expectedVersion)))
+			if err != nil {
+				return err
+			}
+			if prior != nil {
+				return json.Unmarshal(prior, &acc)
+			}
+		}
+		a, err := tx.lock(coinID, coinType, expectedVersion)
+		if err != nil {
+			return err
+		}
+		if err := checkStatus(coinID, a.Status, true); err != nil {
+			return fmt.Errorf("use: %w", err)
+		}
+		if err := tx.checkSpendLimits(coinID, coinType, amount); err != nil {
+			return fmt.Errorf("use: %w", err)
+		}
+		held := tx.held(coinID, coinType)
+		// a shared wallet member tops up from its parent before dipping into its credit limit
+		drawn, err := tx.drawFromPool(a, amount-max(a.Coins-held, 0), userID, dataID)
+		if err != nil {
+			return fmt.Errorf("use: %w", err)
+		}
+		coins := a.Coins
+		if coins-held+a.CreditLimit < amount {
+			return fmt.Errorf("use: insufficient balance (have %d, held %d, credit limit %d, drawn from pool %d, need %d)", coins, held, a.CreditLimit, drawn, amount)
+		}
+		saveRow(tx, a)
+		a.Coins -= amount
+		a.Version++
+		a.LastUsageDate = tx.timestamp()
+		// coins drawn from the pool were credited unlotted and are spent first
+		tx.consumeLots(coinID, coinType, amount-drawn)
+		tx.insertLedger(coinID, coinType, -amount, a.Coins, userID, dataID, LedgerKindUse, nil)
+		tx.notifyOverdrawn(userID, coinID, coinType, coins, a.Coins)
+		acc = tx.view(a)
+		if keyed {
+			if err := tx.completeIdempotency(IdemOpUse, coinID, coinType, dataID, acc); err != nil {
+				return err
+			}
+		}
+		tx.notify(userID, coinID, coinType, dataID, float64(amount), time.Now().UTC(), time.Time{})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// Transfer moves coins of one type between ids and emits two notifications using caller-provided userID (UUID) and dataID.
+// A non-nil expectedVersion is checked against the source account. The coins keep their expiry.
+func (m *MemoryStore) Transfer(ctx context.Context, fromID, toID, coinType string, amount int64, expectedVersion *int64, userID, dataID string) (*Account, *Account, error) {
+	coinType = coinTypeOrDefault(coinType)
+	if amount <= 0 {
+		return nil, nil, errors.New("transfer: amount must be > 0")
+	}
+	userID, err := memUserID(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Keep event ids distinct for the two legs
+	now := time.Now().UTC()
+	keyed := strings.TrimSpace(dataID) != ""
+	outDataID, inDataID := dataID+":out", dataID+":in"
+	if !keyed {
+		outDataID = fmt.Sprintf("transfer:out:%s->%s:%d", fromID, toID, now.UnixNano())
+		inDataID = fmt.Sprintf("transfer:in:%s->%s:%d", fromID, toID, now.UnixNano())
+	}
+
+	var from, to *Account
+	err = m.atomic(func(tx *memTx) error {
+		if keyed {
+			prior, err := tx.claimIdempotency(IdemOpTransfer, fromID, coinType, dataID, requestHash(IdemOpTransfer, fromID, toID, coinType, amount, userID, versionKey(expectedVersion)))
+			if err != nil {
+				return err
+			}
+			if prior != nil {
+				var res transferResponse
+				if err := json.Unmarshal(prior, &res); err != nil {
+					return err
+				}
+				from, to = res.From, res.To
+				return nil
+			}
+		}
+		src, err := tx.lock(fromID, coinType, expectedVersion)
+		if err != nil {
+			return err
+		}
+		fromCoins := src.Coins
+		if err := checkStatus(fromID, src.Status, true); err != nil {
+			return fmt.Errorf("transfer: %w", err)
+		}
+		if err := tx.checkSpendLimits(fromID, coinType, amount); err != nil {
+			return fmt.Errorf("transfer: %w", err)
+		}
+		if fromCoins-tx.held(fromID, coinType)+src.CreditLimit < amount {
+			return fmt.Errorf("transfer: insufficient balance on %s", fromID)
+		}
+		saveRow(tx, src)
+		src.Coins -= amount
+		src.Version++
+		src.LastUsageDate = tx.timestamp()
+		draws := tx.consumeLots(fromID, coinType, amount)
+		dst := tx.restored(toID, coinType)
+		if dst == nil {
+			return pgx.ErrNoRows
+		}
+		saveRow(tx, dst)
+		dst.Coins += amount
+		dst.Version++
+		dst.LastRechargeDate = tx.timestamp()
+		if err := checkStatus(toID, dst.Status, false); err != nil {
+			return fmt.Errorf("transfer: %w", err)
+		}
+		tx.carryLots(toID, coinType, draws, inDataID)
+		tx.insertLedger(fromID, coinType, -amount, fromCoins-amount, userID, outDataID, LedgerKindTransferOut, nil)
+		tx.insertLedger(toID, coinType, amount, dst.Coins, userID, inDataID, LedgerKindTransferIn, nil)
+		tx.notifyOverdrawn(userID, fromID, coinType, fromCoins, fromCoins-amount)
+		from, to = tx.view(src), tx.view(dst)
+		if keyed {
+			if err := tx.completeIdempotency(IdemOpTransfer, fromID, coinType, dataID, transferResponse{From: from, To: to}); err != nil {
+				return err
+			}
+		}
+		tx.notify(userID, fromID, coinType, outDataID, float64(amount), now, time.Time{})
+		tx.notify(userID, toID, coinType, inDataID, float64(amount), now, time.Time{})
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return from, to, nil
+}
+
+// MultiTransfer applies every leg atomically, with the rules of Store.MultiTransfer.
+func (m *MemoryStore) MultiTransfer(ctx context.Context, coinType string, legs []TransferLeg, userID, dataID string) ([]*Account, error) {
+	coinType = coinTypeOrDefault(coinType)
+	if len(legs) < 2 {
+		return nil, errors.New("transferMulti: at least two legs are required")
+	}
+	if len(legs) > maxTransferLegs {
+		return nil, fmt.Errorf("transferMulti: at most %d legs are allowed", maxTransferLegs)
+	}
+	var sum int64
+	seen := make(map[string]bool, len(legs))
+	for i, l := range legs {
+		if strings.TrimSpace(l.AccountID) == "" {
+			return nil, fmt.Errorf("transferMulti: leg %d: accountId is required", i)
+		}
+		if l.Amount == 0 {
+			return nil, fmt.Errorf("transferMulti: leg %d: amount must not be 0", i)
+		}
+		if seen[l.AccountID] {
+			return nil, fmt.Errorf("transferMulti: account %s appears in more than one leg", l.AccountID)
+		}
+		seen[l.AccountID] = true
+		sum += l.Amount
+	}
+	if sum != 0 {
+		return nil, fmt.Errorf("transferMulti: legs do not balance (sum %d)", sum)
+	}
+	userID, err := memUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	keyed := strings.TrimSpace(dataID) != ""
+	baseDataID := dataID
+	if !keyed {
+		baseDataID = fmt.Sprintf("transfer_multi:%d", now.UnixNano())
+	}
+
+	var out []*Account
+	err = m.atomic(func(tx *memTx) error {
+		if keyed {
+			prior, err := tx.claimIdempotency(IdemOpMultiTransfer, legs[0].AccountID, coinType, dataID, requestHash(IdemOpMultiTransfer, coinType, legsKey(legs), userID))
+			if err != nil {
+				return err
+			}
+			if prior != nil {
+				return json.Unmarshal(prior, &out)
+			}
+		}
+
+		// check in id order, as Store locks
+		order := make([]int, len(legs))
+		for i := range order {
+			order[i] = i
+		}
+		sort.Slice(order, func(a, b int) bool { return legs[order[a]].AccountID < legs[order[b]].AccountID })
+		rows := make([]*Account, len(legs))
+		for _, i := range order {
+			l := legs[i]
+			a, err := tx.lock(l.AccountID, coinType, l.ExpectedVersion)
+			if err != nil {
+				if errors.Is(err, pgx.ErrNoRows) {
+					return fmt.Errorf("transferMulti: account %s/%s not found", l.AccountID, coinType)
+				}
+				return fmt.Errorf("transferMulti: %w", err)
+			}
+			if err := checkStatus(l.AccountID, a.Status, l.Amount < 0); err != nil {
+				return fmt.Errorf("transferMulti: %w", err)
+			}
+			rows[i] = a
+		}
+
+		var draws []*lotDraw
+		var credits []int
+		for _, i := range order {
+			l, a := legs[i], rows[i]
+			legDataID := fmt.Sprintf("%s:%d", baseDataID, i)
+			kind := LedgerKindTransferIn
+			before := a.Coins
+			saveRow(tx, a)
+			if l.Amount < 0 {
+				amount := -l.Amount
+				if err := tx.checkSpendLimits(l.AccountID, coinType, amount); err != nil {
+					return fmt.Errorf("transferMulti: %w", err)
+				}
+				if a.Coins-tx.held(l.AccountID, coinType)+a.CreditLimit < amount {
+					return fmt.Errorf("transferMulti: insufficient balance on %s", l.AccountID)
+				}
+				draws = append(draws, tx.consumeLots(l.AccountID, coinType, amount)...)
+				kind = LedgerKindTransferOut
+				a.LastUsageDate = tx.timestamp()
+			} else {
+				credits = append(credits, i)
+				a.LastRechargeDate = tx.timestamp()
+			}
+			a.Coins += l.Amount
+			a.Version++
+			tx.insertLedger(l.AccountID, coinType, l.Amount, a.Coins, userID, legDataID, kind, nil)
+			tx.notifyOverdrawn(userID, l.AccountID, coinType, before, a.Coins)
+			tx.notify(userID, l.AccountID, coinType, legDataID, float64(max(l.Amount, -l.Amount)), now, time.Time{})
+		}
+		amounts := make([]int64, len(credits))
+		for j, i := range credits {
+			amounts[j] = legs[i].Amount
+		}
+		for j, carried := range shareDraws(draws, amounts) {
+			i := credits[j]
+			tx.carryLots(legs[i].AccountID, coinType, carried, fmt.Sprintf("%s:%d", baseDataID, i))
+		}
+
+		out = tx.views(rows)
+		if keyed {
+			return tx.completeIdempotency(IdemOpMultiTransfer, legs[0].AccountID, coinType, dataID, out)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ReverseTransaction gives back all (amount nil) or part of a use, capture or transfer, found
+// by the dataID it was made with, with the rules of Store.ReverseTransaction.
+func (m *MemoryStore) ReverseTransaction(ctx context.Context, dataID string, amount *int64, accountID string, expectedVersion *int64, userID string) (*Reversal, error) {
+	if strings.TrimSpace(dataID) == "" {
+		return nil, errors.New("reverse: dataId is required")
+	}
+	if amount != nil && *amount <= 0 {
+		return nil, errors.New("reverse: amount must be > 0")
+	}
+	userID, err := memUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var res *Reversal
+	err = m.atomic(func(tx *memTx) error {
+		// transfers record their debit leg as "<dataId>:out"
+		var found []*LedgerEntry
+		for _, e := range tx.m.ledger {
+			if (e.DataID == dataID || e.DataID == dataID+":out") && slices.Contains(reversibleKinds, e.Kind) && (accountID == "" || e.AccountID == accountID) {
+				found = append(found, e)
+				if len(found) == 2 {
+					break
+				}
+			}
+		}
+		switch len(found) {
+		case 0:
+			return fmt.Errorf("reverse: no use, capture or transfer with dataId %s found", dataID)
+		case 2:
+			return fmt.Errorf("reverse: several operations use dataId %s; pass the account id", dataID)
+		}
+		orig := *found[0]
+
+		var payee *LedgerEntry
+		if orig.Kind == LedgerKindTransferOut {
+			inDataID := strings.TrimSuffix(orig.DataID, ":out") + ":in"
+			for _, e := range tx.m.ledger {
+				if e.DataID == inDataID && e.Kind == LedgerKindTransferIn && e.CoinType == orig.CoinType {
+					p := *e
+					payee = &p
+					break
+				}
+			}
+			if payee == nil {
+				return fmt.Errorf("reverse: credit leg of transfer %s not found", dataID)
+			}
+		}
+		if payee != nil && payee.AccountID == orig.AccountID {
+			return fmt.Errorf("reverse: transfer %s moved coins to the same account", dataID)
+		}
+		ids := []string{orig.AccountID}
+		if payee != nil {
+			ids = append(ids, payee.AccountID)
+		}
+		rows := map[string]*Account{}
+		for _, id := range ids {
+			var want *int64
+			if id == orig.AccountID {
+				want = expectedVersion
+			}
+			a, err := tx.lock(id, orig.CoinType, want)
+			if err != nil {
+				if errors.Is(err, pgx.ErrNoRows) {
+					return fmt.Errorf("reverse: account %s/%s not found", id, orig.CoinType)
+				}
+				return err
+			}
+			rows[id] = a
+		}
+
+		var reversed, n int64
+		for _, e := range tx.m.ledgerBy[memKey{orig.AccountID, orig.CoinType}] {
+			if e.ReversesID != nil && *e.ReversesID == orig.ID {
+				reversed += e.Delta
+				n++
+			}
+		}
+		remaining := -orig.Delta - reversed
+		amt := remaining
+		if amount != nil {
+			amt = *amount
+		}
+		if amt <= 0 || amt > remaining {
+			return fmt.Errorf("reverse %s: %d of %d already reversed, %d more requested: %w", dataID, reversed, -orig.Delta, amt, ErrReversalExceedsOriginal)
+		}
+		revDataID := fmt.Sprintf("reversal:%s:%d", dataID, n+1)
+		now := time.Now().UTC()
+
+		var draws []*lotDraw
+		if payee != nil {
+			a := rows[payee.AccountID]
+			if err := checkStatus(payee.AccountID, a.Status, true); err != nil {
+				return fmt.Errorf("reverse: %w", err)
+			}
+			if a.Coins-tx.held(a.ID, a.CoinType)+a.CreditLimit < amt {
+				return fmt.Errorf("reverse: insufficient balance on %s", payee.AccountID)
+			}
+			draws = tx.consumeLots(a.ID, a.CoinType, amt)
+			before := a.Coins
+			tx.applyReversal(a, payee, -amt, userID, revDataID+":out")
+			tx.notifyOverdrawn(userID, a.ID, a.CoinType, before, a.Coins)
+			tx.notify(userID, a.ID, a.CoinType, revDataID+":out", float64(amt), now, time.Time{})
+		}
+		a := rows[orig.AccountID]
+		if err := checkStatus(orig.AccountID, a.Status, false); err != nil {
+			return fmt.Errorf("reverse: %w", err)
+		}
+		tx.applyReversal(a, &orig, amt, userID, revDataID)
+		tx.carryLots(a.ID, a.CoinType, draws, revDataID)
+		tx.notify(userID, a.ID, a.CoinType, revDataID, float64(amt), now, time.Time{})
+
+		res = &Reversal{Original: &orig, Amount: amt, TotalReversed: reversed + amt, Remaining: remaining - amt, DataID: revDataID, Account: tx.view(a)}
+		if payee != nil {
+			res.Counterparty = tx.view(rows[payee.AccountID])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// applyReversal changes a's balance by delta and records a reversal entry pointing at of.
+func (tx *memTx) applyReversal(a *Account, of *LedgerEntry, delta int64, userID, dataID string) {
+	saveRow(tx, a)
+	a.Coins += delta
+	a.Version++
+	tx.insertLedger(a.ID, a.CoinType, delta, a.Coins, userID, dataID, LedgerKindReversal, &of.ID)
+}
+
+func (m *MemoryStore) TouchUsage(ctx context.Context, id, coinType string, expectedVersion *int64) (*Account, error) {
+	coinType = coinTypeOrDefault(coinType)
+	var acc *Account
+	err := m.atomic(func(tx *memTx) error {
+		a := tx.get(id, coinType)
+		switch {
+		case a != nil && (expectedVersion == nil || a.Version == *expectedVersion):
+			saveRow(tx, a)
+			a.LastUsageDate = tx.timestamp()
+			a.Version++
+		case expectedVersion != nil && len(tx.m.byID[id]) > 0:
+			return fmt.Errorf("touchUsage %s/%s: %w", id, coinType, ErrVersionConflict)
+		}
+		acc = tx.view(a)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// SetCreditLimit sets how far below zero one coin type of an account may be debited.
+func (m *MemoryStore) SetCreditLimit(ctx context.Context, id, coinType string, limit int64, expectedVersion *int64) (*Account, error) {
+	coinType = coinTypeOrDefault(coinType)
+	if limit < 0 {
+		return nil, errors.New("setCreditLimit: limit must be >= 0")
+	}
+	var acc *Account
+	err := m.atomic(func(tx *memTx) error {
+		a, err := tx.lock(id, coinType, expectedVersion)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return fmt.Errorf("setCreditLimit: account %s/%s not found", id, coinType)
+			}
+			return err
+		}
+		if err := checkStatus(id, a.Status, false); err != nil {
+			return err
+		}
+		saveRow(tx, a)
+		a.CreditLimit = limit
+		a.Version++
+		acc = tx.view(a)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// UpdateAccountMetadata replaces (or with merge, extends) the metadata and/or labels of every
+// coin type of an account. A nil map leaves that part alone.
+func (m *MemoryStore) UpdateAccountMetadata(ctx context.Context, id string, metadata map[string]any, labels map[string]string, merge bool, expectedVersion *int64) ([]*Account, error) {
+	if metadata == nil && labels == nil {
+		return nil, errors.New("updateMetadata: nothing to update")
+	}
+	if err := validateLabels(labels); err != nil {
+		return nil, fmt.Errorf("updateMetadata: %w", err)
+	}
+	var meta map[string]any
+	if metadata != nil {
+		var err error
+		if meta, err = normalizeJSON(metadata); err != nil {
+			return nil, fmt.Errorf("updateMetadata: %w", err)
+		}
+	}
+	err := m.atomic(func(tx *memTx) error {
+		if err := tx.checkAccountVersion(id, expectedVersion); err != nil {
+			return err
+		}
+		rows := tx.balancesOf(id)
+		if len(rows) == 0 {
+			return fmt.Errorf("updateMetadata: account %s not found", id)
+		}
+		for _, a := range rows {
+			saveRow(tx, a)
+			if meta != nil {
+				a.Metadata = mergeMap(a.Metadata, meta, merge)
+			}
+			if labels != nil {
+				a.Labels = mergeMap(a.Labels, labels, merge)
+			}
+			a.Version++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m.ListBalances(ctx, id)
+}
+
+// mergeMap returns a copy of update, laid over cur if merge is set (jsonb ||).
+func mergeMap[M ~map[string]V, V any](cur, update M, merge bool) M {
+	out := M{}
+	if merge {
+		maps.Copy(out, cur)
+	}
+	maps.Copy(out, update)
+	return out
+}
+
+// FreezeAccount blocks every debit of an account (all coin types) until it is unfrozen.
+func (m *MemoryStore) FreezeAccount(ctx context.Context, id string, expectedVersion *int64) ([]*Account, error) {
+	return m.changeStatus(ctx, "FreezeAccount", id, StatusActive, StatusFrozen, expectedVersion)
+}
+
+// UnfreezeAccount makes a frozen account active again.
+func (m *MemoryStore) UnfreezeAccount(ctx context.Context, id string, expectedVersion *int64) ([]*Account, error) {
+	return m.changeStatus(ctx, "UnfreezeAccount", id, StatusFrozen, StatusActive, expectedVersion)
+}
+
+func (m *MemoryStore) changeStatus(ctx context.Context, op, id, from, to string, expectedVersion *int64) ([]*Account, error) {
+	err := m.atomic(func(tx *memTx) error {
+		tx.unarchive(id)
+		if err := tx.checkAccountVersion(id, expectedVersion); err != nil {
+			return err
+		}
+		rows := tx.balancesOf(id)
+		changed := false
+		for _, a := range rows {
+			if a.Status == from {
+				saveRow(tx, a)
+				a.Status = to
+				a.Version++
+				changed = true
+			}
+		}
+		switch {
+		case changed:
+		case len(rows) == 0:
+			return fmt.Errorf("%s: account %s not found", op, id)
+		case rows[0].Status == StatusClosed:
+			return fmt.Errorf("%s: %s: %w", op, id, ErrAccountClosed)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m.ListBalances(ctx, id)
+}
+
+// CloseAccount closes every coin type balance of an account for good, with the rules of
+// Store.CloseAccount: holds are voided and coins are swept to sweepTo.
+func (m *MemoryStore) CloseAccount(ctx context.Context, id, sweepTo string, expectedVersion *int64, userID, dataID string) ([]*Account, error) {
+	sweepTo = strings.TrimSpace(sweepTo)
+	if sweepTo == id {
+		return nil, errors.New("close: sweepTo must be another account")
+	}
+	if sweepTo != "" {
+		uid, err := memUserID(userID)
+		if err != nil {
+			return nil, err
+		}
+		userID = uid
+	}
+	now := time.Now().UTC()
+	if strings.TrimSpace(dataID) == "" {
+		dataID = fmt.Sprintf("close:%s:%d", id, now.UnixNano())
+	}
+
+	err := m.atomic(func(tx *memTx) error {
+		tx.unarchive(id)
+		if err := tx.checkAccountVersion(id, expectedVersion); err != nil {
+			return err
+		}
+		rows := tx.balancesOf(id)
+		if len(rows) == 0 {
+			return fmt.Errorf("close: account %s not found", id)
+		}
+		for _, a := range rows {
+			if a.Status == StatusClosed {
+				return fmt.Errorf("close: %s: %w", id, ErrAccountClosed)
+			}
+		}
+		for k, holds := range tx.m.holdsBy {
+			if k.id != id {
+				continue
+			}
+			for _, h := range holds {
+				if h.Status == HoldActive {
+					saveRow(tx, h)
+					h.Status = HoldVoided
+					h.UpdatedAt = tx.now
+				}
+			}
+		}
+		for _, a := range rows {
+			coins := a.Coins
+			if coins == 0 {
+				continue
+			}
+			if sweepTo == "" || coins < 0 {
+				return fmt.Errorf("close: %s %s balance is %d: %w", id, a.CoinType, coins, ErrBalanceNotZero)
+			}
+			dst := tx.restored(sweepTo, a.CoinType)
+			if dst == nil {
+				return fmt.Errorf("close: sweep target %s has no %s balance", sweepTo, a.CoinType)
+			}
+			saveRow(tx, dst)
+			dst.Coins += coins
+			dst.Version++
+			dst.LastRechargeDate = tx.timestamp()
+			if err := checkStatus(sweepTo, dst.Status, false); err != nil {
+				return fmt.Errorf("close: %w", err)
+			}
+			draws := tx.consumeLots(id, a.CoinType, coins)
+			outDataID, inDataID := dataID+":out", dataID+":in"
+			tx.carryLots(sweepTo, a.CoinType, draws, inDataID)
+			tx.insertLedger(id, a.CoinType, -coins, 0, userID, outDataID, LedgerKindTransferOut, nil)
+			tx.insertLedger(sweepTo, a.CoinType, coins, dst.Coins, userID, inDataID, LedgerKindTransferIn, nil)
+			tx.notify(userID, id, a.CoinType, outDataID, float64(coins), now, time.Time{})
+			tx.notify(userID, sweepTo, a.CoinType, inDataID, float64(coins), now, time.Time{})
+		}
+		for _, a := range rows {
+			saveRow(tx, a)
+			a.Status = StatusClosed
+			a.Coins = 0
+			a.Version++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m.ListBalances(ctx, id)
+}
+
+// SetParent makes id (every coin type) a member of the shared wallet parentID; an empty
+// parentID detaches it.
+func (m *MemoryStore) SetParent(ctx context.Context, id, parentID string, expectedVersion *int64) ([]*Account, error) {
+	parentID = strings.TrimSpace(parentID)
+	if parentID == id {
+		return nil, errors.New("setParent: an account can't be its own parent")
+	}
+	err := m.atomic(func(tx *memTx) error {
+		rows := tx.balancesOf(id)
+		if len(rows) == 0 {
+			return fmt.Errorf("setParent: account %s not found", id)
+		}
+		if err := checkStatus(id, rows[0].Status, false); err != nil {
+			return fmt.Errorf("setParent: %w", err)
+		}
+		if err := tx.checkAccountVersion(id, expectedVersion); err != nil {
+			return err
+		}
+		var parent *string
+		if parentID != "" {
+			prow := tx.balancesOf(parentID)
+			if len(prow) == 0 {
+				return fmt.Errorf("setParent: parent %s not found", parentID)
+			}
+			if err := checkStatus(parentID, prow[0].Status, false); err != nil {
+				return fmt.Errorf("setParent: %w", err)
+			}
+			// walk up from the new parent; meeting id would close a cycle
+			cur := parentID
+			for depth := 0; depth <= maxHierarchyDepth; depth++ {
+				if cur == id {
+					return fmt.Errorf("setParent: %s is below %s already", parentID, id)
+				}
+				up := tx.balancesOf(cur)
+				if len(up) == 0 || up[0].ParentID == nil {
+					break
+				}
+				cur = *up[0].ParentID
+			}
+			parent = &parentID
+		}
+		for _, a := range rows {
+			if (a.ParentID == nil) != (parent == nil) || (parent != nil && *a.ParentID != *parent) {
+				saveRow(tx, a)
+				a.ParentID = clonePtr(parent)
+				a.Version++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m.ListBalances(ctx, id)
+}
+
+// SetPoolAllowance caps how many more coins of one type a member may draw from its parent.
+// A nil allowance lifts the cap.
+func (m *MemoryStore) SetPoolAllowance(ctx context.Context, id, coinType string, allowance, expectedVersion *int64) (*Account, error) {
+	coinType = coinTypeOrDefault(coinType)
+	if allowance != nil && *allowance < 0 {
+		return nil, errors.New("setPoolAllowance: allowance must be >= 0")
+	}
+	var acc *Account
+	err := m.atomic(func(tx *memTx) error {
+		a, err := tx.lock(id, coinType, expectedVersion)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return fmt.Errorf("setPoolAllowance: account %s/%s not found", id, coinType)
+			}
+			return err
+		}
+		if err := checkStatus(id, a.Status, false); err != nil {
+			return err
+		}
+		saveRow(tx, a)
+		a.PoolAllowance = clonePtr(allowance)
+		a.Version++
+		acc = tx.view(a)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// ListSpendLimits returns the limits in effect for one coin type of an account. An empty
+// accountID lists the global defaults.
+func (m *MemoryStore) ListSpendLimits(ctx context.Context, accountID, coinType string) ([]*SpendLimit, error) {
+	var out []*SpendLimit
+	_ = m.atomic(func(tx *memTx) error {
+		out = tx.spendLimits(accountID, coinTypeOrDefault(coinType))
+		return nil
+	})
+	return out, nil
+}
+
+// SetSpendLimit caps the debits of one coin type of an account within a rolling window.
+// An empty accountID sets the global default. It returns the limits now in effect.
+func (m *MemoryStore) SetSpendLimit(ctx context.Context, accountID, coinType string, window time.Duration, maxAmount, maxCount *int64) ([]*SpendLimit, error) {
+	coinType = coinTypeOrDefault(coinType)
+	if window < time.Second {
+		return nil, errors.New("setSpendLimit: window must be at least 1s")
+	}
+	if (maxAmount != nil && *maxAmount < 0) || (maxCount != nil && *maxCount < 0) {
+		return nil, errors.New("setSpendLimit: limits must be >= 0")
+	}
+	secs := int64(window / time.Second)
+	_ = m.atomic(func(tx *memTx) error {
+		setEntry(tx, tx.m.limits, spendKey{accountID, coinType, secs}, &SpendLimit{
+			AccountID:     accountID,
+			CoinType:      coinType,
+			WindowSeconds: secs,
+			MaxAmount:     clonePtr(maxAmount),
+			MaxCount:      clonePtr(maxCount),
+		})
+		return nil
+	})
+	return m.ListSpendLimits(ctx, accountID, coinType)
+}
+
+// ClearSpendLimit removes an account's limit for a window (or the global default if
+// accountID is empty) and returns the limits now in effect.
+func (m *MemoryStore) ClearSpendLimit(ctx context.Context, accountID, coinType string, window time.Duration) ([]*SpendLimit, error) {
+	coinType = coinTypeOrDefault(coinType)
+	_ = m.atomic(func(tx *memTx) error {
+		deleteEntry(tx, tx.m.limits, spendKey{accountID, coinType, int64(window / time.Second)})
+		return nil
+	})
+	return m.ListSpendLimits(ctx, accountID, coinType)
+}
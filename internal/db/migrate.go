@@ -0,0 +1,317 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"log/slog"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// --------------------------------------------
+// Schema migrations (embedded, versioned)
+// --------------------------------------------
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockKey is the pg_advisory_lock key held while migrating, so replicas
+// starting together apply each migration once.
+const migrationLockKey int64 = 0x636f696e73 // "coins"
+
+// ErrMigrationChecksum is returned when an applied migration no longer matches its embedded file.
+var ErrMigrationChecksum = errors.New("migration checksum mismatch")
+
+// Migration is one embedded schema change. Files are named NNNN_name.up.sql and NNNN_name.down.sql.
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string // sha256 of Up
+}
+
+// MigrationState reports a known migration and whether it has been applied.
+type MigrationState struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt *time.Time
+	Modified  bool // applied with a different checksum than the embedded file
+}
+
+// loadMigrations reads the embedded migrations, ordered by version.
+func loadMigrations() ([]*Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]*Migration{}
+	for _, e := range entries {
+		file := e.Name()
+		var dir string
+		switch {
+		case strings.HasSuffix(file, ".up.sql"):
+			dir = "up"
+		case strings.HasSuffix(file, ".down.sql"):
+			dir = "down"
+		default:
+			continue
+		}
+		base := strings.TrimSuffix(file, "."+dir+".sql")
+		num, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s: name must be NNNN_name.%s.sql", file, dir)
+		}
+		version, err := strconv.ParseInt(num, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: bad version: %w", file, err)
+		}
+		body, err := fs.ReadFile(migrationFiles, path.Join("migrations", file))
+		if err != nil {
+			return nil, err
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("migration %d: conflicting names %q and %q", version, m.Name, name)
+		}
+		if dir == "up" {
+			m.Up = string(body)
+			sum := sha256.Sum256(body)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	out := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s: missing up file", m.Version, m.Name)
+		}
+		out = append(out, m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+type appliedMigration struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+// withMigrationLock runs fn on a dedicated connection holding the migration advisory lock.
+func (s *Store) withMigrationLock(ctx context.Context, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := s.Pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		return fmt.Errorf("migrate: acquire lock: %w", err)
+	}
+	defer func() {
+		// the session may be broken by now; releasing the connection drops the lock anyway
+		_, _ = conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+	}()
+
+	if _, err := conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS public.schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL,
+			checksum TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)
+	`); err != nil {
+		return fmt.Errorf("migrate: ensure schema_migrations: %w", err)
+	}
+	return fn(conn)
+}
+
+func appliedMigrations(ctx context.Context, conn *pgxpool.Conn) (map[int64]appliedMigration, error) {
+	rows, err := conn.Query(ctx, `SELECT version, checksum, applied_at FROM public.schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[int64]appliedMigration{}
+	for rows.Next() {
+		var v int64
+		var a appliedMigration
+		if err := rows.Scan(&v, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		out[v] = a
+	}
+	return out, rows.Err()
+}
+
+// MigrateUp applies every pending migration in version order and returns how many ran.
+// It refuses to run if an applied migration was edited after it shipped.
+func (s *Store) MigrateUp(ctx context.Context) (int, error) {
+	log := s.logger()
+	start := time.Now()
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	err = s.withMigrationLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if a, ok := applied[m.Version]; ok {
+				if a.checksum != m.Checksum {
+					return fmt.Errorf("migrate: %d_%s: %w", m.Version, m.Name, ErrMigrationChecksum)
+				}
+				continue
+			}
+			log.Info("MigrateUp: applying", slog.Int64("version", m.Version), slog.String("name", m.Name))
+			tx, err := conn.Begin(ctx)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, m.Up); err != nil {
+				_ = tx.Rollback(ctx)
+				return fmt.Errorf("migrate: %d_%s: %w", m.Version, m.Name, err)
+			}
+			if _, err := tx.Exec(ctx, `
+				INSERT INTO public.schema_migrations (version, name, checksum) VALUES ($1, $2, $3)
+			`, m.Version, m.Name, m.Checksum); err != nil {
+				_ = tx.Rollback(ctx)
+				return err
+			}
+			if err := tx.Commit(ctx); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error("MigrateUp: failed", slog.Int("applied", n), slog.String("error", err.Error()))
+		return n, err
+	}
+	log.Info("MigrateUp: ok", slog.Int("applied", n), slog.Duration("dur", time.Since(start)))
+	return n, nil
+}
+
+// MigrateDown rolls back the most recently applied migrations, newest first, and returns how many ran.
+func (s *Store) MigrateDown(ctx context.Context, steps int) (int, error) {
+	log := s.logger()
+	start := time.Now()
+	if steps <= 0 {
+		steps = 1
+	}
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	byVersion := map[int64]*Migration{}
+	for _, m := range migrations {
+		byVersion[m.Version] = m
+	}
+
+	n := 0
+	err = s.withMigrationLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		versions := make([]int64, 0, len(applied))
+		for v := range applied {
+			versions = append(versions, v)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+		for _, v := range versions {
+			if n == steps {
+				break
+			}
+			m := byVersion[v]
+			if m == nil {
+				return fmt.Errorf("migrate: version %d is applied but unknown to this binary", v)
+			}
+			if strings.TrimSpace(m.Down) == "" {
+				return fmt.Errorf("migrate: %d_%s has no down migration", m.Version, m.Name)
+			}
+			log.Info("MigrateDown: reverting", slog.Int64("version", m.Version), slog.String("name", m.Name))
+			tx, err := conn.Begin(ctx)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, m.Down); err != nil {
+				_ = tx.Rollback(ctx)
+				return fmt.Errorf("migrate: %d_%s down: %w", m.Version, m.Name, err)
+			}
+			if _, err := tx.Exec(ctx, `DELETE FROM public.schema_migrations WHERE version=$1`, m.Version); err != nil {
+				_ = tx.Rollback(ctx)
+				return err
+			}
+			if err := tx.Commit(ctx); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error("MigrateDown: failed", slog.Int("reverted", n), slog.String("error", err.Error()))
+		return n, err
+	}
+	log.Info("MigrateDown: ok", slog.Int("reverted", n), slog.Duration("dur", time.Since(start)))
+	return n, nil
+}
+
+// MigrationStatus lists every embedded migration, plus applied versions this binary doesn't know.
+func (s *Store) MigrationStatus(ctx context.Context) ([]*MigrationState, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var out []*MigrationState
+	err = s.withMigrationLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			st := &MigrationState{Version: m.Version, Name: m.Name}
+			if a, ok := applied[m.Version]; ok {
+				at := a.appliedAt
+				st.Applied, st.AppliedAt, st.Modified = true, &at, a.checksum != m.Checksum
+				delete(applied, m.Version)
+			}
+			out = append(out, st)
+		}
+		for v, a := range applied {
+			at := a.appliedAt
+			out = append(out, &MigrationState{Version: v, Name: "(unknown)", Applied: true, AppliedAt: &at})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS public.coins (
+	id TEXT PRIMARY KEY,
+	coins BIGINT NOT NULL DEFAULT 0,
+	last_recharge_date TIMESTAMPTZ NULL,
+	last_usage_date TIMESTAMPTZ NULL
+);
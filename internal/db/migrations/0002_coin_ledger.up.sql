@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS public.coin_ledger (
+	id BIGSERIAL PRIMARY KEY,
+	account_id TEXT NOT NULL,
+	delta BIGINT NOT NULL,
+	balance_after BIGINT NOT NULL,
+	user_id TEXT NOT NULL DEFAULT '',
+	data_id TEXT NOT NULL DEFAULT '',
+	kind TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS coin_ledger_account_id_idx ON public.coin_ledger (account_id, id DESC);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS public.idempotency_keys (
+	operation TEXT NOT NULL,
+	account_id TEXT NOT NULL,
+	data_id TEXT NOT NULL,
+	request_hash TEXT NOT NULL,
+	response JSONB NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (operation, account_id, data_id)
+);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS public.tx_outbox (
+	id BIGSERIAL PRIMARY KEY,
+	account_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	data_id TEXT NOT NULL,
+	coin_used DOUBLE PRECISION NOT NULL,
+	occurred_at TIMESTAMPTZ NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INT NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	last_error TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	sent_at TIMESTAMPTZ NULL
+);
+CREATE INDEX IF NOT EXISTS tx_outbox_pending_idx ON public.tx_outbox (account_id, id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS tx_outbox_status_idx ON public.tx_outbox (status, id);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS public.coin_holds (
+	id UUID PRIMARY KEY,
+	account_id TEXT NOT NULL,
+	amount BIGINT NOT NULL,
+	captured BIGINT NOT NULL DEFAULT 0,
+	status TEXT NOT NULL DEFAULT 'active',
+	user_id TEXT NOT NULL,
+	data_id TEXT NOT NULL DEFAULT '',
+	expires_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS coin_holds_active_idx ON public.coin_holds (account_id) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS coin_holds_expiry_idx ON public.coin_holds (expires_at) WHERE status = 'active';
@@ -0,0 +1,2 @@
+ALTER TABLE public.tx_outbox DROP COLUMN IF EXISTS expiry;
+DROP TABLE IF EXISTS public.coin_lots;
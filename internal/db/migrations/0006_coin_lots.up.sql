@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS public.coin_lots (
+	id BIGSERIAL PRIMARY KEY,
+	account_id TEXT NOT NULL,
+	amount BIGINT NOT NULL,
+	remaining BIGINT NOT NULL,
+	expires_at TIMESTAMPTZ NULL,
+	data_id TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS coin_lots_account_idx ON public.coin_lots (account_id, expires_at) WHERE remaining > 0;
+CREATE INDEX IF NOT EXISTS coin_lots_expiry_idx ON public.coin_lots (expires_at) WHERE remaining > 0;
+
+ALTER TABLE public.tx_outbox ADD COLUMN IF NOT EXISTS expiry TIMESTAMPTZ NULL;
@@ -0,0 +1,18 @@
+-- Only the default coin type survives a rollback.
+DELETE FROM public.coins WHERE coin_type <> 'COIN';
+DELETE FROM public.coin_ledger WHERE coin_type <> 'COIN';
+DELETE FROM public.idempotency_keys WHERE coin_type <> 'COIN';
+DELETE FROM public.tx_outbox WHERE coin_type <> 'COIN';
+DELETE FROM public.coin_holds WHERE coin_type <> 'COIN';
+DELETE FROM public.coin_lots WHERE coin_type <> 'COIN';
+
+ALTER TABLE public.coins DROP CONSTRAINT coins_pkey, ADD PRIMARY KEY (id);
+ALTER TABLE public.idempotency_keys DROP CONSTRAINT idempotency_keys_pkey,
+	ADD PRIMARY KEY (operation, account_id, data_id);
+
+ALTER TABLE public.coins DROP COLUMN coin_type;
+ALTER TABLE public.coin_ledger DROP COLUMN coin_type;
+ALTER TABLE public.idempotency_keys DROP COLUMN coin_type;
+ALTER TABLE public.tx_outbox DROP COLUMN coin_type;
+ALTER TABLE public.coin_holds DROP COLUMN coin_type;
+ALTER TABLE public.coin_lots DROP COLUMN coin_type;
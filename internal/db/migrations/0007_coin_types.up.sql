@@ -0,0 +1,20 @@
+-- Balances are keyed by (id, coin_type); rows from before coin types become 'COIN'.
+ALTER TABLE public.coins ADD COLUMN IF NOT EXISTS coin_type TEXT NOT NULL DEFAULT 'COIN';
+ALTER TABLE public.coin_ledger ADD COLUMN IF NOT EXISTS coin_type TEXT NOT NULL DEFAULT 'COIN';
+ALTER TABLE public.idempotency_keys ADD COLUMN IF NOT EXISTS coin_type TEXT NOT NULL DEFAULT 'COIN';
+ALTER TABLE public.tx_outbox ADD COLUMN IF NOT EXISTS coin_type TEXT NOT NULL DEFAULT 'COIN';
+ALTER TABLE public.coin_holds ADD COLUMN IF NOT EXISTS coin_type TEXT NOT NULL DEFAULT 'COIN';
+ALTER TABLE public.coin_lots ADD COLUMN IF NOT EXISTS coin_type TEXT NOT NULL DEFAULT 'COIN';
+
+DO $$
+BEGIN
+	IF (SELECT COUNT(*) FROM information_schema.key_column_usage
+	    WHERE table_schema = 'public' AND constraint_name = 'coins_pkey') = 1 THEN
+		ALTER TABLE public.coins DROP CONSTRAINT coins_pkey, ADD PRIMARY KEY (id, coin_type);
+	END IF;
+	IF (SELECT COUNT(*) FROM information_schema.key_column_usage
+	    WHERE table_schema = 'public' AND constraint_name = 'idempotency_keys_pkey') = 3 THEN
+		ALTER TABLE public.idempotency_keys DROP CONSTRAINT idempotency_keys_pkey,
+			ADD PRIMARY KEY (operation, account_id, coin_type, data_id);
+	END IF;
+END $$;
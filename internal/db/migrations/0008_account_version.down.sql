@@ -0,0 +1 @@
+ALTER TABLE public.coins DROP COLUMN IF EXISTS version;
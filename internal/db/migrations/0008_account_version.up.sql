@@ -0,0 +1,2 @@
+-- Optimistic concurrency: every mutation of a balance increments its version.
+ALTER TABLE public.coins ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
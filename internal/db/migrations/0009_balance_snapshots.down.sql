@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS public.coin_ledger_account_created_idx;
+DROP TABLE IF EXISTS public.coin_balance_snapshots;
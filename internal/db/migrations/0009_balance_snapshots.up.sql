@@ -0,0 +1,19 @@
+-- Periodic balance snapshots; a balance as of t is the latest snapshot taken by t plus
+-- the ledger deltas recorded after it.
+CREATE TABLE IF NOT EXISTS public.coin_balance_snapshots (
+	account_id TEXT NOT NULL,
+	coin_type TEXT NOT NULL,
+	taken_at TIMESTAMPTZ NOT NULL,
+	coins BIGINT NOT NULL,
+	ledger_id BIGINT NOT NULL, -- last coin_ledger id included in coins
+	PRIMARY KEY (account_id, coin_type, taken_at)
+);
+CREATE INDEX IF NOT EXISTS coin_balance_snapshots_taken_idx ON public.coin_balance_snapshots (coin_type, taken_at);
+CREATE INDEX IF NOT EXISTS coin_ledger_account_created_idx ON public.coin_ledger (account_id, coin_type, created_at);
+
+-- Seed from the current balances, so coins that predate the ledger are accounted for.
+INSERT INTO public.coin_balance_snapshots (account_id, coin_type, taken_at, coins, ledger_id)
+SELECT c.id, c.coin_type, NOW(), c.coins,
+	COALESCE((SELECT MAX(l.id) FROM public.coin_ledger l WHERE l.account_id = c.id AND l.coin_type = c.coin_type), 0)
+FROM public.coins c
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,2 @@
+ALTER TABLE public.coins DROP CONSTRAINT IF EXISTS coins_status_check;
+ALTER TABLE public.coins DROP COLUMN IF EXISTS status;
@@ -0,0 +1,4 @@
+-- Account lifecycle: active -> frozen -> active, and active|frozen -> closed.
+ALTER TABLE public.coins ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active';
+ALTER TABLE public.coins DROP CONSTRAINT IF EXISTS coins_status_check;
+ALTER TABLE public.coins ADD CONSTRAINT coins_status_check CHECK (status IN ('active', 'frozen', 'closed'));
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS public.coins_overdrawn_idx;
+ALTER TABLE public.coins DROP CONSTRAINT IF EXISTS coins_credit_limit_check;
+ALTER TABLE public.coins DROP COLUMN IF EXISTS credit_limit;
@@ -0,0 +1,5 @@
+-- Overdraft: coins may go down to -credit_limit.
+ALTER TABLE public.coins ADD COLUMN IF NOT EXISTS credit_limit BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE public.coins DROP CONSTRAINT IF EXISTS coins_credit_limit_check;
+ALTER TABLE public.coins ADD CONSTRAINT coins_credit_limit_check CHECK (credit_limit >= 0);
+CREATE INDEX IF NOT EXISTS coins_overdrawn_idx ON public.coins (coins) WHERE coins < 0;
@@ -0,0 +1,11 @@
+-- Velocity caps on debits per rolling window. account_id '' holds the global default for a
+-- coin type; an account's row replaces the default with the same window. NULL means no cap.
+CREATE TABLE IF NOT EXISTS public.coin_spend_limits (
+	account_id TEXT NOT NULL,
+	coin_type TEXT NOT NULL,
+	window_seconds BIGINT NOT NULL CHECK (window_seconds > 0),
+	max_amount BIGINT NULL CHECK (max_amount >= 0),
+	max_count BIGINT NULL CHECK (max_count >= 0),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (account_id, coin_type, window_seconds)
+);
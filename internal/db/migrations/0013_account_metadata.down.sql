@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS public.coins_labels_idx;
+ALTER TABLE public.coins DROP COLUMN IF EXISTS labels;
+ALTER TABLE public.coins DROP COLUMN IF EXISTS metadata;
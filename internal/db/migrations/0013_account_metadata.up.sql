@@ -0,0 +1,4 @@
+-- Free-form metadata and key/value labels, kept identical across an account's coin types.
+ALTER TABLE public.coins ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}'::jsonb;
+ALTER TABLE public.coins ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}'::jsonb;
+CREATE INDEX IF NOT EXISTS coins_labels_idx ON public.coins USING GIN (labels jsonb_path_ops);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS public.coin_recharge_schedules;
@@ -0,0 +1,20 @@
+-- Recurring recharges. Exactly one of cron (five-field, UTC) and interval_seconds is set.
+-- The scheduler fires rows with next_run_at <= NOW() and moves next_run_at forward.
+CREATE TABLE IF NOT EXISTS public.coin_recharge_schedules (
+	id UUID PRIMARY KEY,
+	account_id TEXT NOT NULL,
+	coin_type TEXT NOT NULL,
+	amount BIGINT NOT NULL CHECK (amount > 0),
+	cron TEXT NULL,
+	interval_seconds BIGINT NULL CHECK (interval_seconds > 0),
+	user_id TEXT NOT NULL,
+	enabled BOOLEAN NOT NULL DEFAULT TRUE,
+	next_run_at TIMESTAMPTZ NOT NULL,
+	last_run_at TIMESTAMPTZ NULL,
+	last_error TEXT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	CHECK ((cron IS NULL) <> (interval_seconds IS NULL))
+);
+CREATE INDEX IF NOT EXISTS coin_recharge_schedules_due_idx ON public.coin_recharge_schedules (next_run_at) WHERE enabled;
+CREATE INDEX IF NOT EXISTS coin_recharge_schedules_account_idx ON public.coin_recharge_schedules (account_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS public.coin_ledger_reverses_idx;
+DROP INDEX IF EXISTS public.coin_ledger_data_id_idx;
+ALTER TABLE public.coin_ledger DROP COLUMN IF EXISTS reverses_id;
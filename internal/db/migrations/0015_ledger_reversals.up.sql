@@ -0,0 +1,5 @@
+-- Reversal entries point at the ledger entry they compensate, so the total reversed so far
+-- can be checked against the original amount. Original operations are looked up by data_id.
+ALTER TABLE public.coin_ledger ADD COLUMN IF NOT EXISTS reverses_id BIGINT NULL REFERENCES public.coin_ledger (id);
+CREATE INDEX IF NOT EXISTS coin_ledger_data_id_idx ON public.coin_ledger (data_id);
+CREATE INDEX IF NOT EXISTS coin_ledger_reverses_idx ON public.coin_ledger (reverses_id) WHERE reverses_id IS NOT NULL;
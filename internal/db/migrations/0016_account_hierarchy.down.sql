@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS public.coins_parent_idx;
+ALTER TABLE public.coins DROP CONSTRAINT IF EXISTS coins_pool_allowance_check;
+ALTER TABLE public.coins DROP COLUMN IF EXISTS pool_allowance;
+ALTER TABLE public.coins DROP COLUMN IF EXISTS parent_id;
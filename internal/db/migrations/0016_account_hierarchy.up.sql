@@ -0,0 +1,8 @@
+-- Shared wallets: a member account draws from its parent's balance once its own runs out.
+-- parent_id is kept identical across an account's coin types; pool_allowance (per coin type)
+-- is what the member may still draw from the parent, NULL meaning no cap.
+ALTER TABLE public.coins ADD COLUMN IF NOT EXISTS parent_id TEXT NULL;
+ALTER TABLE public.coins ADD COLUMN IF NOT EXISTS pool_allowance BIGINT NULL;
+ALTER TABLE public.coins DROP CONSTRAINT IF EXISTS coins_pool_allowance_check;
+ALTER TABLE public.coins ADD CONSTRAINT coins_pool_allowance_check CHECK (pool_allowance >= 0);
+CREATE INDEX IF NOT EXISTS coins_parent_idx ON public.coins (parent_id) WHERE parent_id IS NOT NULL;
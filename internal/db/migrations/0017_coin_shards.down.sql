@@ -0,0 +1,13 @@
+-- Fold the shards back into their balances before dropping them.
+UPDATE public.coins c
+SET coins = c.coins + s.coins,
+    last_recharge_date = GREATEST(c.last_recharge_date, s.last_recharge_date)
+FROM (
+	SELECT account_id, coin_type, SUM(coins) AS coins, MAX(last_recharge_date) AS last_recharge_date
+	FROM public.coin_shards
+	GROUP BY account_id, coin_type
+) s
+WHERE c.id = s.account_id AND c.coin_type = s.coin_type;
+DROP TABLE IF EXISTS public.coin_shards;
+ALTER TABLE public.coins DROP CONSTRAINT IF EXISTS coins_shards_check;
+ALTER TABLE public.coins DROP COLUMN IF EXISTS shards;
@@ -0,0 +1,15 @@
+-- Hot-account sharding: a balance with shards > 0 is split between its own coins and that
+-- many sub-balance rows, so concurrent credits update different rows. Its balance is
+-- coins + SUM(coin_shards.coins); shard coins never go below zero.
+ALTER TABLE public.coins ADD COLUMN IF NOT EXISTS shards INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE public.coins DROP CONSTRAINT IF EXISTS coins_shards_check;
+ALTER TABLE public.coins ADD CONSTRAINT coins_shards_check CHECK (shards >= 0);
+CREATE TABLE IF NOT EXISTS public.coin_shards (
+	account_id TEXT NOT NULL,
+	coin_type TEXT NOT NULL,
+	shard INTEGER NOT NULL,
+	coins BIGINT NOT NULL DEFAULT 0 CHECK (coins >= 0),
+	last_recharge_date TIMESTAMPTZ NULL,
+	PRIMARY KEY (account_id, coin_type, shard),
+	FOREIGN KEY (account_id, coin_type) REFERENCES public.coins (id, coin_type) ON DELETE CASCADE
+);
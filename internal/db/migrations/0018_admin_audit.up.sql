@@ -0,0 +1,17 @@
+-- Audit trail of admin mutations. A row is written as pending before the mutation runs and
+-- completed with its outcome afterwards, so no admin change goes unrecorded.
+CREATE TABLE IF NOT EXISTS public.admin_audit (
+	id BIGSERIAL PRIMARY KEY,
+	operation TEXT NOT NULL,
+	caller TEXT NOT NULL DEFAULT '',
+	source_ip TEXT NOT NULL DEFAULT '',
+	args JSONB NOT NULL DEFAULT '{}',
+	before JSONB NULL,
+	after JSONB NULL,
+	outcome TEXT NOT NULL DEFAULT 'pending', -- pending | ok | error
+	error TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	finished_at TIMESTAMPTZ NULL
+);
+CREATE INDEX IF NOT EXISTS admin_audit_operation_idx ON public.admin_audit (operation, id);
+CREATE INDEX IF NOT EXISTS admin_audit_created_idx ON public.admin_audit (created_at);
@@ -0,0 +1,4 @@
+-- Erasure renames an account in place; its shards follow the balance row.
+ALTER TABLE public.coin_shards DROP CONSTRAINT IF EXISTS coin_shards_account_id_coin_type_fkey;
+ALTER TABLE public.coin_shards ADD CONSTRAINT coin_shards_account_id_coin_type_fkey
+	FOREIGN KEY (account_id, coin_type) REFERENCES public.coins (id, coin_type) ON DELETE CASCADE ON UPDATE CASCADE;
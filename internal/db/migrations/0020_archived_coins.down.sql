@@ -0,0 +1,4 @@
+INSERT INTO public.coins SELECT (jsonb_populate_record(NULL::public.coins, a.row)).* FROM public.archived_coins a
+ON CONFLICT DO NOTHING;
+DROP TABLE IF EXISTS public.archived_coins;
+ALTER TABLE public.coins DROP COLUMN IF EXISTS created_at;
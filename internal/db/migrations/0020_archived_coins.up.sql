@@ -0,0 +1,14 @@
+-- Dormant accounts are moved out of public.coins whole (every coin type) and moved back
+-- when touched again. row is the public.coins row as archived; coins is kept beside it so
+-- totals can include archived balances. created_at stays NULL for balances opened before it.
+ALTER TABLE public.coins ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NULL;
+ALTER TABLE public.coins ALTER COLUMN created_at SET DEFAULT NOW();
+CREATE TABLE IF NOT EXISTS public.archived_coins (
+	id TEXT NOT NULL,
+	coin_type TEXT NOT NULL,
+	coins BIGINT NOT NULL,
+	row JSONB NOT NULL,
+	archived_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (id, coin_type)
+);
+CREATE INDEX IF NOT EXISTS archived_coins_coin_type_idx ON public.archived_coins (coin_type);
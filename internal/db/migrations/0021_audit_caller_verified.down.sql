@@ -0,0 +1 @@
+ALTER TABLE public.admin_audit DROP COLUMN IF EXISTS caller_verified;
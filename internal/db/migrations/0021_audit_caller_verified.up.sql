@@ -0,0 +1,2 @@
+-- Whether the caller of an audited mutation proved its identity with a signed header.
+ALTER TABLE public.admin_audit ADD COLUMN IF NOT EXISTS caller_verified BOOLEAN NOT NULL DEFAULT false;
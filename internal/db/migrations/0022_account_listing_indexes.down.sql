@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS public.coins_by_usage_idx;
+DROP INDEX IF EXISTS public.coins_by_recharge_idx;
+DROP INDEX IF EXISTS public.coins_by_coins_idx;
@@ -0,0 +1,5 @@
+-- Keyset pagination of the account listings: one index per sort order, on the very
+-- expressions the listings order and page by, so a page is an index range scan.
+CREATE INDEX IF NOT EXISTS coins_by_coins_idx ON public.coins (coins DESC, id, coin_type);
+CREATE INDEX IF NOT EXISTS coins_by_recharge_idx ON public.coins (last_recharge_date DESC, id, coin_type);
+CREATE INDEX IF NOT EXISTS coins_by_usage_idx ON public.coins ((COALESCE(last_usage_date, '-infinity'::timestamptz)), id, coin_type);
@@ -0,0 +1 @@
+ALTER TABLE public.tx_outbox DROP COLUMN IF EXISTS topic;
@@ -0,0 +1,5 @@
+-- Outbox entries carry a topic naming the notifier that delivers them: transactions go to
+-- the Transactions service, account alerts such as overdrafts to the alert notifier.
+-- Overdraft alerts still queued were enqueued as transactions; they move to their topic.
+ALTER TABLE public.tx_outbox ADD COLUMN IF NOT EXISTS topic TEXT NOT NULL DEFAULT 'transaction';
+UPDATE public.tx_outbox SET topic = 'overdrawn' WHERE status <> 'sent' AND data_id LIKE 'overdrawn:%';
@@ -1,11 +1,170 @@
 package db
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
-// Account represents a row in public.coins
+// Account represents a row in public.coins: the balance of one coin type of an account.
 type Account struct {
 	ID               string     `db:"id" json:"id"`
+	CoinType         string     `db:"coin_type" json:"coinType"`
 	Coins            int64      `db:"coins" json:"coins"`
+	Version          int64      `db:"version" json:"version"`          // incremented by every mutation
+	Status           string     `db:"status" json:"status"`            // active | frozen | closed
+	CreditLimit      int64      `db:"credit_limit" json:"creditLimit"` // how far coins may go below zero
 	LastRechargeDate *time.Time `db:"last_recharge_date" json:"lastRechargeDate"`
 	LastUsageDate    *time.Time `db:"last_usage_date" json:"lastUsageDate"`
+	CreatedAt        *time.Time `db:"created_at" json:"createdAt"` // nil for balances opened before it was recorded
+
+	// Metadata and Labels are shared by every coin type of an account.
+	Metadata map[string]any    `db:"metadata" json:"metadata"`
+	Labels   map[string]string `db:"labels" json:"labels"`
+
+	// ParentID is the shared wallet the account draws from once its own balance runs out,
+	// at most PoolAllowance more coins of this type (nil: no cap).
+	ParentID      *string `db:"parent_id" json:"parentId"`
+	PoolAllowance *int64  `db:"pool_allowance" json:"poolAllowance"`
+
+	// Held is the sum of active holds; Available = Coins - Held + CreditLimit. Both are computed, not stored.
+	Held      int64 `db:"-" json:"held"`
+	Available int64 `db:"-" json:"available"`
+}
+
+// Ledger entry kinds, one per balance-mutating operation.
+const (
+	LedgerKindCreate        = "create"
+	LedgerKindRecharge      = "recharge"
+	LedgerKindBatchRecharge = "batch_recharge"
+	LedgerKindUse           = "use"
+	LedgerKindTransferOut   = "transfer_out"
+	LedgerKindTransferIn    = "transfer_in"
+	LedgerKindSet           = "set"
+	LedgerKindCapture       = "capture"
+	LedgerKindExpire        = "expire"
+	LedgerKindDelete        = "delete"
+	LedgerKindReversal      = "reversal"
+	LedgerKindPoolDraw      = "pool_draw"   // parent side of a shared wallet draw
+	LedgerKindPoolCredit    = "pool_credit" // member side of a shared wallet draw
+)
+
+// LedgerEntry represents a row in public.coin_ledger
+type LedgerEntry struct {
+	ID           int64     `db:"id" json:"id"`
+	AccountID    string    `db:"account_id" json:"accountId"`
+	CoinType     string    `db:"coin_type" json:"coinType"`
+	Delta        int64     `db:"delta" json:"delta"`
+	BalanceAfter int64     `db:"balance_after" json:"balanceAfter"`
+	UserID       string    `db:"user_id" json:"userId"`
+	DataID       string    `db:"data_id" json:"dataId"`
+	Kind         string    `db:"kind" json:"kind"`
+	ReversesID   *int64    `db:"reverses_id" json:"reversesId"` // set on reversal entries
+	CreatedAt    time.Time `db:"created_at" json:"createdAt"`
+}
+
+// OutboxEntry represents a row in public.tx_outbox
+type OutboxEntry struct {
+	ID            int64      `db:"id" json:"id"`
+	AccountID     string     `db:"account_id" json:"accountId"`
+	CoinType      string     `db:"coin_type" json:"coinType"`
+	UserID        string     `db:"user_id" json:"userId"`
+	DataID        string     `db:"data_id" json:"dataId"`
+	CoinUsed      float64    `db:"coin_used" json:"coinUsed"`
+	OccurredAt    time.Time  `db:"occurred_at" json:"occurredAt"`
+	Status        string     `db:"status" json:"status"`
+	Attempts      int        `db:"attempts" json:"attempts"`
+	NextAttemptAt time.Time  `db:"next_attempt_at" json:"nextAttemptAt"`
+	LastError     string     `db:"last_error" json:"lastError"`
+	CreatedAt     time.Time  `db:"created_at" json:"createdAt"`
+	SentAt        *time.Time `db:"sent_at" json:"sentAt"`
+	Expiry        *time.Time `db:"expiry" json:"expiry"`
+	Topic         string     `db:"topic" json:"topic"` // OutboxTopicTransaction or OutboxTopicOverdrawn
+}
+
+// Hold states.
+const (
+	HoldActive   = "active"
+	HoldCaptured = "captured"
+	HoldVoided   = "voided"
+	HoldExpired  = "expired"
+)
+
+// Hold represents a row in public.coin_holds
+type Hold struct {
+	ID        string    `db:"id" json:"id"`
+	AccountID string    `db:"account_id" json:"accountId"`
+	CoinType  string    `db:"coin_type" json:"coinType"`
+	Amount    int64     `db:"amount" json:"amount"`
+	Captured  int64     `db:"captured" json:"captured"`
+	Status    string    `db:"status" json:"status"`
+	UserID    string    `db:"user_id" json:"userId"`
+	DataID    string    `db:"data_id" json:"dataId"`
+	ExpiresAt time.Time `db:"expires_at" json:"expiresAt"`
+	CreatedAt time.Time `db:"created_at" json:"createdAt"`
+	UpdatedAt time.Time `db:"updated_at" json:"updatedAt"`
+}
+
+// SpendLimit represents a row in public.coin_spend_limits: a cap on the debits (use,
+// transfer out, capture) of one coin type within a rolling window. Nil caps are unlimited.
+type SpendLimit struct {
+	AccountID     string `db:"account_id" json:"accountId"` // empty for the global default
+	CoinType      string `db:"coin_type" json:"coinType"`
+	WindowSeconds int64  `db:"window_seconds" json:"windowSeconds"`
+	MaxAmount     *int64 `db:"max_amount" json:"maxAmount"`
+	MaxCount      *int64 `db:"max_count" json:"maxCount"`
+}
+
+// CoinLot represents a row in public.coin_lots: the coins credited by one recharge.
+// Debits draw from the lots that expire first; whatever is left at ExpiresAt is burned.
+type CoinLot struct {
+	ID        int64      `db:"id" json:"id"`
+	AccountID string     `db:"account_id" json:"accountId"`
+	CoinType  string     `db:"coin_type" json:"coinType"`
+	Amount    int64      `db:"amount" json:"amount"`
+	Remaining int64      `db:"remaining" json:"remaining"`
+	ExpiresAt *time.Time `db:"expires_at" json:"expiresAt"`
+	DataID    string     `db:"data_id" json:"dataId"`
+	CreatedAt time.Time  `db:"created_at" json:"createdAt"`
+}
+
+// RechargeSchedule represents a row in public.coin_recharge_schedules: a recurring recharge
+// of an account, driven by either a cron expression or a fixed interval.
+type RechargeSchedule struct {
+	ID              string     `db:"id" json:"id"`
+	AccountID       string     `db:"account_id" json:"accountId"`
+	CoinType        string     `db:"coin_type" json:"coinType"`
+	Amount          int64      `db:"amount" json:"amount"`
+	Cron            *string    `db:"cron" json:"cron"`
+	IntervalSeconds *int64     `db:"interval_seconds" json:"intervalSeconds"`
+	UserID          string     `db:"user_id" json:"userId"`
+	Enabled         bool       `db:"enabled" json:"enabled"`
+	NextRunAt       time.Time  `db:"next_run_at" json:"nextRunAt"`
+	LastRunAt       *time.Time `db:"last_run_at" json:"lastRunAt"`
+	LastError       *string    `db:"last_error" json:"lastError"`
+	CreatedAt       time.Time  `db:"created_at" json:"createdAt"`
+	UpdatedAt       time.Time  `db:"updated_at" json:"updatedAt"`
+}
+
+// Audit outcomes.
+const (
+	AuditPending = "pending" // recorded, mutation not finished (or the process died meanwhile)
+	AuditOK      = "ok"
+	AuditError   = "error"
+)
+
+// AuditEntry represents a row in public.admin_audit: one call of an admin mutation.
+// Args, Before and After are JSON documents; Before and After are null when not captured.
+type AuditEntry struct {
+	ID         int64           `db:"id" json:"id"`
+	Operation  string          `db:"operation" json:"operation"`
+	Caller     string          `db:"caller" json:"caller"`
+	Verified   bool            `db:"caller_verified" json:"callerVerified"` // Caller was signed, not just claimed
+	SourceIP   string          `db:"source_ip" json:"sourceIp"`
+	Args       json.RawMessage `db:"args" json:"args"`
+	Before     json.RawMessage `db:"before" json:"before"`
+	After      json.RawMessage `db:"after" json:"after"`
+	Outcome    string          `db:"outcome" json:"outcome"` // pending | ok | error
+	Error      string          `db:"error" json:"error"`
+	CreatedAt  time.Time       `db:"created_at" json:"createdAt"`
+	FinishedAt *time.Time      `db:"finished_at" json:"finishedAt"`
 }
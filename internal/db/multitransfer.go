@@ -0,0 +1,242 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// --------------------------------------------
+// Multi-leg transfers
+// --------------------------------------------
+
+// IdemOpMultiTransfer is the idempotency operation of MultiTransfer, keyed on the first leg's account.
+const IdemOpMultiTransfer = "transfer_multi"
+
+// maxTransferLegs caps the number of legs (and so row locks) of one MultiTransfer.
+const maxTransferLegs = 100
+
+// TransferLeg is one side of a MultiTransfer: a negative Amount debits AccountID and a
+// positive one credits it. A non-nil ExpectedVersion is checked against the account.
+type TransferLeg struct {
+	AccountID       string `json:"accountId"`
+	Amount          int64  `json:"amount"`
+	ExpectedVersion *int64 `json:"expectedVersion,omitempty"`
+}
+
+// MultiTransfer applies every leg atomically; the legs must sum to zero and name each
+// account once. It returns the accounts in leg order. Each leg gets its own ledger entry
+// and notification, with dataID suffixed by ":<leg index>".
+func (s *Store) MultiTransfer(ctx context.Context, coinType string, legs []TransferLeg, userID, dataID string) ([]*Account, error) {
+	log := s.logger()
+	start := time.Now()
+	coinType = coinTypeOrDefault(coinType)
+	log.Info("MultiTransfer: start",
+		slog.String("coinType", coinType),
+		slog.Int("legs", len(legs)),
+		slog.String("userID_in", userID),
+		slog.String("dataID_in", dataID),
+	)
+	if len(legs) < 2 {
+		return nil, errors.New("transferMulti: at least two legs are required")
+	}
+	if len(legs) > maxTransferLegs {
+		return nil, fmt.Errorf("transferMulti: at most %d legs are allowed", maxTransferLegs)
+	}
+	var sum, moved int64
+	seen := make(map[string]bool, len(legs))
+	for i, l := range legs {
+		if strings.TrimSpace(l.AccountID) == "" {
+			return nil, fmt.Errorf("transferMulti: leg %d: accountId is required", i)
+		}
+		if l.Amount == 0 {
+			return nil, fmt.Errorf("transferMulti: leg %d: amount must not be 0", i)
+		}
+		if seen[l.AccountID] {
+			return nil, fmt.Errorf("transferMulti: account %s appears in more than one leg", l.AccountID)
+		}
+		seen[l.AccountID] = true
+		sum += l.Amount
+		if l.Amount > 0 {
+			moved += l.Amount
+		}
+	}
+	if sum != 0 {
+		return nil, fmt.Errorf("transferMulti: legs do not balance (sum %d)", sum)
+	}
+	if strings.TrimSpace(userID) == "" {
+		return nil, errors.New("userID is required (UUID)")
+	}
+	uid, err := canonicalUUID(userID)
+	if err != nil {
+		return nil, err
+	}
+	userID = uid
+
+	now := time.Now().UTC()
+	keyed := strings.TrimSpace(dataID) != ""
+	baseDataID := dataID
+	if !keyed {
+		baseDataID = fmt.Sprintf("transfer_multi:%d", now.UnixNano())
+	}
+
+	tx, err := s.conn(ctx).Begin(ctx)
+	if err != nil {
+		log.Error("MultiTransfer: begin tx failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if keyed {
+		prior, err := s.claimIdempotency(ctx, tx, IdemOpMultiTransfer, legs[0].AccountID, coinType, dataID, requestHash(IdemOpMultiTransfer, coinType, legsKey(legs), userID))
+		if err != nil {
+			return nil, err
+		}
+		if prior != nil {
+			var res []*Account
+			if err := json.Unmarshal(prior, &res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		}
+	}
+
+	// lock in id order so concurrent transfers over the same accounts can't deadlock
+	order := make([]int, len(legs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return legs[order[a]].AccountID < legs[order[b]].AccountID })
+	locked := make([]*lockedBalance, len(legs))
+	for _, i := range order {
+		l := legs[i]
+		lb, err := lockAccount(ctx, tx, l.AccountID, coinType, l.ExpectedVersion)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, fmt.Errorf("transferMulti: account %s/%s not found", l.AccountID, coinType)
+			}
+			log.Error("MultiTransfer: lock failed", slog.String("id", l.AccountID), slog.String("error", err.Error()))
+			return nil, fmt.Errorf("transferMulti: %w", err)
+		}
+		if err := checkStatus(l.AccountID, lb.status, l.Amount < 0); err != nil {
+			return nil, fmt.Errorf("transferMulti: %w", err)
+		}
+		locked[i] = lb
+	}
+
+	// the credit legs share the lots the debit legs draw, once every debit has drawn
+	var draws []*lotDraw
+	var credits []int
+	for _, i := range order {
+		l, lb := legs[i], locked[i]
+		legDataID := fmt.Sprintf("%s:%d", baseDataID, i)
+		kind := LedgerKindTransferIn
+		change := l.Amount
+		if l.Amount < 0 {
+			amount := -l.Amount
+			if err := s.checkSpendLimits(ctx, tx, l.AccountID, coinType, amount); err != nil {
+				return nil, fmt.Errorf("transferMulti: %w", err)
+			}
+			held, err := heldAmount(ctx, tx, l.AccountID, coinType)
+			if err != nil {
+				log.Error("MultiTransfer: held sum failed", slog.String("id", l.AccountID), slog.String("error", err.Error()))
+				return nil, err
+			}
+			if lb.coins-held+lb.creditLimit < amount {
+				return nil, fmt.Errorf("transferMulti: insufficient balance on %s", l.AccountID)
+			}
+			drawn, err := s.takeLots(ctx, tx, l.AccountID, coinType, amount)
+			if err != nil {
+				return nil, err
+			}
+			draws = append(draws, drawn...)
+			rest, err := s.drawShards(ctx, tx, l.AccountID, coinType, lb, amount)
+			if err != nil {
+				return nil, err
+			}
+			change = -rest
+			kind = LedgerKindTransferOut
+		} else {
+			credits = append(credits, i)
+		}
+		if _, err := tx.Exec(ctx, `
+			UPDATE public.coins
+			SET coins = coins + $3,
+			    version = version + 1,
+			    last_usage_date = CASE WHEN $3::bigint < 0 THEN NOW() ELSE last_usage_date END,
+			    last_recharge_date = CASE WHEN $3::bigint > 0 THEN NOW() ELSE last_recharge_date END
+			WHERE id=$1 AND coin_type=$2
+		`, l.AccountID, coinType, change); err != nil {
+			log.Error("MultiTransfer: update failed", slog.String("id", l.AccountID), slog.String("error", err.Error()))
+			return nil, err
+		}
+		after := lb.coins + l.Amount
+		if err := s.insertLedger(ctx, tx, l.AccountID, coinType, l.Amount, after, userID, legDataID, kind); err != nil {
+			return nil, err
+		}
+		if err := s.notifyOverdrawn(ctx, tx, userID, l.AccountID, coinType, lb.coins, after); err != nil {
+			return nil, err
+		}
+		amount := l.Amount
+		if amount < 0 {
+			amount = -amount
+		}
+		if err := s.notify(ctx, tx, userID, l.AccountID, coinType, legDataID, float64(amount), now, time.Time{}); err != nil {
+			return nil, err
+		}
+	}
+
+	amounts := make([]int64, len(credits))
+	for j, i := range credits {
+		amounts[j] = legs[i].Amount
+	}
+	for j, carried := range shareDraws(draws, amounts) {
+		i := credits[j]
+		if err := s.carryLots(ctx, tx, legs[i].AccountID, coinType, carried, fmt.Sprintf("%s:%d", baseDataID, i)); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make([]*Account, len(legs))
+	for i, l := range legs {
+		acc, err := s.getAccount(ctx, tx, l.AccountID, coinType)
+		if err != nil {
+			log.Error("MultiTransfer: readback failed", slog.String("id", l.AccountID), slog.String("error", err.Error()))
+			return nil, err
+		}
+		out[i] = acc
+	}
+	if keyed {
+		if err := s.completeIdempotency(ctx, tx, IdemOpMultiTransfer, legs[0].AccountID, coinType, dataID, out); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Error("MultiTransfer: commit failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	log.Info("MultiTransfer: ok",
+		slog.Int("legs", len(legs)),
+		slog.Int64("moved", moved),
+		slog.Duration("dur", time.Since(start)),
+	)
+	return out, nil
+}
+
+// legsKey renders the legs for requestHash.
+func legsKey(legs []TransferLeg) [][]any {
+	out := make([][]any, 0, len(legs))
+	for _, l := range legs {
+		out = append(out, []any{l.AccountID, l.Amount, versionKey(l.ExpectedVersion)})
+	}
+	return out
+}
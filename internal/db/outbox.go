@@ -0,0 +1,358 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// --------------------------------------------
+// Transactional outbox for TxNotifier and AlertNotifier deliveries
+// --------------------------------------------
+
+// Outbox entry states.
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	OutboxDead    = "dead"
+)
+
+// Outbox topics: what an entry is and which notifier delivers it.
+const (
+	OutboxTopicTransaction = "transaction" // a balance change, for TxNotifier
+	OutboxTopicOverdrawn   = "overdrawn"   // a balance went below zero, for AlertNotifier; CoinUsed is the overdraft
+)
+
+const notifyPlatform = "coin-service"
+
+// OutboxConfig tunes the outbox dispatcher. Zero values fall back to defaults.
+type OutboxConfig struct {
+	PollInterval time.Duration // idle wait between passes (default 1s)
+	BatchSize    int           // entries claimed per pass (default 100)
+	MaxAttempts  int           // attempts before an entry is dead-lettered (default 10)
+	BaseBackoff  time.Duration // first retry delay, doubled per attempt (default 1s)
+	MaxBackoff   time.Duration // retry delay cap (default 5m)
+	SendTimeout  time.Duration // per-delivery timeout (default 10s)
+	Lease        time.Duration // how long a pass holds the entries it claimed (default 2m)
+}
+
+func (c OutboxConfig) withDefaults() OutboxConfig {
+	if c.PollInterval <= 0 {
+		c.PollInterval = time.Second
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = 100
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 10
+	}
+	if c.BaseBackoff <= 0 {
+		c.BaseBackoff = time.Second
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = 5 * time.Minute
+	}
+	if c.SendTimeout <= 0 {
+		c.SendTimeout = 10 * time.Second
+	}
+	if c.Lease <= 0 {
+		c.Lease = 2 * time.Minute
+	}
+	if c.Lease < 2*c.SendTimeout {
+		c.Lease = 2 * c.SendTimeout
+	}
+	return c
+}
+
+// backoff returns the retry delay after the given number of failed attempts.
+func (c OutboxConfig) backoff(attempts int) time.Duration {
+	d := c.BaseBackoff
+	for i := 1; i < attempts && d < c.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > c.MaxBackoff {
+		d = c.MaxBackoff
+	}
+	return d
+}
+
+// notify queues a transaction notification inside the caller's transaction, so it is
+// recorded if and only if the balance change commits. Delivery is done by the dispatcher.
+// expiry is forwarded to the notifier; the zero time means none.
+func (s *Store) notify(ctx context.Context, tx pgx.Tx, userID, coinID, coinType, dataID string, coinUsed float64, when, expiry time.Time) error {
+	l := s.logger()
+	if s.Notifier == nil {
+		l.Debug("notify: notifier nil; skipping",
+			slog.String("userID", userID),
+			slog.String("dataID", dataID),
+		)
+		return nil
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO public.tx_outbox (account_id, coin_type, user_id, data_id, coin_used, occurred_at, expiry)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, coinID, coinType, userID, dataID, coinUsed, when.UTC(), nullTime(expiry)); err != nil {
+		l.Error("notify: enqueue failed",
+			slog.String("userID", userID),
+			slog.String("dataID", dataID),
+			slog.String("coinID", coinID),
+			slog.String("error", err.Error()),
+		)
+		return err
+	}
+	l.Debug("notify: queued",
+		slog.String("userID", userID),
+		slog.String("dataID", dataID),
+		slog.String("coinID", coinID),
+		slog.Float64("coinUsed", coinUsed),
+		slog.Time("when_utc", when.UTC()),
+	)
+	return nil
+}
+
+// deliver sends one outbox entry to the notifier of its topic.
+func deliver(ctx context.Context, n TxNotifier, a AlertNotifier, e *OutboxEntry) error {
+	switch e.Topic {
+	case OutboxTopicOverdrawn:
+		if a == nil {
+			return errors.New("outbox: alert notifier is nil")
+		}
+		return a.Overdrawn(ctx, e.DataID, e.UserID, e.AccountID, e.CoinType, int64(e.CoinUsed), e.OccurredAt.UTC())
+	default:
+		if n == nil {
+			return errors.New("outbox: notifier is nil")
+		}
+		var expiry time.Time
+		if e.Expiry != nil {
+			expiry = e.Expiry.UTC()
+		}
+		return n.Create(ctx, e.UserID, e.DataID, e.AccountID, e.CoinType, notifyPlatform, e.CoinUsed, e.OccurredAt.UTC(), expiry)
+	}
+}
+
+// RunOutboxDispatcher drains the outbox to s.Notifier and s.Alerts until ctx is cancelled.
+// Several replicas may run it concurrently; rows are claimed with SKIP LOCKED.
+func (s *Store) RunOutboxDispatcher(ctx context.Context, cfg OutboxConfig) {
+	log := s.logger()
+	cfg = cfg.withDefaults()
+	log.Info("outbox: dispatcher started", slog.Duration("poll", cfg.PollInterval), slog.Int("maxAttempts", cfg.MaxAttempts))
+	for {
+		n, err := s.DispatchOutbox(ctx, cfg)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.Error("outbox: pass failed", slog.String("error", err.Error()))
+		}
+		if n > 0 && err == nil {
+			continue // more may be ready (e.g. the next entry of the same account)
+		}
+		select {
+		case <-ctx.Done():
+			log.Info("outbox: dispatcher stopped")
+			return
+		case <-time.After(cfg.PollInterval):
+		}
+	}
+}
+
+// DispatchOutbox runs a single delivery pass and returns how many entries were attempted.
+// Only the oldest pending entry of each account is eligible, which keeps per-account order.
+// The batch is claimed by pushing its next_attempt_at out by cfg.Lease and committing, so
+// no transaction or row lock is held while sending; a pass that dies mid-way leaves its
+// entries to be retried once the lease runs out.
+func (s *Store) DispatchOutbox(ctx context.Context, cfg OutboxConfig) (int, error) {
+	log := s.logger()
+	cfg = cfg.withDefaults()
+	if s.Notifier == nil && s.Alerts == nil {
+		return 0, errors.New("outbox: notifier is nil")
+	}
+
+	claimed := time.Now()
+	batch, err := s.claimOutbox(ctx, cfg)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, e := range batch {
+		if time.Since(claimed) > cfg.Lease-cfg.SendTimeout {
+			// the lease is running out; hand the rest back rather than race a later pass
+			if err := s.releaseOutbox(ctx, batch[i:]); err != nil {
+				return i, err
+			}
+			return i, nil
+		}
+		sendCtx, cancel := context.WithTimeout(ctx, cfg.SendTimeout)
+		sendErr := deliver(sendCtx, s.Notifier, s.Alerts, e)
+		cancel()
+
+		attempts := e.Attempts + 1
+		if sendErr == nil {
+			if _, err := s.Pool.Exec(ctx, `
+				UPDATE public.tx_outbox
+				SET status = 'sent', attempts = $2, sent_at = NOW(), last_error = ''
+				WHERE id = $1 AND status = 'pending'
+			`, e.ID, attempts); err != nil {
+				return i, err
+			}
+			log.Debug("outbox: sent", slog.Int64("id", e.ID), slog.String("dataID", e.DataID))
+			continue
+		}
+
+		status := OutboxPending
+		if attempts >= cfg.MaxAttempts {
+			status = OutboxDead
+		}
+		if _, err := s.Pool.Exec(ctx, `
+			UPDATE public.tx_outbox
+			SET status = $2, attempts = $3, last_error = $4, next_attempt_at = NOW() + make_interval(secs => $5)
+			WHERE id = $1 AND status = 'pending'
+		`, e.ID, status, attempts, sendErr.Error(), cfg.backoff(attempts).Seconds()); err != nil {
+			return i, err
+		}
+		log.Warn("outbox: delivery failed",
+			slog.Int64("id", e.ID),
+			slog.String("dataID", e.DataID),
+			slog.Int("attempts", attempts),
+			slog.String("status", status),
+			slog.String("error", sendErr.Error()),
+		)
+	}
+	return len(batch), nil
+}
+
+// claimOutbox leases up to cfg.BatchSize deliverable entries to the caller, oldest first.
+// Concurrent passes skip each other's rows, and the lease keeps them away afterwards.
+func (s *Store) claimOutbox(ctx context.Context, cfg OutboxConfig) ([]*OutboxEntry, error) {
+	rows, err := s.Pool.Query(ctx, `
+		UPDATE public.tx_outbox
+		SET next_attempt_at = NOW() + make_interval(secs => $2)
+		WHERE id IN (
+			SELECT id FROM public.tx_outbox o
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			  AND NOT EXISTS (
+				SELECT 1 FROM public.tx_outbox p
+				WHERE p.account_id = o.account_id AND p.status = 'pending' AND p.id < o.id
+			  )
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, account_id, coin_type, user_id, data_id, coin_used, occurred_at, attempts, expiry, topic
+	`, cfg.BatchSize, cfg.Lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var batch []*OutboxEntry
+	for rows.Next() {
+		var e OutboxEntry
+		if err := rows.Scan(&e.ID, &e.AccountID, &e.CoinType, &e.UserID, &e.DataID, &e.CoinUsed, &e.OccurredAt, &e.Attempts, &e.Expiry, &e.Topic); err != nil {
+			return nil, err
+		}
+		batch = append(batch, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(batch, func(a, b *OutboxEntry) int { return cmp.Compare(a.ID, b.ID) })
+	return batch, nil
+}
+
+// releaseOutbox makes claimed but unsent entries deliverable again right away.
+func (s *Store) releaseOutbox(ctx context.Context, entries []*OutboxEntry) error {
+	ids := make([]int64, 0, len(entries))
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+	_, err := s.Pool.Exec(ctx, `
+		UPDATE public.tx_outbox SET next_attempt_at = NOW() WHERE id = ANY($1) AND status = 'pending'
+	`, ids)
+	return err
+}
+
+// nullTime maps the zero time to SQL NULL.
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
+
+const outboxColumns = `id, account_id, coin_type, user_id, data_id, coin_used, occurred_at, status, attempts, next_attempt_at, last_error, created_at, sent_at, expiry, topic`
+
+func scanOutbox(row pgx.Row) (*OutboxEntry, error) {
+	var e OutboxEntry
+	if err := row.Scan(&e.ID, &e.AccountID, &e.CoinType, &e.UserID, &e.DataID, &e.CoinUsed, &e.OccurredAt, &e.Status, &e.Attempts, &e.NextAttemptAt, &e.LastError, &e.CreatedAt, &e.SentAt, &e.Expiry, &e.Topic); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// ListOutbox returns outbox entries, oldest first, optionally filtered by status.
+// after is the id of the last entry of the previous page (0 for the first page).
+func (s *Store) ListOutbox(ctx context.Context, status string, first int, after int64) ([]*OutboxEntry, error) {
+	log := s.logger()
+	start := time.Now()
+	if first <= 0 {
+		first = 50
+	}
+	if first > 200 {
+		first = 200
+	}
+	log.Debug("ListOutbox: query", slog.String("status", status), slog.Int("first", first), slog.Int64("after", after))
+	rows, err := s.reader(ctx).Query(ctx, `
+		SELECT `+outboxColumns+`
+		FROM public.tx_outbox
+		WHERE ($1 = '' OR status = $1) AND id > $2
+		ORDER BY id
+		LIMIT $3
+	`, status, after, first)
+	if err != nil {
+		log.Error("ListOutbox: query failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*OutboxEntry
+	for rows.Next() {
+		e, err := scanOutbox(rows)
+		if err != nil {
+			log.Error("ListOutbox: scan failed", slog.String("error", err.Error()))
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error("ListOutbox: rows err", slog.String("error", err.Error()))
+		return nil, err
+	}
+	log.Debug("ListOutbox: ok", slog.Int("count", len(out)), slog.Duration("dur", time.Since(start)))
+	return out, nil
+}
+
+// ReplayOutbox puts a dead or stuck entry back into the queue for immediate delivery.
+// Returns nil if the entry does not exist or was already sent.
+func (s *Store) ReplayOutbox(ctx context.Context, id int64) (*OutboxEntry, error) {
+	log := s.logger()
+	log.Info("ReplayOutbox: start", slog.Int64("id", id))
+	e, err := scanOutbox(s.Pool.QueryRow(ctx, `
+		UPDATE public.tx_outbox
+		SET status = 'pending', attempts = 0, next_attempt_at = NOW(), last_error = ''
+		WHERE id = $1 AND status <> 'sent'
+		RETURNING `+outboxColumns, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			log.Info("ReplayOutbox: not found or already sent", slog.Int64("id", id))
+			return nil, nil
+		}
+		log.Error("ReplayOutbox: failed", slog.Int64("id", id), slog.String("error", err.Error()))
+		return nil, err
+	}
+	log.Info("ReplayOutbox: ok", slog.Int64("id", id))
+	return e, nil
+}
@@ -0,0 +1,167 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"log/slog"
+)
+
+// --------------------------------------------
+// Keyset (cursor) pagination of account listings
+// --------------------------------------------
+
+// Page sizes of the account listings.
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// ErrInvalidCursor is returned for an after cursor that wasn't issued by the same listing.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// PageArgs selects up to First rows (DefaultPageSize if 0) after the After cursor ("" for the first page).
+type PageArgs struct {
+	First int
+	After string
+}
+
+// AccountPage is one page of an account listing. Cursors[i] resumes the listing after Accounts[i].
+type AccountPage struct {
+	Accounts        []*Account
+	Cursors         []string
+	HasNextPage     bool
+	HasPreviousPage bool
+
+	count func(ctx context.Context) (int64, error)
+}
+
+// TotalCount counts every row of the listing, ignoring the page. It is a separate query
+// so callers that don't need it don't pay for it.
+func (p *AccountPage) TotalCount(ctx context.Context) (int64, error) {
+	return p.count(ctx)
+}
+
+// pageCursor holds the sort key of the last row of a page. K names the listing.
+type pageCursor struct {
+	K        string     `json:"k"`
+	ID       string     `json:"i"`
+	CoinType string     `json:"t"`
+	Coins    int64      `json:"c,omitempty"`
+	At       *time.Time `json:"a,omitempty"`
+}
+
+func (c pageCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(kind, s string) (*pageCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c pageCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.K != kind {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// accountListing describes one listing of public.coins c: its filter, a total order and
+// how to resume after a cursor.
+type accountListing struct {
+	name  string
+	where string // conditions over c using $1..$len(args)
+	args  []any
+	order string
+	// after renders "rows strictly after c" numbering parameters from next
+	after  func(c *pageCursor, next int) (string, []any)
+	cursor func(a *Account) pageCursor
+	// key, if set, is the indexed column of c that where, order and after sort and filter on,
+	// which shardedKey (see shardedCoins) stands for on a sharded balance. The listing then
+	// runs over unsharded balances off the index and over sharded ones apart, and merges them.
+	key, shardedKey string
+}
+
+func (s *Store) pageAccounts(ctx context.Context, l accountListing, page PageArgs) (*AccountPage, error) {
+	log := s.logger()
+	start := time.Now()
+	first := page.First
+	switch {
+	case first == 0:
+		first = DefaultPageSize
+	case first < 0 || first > MaxPageSize:
+		return nil, fmt.Errorf("%s: first must be between 1 and %d", l.name, MaxPageSize)
+	}
+	cond := l.where
+	args := append([]any{}, l.args...)
+	if page.After != "" {
+		c, err := decodeCursor(l.name, page.After)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", l.name, err)
+		}
+		after, cargs := l.after(c, len(args)+1)
+		cond += " AND " + after
+		args = append(args, cargs...)
+	}
+	q := fmt.Sprintf(`SELECT %s FROM public.coins c WHERE %s ORDER BY %s LIMIT %d`, accountColumns, cond, l.order, first+1)
+	where := l.where
+	if l.key != "" {
+		sharded := func(s string) string { return strings.ReplaceAll(s, l.key, l.shardedKey) }
+		// shardedKey equals key on an unsharded balance, so it orders the merged rows
+		q = fmt.Sprintf(`
+			SELECT %[1]s FROM public.coins c WHERE (c.id, c.coin_type) IN (
+				(SELECT c.id, c.coin_type FROM public.coins c WHERE c.shards = 0 AND %[2]s ORDER BY %[3]s LIMIT %[6]d)
+				UNION ALL
+				(SELECT c.id, c.coin_type FROM public.coins c WHERE c.shards > 0 AND %[4]s ORDER BY %[5]s LIMIT %[6]d)
+			)
+			ORDER BY %[5]s LIMIT %[6]d`, accountColumns, cond, l.order, sharded(cond), sharded(l.order), first+1)
+		where = sharded(where)
+	}
+	log.Debug(l.name+": query", slog.Int("first", first), slog.Bool("after", page.After != ""))
+
+	rows, err := s.reader(ctx).Query(ctx, q, args...)
+	if err != nil {
+		log.Error(l.name+": query failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+	out := &AccountPage{Accounts: []*Account{}, Cursors: []string{}, HasPreviousPage: page.After != ""}
+	for rows.Next() {
+		a, err := scanAccount(rows)
+		if err != nil {
+			log.Error(l.name+": scan failed", slog.String("error", err.Error()))
+			return nil, err
+		}
+		out.Accounts = append(out.Accounts, a)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error(l.name+": rows err", slog.String("error", err.Error()))
+		return nil, err
+	}
+	if len(out.Accounts) > first {
+		out.Accounts = out.Accounts[:first]
+		out.HasNextPage = true
+	}
+	for _, a := range out.Accounts {
+		c := l.cursor(a)
+		c.K, c.ID, c.CoinType = l.name, a.ID, a.CoinType
+		out.Cursors = append(out.Cursors, c.encode())
+	}
+	out.count = func(ctx context.Context) (int64, error) {
+		var n int64
+		if err := s.reader(ctx).QueryRow(ctx, `SELECT COUNT(*) FROM public.coins c WHERE `+where, l.args...).Scan(&n); err != nil {
+			log.Error(l.name+": count failed", slog.String("error", err.Error()))
+			return 0, err
+		}
+		return n, nil
+	}
+	log.Debug(l.name+": ok", slog.Int("count", len(out.Accounts)), slog.Bool("hasNextPage", out.HasNextPage), slog.Duration("dur", time.Since(start)))
+	return out, nil
+}
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"log/slog"
+)
+
+// --------------------------------------------
+// Read replica routing
+// --------------------------------------------
+
+// DefaultMaxReplicaLag applies when Store.MaxReplicaLag is zero.
+const DefaultMaxReplicaLag = 5 * time.Second
+
+// replicaCheckInterval is how long a replica lag measurement is trusted.
+const replicaCheckInterval = time.Second
+
+// replicaCheckTimeout bounds one replica lag measurement.
+const replicaCheckTimeout = 2 * time.Second
+
+// replicaState caches whether the replica is fit to serve reads.
+type replicaState struct {
+	mu        sync.Mutex
+	checkedAt time.Time
+	ok        bool
+}
+
+// reader returns where a read-only method should query: the replica while it is reachable
+// and within MaxReplicaLag of the primary, the primary otherwise. Mutations and the reads
+// that follow them within a mutation (readbacks) use s.conn directly.
+func (s *Store) reader(ctx context.Context) querier {
+	if s.Replica == nil || wantsPrimary(ctx) {
+		return s.conn(ctx)
+	}
+	st := &s.replica
+	st.mu.Lock()
+	if time.Since(st.checkedAt) < replicaCheckInterval {
+		ok := st.ok
+		st.mu.Unlock()
+		if ok {
+			return s.Replica
+		}
+		return s.Pool
+	}
+	// claim the check, so concurrent readers keep using the last verdict meanwhile
+	st.checkedAt = time.Now()
+	wasOK := st.ok
+	st.mu.Unlock()
+
+	ok := s.replicaFresh()
+	st.mu.Lock()
+	st.ok = ok
+	st.mu.Unlock()
+	if ok != wasOK {
+		if ok {
+			s.logger().Info("replica: serving reads")
+		} else {
+			s.logger().Warn("replica: unavailable or lagging; reading from the primary")
+		}
+	}
+	if ok {
+		return s.Replica
+	}
+	return s.Pool
+}
+
+// replicaFresh reports whether the replica answers and has replayed the primary's WAL up to
+// MaxReplicaLag ago. A replica that is streaming from the primary and has replayed everything
+// it received counts as current even when the primary has been idle for longer than that; one
+// whose WAL receiver is down, or whose status the role may not read (pg_read_all_stats), is
+// judged by its last replayed transaction alone. The check runs on its own context, so a
+// cancelled request can't mark the replica unfit for everyone else.
+func (s *Store) replicaFresh() bool {
+	maxLag := s.MaxReplicaLag
+	if maxLag <= 0 {
+		maxLag = DefaultMaxReplicaLag
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), replicaCheckTimeout)
+	defer cancel()
+	var lag *float64
+	if err := s.Replica.QueryRow(ctx, `
+		SELECT CASE
+			WHEN NOT pg_is_in_recovery() THEN 0
+			WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn()
+			 AND EXISTS (SELECT 1 FROM pg_stat_wal_receiver WHERE status = 'streaming') THEN 0
+			ELSE EXTRACT(EPOCH FROM NOW() - pg_last_xact_replay_timestamp())
+		END::float8
+	`).Scan(&lag); err != nil {
+		s.logger().Warn("replica: lag check failed", slog.String("error", err.Error()))
+		return false
+	}
+	// NULL: nothing replayed yet
+	if lag == nil {
+		return false
+	}
+	return time.Duration(*lag*float64(time.Second)) <= maxLag
+}
+
+type primaryKey struct{}
+
+// WithPrimary marks ctx so that reads made with it skip the replica, e.g. the fields a
+// client selects on a mutation's result, which must reflect the write just made.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+func wantsPrimary(ctx context.Context) bool {
+	v, _ := ctx.Value(primaryKey{}).(bool)
+	return v
+}
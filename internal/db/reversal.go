@@ -0,0 +1,260 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// --------------------------------------------
+// Refunds / reversals by original dataId
+// --------------------------------------------
+
+// ErrReversalExceedsOriginal is returned when a reversal would give back more than the original operation took.
+var ErrReversalExceedsOriginal = errors.New("reversal exceeds the original amount")
+
+// reversibleKinds are the debits that can be reversed.
+var reversibleKinds = []string{LedgerKindUse, LedgerKindCapture, LedgerKindTransferOut}
+
+// Reversal is the outcome of ReverseTransaction. Counterparty is the payee a transfer is
+// taken back from; it is nil for use and capture.
+type Reversal struct {
+	Original      *LedgerEntry `json:"original"`
+	Amount        int64        `json:"amount"`
+	TotalReversed int64        `json:"totalReversed"`
+	Remaining     int64        `json:"remaining"`
+	DataID        string       `json:"dataId"`
+	Account       *Account     `json:"account"`
+	Counterparty  *Account     `json:"counterparty"`
+}
+
+// ReverseTransaction gives back all (amount nil) or part of a use, capture or transfer, found
+// by the dataID it was made with. accountID narrows the lookup when several accounts used the
+// same dataID. Reversals of one operation never add up to more than it took. A transfer is
+// reversed by moving the coins back from the payee. The compensating entries are recorded
+// as "reversal" and notified with dataID "reversal:<original dataId>:<n>". A non-nil
+// expectedVersion is checked against the account that is refunded.
+func (s *Store) ReverseTransaction(ctx context.Context, dataID string, amount *int64, accountID string, expectedVersion *int64, userID string) (*Reversal, error) {
+	log := s.logger()
+	start := time.Now()
+	log.Info("ReverseTransaction: start",
+		slog.String("dataID", dataID),
+		slog.Any("amount", amount),
+		slog.String("accountID", accountID),
+		slog.Any("expectedVersion", expectedVersion),
+		slog.String("userID_in", userID),
+	)
+	if strings.TrimSpace(dataID) == "" {
+		return nil, errors.New("reverse: dataId is required")
+	}
+	if amount != nil && *amount <= 0 {
+		return nil, errors.New("reverse: amount must be > 0")
+	}
+	if strings.TrimSpace(userID) == "" {
+		return nil, errors.New("userID is required (UUID)")
+	}
+	uid, err := canonicalUUID(userID)
+	if err != nil {
+		return nil, err
+	}
+	userID = uid
+
+	tx, err := s.conn(ctx).Begin(ctx)
+	if err != nil {
+		log.Error("ReverseTransaction: begin tx failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// transfers record their debit leg as "<dataId>:out"
+	rows, err := tx.Query(ctx, `
+		SELECT `+ledgerColumns+` FROM public.coin_ledger
+		WHERE data_id IN ($1, $1 || ':out') AND kind = ANY($2) AND ($3 = '' OR account_id = $3)
+		ORDER BY id
+		LIMIT 2
+	`, dataID, reversibleKinds, accountID)
+	if err != nil {
+		log.Error("ReverseTransaction: lookup failed", slog.String("dataID", dataID), slog.String("error", err.Error()))
+		return nil, err
+	}
+	var found []*LedgerEntry
+	for rows.Next() {
+		e, err := scanLedgerEntry(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		found = append(found, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("reverse: no use, capture or transfer with dataId %s found", dataID)
+	case 2:
+		return nil, fmt.Errorf("reverse: several operations use dataId %s; pass the account id", dataID)
+	}
+	orig := found[0]
+
+	var payee *LedgerEntry
+	if orig.Kind == LedgerKindTransferOut {
+		payee, err = scanLedgerEntry(tx.QueryRow(ctx, `
+			SELECT `+ledgerColumns+` FROM public.coin_ledger
+			WHERE data_id = $1 AND kind = $2 AND coin_type = $3
+			ORDER BY id
+			LIMIT 1
+		`, strings.TrimSuffix(orig.DataID, ":out")+":in", LedgerKindTransferIn, orig.CoinType))
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, fmt.Errorf("reverse: credit leg of transfer %s not found", dataID)
+			}
+			return nil, err
+		}
+	}
+
+	if payee != nil && payee.AccountID == orig.AccountID {
+		return nil, fmt.Errorf("reverse: transfer %s moved coins to the same account", dataID)
+	}
+
+	// lock in id order, as MultiTransfer does
+	ids := []string{orig.AccountID}
+	if payee != nil {
+		if payee.AccountID < orig.AccountID {
+			ids = []string{payee.AccountID, orig.AccountID}
+		} else {
+			ids = append(ids, payee.AccountID)
+		}
+	}
+	locked := map[string]*lockedBalance{}
+	for _, id := range ids {
+		var want *int64
+		if id == orig.AccountID {
+			want = expectedVersion
+		}
+		lb, err := lockAccount(ctx, tx, id, orig.CoinType, want)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, fmt.Errorf("reverse: account %s/%s not found", id, orig.CoinType)
+			}
+			return nil, err
+		}
+		locked[id] = lb
+	}
+
+	var reversed int64
+	if err := tx.QueryRow(ctx, `
+		SELECT COALESCE(SUM(delta), 0)::bigint FROM public.coin_ledger WHERE reverses_id = $1
+	`, orig.ID).Scan(&reversed); err != nil {
+		return nil, err
+	}
+	remaining := -orig.Delta - reversed
+	amt := remaining
+	if amount != nil {
+		amt = *amount
+	}
+	if amt <= 0 || amt > remaining {
+		return nil, fmt.Errorf("reverse %s: %d of %d already reversed, %d more requested: %w", dataID, reversed, -orig.Delta, amt, ErrReversalExceedsOriginal)
+	}
+
+	var n int64
+	if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM public.coin_ledger WHERE reverses_id = $1`, orig.ID).Scan(&n); err != nil {
+		return nil, err
+	}
+	revDataID := fmt.Sprintf("reversal:%s:%d", dataID, n+1)
+	now := time.Now().UTC()
+
+	// coins taken back from a payee keep the expiry they had; a reversed use or capture comes
+	// back unlotted, since which lots it drew from isn't recorded
+	var draws []*lotDraw
+	if payee != nil {
+		lb := locked[payee.AccountID]
+		if err := checkStatus(payee.AccountID, lb.status, true); err != nil {
+			return nil, fmt.Errorf("reverse: %w", err)
+		}
+		held, err := heldAmount(ctx, tx, payee.AccountID, orig.CoinType)
+		if err != nil {
+			return nil, err
+		}
+		if lb.coins-held+lb.creditLimit < amt {
+			return nil, fmt.Errorf("reverse: insufficient balance on %s", payee.AccountID)
+		}
+		if draws, err = s.takeLots(ctx, tx, payee.AccountID, orig.CoinType, amt); err != nil {
+			return nil, err
+		}
+		rest, err := s.drawShards(ctx, tx, payee.AccountID, orig.CoinType, lb, amt)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.applyReversal(ctx, tx, payee, -amt, -rest, lb.coins-amt, userID, revDataID+":out"); err != nil {
+			return nil, err
+		}
+		if err := s.notifyOverdrawn(ctx, tx, userID, payee.AccountID, orig.CoinType, lb.coins, lb.coins-amt); err != nil {
+			return nil, err
+		}
+		if err := s.notify(ctx, tx, userID, payee.AccountID, orig.CoinType, revDataID+":out", float64(amt), now, time.Time{}); err != nil {
+			return nil, err
+		}
+	}
+	lb := locked[orig.AccountID]
+	if err := checkStatus(orig.AccountID, lb.status, false); err != nil {
+		return nil, fmt.Errorf("reverse: %w", err)
+	}
+	if err := s.applyReversal(ctx, tx, orig, amt, amt, lb.coins+amt, userID, revDataID); err != nil {
+		return nil, err
+	}
+	if err := s.carryLots(ctx, tx, orig.AccountID, orig.CoinType, draws, revDataID); err != nil {
+		return nil, err
+	}
+	if err := s.notify(ctx, tx, userID, orig.AccountID, orig.CoinType, revDataID, float64(amt), now, time.Time{}); err != nil {
+		return nil, err
+	}
+
+	res := &Reversal{Original: orig, Amount: amt, TotalReversed: reversed + amt, Remaining: remaining - amt, DataID: revDataID}
+	if res.Account, err = s.getAccount(ctx, tx, orig.AccountID, orig.CoinType); err != nil {
+		return nil, err
+	}
+	if payee != nil {
+		if res.Counterparty, err = s.getAccount(ctx, tx, payee.AccountID, orig.CoinType); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Error("ReverseTransaction: commit failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	log.Info("ReverseTransaction: ok",
+		slog.String("dataID", dataID),
+		slog.Int64("amount", amt),
+		slog.Int64("remaining", res.Remaining),
+		slog.Duration("dur", time.Since(start)),
+	)
+	return res, nil
+}
+
+// applyReversal changes the balance of the entry's account by delta, of which change falls
+// on its row and the rest on its shards (see drawShards), and records a reversal ledger
+// entry pointing at it.
+func (s *Store) applyReversal(ctx context.Context, tx pgx.Tx, of *LedgerEntry, delta, change, balanceAfter int64, userID, dataID string) error {
+	if _, err := tx.Exec(ctx, `
+		UPDATE public.coins SET coins = coins + $3, version = version + 1 WHERE id=$1 AND coin_type=$2
+	`, of.AccountID, of.CoinType, change); err != nil {
+		s.logger().Error("applyReversal: update failed", slog.String("accountID", of.AccountID), slog.String("error", err.Error()))
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO public.coin_ledger (account_id, coin_type, delta, balance_after, user_id, data_id, kind, reverses_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, of.AccountID, of.CoinType, delta, balanceAfter, userID, dataID, LedgerKindReversal, of.ID); err != nil {
+		s.logger().Error("applyReversal: ledger insert failed", slog.String("accountID", of.AccountID), slog.String("error", err.Error()))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,398 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"log/slog"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// --------------------------------------------
+// Scheduled & recurring recharges
+// --------------------------------------------
+
+// schedulerLockKey is the pg_advisory_lock key held by the replica firing due schedules.
+const schedulerLockKey int64 = 0x636f696e73 + 1
+
+const scheduleColumns = `id, account_id, coin_type, amount, cron, interval_seconds, user_id, enabled,
+	next_run_at, last_run_at, last_error, created_at, updated_at`
+
+func scanSchedule(row pgx.Row) (*RechargeSchedule, error) {
+	var sc RechargeSchedule
+	if err := row.Scan(&sc.ID, &sc.AccountID, &sc.CoinType, &sc.Amount, &sc.Cron, &sc.IntervalSeconds, &sc.UserID, &sc.Enabled,
+		&sc.NextRunAt, &sc.LastRunAt, &sc.LastError, &sc.CreatedAt, &sc.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &sc, nil
+}
+
+// nextRun returns when sc fires next after now, skipping any runs missed while it was due.
+// The zero time means never (a cron expression with no future match).
+func (sc *RechargeSchedule) nextRun(now time.Time) (time.Time, error) {
+	if sc.Cron != nil {
+		spec, err := parseCron(*sc.Cron)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return spec.next(now), nil
+	}
+	if sc.IntervalSeconds == nil || *sc.IntervalSeconds <= 0 {
+		return time.Time{}, errors.New("schedule needs a cron expression or an interval")
+	}
+	interval := time.Duration(*sc.IntervalSeconds) * time.Second
+	next := sc.NextRunAt
+	if next.IsZero() || next.After(now) {
+		return now.Add(interval), nil
+	}
+	skipped := now.Sub(next) / interval
+	return next.Add((skipped + 1) * interval), nil
+}
+
+// setTiming makes sc run on cron (if non-empty) or every interval, validating either one.
+func (sc *RechargeSchedule) setTiming(cron string, interval time.Duration) error {
+	cron = strings.TrimSpace(cron)
+	switch {
+	case cron != "" && interval != 0:
+		return errors.New("schedule: set either cron or interval, not both")
+	case cron != "":
+		if _, err := parseCron(cron); err != nil {
+			return fmt.Errorf("schedule: %w", err)
+		}
+		sc.Cron, sc.IntervalSeconds = &cron, nil
+	case interval >= time.Minute:
+		secs := int64(interval / time.Second)
+		sc.Cron, sc.IntervalSeconds = nil, &secs
+	default:
+		return errors.New("schedule: interval must be at least 1m")
+	}
+	return nil
+}
+
+// CreateRechargeSchedule recharges one coin type of an account by amount on a cron expression
+// (five fields, UTC) or every interval, starting at startAt if given. userID (UUID) is recorded
+// on every recharge. A non-nil expectedVersion is checked against the scheduled balance, here
+// and in the other schedule mutations.
+func (s *Store) CreateRechargeSchedule(ctx context.Context, accountID, coinType string, amount int64, cron string, interval time.Duration, startAt *time.Time, expectedVersion *int64, userID string) (*RechargeSchedule, error) {
+	log := s.logger()
+	start := time.Now()
+	coinType = coinTypeOrDefault(coinType)
+	log.Info("CreateRechargeSchedule: start",
+		slog.String("accountID", accountID),
+		slog.String("coinType", coinType),
+		slog.Int64("amount", amount),
+		slog.String("cron", cron),
+		slog.Duration("interval", interval),
+		slog.Any("expectedVersion", expectedVersion),
+		slog.String("userID_in", userID),
+	)
+	if amount <= 0 {
+		return nil, errors.New("schedule: amount must be > 0")
+	}
+	uid, err := canonicalUUID(userID)
+	if err != nil {
+		return nil, err
+	}
+	sc := &RechargeSchedule{AccountID: accountID, CoinType: coinType, Amount: amount, UserID: uid, Enabled: true}
+	if err := sc.setTiming(cron, interval); err != nil {
+		return nil, err
+	}
+	if startAt != nil {
+		sc.NextRunAt = startAt.UTC()
+	} else if sc.NextRunAt, err = sc.nextRun(time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	if sc.NextRunAt.IsZero() {
+		return nil, fmt.Errorf("schedule: cron %q never fires", *sc.Cron)
+	}
+
+	tx, err := s.conn(ctx).Begin(ctx)
+	if err != nil {
+		log.Error("CreateRechargeSchedule: begin tx failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err := lockAccount(ctx, tx, accountID, coinType, expectedVersion); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("schedule: account %s/%s not found", accountID, coinType)
+		}
+		return nil, err
+	}
+	sc, err = scanSchedule(tx.QueryRow(ctx, `
+		INSERT INTO public.coin_recharge_schedules (id, account_id, coin_type, amount, cron, interval_seconds, user_id, next_run_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING `+scheduleColumns,
+		uuid.NewString(), sc.AccountID, sc.CoinType, sc.Amount, sc.Cron, sc.IntervalSeconds, sc.UserID, sc.NextRunAt))
+	if err != nil {
+		log.Error("CreateRechargeSchedule: insert failed", slog.String("accountID", accountID), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Error("CreateRechargeSchedule: commit failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	log.Info("CreateRechargeSchedule: ok", slog.String("id", sc.ID), slog.Time("nextRunAt", sc.NextRunAt), slog.Duration("dur", time.Since(start)))
+	return sc, nil
+}
+
+// UpdateRechargeSchedule changes the non-nil fields of a schedule. A new cron replaces the
+// interval and vice versa. Changing the timing or re-enabling recomputes the next run from now.
+func (s *Store) UpdateRechargeSchedule(ctx context.Context, id string, amount *int64, cron *string, interval *time.Duration, enabled *bool, expectedVersion *int64) (*RechargeSchedule, error) {
+	log := s.logger()
+	log.Info("UpdateRechargeSchedule: start",
+		slog.String("id", id),
+		slog.Any("amount", amount),
+		slog.Any("cron", cron),
+		slog.Any("interval", interval),
+		slog.Any("enabled", enabled),
+		slog.Any("expectedVersion", expectedVersion),
+	)
+	tx, err := s.conn(ctx).Begin(ctx)
+	if err != nil {
+		log.Error("UpdateRechargeSchedule: begin tx failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	sc, err := scanSchedule(tx.QueryRow(ctx, `SELECT `+scheduleColumns+` FROM public.coin_recharge_schedules WHERE id=$1 FOR UPDATE`, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("schedule %s not found", id)
+		}
+		return nil, err
+	}
+	if err := checkScheduleVersion(ctx, tx, sc, expectedVersion); err != nil {
+		return nil, err
+	}
+	reschedule := false
+	if amount != nil {
+		if *amount <= 0 {
+			return nil, errors.New("schedule: amount must be > 0")
+		}
+		sc.Amount = *amount
+	}
+	if cron != nil || interval != nil {
+		var c string
+		var d time.Duration
+		if cron != nil {
+			c = *cron
+		}
+		if interval != nil {
+			d = *interval
+		}
+		if err := sc.setTiming(c, d); err != nil {
+			return nil, err
+		}
+		reschedule = true
+	}
+	if enabled != nil {
+		reschedule = reschedule || (*enabled && !sc.Enabled)
+		sc.Enabled = *enabled
+	}
+	if reschedule {
+		sc.NextRunAt = time.Time{}
+		if sc.NextRunAt, err = sc.nextRun(time.Now().UTC()); err != nil {
+			return nil, err
+		}
+		if sc.NextRunAt.IsZero() {
+			return nil, fmt.Errorf("schedule: cron %q never fires", *sc.Cron)
+		}
+	}
+	sc, err = scanSchedule(tx.QueryRow(ctx, `
+		UPDATE public.coin_recharge_schedules
+		SET amount=$2, cron=$3, interval_seconds=$4, enabled=$5, next_run_at=$6, updated_at=NOW()
+		WHERE id=$1
+		RETURNING `+scheduleColumns,
+		id, sc.Amount, sc.Cron, sc.IntervalSeconds, sc.Enabled, sc.NextRunAt))
+	if err != nil {
+		log.Error("UpdateRechargeSchedule: update failed", slog.String("id", id), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Error("UpdateRechargeSchedule: commit failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	log.Info("UpdateRechargeSchedule: ok", slog.String("id", id), slog.Time("nextRunAt", sc.NextRunAt))
+	return sc, nil
+}
+
+// DeleteRechargeSchedule removes a schedule and reports whether it existed.
+func (s *Store) DeleteRechargeSchedule(ctx context.Context, id string, expectedVersion *int64) (bool, error) {
+	s.logger().Info("DeleteRechargeSchedule", slog.String("id", id), slog.Any("expectedVersion", expectedVersion))
+	tx, err := s.conn(ctx).Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	sc, err := scanSchedule(tx.QueryRow(ctx, `SELECT `+scheduleColumns+` FROM public.coin_recharge_schedules WHERE id=$1 FOR UPDATE`, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := checkScheduleVersion(ctx, tx, sc, expectedVersion); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM public.coin_recharge_schedules WHERE id=$1`, id); err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+// checkScheduleVersion checks a non-nil expectedVersion against the balance sc recharges.
+func checkScheduleVersion(ctx context.Context, tx pgx.Tx, sc *RechargeSchedule, expectedVersion *int64) error {
+	if expectedVersion == nil {
+		return nil
+	}
+	if _, err := lockAccount(ctx, tx, sc.AccountID, sc.CoinType, expectedVersion); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("schedule: account %s/%s not found", sc.AccountID, sc.CoinType)
+		}
+		return err
+	}
+	return nil
+}
+
+// GetRechargeSchedule returns a schedule, or nil if it doesn't exist.
+func (s *Store) GetRechargeSchedule(ctx context.Context, id string) (*RechargeSchedule, error) {
+	sc, err := scanSchedule(s.reader(ctx).QueryRow(ctx, `SELECT `+scheduleColumns+` FROM public.coin_recharge_schedules WHERE id=$1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return sc, err
+}
+
+// ListRechargeSchedules returns the schedules of an account (all if accountID is empty), soonest first.
+func (s *Store) ListRechargeSchedules(ctx context.Context, accountID string) ([]*RechargeSchedule, error) {
+	rows, err := s.reader(ctx).Query(ctx, `
+		SELECT `+scheduleColumns+` FROM public.coin_recharge_schedules
+		WHERE ($1 = '' OR account_id = $1)
+		ORDER BY next_run_at, id
+	`, accountID)
+	if err != nil {
+		s.logger().Error("ListRechargeSchedules: query failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+	out := []*RechargeSchedule{}
+	for rows.Next() {
+		sc, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sc)
+	}
+	return out, rows.Err()
+}
+
+// FireDueSchedules recharges up to batch due schedules and returns how many fired. Only the
+// replica holding the scheduler advisory lock fires; the others return 0. Each run recharges
+// with dataID "schedule:<id>:<unix run time>", so a run retried after a crash is applied once.
+func (s *Store) FireDueSchedules(ctx context.Context, batch int) (int, error) {
+	log := s.logger()
+	if batch <= 0 {
+		batch = 100
+	}
+	conn, err := s.Pool.Acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+	var locked bool
+	if err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, schedulerLockKey).Scan(&locked); err != nil {
+		return 0, fmt.Errorf("scheduler: acquire lock: %w", err)
+	}
+	if !locked {
+		log.Debug("scheduler: another replica holds the lock")
+		return 0, nil
+	}
+	defer func() {
+		_, _ = conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, schedulerLockKey)
+	}()
+
+	rows, err := conn.Query(ctx, `
+		SELECT `+scheduleColumns+` FROM public.coin_recharge_schedules
+		WHERE enabled AND next_run_at <= NOW()
+		ORDER BY next_run_at
+		LIMIT $1
+	`, batch)
+	if err != nil {
+		return 0, err
+	}
+	var due []*RechargeSchedule
+	for rows.Next() {
+		sc, err := scanSchedule(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		due = append(due, sc)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	fired := 0
+	for _, sc := range due {
+		dataID := fmt.Sprintf("schedule:%s:%d", sc.ID, sc.NextRunAt.Unix())
+		var lastErr *string
+		if _, err := s.Recharge(ctx, sc.AccountID, sc.CoinType, sc.Amount, nil, sc.UserID, dataID); err != nil {
+			if ctx.Err() != nil {
+				return fired, ctx.Err()
+			}
+			log.Warn("scheduler: recharge failed", slog.String("schedule", sc.ID), slog.String("accountID", sc.AccountID), slog.String("error", err.Error()))
+			msg := err.Error()
+			lastErr = &msg
+		} else {
+			fired++
+		}
+		enabled := true
+		next, err := sc.nextRun(time.Now().UTC())
+		if err != nil || next.IsZero() {
+			// nothing left to fire; keep the row for inspection
+			enabled, next = false, sc.NextRunAt
+		}
+		if _, err := conn.Exec(ctx, `
+			UPDATE public.coin_recharge_schedules
+			SET next_run_at=$3, enabled=$4, last_run_at=NOW(), last_error=$5, updated_at=NOW()
+			WHERE id=$1 AND next_run_at=$2
+		`, sc.ID, sc.NextRunAt, next, enabled, lastErr); err != nil {
+			log.Error("scheduler: advance failed", slog.String("schedule", sc.ID), slog.String("error", err.Error()))
+			return fired, err
+		}
+	}
+	if len(due) > 0 {
+		log.Info("scheduler: pass done", slog.Int("due", len(due)), slog.Int("fired", fired))
+	}
+	return fired, nil
+}
+
+// RunRechargeScheduler fires due schedules every interval (default 1m) until ctx is cancelled.
+func (s *Store) RunRechargeScheduler(ctx context.Context, interval time.Duration) {
+	log := s.logger()
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("recharge scheduler: stopped")
+			return
+		case <-t.C:
+			if _, err := s.FireDueSchedules(ctx, 0); err != nil && !errors.Is(err, context.Canceled) {
+				log.Error("recharge scheduler: pass failed", slog.String("error", err.Error()))
+			}
+		}
+	}
+}
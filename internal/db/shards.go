@@ -0,0 +1,247 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// --------------------------------------------
+// Hot-account sharding (sub-balances)
+// --------------------------------------------
+
+// MaxShards bounds the sub-balance rows of one sharded balance.
+const MaxShards = 64
+
+// shardedCoins is the balance of public.coins c: its own coins plus those of its shards.
+const shardedCoins = `(c.coins + COALESCE((SELECT SUM(sh.coins) FROM public.coin_shards sh
+			WHERE sh.account_id = c.id AND sh.coin_type = c.coin_type), 0))`
+
+// rechargedAt is the last recharge of public.coins c; credits to a sharded balance stamp the shard.
+const rechargedAt = `GREATEST(c.last_recharge_date, (SELECT MAX(sh.last_recharge_date) FROM public.coin_shards sh
+			WHERE sh.account_id = c.id AND sh.coin_type = c.coin_type))`
+
+// ShardAccount splits the coinType balance of id into n sub-balance rows, so that credits
+// to it (Recharge, BatchRecharge, the receiving side of Transfer) stop queueing on one row
+// lock: each credit only share-locks the balance and adds to a random shard. Debits still
+// lock the balance and draw from its own coins first, then from the shards. Credits to a
+// sharded balance don't bump its version. An n of 0 or 1 folds the shards back into the
+// balance. The balance and everything read from it stay the same either way.
+func (s *Store) ShardAccount(ctx context.Context, id, coinType string, n int) (*Account, error) {
+	log := s.logger()
+	start := time.Now()
+	coinType = coinTypeOrDefault(coinType)
+	log.Info("ShardAccount: start", slog.String("id", id), slog.String("coinType", coinType), slog.Int("shards", n))
+	if n < 0 || n > MaxShards {
+		return nil, fmt.Errorf("shard: shards must be between 0 and %d", MaxShards)
+	}
+	if n == 1 {
+		n = 0
+	}
+
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		log.Error("ShardAccount: begin tx failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	locked, err := lockAccount(ctx, tx, id, coinType, nil)
+	if err != nil {
+		log.Error("ShardAccount: select failed", slog.String("id", id), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if err := checkStatus(id, locked.status, false); err != nil {
+		return nil, fmt.Errorf("shard: %w", err)
+	}
+	if locked.shards != n {
+		// fold the shards that go away into the balance's own coins
+		if _, err := tx.Exec(ctx, `
+			WITH gone AS (
+				DELETE FROM public.coin_shards WHERE account_id=$1 AND coin_type=$2 AND shard >= $3
+				RETURNING coins, last_recharge_date
+			)
+			UPDATE public.coins
+			SET coins = coins + COALESCE((SELECT SUM(coins) FROM gone), 0),
+			    last_recharge_date = GREATEST(last_recharge_date, (SELECT MAX(last_recharge_date) FROM gone)),
+			    shards = $3,
+			    version = version + 1
+			WHERE id=$1 AND coin_type=$2
+		`, id, coinType, n); err != nil {
+			log.Error("ShardAccount: fold failed", slog.String("id", id), slog.String("error", err.Error()))
+			return nil, err
+		}
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO public.coin_shards (account_id, coin_type, shard)
+			SELECT $1, $2, generate_series(0, $3 - 1)
+			ON CONFLICT DO NOTHING
+		`, id, coinType, n); err != nil {
+			log.Error("ShardAccount: insert shards failed", slog.String("id", id), slog.String("error", err.Error()))
+			return nil, err
+		}
+		// ledger entries written while sharded don't carry an ordered balance_after, so take
+		// an exact snapshot now that no credit is in flight
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO public.coin_balance_snapshots (account_id, coin_type, taken_at, coins, ledger_id)
+			SELECT $1, $2, NOW(), $3, COALESCE(MAX(id), 0) FROM public.coin_ledger WHERE account_id=$1 AND coin_type=$2
+			ON CONFLICT DO NOTHING
+		`, id, coinType, locked.coins); err != nil {
+			log.Error("ShardAccount: snapshot failed", slog.String("id", id), slog.String("error", err.Error()))
+			return nil, err
+		}
+	}
+	acc, err := s.getAccount(ctx, tx, id, coinType)
+	if err != nil {
+		log.Error("ShardAccount: readback failed", slog.String("id", id), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Error("ShardAccount: commit failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	log.Info("ShardAccount: ok", slog.String("id", id), slog.Int("shards", n), slog.Duration("dur", time.Since(start)))
+	return acc, nil
+}
+
+// shardSum returns the coins held in the shards of a balance.
+func shardSum(ctx context.Context, q querier, id, coinType string) (int64, error) {
+	var sum int64
+	err := q.QueryRow(ctx, `
+		SELECT COALESCE(SUM(coins), 0)::bigint FROM public.coin_shards WHERE account_id=$1 AND coin_type=$2
+	`, id, coinType).Scan(&sum)
+	return sum, err
+}
+
+// shardTotals returns the coins held in shards per coin type of account id.
+func shardTotals(ctx context.Context, q querier, id string) (map[string]int64, error) {
+	rows, err := q.Query(ctx, `
+		SELECT coin_type, SUM(coins)::bigint FROM public.coin_shards WHERE account_id=$1 GROUP BY coin_type
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]int64{}
+	for rows.Next() {
+		var coinType string
+		var coins int64
+		if err := rows.Scan(&coinType, &coins); err != nil {
+			return nil, err
+		}
+		out[coinType] = coins
+	}
+	return out, rows.Err()
+}
+
+// lockForCredit locks a balance that is about to be credited. A sharded balance is only
+// share-locked, so credits to it run side by side while debits, which lock it exclusively,
+// wait; anything else is locked as by lockAccount.
+func lockForCredit(ctx context.Context, tx pgx.Tx, id, coinType string, expectedVersion *int64) (*lockedBalance, error) {
+	// unlocked peek: a balance sharded after it is credited under the exclusive lock, which is still exact
+	var shards int
+	if err := tx.QueryRow(ctx, `SELECT shards FROM public.coins WHERE id=$1 AND coin_type=$2`, id, coinType).Scan(&shards); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return nil, err
+		}
+		// archived balances are never sharded; lockAccount restores them
+		return lockAccount(ctx, tx, id, coinType, expectedVersion)
+	}
+	if shards == 0 {
+		return lockAccount(ctx, tx, id, coinType, expectedVersion)
+	}
+	var b lockedBalance
+	var version int64
+	if err := tx.QueryRow(ctx, `
+		SELECT coins, version, status, credit_limit, parent_id, pool_allowance, shards FROM public.coins WHERE id=$1 AND coin_type=$2 FOR SHARE
+	`, id, coinType).Scan(&b.coins, &version, &b.status, &b.creditLimit, &b.parentID, &b.poolAllowance, &b.shards); err != nil {
+		return nil, err
+	}
+	if b.shards == 0 {
+		// unsharded meanwhile; crediting the row itself would need the exclusive lock
+		return nil, fmt.Errorf("%s/%s: sharding changed: %w", id, coinType, ErrVersionConflict)
+	}
+	if expectedVersion != nil && *expectedVersion != version {
+		return nil, fmt.Errorf("%s/%s: expected version %d, have %d: %w", id, coinType, *expectedVersion, version, ErrVersionConflict)
+	}
+	sum, err := shardSum(ctx, tx, id, coinType)
+	if err != nil {
+		return nil, err
+	}
+	b.coins += sum
+	b.sharded = sum
+	return &b, nil
+}
+
+// creditShard adds amount to a random shard of a sharded balance and returns the balance as
+// tx sees it: its own credit plus those committed so far.
+func (s *Store) creditShard(ctx context.Context, tx pgx.Tx, id, coinType string, shards int, amount int64) (int64, error) {
+	if _, err := tx.Exec(ctx, `
+		UPDATE public.coin_shards SET coins = coins + $4, last_recharge_date = NOW()
+		WHERE account_id=$1 AND coin_type=$2 AND shard=$3
+	`, id, coinType, rand.IntN(shards), amount); err != nil {
+		s.logger().Error("creditShard: update failed", slog.String("id", id), slog.String("error", err.Error()))
+		return 0, err
+	}
+	var balance int64
+	if err := tx.QueryRow(ctx, `
+		SELECT `+shardedCoins+` FROM public.coins c WHERE c.id=$1 AND c.coin_type=$2
+	`, id, coinType).Scan(&balance); err != nil {
+		return 0, err
+	}
+	return balance, nil
+}
+
+// drawShards takes the part of a debit that the balance's own coins don't cover out of its
+// shards: from one random shard if it has enough, else from each in turn. It returns what is
+// left for the row itself to be debited by, which is more than its coins only on an
+// overdraft. Callers hold the exclusive row lock, so no credit changes the shards meanwhile.
+func (s *Store) drawShards(ctx context.Context, tx pgx.Tx, id, coinType string, locked *lockedBalance, amount int64) (int64, error) {
+	if locked.shards == 0 {
+		return amount, nil
+	}
+	own := locked.coins - locked.sharded
+	need := min(amount-max(own, 0), locked.sharded)
+	if need <= 0 {
+		return amount, nil
+	}
+	tag, err := tx.Exec(ctx, `
+		UPDATE public.coin_shards SET coins = coins - $4
+		WHERE account_id=$1 AND coin_type=$2 AND shard=$3 AND coins >= $4
+	`, id, coinType, rand.IntN(locked.shards), need)
+	if err != nil {
+		s.logger().Error("drawShards: failed", slog.String("id", id), slog.String("error", err.Error()))
+		return 0, err
+	}
+	if tag.RowsAffected() == 0 {
+		if _, err := tx.Exec(ctx, `
+			UPDATE public.coin_shards s
+			SET coins = s.coins - LEAST(s.coins, $3 - o.before)
+			FROM (
+				SELECT shard, SUM(coins) OVER (ORDER BY shard) - coins AS before
+				FROM public.coin_shards
+				WHERE account_id = $1 AND coin_type = $2 AND coins > 0
+			) o
+			WHERE s.account_id = $1 AND s.coin_type = $2 AND s.shard = o.shard AND o.before < $3
+		`, id, coinType, need); err != nil {
+			s.logger().Error("drawShards: fallback failed", slog.String("id", id), slog.String("error", err.Error()))
+			return 0, err
+		}
+	}
+	locked.sharded -= need
+	return amount - need, nil
+}
+
+// zeroShards empties the shards of a balance whose row is about to be set outright; an
+// empty coinType means every coin type of id.
+func zeroShards(ctx context.Context, tx pgx.Tx, id, coinType string) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE public.coin_shards SET coins = 0 WHERE account_id=$1 AND ($2 = '' OR coin_type=$2) AND coins <> 0
+	`, id, coinType)
+	return err
+}
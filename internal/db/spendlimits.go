@@ -0,0 +1,134 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// --------------------------------------------
+// Velocity / spend caps per rolling window
+// --------------------------------------------
+
+// ErrSpendLimitExceeded is returned when a debit would break a spend limit.
+var ErrSpendLimitExceeded = errors.New("spend limit exceeded")
+
+// debitKinds are the ledger kinds that count against spend limits.
+var debitKinds = []string{LedgerKindUse, LedgerKindTransferOut, LedgerKindCapture, LedgerKindPoolDraw}
+
+// ListSpendLimits returns the limits in effect for one coin type of an account: its own
+// limits plus the global defaults for windows it doesn't override. An empty accountID
+// lists the global defaults.
+func (s *Store) ListSpendLimits(ctx context.Context, accountID, coinType string) ([]*SpendLimit, error) {
+	return s.spendLimits(ctx, s.reader(ctx), accountID, coinTypeOrDefault(coinType))
+}
+
+func (s *Store) spendLimits(ctx context.Context, q querier, accountID, coinType string) ([]*SpendLimit, error) {
+	// account_id DESC puts an account's own row ahead of the '' default
+	rows, err := q.Query(ctx, `
+		SELECT DISTINCT ON (window_seconds) account_id, coin_type, window_seconds, max_amount, max_count
+		FROM public.coin_spend_limits
+		WHERE account_id IN ($1, '') AND coin_type=$2
+		ORDER BY window_seconds, account_id DESC
+	`, accountID, coinType)
+	if err != nil {
+		s.logger().Error("spendLimits: query failed", slog.String("accountID", accountID), slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []*SpendLimit{}
+	for rows.Next() {
+		var l SpendLimit
+		if err := rows.Scan(&l.AccountID, &l.CoinType, &l.WindowSeconds, &l.MaxAmount, &l.MaxCount); err != nil {
+			return nil, err
+		}
+		out = append(out, &l)
+	}
+	return out, rows.Err()
+}
+
+// SetSpendLimit caps the debits of one coin type of an account within a rolling window.
+// An empty accountID sets the global default. Nil maxAmount and maxCount mean no cap, which
+// lets an account opt out of a default. It returns the limits now in effect.
+func (s *Store) SetSpendLimit(ctx context.Context, accountID, coinType string, window time.Duration, maxAmount, maxCount *int64) ([]*SpendLimit, error) {
+	log := s.logger()
+	start := time.Now()
+	coinType = coinTypeOrDefault(coinType)
+	log.Info("SetSpendLimit: start",
+		slog.String("accountID", accountID),
+		slog.String("coinType", coinType),
+		slog.Duration("window", window),
+		slog.Any("maxAmount", maxAmount),
+		slog.Any("maxCount", maxCount),
+	)
+	if window < time.Second {
+		return nil, errors.New("setSpendLimit: window must be at least 1s")
+	}
+	if (maxAmount != nil && *maxAmount < 0) || (maxCount != nil && *maxCount < 0) {
+		return nil, errors.New("setSpendLimit: limits must be >= 0")
+	}
+	if _, err := s.conn(ctx).Exec(ctx, `
+		INSERT INTO public.coin_spend_limits (account_id, coin_type, window_seconds, max_amount, max_count)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (account_id, coin_type, window_seconds)
+		DO UPDATE SET max_amount = EXCLUDED.max_amount, max_count = EXCLUDED.max_count, updated_at = NOW()
+	`, accountID, coinType, int64(window/time.Second), maxAmount, maxCount); err != nil {
+		log.Error("SetSpendLimit: upsert failed", slog.String("accountID", accountID), slog.String("error", err.Error()))
+		return nil, err
+	}
+	log.Info("SetSpendLimit: ok", slog.String("accountID", accountID), slog.Duration("dur", time.Since(start)))
+	return s.spendLimits(ctx, s.conn(ctx), accountID, coinType)
+}
+
+// ClearSpendLimit removes an account's limit for a window (or the global default if
+// accountID is empty) and returns the limits now in effect.
+func (s *Store) ClearSpendLimit(ctx context.Context, accountID, coinType string, window time.Duration) ([]*SpendLimit, error) {
+	log := s.logger()
+	coinType = coinTypeOrDefault(coinType)
+	log.Info("ClearSpendLimit: start", slog.String("accountID", accountID), slog.String("coinType", coinType), slog.Duration("window", window))
+	if _, err := s.conn(ctx).Exec(ctx, `
+		DELETE FROM public.coin_spend_limits WHERE account_id=$1 AND coin_type=$2 AND window_seconds=$3
+	`, accountID, coinType, int64(window/time.Second)); err != nil {
+		log.Error("ClearSpendLimit: delete failed", slog.String("accountID", accountID), slog.String("error", err.Error()))
+		return nil, err
+	}
+	return s.spendLimits(ctx, s.conn(ctx), accountID, coinType)
+}
+
+// checkSpendLimits fails if debiting amount now would break a limit in effect for the account.
+// Callers hold the account row lock, so concurrent debits can't both pass the check.
+func (s *Store) checkSpendLimits(ctx context.Context, tx pgx.Tx, accountID, coinType string, amount int64) error {
+	limits, err := s.spendLimits(ctx, tx, accountID, coinType)
+	if err != nil {
+		return err
+	}
+	for _, l := range limits {
+		if l.MaxAmount == nil && l.MaxCount == nil {
+			continue
+		}
+		var spent, count int64
+		if err := tx.QueryRow(ctx, `
+			SELECT COALESCE(SUM(-delta), 0)::bigint, COUNT(*)
+			FROM public.coin_ledger
+			WHERE account_id=$1 AND coin_type=$2 AND kind = ANY($3)
+			  AND created_at > NOW() - make_interval(secs => $4)
+		`, accountID, coinType, debitKinds, float64(l.WindowSeconds)).Scan(&spent, &count); err != nil {
+			s.logger().Error("checkSpendLimits: query failed", slog.String("accountID", accountID), slog.String("error", err.Error()))
+			return err
+		}
+		window := time.Duration(l.WindowSeconds) * time.Second
+		if l.MaxAmount != nil && spent+amount > *l.MaxAmount {
+			return fmt.Errorf("%s: %d spent in %s, %d more would exceed %d: %w", accountID, spent, window, amount, *l.MaxAmount, ErrSpendLimitExceeded)
+		}
+		if l.MaxCount != nil && count+1 > *l.MaxCount {
+			return fmt.Errorf("%s: %d debits in %s, limit %d: %w", accountID, count, window, *l.MaxCount, ErrSpendLimitExceeded)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,319 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// --------------------------------------------
+// Shared wallets (parent / member accounts)
+// --------------------------------------------
+
+// hierarchyLockKey is the pg_advisory_xact_lock key held while relinking accounts, so two
+// concurrent SetParent calls can't close a cycle between them.
+const hierarchyLockKey int64 = 0x636f696e73 + 2
+
+// maxHierarchyDepth bounds the walks up and down the account tree.
+const maxHierarchyDepth = 32
+
+// HierarchyTotals rolls up one coin type over an account and every account below it.
+type HierarchyTotals struct {
+	RootID    string `json:"rootId"`
+	CoinType  string `json:"coinType"`
+	Accounts  int64  `json:"accounts"` // balances in the tree, root included
+	Depth     int64  `json:"depth"`    // levels below the root
+	Coins     int64  `json:"coins"`
+	Held      int64  `json:"held"`
+	Available int64  `json:"available"`
+}
+
+// SetParent makes id (every coin type) a member of the shared wallet parentID; an empty
+// parentID detaches it. A member's Use falls through to the parent's balance of the same
+// coin type once its own is exhausted. Only the direct parent is drawn from. A non-nil
+// expectedVersion is checked against id's default coin type balance.
+func (s *Store) SetParent(ctx context.Context, id, parentID string, expectedVersion *int64) ([]*Account, error) {
+	log := s.logger()
+	start := time.Now()
+	parentID = strings.TrimSpace(parentID)
+	log.Info("SetParent: start", slog.String("id", id), slog.String("parentID", parentID), slog.Any("expectedVersion", expectedVersion))
+	if parentID == id {
+		return nil, errors.New("setParent: an account can't be its own parent")
+	}
+
+	tx, err := s.conn(ctx).Begin(ctx)
+	if err != nil {
+		log.Error("SetParent: begin tx failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, hierarchyLockKey); err != nil {
+		return nil, fmt.Errorf("setParent: acquire lock: %w", err)
+	}
+	var status string
+	if err := tx.QueryRow(ctx, `SELECT status FROM public.coins WHERE id=$1 LIMIT 1`, id).Scan(&status); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("setParent: account %s not found", id)
+		}
+		return nil, err
+	}
+	if err := checkStatus(id, status, false); err != nil {
+		return nil, fmt.Errorf("setParent: %w", err)
+	}
+	if err := checkAccountVersion(ctx, tx, id, expectedVersion); err != nil {
+		return nil, err
+	}
+	var parent *string
+	if parentID != "" {
+		if err := tx.QueryRow(ctx, `SELECT status FROM public.coins WHERE id=$1 LIMIT 1`, parentID).Scan(&status); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, fmt.Errorf("setParent: parent %s not found", parentID)
+			}
+			return nil, err
+		}
+		if err := checkStatus(parentID, status, false); err != nil {
+			return nil, fmt.Errorf("setParent: %w", err)
+		}
+		// walk up from the new parent; meeting id would close a cycle
+		var cycle bool
+		if err := tx.QueryRow(ctx, `
+			WITH RECURSIVE up(id, depth) AS (
+				SELECT $2::text, 0
+				UNION
+				SELECT c.parent_id, up.depth + 1 FROM public.coins c JOIN up ON c.id = up.id
+				WHERE c.parent_id IS NOT NULL AND up.depth < $3
+			)
+			SELECT EXISTS (SELECT 1 FROM up WHERE id = $1)
+		`, id, parentID, maxHierarchyDepth).Scan(&cycle); err != nil {
+			log.Error("SetParent: cycle check failed", slog.String("id", id), slog.String("error", err.Error()))
+			return nil, err
+		}
+		if cycle {
+			return nil, fmt.Errorf("setParent: %s is below %s already", parentID, id)
+		}
+		parent = &parentID
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE public.coins SET parent_id=$2, version = version + 1 WHERE id=$1 AND parent_id IS DISTINCT FROM $2
+	`, id, parent); err != nil {
+		log.Error("SetParent: update failed", slog.String("id", id), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Error("SetParent: commit failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	log.Info("SetParent: ok", slog.String("id", id), slog.String("parentID", parentID), slog.Duration("dur", time.Since(start)))
+	return s.listBalances(ctx, s.conn(ctx), id)
+}
+
+// SetPoolAllowance caps how many more coins of one type a member may draw from its parent.
+// A nil allowance lifts the cap. Each draw lowers the allowance by the amount drawn.
+func (s *Store) SetPoolAllowance(ctx context.Context, id, coinType string, allowance, expectedVersion *int64) (*Account, error) {
+	log := s.logger()
+	start := time.Now()
+	coinType = coinTypeOrDefault(coinType)
+	log.Info("SetPoolAllowance: start",
+		slog.String("id", id),
+		slog.String("coinType", coinType),
+		slog.Any("allowance", allowance),
+		slog.Any("expectedVersion", expectedVersion),
+	)
+	if allowance != nil && *allowance < 0 {
+		return nil, errors.New("setPoolAllowance: allowance must be >= 0")
+	}
+
+	tx, err := s.conn(ctx).Begin(ctx)
+	if err != nil {
+		log.Error("SetPoolAllowance: begin tx failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	locked, err := lockAccount(ctx, tx, id, coinType, expectedVersion)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("setPoolAllowance: account %s/%s not found", id, coinType)
+		}
+		log.Error("SetPoolAllowance: select failed", slog.String("id", id), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if err := checkStatus(id, locked.status, false); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE public.coins SET pool_allowance=$3, version = version + 1 WHERE id=$1 AND coin_type=$2
+	`, id, coinType, allowance); err != nil {
+		log.Error("SetPoolAllowance: update failed", slog.String("id", id), slog.String("error", err.Error()))
+		return nil, err
+	}
+	acc, err := s.getAccount(ctx, tx, id, coinType)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Error("SetPoolAllowance: commit failed", slog.String("error", err.Error()))
+		return nil, err
+	}
+	log.Info("SetPoolAllowance: ok", slog.String("id", id), slog.Any("allowance", allowance), slog.Duration("dur", time.Since(start)))
+	return acc, nil
+}
+
+// drawFromPool moves up to need coins from a member's parent into the member, capped by the
+// member's pool allowance and by what the parent holds unreserved (its credit limit is not
+// shared). It returns the amount moved and adds it to member.coins. The parent's side is
+// recorded as "pool_draw", counts against its spend limits and is notified with
+// dataID + ":pool". Callers hold the member's row lock; the parent row is locked here.
+func (s *Store) drawFromPool(ctx context.Context, tx pgx.Tx, memberID, coinType string, member *lockedBalance, need int64, userID, dataID string) (int64, error) {
+	if member.parentID == nil || need <= 0 {
+		return 0, nil
+	}
+	if member.poolAllowance != nil {
+		need = min(need, *member.poolAllowance)
+	}
+	if need <= 0 {
+		return 0, nil
+	}
+	parentID := *member.parentID
+	parent, err := lockAccount(ctx, tx, parentID, coinType, nil)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// the parent doesn't hold this coin type
+		return 0, nil
+	}
+	if err != nil {
+		s.logger().Error("drawFromPool: lock parent failed", slog.String("parentID", parentID), slog.String("error", err.Error()))
+		return 0, err
+	}
+	if parent.status != StatusActive {
+		return 0, nil
+	}
+	held, err := heldAmount(ctx, tx, parentID, coinType)
+	if err != nil {
+		return 0, err
+	}
+	draw := min(need, parent.coins-held)
+	if draw <= 0 {
+		return 0, nil
+	}
+	if err := s.checkSpendLimits(ctx, tx, parentID, coinType, draw); err != nil {
+		return 0, fmt.Errorf("shared wallet %s: %w", parentID, err)
+	}
+	rest, err := s.drawShards(ctx, tx, parentID, coinType, parent, draw)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE public.coins
+		SET coins = coins - $3,
+		    version = version + 1,
+		    last_usage_date = NOW()
+		WHERE id=$1 AND coin_type=$2
+	`, parentID, coinType, rest); err != nil {
+		s.logger().Error("drawFromPool: debit parent failed", slog.String("parentID", parentID), slog.String("error", err.Error()))
+		return 0, err
+	}
+	if err := s.consumeLots(ctx, tx, parentID, coinType, draw); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE public.coins
+		SET coins = coins + $3,
+		    pool_allowance = pool_allowance - $3
+		WHERE id=$1 AND coin_type=$2
+	`, memberID, coinType, draw); err != nil {
+		s.logger().Error("drawFromPool: credit member failed", slog.String("memberID", memberID), slog.String("error", err.Error()))
+		return 0, err
+	}
+	poolDataID := dataID + ":pool"
+	if err := s.insertLedger(ctx, tx, parentID, coinType, -draw, parent.coins-draw, userID, poolDataID, LedgerKindPoolDraw); err != nil {
+		return 0, err
+	}
+	if err := s.insertLedger(ctx, tx, memberID, coinType, draw, member.coins+draw, userID, poolDataID, LedgerKindPoolCredit); err != nil {
+		return 0, err
+	}
+	if err := s.notify(ctx, tx, userID, parentID, coinType, poolDataID, float64(draw), time.Now().UTC(), time.Time{}); err != nil {
+		return 0, err
+	}
+	member.coins += draw
+	if member.poolAllowance != nil {
+		*member.poolAllowance -= draw
+	}
+	return draw, nil
+}
+
+// ListMembers returns the coinType balances of the accounts directly below parentID, ordered by id.
+func (s *Store) ListMembers(ctx context.Context, parentID, coinType string) ([]*Account, error) {
+	log := s.logger()
+	start := time.Now()
+	coinType = coinTypeOrDefault(coinType)
+	log.Debug("ListMembers: query", slog.String("parentID", parentID), slog.String("coinType", coinType))
+	rows, err := s.reader(ctx).Query(ctx, `
+		SELECT `+accountColumns+`
+		FROM public.coins c
+		WHERE c.parent_id=$1 AND c.coin_type=$2
+		ORDER BY c.id
+	`, parentID, coinType)
+	if err != nil {
+		log.Error("ListMembers: query failed", slog.String("parentID", parentID), slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []*Account{}
+	for rows.Next() {
+		a, err := scanAccount(rows)
+		if err != nil {
+			log.Error("ListMembers: scan failed", slog.String("error", err.Error()))
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error("ListMembers: rows err", slog.String("error", err.Error()))
+		return nil, err
+	}
+	log.Debug("ListMembers: ok", slog.Int("count", len(out)), slog.Duration("dur", time.Since(start)))
+	return out, nil
+}
+
+// GetHierarchyTotals sums one coin type over rootID and every account below it, at any depth.
+// Accounts below the root that don't hold coinType are walked through but not counted.
+// It returns nil if rootID holds no coinType balance and has no members that do.
+func (s *Store) GetHierarchyTotals(ctx context.Context, rootID, coinType string) (*HierarchyTotals, error) {
+	log := s.logger()
+	start := time.Now()
+	coinType = coinTypeOrDefault(coinType)
+	log.Debug("GetHierarchyTotals: query", slog.String("rootID", rootID), slog.String("coinType", coinType))
+	t := HierarchyTotals{RootID: rootID, CoinType: coinType}
+	if err := s.reader(ctx).QueryRow(ctx, `
+		WITH RECURSIVE tree(id, depth) AS (
+			SELECT $1::text, 0
+			UNION
+			SELECT c.id, tree.depth + 1 FROM public.coins c JOIN tree ON c.parent_id = tree.id
+			WHERE tree.depth < $3
+		)
+		SELECT COUNT(*), COALESCE(MAX(tree.depth), 0), COALESCE(SUM(`+shardedCoins+`), 0)::bigint, COALESCE(SUM(h.held), 0)::bigint
+		FROM tree
+		JOIN public.coins c ON c.id = tree.id AND c.coin_type = $2
+		CROSS JOIN LATERAL (
+			SELECT COALESCE(SUM(amount), 0) AS held FROM public.coin_holds
+			WHERE account_id = c.id AND coin_type = c.coin_type AND status = 'active' AND expires_at > NOW()
+		) h
+	`, rootID, coinType, maxHierarchyDepth).Scan(&t.Accounts, &t.Depth, &t.Coins, &t.Held); err != nil {
+		log.Error("GetHierarchyTotals: query failed", slog.String("rootID", rootID), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if t.Accounts == 0 {
+		return nil, nil
+	}
+	t.Available = t.Coins - t.Held
+	log.Debug("GetHierarchyTotals: ok", slog.String("rootID", rootID), slog.Int64("accounts", t.Accounts), slog.Duration("dur", time.Since(start)))
+	return &t, nil
+}
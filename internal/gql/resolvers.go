@@ -2,24 +2,34 @@ package gql
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
 
 	dbpkg "github.com/devifyX/go-back-coin-service/internal/db"
+	mw "github.com/devifyX/go-back-coin-service/internal/middleware"
 )
 
 // Resolvers holds dependencies used by GraphQL resolvers.
 type Resolvers struct {
-	Store *dbpkg.Store
+	Store dbpkg.AccountStore
 
 	// Optional: default timeouts per op
 	QueryTimeout    time.Duration
 	MutationTimeout time.Duration
+
+	// RequireVerifiedCaller refuses admin mutations and the audit log to requests without a
+	// signed X-Caller-Id. Off, they run and are audited with whatever caller was claimed.
+	RequireVerifiedCaller bool
 }
 
-func NewResolvers(store *dbpkg.Store) *Resolvers {
+func NewResolvers(store dbpkg.AccountStore) *Resolvers {
 	return &Resolvers{
 		Store:           store,
 		QueryTimeout:    10 * time.Second,
@@ -32,7 +42,12 @@ func (r *Resolvers) qctx(p graphql.ResolveParams) (context.Context, context.Canc
 	if timeout <= 0 {
 		timeout = 10 * time.Second
 	}
-	return context.WithTimeout(p.Context, timeout)
+	ctx := p.Context
+	// fields selected under a mutation read back what it wrote, so not from a lagging replica
+	if op, ok := p.Info.Operation.(*ast.OperationDefinition); ok && op.Operation == ast.OperationTypeMutation {
+		ctx = dbpkg.WithPrimary(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
 }
 
 func (r *Resolvers) mctx(p graphql.ResolveParams) (context.Context, context.CancelFunc) {
@@ -43,6 +58,208 @@ func (r *Resolvers) mctx(p graphql.ResolveParams) (context.Context, context.Canc
 	return context.WithTimeout(p.Context, timeout)
 }
 
+// int64Arg parses an optional numeric ID argument (e.g. a pagination cursor); missing means 0.
+func int64Arg(args map[string]any, name string) (int64, error) {
+	v, ok := args[name].(string)
+	if !ok || v == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return n, nil
+}
+
+// int64PtrArg reads an optional Int argument; missing means nil.
+func int64PtrArg(args map[string]any, name string) *int64 {
+	v, ok := args[name].(int)
+	if !ok {
+		return nil
+	}
+	n := int64(v)
+	return &n
+}
+
+// codedError carries a machine-readable code in the GraphQL error's extensions.
+type codedError struct {
+	err  error
+	code string
+}
+
+func (e *codedError) Error() string { return e.err.Error() }
+func (e *codedError) Unwrap() error { return e.err }
+func (e *codedError) Extensions() map[string]any {
+	return map[string]any{"code": e.code}
+}
+
+// withErrorCodes tags store errors that clients are expected to handle, such as
+// a stale expectedVersion, with an error code.
+func withErrorCodes(fn graphql.FieldResolveFn) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		res, err := fn(p)
+		switch {
+		case errors.Is(err, dbpkg.ErrVersionConflict):
+			return nil, &codedError{err: err, code: "VERSION_CONFLICT"}
+		case errors.Is(err, dbpkg.ErrAccountFrozen):
+			return nil, &codedError{err: err, code: "ACCOUNT_FROZEN"}
+		case errors.Is(err, dbpkg.ErrAccountClosed):
+			return nil, &codedError{err: err, code: "ACCOUNT_CLOSED"}
+		case errors.Is(err, dbpkg.ErrSpendLimitExceeded):
+			return nil, &codedError{err: err, code: "SPEND_LIMIT_EXCEEDED"}
+		case errors.Is(err, dbpkg.ErrReversalExceedsOriginal):
+			return nil, &codedError{err: err, code: "REVERSAL_EXCEEDS_ORIGINAL"}
+		case errors.Is(err, dbpkg.ErrAccountNotClosed):
+			return nil, &codedError{err: err, code: "ACCOUNT_NOT_CLOSED"}
+		}
+		return res, err
+	}
+}
+
+// stateFn reads the state an admin mutation changes, for its audit entry.
+type stateFn func(ctx context.Context, p graphql.ResolveParams) (any, error)
+
+// audited guards an admin mutation and records every call of it in the audit trail: the
+// caller, the arguments, the state before and after, and the outcome; see
+// AccountStore.RunAudited. A nil state records the arguments and outcome only. The caller
+// is the X-Caller-Id of the request, else its userId argument, and is marked verified only
+// if the header was signed; see adminOnly.
+func (r *Resolvers) audited(op string, state stateFn, fn graphql.FieldResolveFn) graphql.FieldResolveFn {
+	return r.adminOnly(op, func(p graphql.ResolveParams) (any, error) {
+		c := mw.CallerFrom(p.Context)
+		if c.ID == "" {
+			c.ID, _ = p.Args["userId"].(string)
+		}
+		ctx, cancel := r.mctx(p)
+		defer cancel()
+		ctx = dbpkg.WithPrimary(ctx)
+
+		args, err := json.Marshal(p.Args)
+		if err != nil {
+			return nil, fmt.Errorf("audit: %w", err)
+		}
+		var read func(context.Context) (any, error)
+		if state != nil {
+			read = func(ctx context.Context) (any, error) { return state(ctx, p) }
+		}
+		return r.Store.RunAudited(ctx, &dbpkg.AuditEntry{Operation: op, Caller: c.ID, Verified: c.Verified, SourceIP: c.IP, Args: args}, read,
+			func(ctx context.Context) (any, error) {
+				p := p
+				p.Context = ctx
+				return fn(p)
+			})
+	})
+}
+
+// adminOnly refuses calls of an admin field from requests without a signed X-Caller-Id
+// if RequireVerifiedCaller is set.
+func (r *Resolvers) adminOnly(op string, fn graphql.FieldResolveFn) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		if r.RequireVerifiedCaller && !mw.CallerFrom(p.Context).Verified {
+			return nil, &codedError{err: fmt.Errorf("%s: a signed %s is required", op, mw.CallerHeader), code: "UNAUTHENTICATED"}
+		}
+		return fn(p)
+	}
+}
+
+// accountState is the audited state of setCoins, setCreditLimit and setPoolAllowance: the
+// balance they change.
+func (r *Resolvers) accountState(ctx context.Context, p graphql.ResolveParams) (any, error) {
+	id, _ := p.Args["id"].(string)
+	coinType, _ := p.Args["coinType"].(string)
+	return r.Store.GetAccount(ctx, id, coinType)
+}
+
+// balancesState is the audited state of deleteUser, setParent and the lifecycle mutations:
+// every balance of the account.
+func (r *Resolvers) balancesState(ctx context.Context, p graphql.ResolveParams) (any, error) {
+	id, _ := p.Args["id"].(string)
+	return r.Store.ListBalances(ctx, id)
+}
+
+// spendLimitsState is the audited state of setSpendLimit and clearSpendLimit: the spend
+// limits of the account and coin type.
+func (r *Resolvers) spendLimitsState(ctx context.Context, p graphql.ResolveParams) (any, error) {
+	id, _ := p.Args["id"].(string)
+	coinType, _ := p.Args["coinType"].(string)
+	return r.Store.ListSpendLimits(ctx, id, coinType)
+}
+
+// batchState is the audited state of batchRecharge: the balances of its ids and items.
+func (r *Resolvers) batchState(ctx context.Context, p graphql.ResolveParams) (any, error) {
+	var ids []string
+	if raw, ok := p.Args["ids"].([]any); ok {
+		for _, v := range raw {
+			id, _ := v.(string)
+			ids = append(ids, id)
+		}
+	}
+	if raw, ok := p.Args["items"].([]any); ok {
+		for _, v := range raw {
+			it, _ := v.(map[string]any)
+			id, _ := it["id"].(string)
+			ids = append(ids, id)
+		}
+	}
+	coinType, _ := p.Args["coinType"].(string)
+	return r.Store.GetAccounts(ctx, ids, coinType)
+}
+
+// pageArgs reads the Relay first/after arguments.
+func pageArgs(args map[string]any) dbpkg.PageArgs {
+	first, _ := args["first"].(int)
+	after, _ := args["after"].(string)
+	return dbpkg.PageArgs{First: first, After: after}
+}
+
+// connection shapes an account page as a Relay connection. totalCount is resolved by
+// ConnectionTotalCount from the "page" key, only when selected.
+func connection(page *dbpkg.AccountPage, err error) (any, error) {
+	if err != nil {
+		return nil, err
+	}
+	edges := make([]map[string]any, 0, len(page.Accounts))
+	for i, a := range page.Accounts {
+		edges = append(edges, map[string]any{"node": a, "cursor": page.Cursors[i]})
+	}
+	info := map[string]any{"hasNextPage": page.HasNextPage, "hasPreviousPage": page.HasPreviousPage}
+	if n := len(page.Cursors); n > 0 {
+		info["startCursor"], info["endCursor"] = page.Cursors[0], page.Cursors[n-1]
+	}
+	return map[string]any{"edges": edges, "pageInfo": info, "page": page}, nil
+}
+
+// ConnectionTotalCount resolves AccountConnection.totalCount.
+func (r *Resolvers) ConnectionTotalCount() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		src, _ := p.Source.(map[string]any)
+		page, ok := src["page"].(*dbpkg.AccountPage)
+		if !ok {
+			return nil, nil
+		}
+		ctx, cancel := r.qctx(p)
+		defer cancel()
+		n, err := page.TotalCount(ctx)
+		return int(n), err
+	}
+}
+
+// auditConnection shapes an audit log page as a Relay connection.
+func auditConnection(page *dbpkg.AuditPage, err error) (any, error) {
+	if err != nil {
+		return nil, err
+	}
+	edges := make([]map[string]any, 0, len(page.Entries))
+	for i, e := range page.Entries {
+		edges = append(edges, map[string]any{"node": e, "cursor": page.Cursors[i]})
+	}
+	info := map[string]any{"hasNextPage": page.HasNextPage, "hasPreviousPage": page.HasPreviousPage}
+	if n := len(page.Cursors); n > 0 {
+		info["startCursor"], info["endCursor"] = page.Cursors[0], page.Cursors[n-1]
+	}
+	return map[string]any{"edges": edges, "pageInfo": info}, nil
+}
+
 // -------- Query resolvers --------
 
 func (r *Resolvers) GetUser() graphql.FieldResolveFn {
@@ -50,7 +267,20 @@ func (r *Resolvers) GetUser() graphql.FieldResolveFn {
 		ctx, cancel := r.qctx(p)
 		defer cancel()
 		id := p.Args["id"].(string)
-		return r.Store.GetAccount(ctx, id)
+		coinType, _ := p.Args["coinType"].(string)
+		return r.Store.GetAccount(ctx, id, coinType)
+	}
+}
+
+// GetUserAsOf(id: ID!, at: DateTime!, coinType: String)
+func (r *Resolvers) GetUserAsOf() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.qctx(p)
+		defer cancel()
+		id := p.Args["id"].(string)
+		at := p.Args["at"].(time.Time)
+		coinType, _ := p.Args["coinType"].(string)
+		return r.Store.GetAccountAsOf(ctx, id, coinType, at)
 	}
 }
 
@@ -58,9 +288,16 @@ func (r *Resolvers) ListUsers() graphql.FieldResolveFn {
 	return func(p graphql.ResolveParams) (any, error) {
 		ctx, cancel := r.qctx(p)
 		defer cancel()
-		limit, _ := p.Args["limit"].(int)
-		offset, _ := p.Args["offset"].(int)
-		return r.Store.ListAccounts(ctx, limit, offset)
+		f := dbpkg.AccountFilter{}
+		f.Status, _ = p.Args["status"].(string)
+		if sel, ok := p.Args["labelSelector"].(string); ok {
+			labels, err := dbpkg.ParseLabelSelector(sel)
+			if err != nil {
+				return nil, err
+			}
+			f.Labels = labels
+		}
+		return connection(r.Store.ListAccounts(ctx, pageArgs(p.Args), f))
 	}
 }
 
@@ -69,7 +306,8 @@ func (r *Resolvers) GetBalance() graphql.FieldResolveFn {
 		ctx, cancel := r.qctx(p)
 		defer cancel()
 		id := p.Args["id"].(string)
-		acct, err := r.Store.GetAccount(ctx, id)
+		coinType, _ := p.Args["coinType"].(string)
+		acct, err := r.Store.GetAccount(ctx, id, coinType)
 		if err != nil || acct == nil {
 			return nil, err
 		}
@@ -90,7 +328,7 @@ func (r *Resolvers) GetUsersByCoinsRange() graphql.FieldResolveFn {
 			vv := int64(v)
 			maxPtr = &vv
 		}
-		return r.Store.ListAccountsByCoinsRange(ctx, minPtr, maxPtr)
+		return connection(r.Store.ListAccountsByCoinsRange(ctx, minPtr, maxPtr, pageArgs(p.Args)))
 	}
 }
 
@@ -99,7 +337,7 @@ func (r *Resolvers) GetRecentRecharges() graphql.FieldResolveFn {
 		ctx, cancel := r.qctx(p)
 		defer cancel()
 		since := p.Args["since"].(time.Time)
-		return r.Store.ListRecentRecharges(ctx, since)
+		return connection(r.Store.ListRecentRecharges(ctx, since, pageArgs(p.Args)))
 	}
 }
 
@@ -108,7 +346,7 @@ func (r *Resolvers) GetInactiveSince() graphql.FieldResolveFn {
 		ctx, cancel := r.qctx(p)
 		defer cancel()
 		before := p.Args["before"].(time.Time)
-		return r.Store.ListInactiveSince(ctx, before)
+		return connection(r.Store.ListInactiveSince(ctx, before, pageArgs(p.Args)))
 	}
 }
 
@@ -125,7 +363,84 @@ func (r *Resolvers) TotalCoins() graphql.FieldResolveFn {
 	return func(p graphql.ResolveParams) (any, error) {
 		ctx, cancel := r.qctx(p)
 		defer cancel()
-		s, err := r.Store.SumCoins(ctx)
+		coinType, _ := p.Args["coinType"].(string)
+		s, err := r.Store.SumCoins(ctx, coinType)
+		return int(s), err
+	}
+}
+
+// AccountLabels lists the labels of an account as key/value pairs sorted by key.
+func (r *Resolvers) AccountLabels() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		acct, ok := p.Source.(*dbpkg.Account)
+		if !ok || acct == nil {
+			return nil, nil
+		}
+		keys := make([]string, 0, len(acct.Labels))
+		for k := range acct.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		out := make([]map[string]any, 0, len(keys))
+		for _, k := range keys {
+			out = append(out, map[string]any{"key": k, "value": acct.Labels[k]})
+		}
+		return out, nil
+	}
+}
+
+func (r *Resolvers) AccountSpendLimits() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		acct, ok := p.Source.(*dbpkg.Account)
+		if !ok || acct == nil {
+			return nil, nil
+		}
+		ctx, cancel := r.qctx(p)
+		defer cancel()
+		return r.Store.ListSpendLimits(ctx, acct.ID, acct.CoinType)
+	}
+}
+
+// SpendLimits(id: ID, coinType: String)
+func (r *Resolvers) SpendLimits() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.qctx(p)
+		defer cancel()
+		id, _ := p.Args["id"].(string)
+		coinType, _ := p.Args["coinType"].(string)
+		return r.Store.ListSpendLimits(ctx, id, coinType)
+	}
+}
+
+// OverdrawnAccounts(coinType: String)
+func (r *Resolvers) OverdrawnAccounts() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.qctx(p)
+		defer cancel()
+		coinType, _ := p.Args["coinType"].(string)
+		return r.Store.ListOverdrawnAccounts(ctx, coinType)
+	}
+}
+
+// HierarchyTotals(id: ID!, coinType: String)
+func (r *Resolvers) HierarchyTotals() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.qctx(p)
+		defer cancel()
+		id := p.Args["id"].(string)
+		coinType, _ := p.Args["coinType"].(string)
+		return r.Store.GetHierarchyTotals(ctx, id, coinType)
+	}
+}
+
+// TotalCoinsAsOf(at: DateTime!, coinType: String)
+func (r *Resolvers) TotalCoinsAsOf() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.qctx(p)
+		defer cancel()
+		at := p.Args["at"].(time.Time)
+		coinType, _ := p.Args["coinType"].(string)
+		s, err := r.Store.SumCoinsAsOf(ctx, coinType, at)
 		return int(s), err
 	}
 }
@@ -139,6 +454,138 @@ func (r *Resolvers) ExistsUser() graphql.FieldResolveFn {
 	}
 }
 
+func (r *Resolvers) GetHold() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.qctx(p)
+		defer cancel()
+		id := p.Args["id"].(string)
+		return r.Store.GetHold(ctx, id)
+	}
+}
+
+func (r *Resolvers) OutboxEntries() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.qctx(p)
+		defer cancel()
+		status, _ := p.Args["status"].(string)
+		first, _ := p.Args["first"].(int)
+		after, err := int64Arg(p.Args, "after")
+		if err != nil {
+			return nil, err
+		}
+		entries, err := r.Store.ListOutbox(ctx, status, first, after)
+		if err != nil {
+			return nil, err
+		}
+		if entries == nil {
+			entries = []*dbpkg.OutboxEntry{}
+		}
+		return entries, nil
+	}
+}
+
+// AuditLog(filter: AuditFilterInput, first: Int, after: String)
+func (r *Resolvers) AuditLog() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.qctx(p)
+		defer cancel()
+		var f dbpkg.AuditFilter
+		if in, ok := p.Args["filter"].(map[string]any); ok {
+			f.Operation, _ = in["operation"].(string)
+			f.Caller, _ = in["caller"].(string)
+			f.Outcome, _ = in["outcome"].(string)
+			if t, ok := in["since"].(time.Time); ok {
+				f.Since = &t
+			}
+			if t, ok := in["until"].(time.Time); ok {
+				f.Until = &t
+			}
+		}
+		return auditConnection(r.Store.ListAuditLog(ctx, f, pageArgs(p.Args)))
+	}
+}
+
+// -------- Field resolvers --------
+
+// AccountLedger resolves Account.ledger(first: Int, after: ID).
+func (r *Resolvers) AccountLedger() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		acct, ok := p.Source.(*dbpkg.Account)
+		if !ok || acct == nil {
+			return nil, nil
+		}
+		ctx, cancel := r.qctx(p)
+		defer cancel()
+		first, _ := p.Args["first"].(int)
+		after, err := int64Arg(p.Args, "after")
+		if err != nil {
+			return nil, err
+		}
+		entries, err := r.Store.ListLedger(ctx, acct.ID, acct.CoinType, first, after)
+		if err != nil {
+			return nil, err
+		}
+		if entries == nil {
+			entries = []*dbpkg.LedgerEntry{}
+		}
+		return entries, nil
+	}
+}
+
+func (r *Resolvers) AccountExpirations() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		acct, ok := p.Source.(*dbpkg.Account)
+		if !ok || acct == nil {
+			return nil, nil
+		}
+		ctx, cancel := r.qctx(p)
+		defer cancel()
+		var before *time.Time
+		if v, ok := p.Args["before"].(time.Time); ok {
+			before = &v
+		}
+		lots, err := r.Store.ListUpcomingExpirations(ctx, acct.ID, acct.CoinType, before)
+		if err != nil {
+			return nil, err
+		}
+		if lots == nil {
+			lots = []*dbpkg.CoinLot{}
+		}
+		return lots, nil
+	}
+}
+
+func (r *Resolvers) AccountBalances() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		acct, ok := p.Source.(*dbpkg.Account)
+		if !ok || acct == nil {
+			return nil, nil
+		}
+		ctx, cancel := r.qctx(p)
+		defer cancel()
+		balances, err := r.Store.ListBalances(ctx, acct.ID)
+		if err != nil {
+			return nil, err
+		}
+		if balances == nil {
+			balances = []*dbpkg.Account{}
+		}
+		return balances, nil
+	}
+}
+
+func (r *Resolvers) AccountMembers() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		acct, ok := p.Source.(*dbpkg.Account)
+		if !ok || acct == nil {
+			return nil, nil
+		}
+		ctx, cancel := r.qctx(p)
+		defer cancel()
+		return r.Store.ListMembers(ctx, acct.ID, acct.CoinType)
+	}
+}
+
 // -------- Mutation resolvers --------
 
 func (r *Resolvers) CreateUser() graphql.FieldResolveFn {
@@ -151,11 +598,61 @@ func (r *Resolvers) CreateUser() graphql.FieldResolveFn {
 			vv := int64(v)
 			coinsPtr = &vv
 		}
-		return r.Store.CreateAccount(ctx, id, coinsPtr)
+		coinType, _ := p.Args["coinType"].(string)
+		metadata, err := metadataArg(p.Args)
+		if err != nil {
+			return nil, err
+		}
+		return r.Store.CreateAccount(ctx, id, coinType, coinsPtr, metadata, labelsArg(p.Args))
+	}
+}
+
+// UpdateUserMetadata(id: ID!, metadata: JSON, labels: [LabelInput!], merge: Boolean, expectedVersion: Int)
+func (r *Resolvers) UpdateUserMetadata() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.mctx(p)
+		defer cancel()
+		id := p.Args["id"].(string)
+		merge, _ := p.Args["merge"].(bool)
+		metadata, err := metadataArg(p.Args)
+		if err != nil {
+			return nil, err
+		}
+		return r.Store.UpdateAccountMetadata(ctx, id, metadata, labelsArg(p.Args), merge, int64PtrArg(p.Args, "expectedVersion"))
+	}
+}
+
+// metadataArg reads the optional metadata argument, which must be a JSON object.
+func metadataArg(args map[string]any) (map[string]any, error) {
+	v, ok := args["metadata"]
+	if !ok || v == nil {
+		return nil, nil
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, errors.New("metadata must be a JSON object")
+	}
+	return m, nil
+}
+
+// labelsArg turns the optional [LabelInput!] argument into a map; nil if omitted.
+func labelsArg(args map[string]any) map[string]string {
+	list, ok := args["labels"].([]any)
+	if !ok {
+		return nil
+	}
+	out := make(map[string]string, len(list))
+	for _, item := range list {
+		if l, ok := item.(map[string]any); ok {
+			k, _ := l["key"].(string)
+			v, _ := l["value"].(string)
+			out[k] = v
+		}
 	}
+	return out
 }
 
-// RechargeCoins(id: ID!, amount: Int!, userId: ID!, dataId: String)
+// RechargeCoins(id: ID!, amount: Int!, userId: ID!, dataId: String, expiresAt: DateTime, coinType: String, expectedVersion: Int)
 func (r *Resolvers) RechargeCoins() graphql.FieldResolveFn {
 	return func(p graphql.ResolveParams) (any, error) {
 		ctx, cancel := r.mctx(p)
@@ -172,23 +669,42 @@ func (r *Resolvers) RechargeCoins() graphql.FieldResolveFn {
 		if v, ok := p.Args["dataId"].(string); ok {
 			dataID = v
 		}
+		var expiresAt *time.Time
+		if v, ok := p.Args["expiresAt"].(time.Time); ok {
+			expiresAt = &v
+		}
+		coinType, _ := p.Args["coinType"].(string)
+
+		expectedVersion := int64PtrArg(p.Args, "expectedVersion")
 
-		return r.Store.Recharge(ctx, id, amount, userIDv, dataID)
+		return r.Store.RechargeWithExpiry(ctx, id, coinType, amount, expiresAt, expectedVersion, userIDv, dataID)
 	}
 }
 
-// BatchRecharge(ids: [ID!]!, amount: Int!, userId: ID!, dataId: String)
+// BatchRecharge(ids: [ID!], amount: Int, items: [BatchRechargeItemInput!], userId: ID!, dataId: String, coinType: String)
 func (r *Resolvers) BatchRecharge() graphql.FieldResolveFn {
 	return func(p graphql.ResolveParams) (any, error) {
 		ctx, cancel := r.mctx(p)
 		defer cancel()
 
-		raw := p.Args["ids"].([]any)
-		ids := make([]string, 0, len(raw))
-		for _, v := range raw {
-			ids = append(ids, v.(string))
+		var items []dbpkg.BatchRechargeItem
+		if raw, ok := p.Args["ids"].([]any); ok && len(raw) > 0 {
+			amount, ok := p.Args["amount"].(int)
+			if !ok {
+				return nil, errors.New("amount is required with ids")
+			}
+			for _, v := range raw {
+				items = append(items, dbpkg.BatchRechargeItem{ID: v.(string), Amount: int64(amount)})
+			}
+		}
+		if raw, ok := p.Args["items"].([]any); ok {
+			for _, v := range raw {
+				it, _ := v.(map[string]any)
+				id, _ := it["id"].(string)
+				amount, _ := it["amount"].(int)
+				items = append(items, dbpkg.BatchRechargeItem{ID: id, Amount: int64(amount)})
+			}
 		}
-		amount := int64(p.Args["amount"].(int))
 
 		userIDv, ok := p.Args["userId"].(string)
 		if !ok || userIDv == "" {
@@ -198,13 +714,13 @@ func (r *Resolvers) BatchRecharge() graphql.FieldResolveFn {
 		if v, ok := p.Args["dataId"].(string); ok {
 			baseDataID = v
 		}
+		coinType, _ := p.Args["coinType"].(string)
 
-		n, err := r.Store.BatchRecharge(ctx, ids, amount, userIDv, baseDataID)
-		return int(n), err
+		return r.Store.BatchRecharge(ctx, items, coinType, userIDv, baseDataID)
 	}
 }
 
-// UseCoins(id: ID!, amount: Int!, userId: ID!, dataId: String)
+// UseCoins(id: ID!, amount: Int!, userId: ID!, dataId: String, coinType: String, expectedVersion: Int)
 func (r *Resolvers) UseCoins() graphql.FieldResolveFn {
 	return func(p graphql.ResolveParams) (any, error) {
 		ctx, cancel := r.mctx(p)
@@ -221,12 +737,15 @@ func (r *Resolvers) UseCoins() graphql.FieldResolveFn {
 		if v, ok := p.Args["dataId"].(string); ok {
 			dataID = v
 		}
+		coinType, _ := p.Args["coinType"].(string)
+
+		expectedVersion := int64PtrArg(p.Args, "expectedVersion")
 
-		return r.Store.Use(ctx, id, amount, userIDv, dataID)
+		return r.Store.Use(ctx, id, coinType, amount, expectedVersion, userIDv, dataID)
 	}
 }
 
-// TransferCoins(fromId: ID!, toId: ID!, amount: Int!, userId: ID!, dataId: String)
+// TransferCoins(fromId: ID!, toId: ID!, amount: Int!, userId: ID!, dataId: String, coinType: String, expectedVersion: Int)
 func (r *Resolvers) TransferCoins() graphql.FieldResolveFn {
 	return func(p graphql.ResolveParams) (any, error) {
 		ctx, cancel := r.mctx(p)
@@ -244,8 +763,11 @@ func (r *Resolvers) TransferCoins() graphql.FieldResolveFn {
 		if v, ok := p.Args["dataId"].(string); ok {
 			dataID = v
 		}
+		coinType, _ := p.Args["coinType"].(string)
+
+		expectedVersion := int64PtrArg(p.Args, "expectedVersion")
 
-		from, to, err := r.Store.Transfer(ctx, fromID, toID, amount, userIDv, dataID)
+		from, to, err := r.Store.Transfer(ctx, fromID, toID, coinType, amount, expectedVersion, userIDv, dataID)
 		if err != nil {
 			return nil, err
 		}
@@ -256,7 +778,30 @@ func (r *Resolvers) TransferCoins() graphql.FieldResolveFn {
 	}
 }
 
-// SetCoins(id: ID!, coins: Int!, userId: ID!, dataId: String)
+// TransferMulti(legs: [TransferLegInput!]!, userId: ID!, dataId: String, coinType: String)
+func (r *Resolvers) TransferMulti() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.mctx(p)
+		defer cancel()
+
+		var legs []dbpkg.TransferLeg
+		for _, item := range p.Args["legs"].([]any) {
+			l, _ := item.(map[string]any)
+			id, _ := l["accountId"].(string)
+			amount, _ := l["amount"].(int)
+			legs = append(legs, dbpkg.TransferLeg{AccountID: id, Amount: int64(amount), ExpectedVersion: int64PtrArg(l, "expectedVersion")})
+		}
+		userIDv, ok := p.Args["userId"].(string)
+		if !ok || userIDv == "" {
+			return nil, errors.New("userId (UUID) is required")
+		}
+		dataID, _ := p.Args["dataId"].(string)
+		coinType, _ := p.Args["coinType"].(string)
+		return r.Store.MultiTransfer(ctx, coinType, legs, userIDv, dataID)
+	}
+}
+
+// SetCoins(id: ID!, coins: Int!, userId: ID!, dataId: String, coinType: String, expectedVersion: Int)
 func (r *Resolvers) SetCoins() graphql.FieldResolveFn {
 	return func(p graphql.ResolveParams) (any, error) {
 		ctx, cancel := r.mctx(p)
@@ -273,8 +818,85 @@ func (r *Resolvers) SetCoins() graphql.FieldResolveFn {
 		if v, ok := p.Args["dataId"].(string); ok {
 			dataID = v
 		}
+		coinType, _ := p.Args["coinType"].(string)
+
+		expectedVersion := int64PtrArg(p.Args, "expectedVersion")
+
+		return r.Store.SetCoinsExact(ctx, id, coinType, coins, expectedVersion, userIDv, dataID)
+	}
+}
+
+// AuthorizeCoins(id: ID!, amount: Int!, ttlSeconds: Int, userId: ID!, dataId: String, coinType: String, expectedVersion: Int)
+func (r *Resolvers) AuthorizeCoins() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.mctx(p)
+		defer cancel()
+
+		id := p.Args["id"].(string)
+		amount := int64(p.Args["amount"].(int))
+		var ttl time.Duration
+		if v, ok := p.Args["ttlSeconds"].(int); ok {
+			ttl = time.Duration(v) * time.Second
+		}
+
+		userIDv, ok := p.Args["userId"].(string)
+		if !ok || userIDv == "" {
+			return nil, errors.New("userId (UUID) is required")
+		}
+		var dataID string
+		if v, ok := p.Args["dataId"].(string); ok {
+			dataID = v
+		}
+		coinType, _ := p.Args["coinType"].(string)
 
-		return r.Store.SetCoinsExact(ctx, id, coins, userIDv, dataID)
+		expectedVersion := int64PtrArg(p.Args, "expectedVersion")
+
+		return r.Store.Authorize(ctx, id, coinType, amount, ttl, expectedVersion, userIDv, dataID)
+	}
+}
+
+// CaptureHold(holdId: ID!, amount: Int, userId: ID!, dataId: String, expectedVersion: Int)
+func (r *Resolvers) CaptureHold() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.mctx(p)
+		defer cancel()
+
+		holdID := p.Args["holdId"].(string)
+		var amountPtr *int64
+		if v, ok := p.Args["amount"].(int); ok {
+			vv := int64(v)
+			amountPtr = &vv
+		}
+
+		userIDv, ok := p.Args["userId"].(string)
+		if !ok || userIDv == "" {
+			return nil, errors.New("userId (UUID) is required")
+		}
+		var dataID string
+		if v, ok := p.Args["dataId"].(string); ok {
+			dataID = v
+		}
+
+		expectedVersion := int64PtrArg(p.Args, "expectedVersion")
+
+		hold, acct, err := r.Store.Capture(ctx, holdID, amountPtr, expectedVersion, userIDv, dataID)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{
+			"hold":    hold,
+			"account": acct,
+		}, nil
+	}
+}
+
+// VoidHold(holdId: ID!, expectedVersion: Int)
+func (r *Resolvers) VoidHold() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.mctx(p)
+		defer cancel()
+		holdID := p.Args["holdId"].(string)
+		return r.Store.Void(ctx, holdID, int64PtrArg(p.Args, "expectedVersion"))
 	}
 }
 
@@ -283,7 +905,182 @@ func (r *Resolvers) TouchUsage() graphql.FieldResolveFn {
 		ctx, cancel := r.mctx(p)
 		defer cancel()
 		id := p.Args["id"].(string)
-		return r.Store.TouchUsage(ctx, id)
+		coinType, _ := p.Args["coinType"].(string)
+		return r.Store.TouchUsage(ctx, id, coinType, int64PtrArg(p.Args, "expectedVersion"))
+	}
+}
+
+// SetCreditLimit(id: ID!, limit: Int!, coinType: String, expectedVersion: Int)
+func (r *Resolvers) SetCreditLimit() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.mctx(p)
+		defer cancel()
+		id := p.Args["id"].(string)
+		limit := int64(p.Args["limit"].(int))
+		coinType, _ := p.Args["coinType"].(string)
+		return r.Store.SetCreditLimit(ctx, id, coinType, limit, int64PtrArg(p.Args, "expectedVersion"))
+	}
+}
+
+// SetParent(id: ID!, parentId: ID, expectedVersion: Int)
+func (r *Resolvers) SetParent() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.mctx(p)
+		defer cancel()
+		id := p.Args["id"].(string)
+		parentID, _ := p.Args["parentId"].(string)
+		return r.Store.SetParent(ctx, id, parentID, int64PtrArg(p.Args, "expectedVersion"))
+	}
+}
+
+// SetPoolAllowance(id: ID!, allowance: Int, coinType: String, expectedVersion: Int)
+func (r *Resolvers) SetPoolAllowance() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.mctx(p)
+		defer cancel()
+		id := p.Args["id"].(string)
+		coinType, _ := p.Args["coinType"].(string)
+		return r.Store.SetPoolAllowance(ctx, id, coinType, int64PtrArg(p.Args, "allowance"), int64PtrArg(p.Args, "expectedVersion"))
+	}
+}
+
+// SetSpendLimit(id: ID, windowSeconds: Int!, maxAmount: Int, maxCount: Int, coinType: String)
+func (r *Resolvers) SetSpendLimit() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.mctx(p)
+		defer cancel()
+		id, _ := p.Args["id"].(string)
+		window := time.Duration(p.Args["windowSeconds"].(int)) * time.Second
+		coinType, _ := p.Args["coinType"].(string)
+		return r.Store.SetSpendLimit(ctx, id, coinType, window, int64PtrArg(p.Args, "maxAmount"), int64PtrArg(p.Args, "maxCount"))
+	}
+}
+
+// ClearSpendLimit(id: ID, windowSeconds: Int!, coinType: String)
+func (r *Resolvers) ClearSpendLimit() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.mctx(p)
+		defer cancel()
+		id, _ := p.Args["id"].(string)
+		window := time.Duration(p.Args["windowSeconds"].(int)) * time.Second
+		coinType, _ := p.Args["coinType"].(string)
+		return r.Store.ClearSpendLimit(ctx, id, coinType, window)
+	}
+}
+
+// ReverseTransaction(dataId: String!, amount: Int, accountId: ID, userId: ID!, expectedVersion: Int)
+func (r *Resolvers) ReverseTransaction() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.mctx(p)
+		defer cancel()
+		dataID := p.Args["dataId"].(string)
+		accountID, _ := p.Args["accountId"].(string)
+		userIDv, ok := p.Args["userId"].(string)
+		if !ok || userIDv == "" {
+			return nil, errors.New("userId (UUID) is required")
+		}
+		return r.Store.ReverseTransaction(ctx, dataID, int64PtrArg(p.Args, "amount"), accountID, int64PtrArg(p.Args, "expectedVersion"), userIDv)
+	}
+}
+
+// RechargeSchedule(id: ID!)
+func (r *Resolvers) RechargeSchedule() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.qctx(p)
+		defer cancel()
+		return r.Store.GetRechargeSchedule(ctx, p.Args["id"].(string))
+	}
+}
+
+// RechargeSchedules(accountId: ID)
+func (r *Resolvers) RechargeSchedules() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.qctx(p)
+		defer cancel()
+		accountID, _ := p.Args["accountId"].(string)
+		return r.Store.ListRechargeSchedules(ctx, accountID)
+	}
+}
+
+// CreateRechargeSchedule(accountId: ID!, amount: Int!, userId: ID!, cron: String, intervalSeconds: Int, startAt: DateTime, coinType: String, expectedVersion: Int)
+func (r *Resolvers) CreateRechargeSchedule() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.mctx(p)
+		defer cancel()
+		accountID := p.Args["accountId"].(string)
+		amount := int64(p.Args["amount"].(int))
+		userID := p.Args["userId"].(string)
+		cron, _ := p.Args["cron"].(string)
+		secs, _ := p.Args["intervalSeconds"].(int)
+		coinType, _ := p.Args["coinType"].(string)
+		var startAt *time.Time
+		if t, ok := p.Args["startAt"].(time.Time); ok {
+			startAt = &t
+		}
+		return r.Store.CreateRechargeSchedule(ctx, accountID, coinType, amount, cron, time.Duration(secs)*time.Second, startAt, int64PtrArg(p.Args, "expectedVersion"), userID)
+	}
+}
+
+// UpdateRechargeSchedule(id: ID!, amount: Int, cron: String, intervalSeconds: Int, enabled: Boolean, expectedVersion: Int)
+func (r *Resolvers) UpdateRechargeSchedule() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.mctx(p)
+		defer cancel()
+		id := p.Args["id"].(string)
+		var cron *string
+		if v, ok := p.Args["cron"].(string); ok {
+			cron = &v
+		}
+		var interval *time.Duration
+		if v, ok := p.Args["intervalSeconds"].(int); ok {
+			d := time.Duration(v) * time.Second
+			interval = &d
+		}
+		var enabled *bool
+		if v, ok := p.Args["enabled"].(bool); ok {
+			enabled = &v
+		}
+		return r.Store.UpdateRechargeSchedule(ctx, id, int64PtrArg(p.Args, "amount"), cron, interval, enabled, int64PtrArg(p.Args, "expectedVersion"))
+	}
+}
+
+// DeleteRechargeSchedule(id: ID!, expectedVersion: Int)
+func (r *Resolvers) DeleteRechargeSchedule() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.mctx(p)
+		defer cancel()
+		return r.Store.DeleteRechargeSchedule(ctx, p.Args["id"].(string), int64PtrArg(p.Args, "expectedVersion"))
+	}
+}
+
+// FreezeUser(id: ID!, expectedVersion: Int)
+func (r *Resolvers) FreezeUser() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.mctx(p)
+		defer cancel()
+		return r.Store.FreezeAccount(ctx, p.Args["id"].(string), int64PtrArg(p.Args, "expectedVersion"))
+	}
+}
+
+// UnfreezeUser(id: ID!, expectedVersion: Int)
+func (r *Resolvers) UnfreezeUser() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.mctx(p)
+		defer cancel()
+		return r.Store.UnfreezeAccount(ctx, p.Args["id"].(string), int64PtrArg(p.Args, "expectedVersion"))
+	}
+}
+
+// CloseUser(id: ID!, sweepTo: ID, userId: ID, dataId: String, expectedVersion: Int)
+func (r *Resolvers) CloseUser() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.mctx(p)
+		defer cancel()
+		id := p.Args["id"].(string)
+		sweepTo, _ := p.Args["sweepTo"].(string)
+		userID, _ := p.Args["userId"].(string)
+		dataID, _ := p.Args["dataId"].(string)
+		return r.Store.CloseAccount(ctx, id, sweepTo, int64PtrArg(p.Args, "expectedVersion"), userID, dataID)
 	}
 }
 
@@ -292,6 +1089,55 @@ func (r *Resolvers) DeleteUser() graphql.FieldResolveFn {
 		ctx, cancel := r.mctx(p)
 		defer cancel()
 		id := p.Args["id"].(string)
-		return r.Store.DeleteAccount(ctx, id)
+		return r.Store.DeleteAccount(ctx, id, int64PtrArg(p.Args, "expectedVersion"))
+	}
+}
+
+// ExportUserData(id: ID!)
+func (r *Resolvers) ExportUserData() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.mctx(p)
+		defer cancel()
+		id := p.Args["id"].(string)
+		return r.Store.ExportAccount(ctx, id)
+	}
+}
+
+// EraseUser(id: ID!)
+func (r *Resolvers) EraseUser() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.mctx(p)
+		defer cancel()
+		id := p.Args["id"].(string)
+		return r.Store.EraseAccount(ctx, id)
+	}
+}
+
+// ArchiveDormantAccounts(inactiveDays: Int!, maxCoins: Int, dryRun: Boolean)
+func (r *Resolvers) ArchiveDormantAccounts() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.mctx(p)
+		defer cancel()
+		days, _ := p.Args["inactiveDays"].(int)
+		maxCoins, _ := p.Args["maxCoins"].(int)
+		dryRun, _ := p.Args["dryRun"].(bool)
+		return r.Store.ArchiveDormant(ctx, dbpkg.ArchiveConfig{
+			InactiveFor: time.Duration(days) * 24 * time.Hour,
+			MaxCoins:    int64(maxCoins),
+			DryRun:      dryRun,
+		})
+	}
+}
+
+// ReplayOutboxEntry(id: ID!)
+func (r *Resolvers) ReplayOutboxEntry() graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		ctx, cancel := r.mctx(p)
+		defer cancel()
+		id, err := int64Arg(p.Args, "id")
+		if err != nil {
+			return nil, err
+		}
+		return r.Store.ReplayOutbox(ctx, id)
 	}
 }
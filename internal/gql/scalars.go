@@ -0,0 +1,53 @@
+package gql
+
+import (
+	"strconv"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// jsonScalar passes arbitrary JSON values (objects, lists, strings, numbers, booleans) through as is.
+var jsonScalar = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "JSON",
+	Description: "Arbitrary JSON value.",
+	Serialize:   func(v any) any { return v },
+	ParseValue:  func(v any) any { return v },
+	ParseLiteral: func(v ast.Value) any {
+		return jsonLiteral(v)
+	},
+})
+
+func jsonLiteral(v ast.Value) any {
+	switch v := v.(type) {
+	case *ast.ObjectValue:
+		out := make(map[string]any, len(v.Fields))
+		for _, f := range v.Fields {
+			out[f.Name.Value] = jsonLiteral(f.Value)
+		}
+		return out
+	case *ast.ListValue:
+		out := make([]any, 0, len(v.Values))
+		for _, item := range v.Values {
+			out = append(out, jsonLiteral(item))
+		}
+		return out
+	case *ast.StringValue:
+		return v.Value
+	case *ast.EnumValue:
+		return v.Value
+	case *ast.BooleanValue:
+		return v.Value
+	case *ast.IntValue:
+		if n, err := strconv.ParseInt(v.Value, 10, 64); err == nil {
+			return n
+		}
+		return nil
+	case *ast.FloatValue:
+		if f, err := strconv.ParseFloat(v.Value, 64); err == nil {
+			return f
+		}
+		return nil
+	}
+	return nil
+}
@@ -7,13 +7,338 @@ import (
 // NewSchema builds the GraphQL schema using the provided resolvers.
 func NewSchema(r *Resolvers) (graphql.Schema, error) {
 	// ----- Types -----
+	ledgerEntryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "LedgerEntry",
+		Fields: graphql.Fields{
+			"id":           &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"accountId":    &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"coinType":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"delta":        &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"balanceAfter": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"userId":       &graphql.Field{Type: graphql.String},
+			"dataId":       &graphql.Field{Type: graphql.String},
+			"kind":         &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"reversesId":   &graphql.Field{Type: graphql.ID}, // ledger entry a reversal compensates
+			"createdAt":    &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+		},
+	})
+
+	coinLotType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "CoinLot",
+		Fields: graphql.Fields{
+			"id":        &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"accountId": &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"coinType":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"amount":    &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"remaining": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"expiresAt": &graphql.Field{Type: graphql.DateTime},
+			"dataId":    &graphql.Field{Type: graphql.String},
+			"createdAt": &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+		},
+	})
+
+	spendLimitType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "SpendLimit",
+		Fields: graphql.Fields{
+			"accountId":     &graphql.Field{Type: graphql.ID}, // empty for the global default
+			"coinType":      &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"windowSeconds": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"maxAmount":     &graphql.Field{Type: graphql.Int},
+			"maxCount":      &graphql.Field{Type: graphql.Int},
+		},
+	})
+
+	labelType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Label",
+		Fields: graphql.Fields{
+			"key":   &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"value": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+
+	labelInputType := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "LabelInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"key":   &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"value": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+
+	transferLegInputType := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "TransferLegInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"accountId":       &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.ID)},
+			"amount":          &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.Int)}, // negative debits, positive credits
+			"expectedVersion": &graphql.InputObjectFieldConfig{Type: graphql.Int},
+		},
+	})
+
+	batchRechargeItemInputType := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "BatchRechargeItemInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"id":     &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.ID)},
+			"amount": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.Int)},
+		},
+	})
+
+	batchRechargeResultType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "BatchRechargeResult",
+		Fields: graphql.Fields{
+			"id":     &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"amount": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"status": &graphql.Field{Type: graphql.NewNonNull(graphql.String)}, // credited | not_found | rejected
+			"coins":  &graphql.Field{Type: graphql.Int},                        // new balance when credited
+			"error":  &graphql.Field{Type: graphql.String},
+		},
+	})
+
+	rechargeScheduleType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "RechargeSchedule",
+		Fields: graphql.Fields{
+			"id":              &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"accountId":       &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"coinType":        &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"amount":          &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"cron":            &graphql.Field{Type: graphql.String},
+			"intervalSeconds": &graphql.Field{Type: graphql.Int},
+			"userId":          &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"enabled":         &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+			"nextRunAt":       &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+			"lastRunAt":       &graphql.Field{Type: graphql.DateTime},
+			"lastError":       &graphql.Field{Type: graphql.String},
+			"createdAt":       &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+			"updatedAt":       &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+		},
+	})
+
 	accountType := graphql.NewObject(graphql.ObjectConfig{
 		Name: "Account",
 		Fields: graphql.Fields{
 			"id":               &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"coinType":         &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
 			"coins":            &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"version":          &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"status":           &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"creditLimit":      &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
 			"lastRechargeDate": &graphql.Field{Type: graphql.DateTime},
 			"lastUsageDate":    &graphql.Field{Type: graphql.DateTime},
+			"createdAt":        &graphql.Field{Type: graphql.DateTime},
+			"held":             &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"available":        &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"metadata":         &graphql.Field{Type: jsonScalar},
+			"parentId":         &graphql.Field{Type: graphql.ID},  // shared wallet drawn from once coins run out
+			"poolAllowance":    &graphql.Field{Type: graphql.Int}, // still drawable from the parent; null means no cap
+
+			// labels: [Label!]! (sorted by key)
+			"labels": &graphql.Field{
+				Type:    graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(labelType))),
+				Resolve: r.AccountLabels(),
+			},
+
+			// ledger(first: Int, after: ID): [LedgerEntry!]! (newest first; after = last seen entry id)
+			"ledger": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(ledgerEntryType))),
+				Args: graphql.FieldConfigArgument{
+					"first": &graphql.ArgumentConfig{Type: graphql.Int},
+					"after": &graphql.ArgumentConfig{Type: graphql.ID},
+				},
+				Resolve: r.AccountLedger(),
+			},
+
+			// expirations(before: DateTime): [CoinLot!]! (unspent coins that will expire, soonest first)
+			"expirations": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(coinLotType))),
+				Args: graphql.FieldConfigArgument{
+					"before": &graphql.ArgumentConfig{Type: graphql.DateTime},
+				},
+				Resolve: r.AccountExpirations(),
+			},
+
+			// spendLimits: [SpendLimit!]! (own limits plus the global defaults it doesn't override)
+			"spendLimits": &graphql.Field{
+				Type:    graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(spendLimitType))),
+				Resolve: r.AccountSpendLimits(),
+			},
+		},
+	})
+
+	// balances: [Account!]! (every coin type held under the same account id)
+	accountType.AddFieldConfig("balances", &graphql.Field{
+		Type:    graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(accountType))),
+		Resolve: r.AccountBalances(),
+	})
+
+	// members: [Account!]! (same coin type of the accounts directly below this one)
+	accountType.AddFieldConfig("members", &graphql.Field{
+		Type:    graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(accountType))),
+		Resolve: r.AccountMembers(),
+	})
+
+	hierarchyTotalsType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "HierarchyTotals",
+		Fields: graphql.Fields{
+			"rootId":    &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"coinType":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"accounts":  &graphql.Field{Type: graphql.NewNonNull(graphql.Int)}, // root included
+			"depth":     &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"coins":     &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"held":      &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"available": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)}, // coins - held; credit limits not included
+		},
+	})
+
+	pageInfoType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "PageInfo",
+		Fields: graphql.Fields{
+			"hasNextPage":     &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+			"hasPreviousPage": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+			"startCursor":     &graphql.Field{Type: graphql.String},
+			"endCursor":       &graphql.Field{Type: graphql.String},
+		},
+	})
+
+	accountEdgeType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "AccountEdge",
+		Fields: graphql.Fields{
+			"node":   &graphql.Field{Type: graphql.NewNonNull(accountType)},
+			"cursor": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+
+	accountConnectionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "AccountConnection",
+		Fields: graphql.Fields{
+			"edges":    &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(accountEdgeType)))},
+			"pageInfo": &graphql.Field{Type: graphql.NewNonNull(pageInfoType)},
+			// totalCount: Int! (all rows of the listing, not just this page; counted only when selected)
+			"totalCount": &graphql.Field{
+				Type:    graphql.NewNonNull(graphql.Int),
+				Resolve: r.ConnectionTotalCount(),
+			},
+		},
+	})
+
+	auditEntryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "AuditEntry",
+		Fields: graphql.Fields{
+			"id":             &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"operation":      &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"caller":         &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"callerVerified": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+			"sourceIp":       &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"args":           &graphql.Field{Type: graphql.NewNonNull(jsonScalar)},
+			"before":         &graphql.Field{Type: jsonScalar},
+			"after":          &graphql.Field{Type: jsonScalar},
+			"outcome":        &graphql.Field{Type: graphql.NewNonNull(graphql.String)}, // pending | ok | error
+			"error":          &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"createdAt":      &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+			"finishedAt":     &graphql.Field{Type: graphql.DateTime},
+		},
+	})
+
+	auditEdgeType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "AuditEdge",
+		Fields: graphql.Fields{
+			"node":   &graphql.Field{Type: graphql.NewNonNull(auditEntryType)},
+			"cursor": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+
+	auditConnectionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "AuditConnection",
+		Fields: graphql.Fields{
+			"edges":    &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(auditEdgeType)))},
+			"pageInfo": &graphql.Field{Type: graphql.NewNonNull(pageInfoType)},
+		},
+	})
+
+	auditFilterInputType := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "AuditFilterInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"operation": &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"caller":    &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"outcome":   &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"since":     &graphql.InputObjectFieldConfig{Type: graphql.DateTime},
+			"until":     &graphql.InputObjectFieldConfig{Type: graphql.DateTime},
+		},
+	})
+
+	erasureType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Erasure",
+		Fields: graphql.Fields{
+			"pseudonym": &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"balances":  &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"records":   &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		},
+	})
+
+	archiveReportType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "ArchiveReport",
+		Fields: graphql.Fields{
+			"dryRun":   &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+			"cutoff":   &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+			"accounts": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"balances": &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(accountType)))},
+			"coins":    &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		},
+	})
+
+	reversalType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Reversal",
+		Fields: graphql.Fields{
+			"original":      &graphql.Field{Type: graphql.NewNonNull(ledgerEntryType)},
+			"amount":        &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"totalReversed": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"remaining":     &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"dataId":        &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"account":       &graphql.Field{Type: accountType},
+			"counterparty":  &graphql.Field{Type: accountType}, // payee of a reversed transfer
+		},
+	})
+
+	outboxEntryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "OutboxEntry",
+		Fields: graphql.Fields{
+			"id":            &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"accountId":     &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"coinType":      &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"userId":        &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"dataId":        &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"coinUsed":      &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+			"occurredAt":    &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+			"status":        &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"attempts":      &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"nextAttemptAt": &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+			"lastError":     &graphql.Field{Type: graphql.String},
+			"createdAt":     &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+			"sentAt":        &graphql.Field{Type: graphql.DateTime},
+			"expiry":        &graphql.Field{Type: graphql.DateTime},
+			"topic":         &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+
+	holdType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Hold",
+		Fields: graphql.Fields{
+			"id":        &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"accountId": &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"coinType":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"amount":    &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"captured":  &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"status":    &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"userId":    &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"dataId":    &graphql.Field{Type: graphql.String},
+			"expiresAt": &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+			"createdAt": &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+			"updatedAt": &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+		},
+	})
+
+	captureResultType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "CaptureResult",
+		Fields: graphql.Fields{
+			"hold":    &graphql.Field{Type: holdType},
+			"account": &graphql.Field{Type: accountType},
 		},
 	})
 
@@ -29,58 +354,81 @@ func NewSchema(r *Resolvers) (graphql.Schema, error) {
 	query := graphql.NewObject(graphql.ObjectConfig{
 		Name: "Query",
 		Fields: graphql.Fields{
-			// getUser(id: ID!): Account
+			// getUser(id: ID!, coinType: String): Account
 			"getUser": &graphql.Field{
 				Type: accountType,
 				Args: graphql.FieldConfigArgument{
-					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"id":       &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"coinType": &graphql.ArgumentConfig{Type: graphql.String},
 				},
 				Resolve: r.GetUser(),
 			},
 
-			// listUsers(limit: Int, offset: Int): [Account!]!
+			// getUserAsOf(id: ID!, at: DateTime!, coinType: String): Account (only id, coinType, coins and available are historical)
+			"getUserAsOf": &graphql.Field{
+				Type: accountType,
+				Args: graphql.FieldConfigArgument{
+					"id":       &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"at":       &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.DateTime)},
+					"coinType": &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: r.GetUserAsOf(),
+			},
+
+			// listUsers(first: Int, after: String, status: String, labelSelector: String): AccountConnection!
+			// ordered by id and coin type; first defaults to 50, at most 200
+			// labelSelector: comma-separated key=value, key!=value, key or !key terms, all of which must match
 			"listUsers": &graphql.Field{
-				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(accountType))),
+				Type: graphql.NewNonNull(accountConnectionType),
 				Args: graphql.FieldConfigArgument{
-					"limit":  &graphql.ArgumentConfig{Type: graphql.Int},
-					"offset": &graphql.ArgumentConfig{Type: graphql.Int},
+					"first":         &graphql.ArgumentConfig{Type: graphql.Int},
+					"after":         &graphql.ArgumentConfig{Type: graphql.String},
+					"status":        &graphql.ArgumentConfig{Type: graphql.String},
+					"labelSelector": &graphql.ArgumentConfig{Type: graphql.String},
 				},
 				Resolve: r.ListUsers(),
 			},
 
-			// getBalance(id: ID!): Int!
+			// getBalance(id: ID!, coinType: String): Int!
 			"getBalance": &graphql.Field{
 				Type: graphql.NewNonNull(graphql.Int),
 				Args: graphql.FieldConfigArgument{
-					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"id":       &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"coinType": &graphql.ArgumentConfig{Type: graphql.String},
 				},
 				Resolve: r.GetBalance(),
 			},
 
-			// getUsersByCoinsRange(min: Int, max: Int): [Account!]!
+			// getUsersByCoinsRange(min: Int, max: Int, first: Int, after: String): AccountConnection! (richest first)
 			"getUsersByCoinsRange": &graphql.Field{
-				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(accountType))),
+				Type: graphql.NewNonNull(accountConnectionType),
 				Args: graphql.FieldConfigArgument{
-					"min": &graphql.ArgumentConfig{Type: graphql.Int},
-					"max": &graphql.ArgumentConfig{Type: graphql.Int},
+					"min":   &graphql.ArgumentConfig{Type: graphql.Int},
+					"max":   &graphql.ArgumentConfig{Type: graphql.Int},
+					"first": &graphql.ArgumentConfig{Type: graphql.Int},
+					"after": &graphql.ArgumentConfig{Type: graphql.String},
 				},
 				Resolve: r.GetUsersByCoinsRange(),
 			},
 
-			// getRecentRecharges(since: DateTime!): [Account!]!
+			// getRecentRecharges(since: DateTime!, first: Int, after: String): AccountConnection! (most recent first)
 			"getRecentRecharges": &graphql.Field{
-				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(accountType))),
+				Type: graphql.NewNonNull(accountConnectionType),
 				Args: graphql.FieldConfigArgument{
 					"since": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.DateTime)},
+					"first": &graphql.ArgumentConfig{Type: graphql.Int},
+					"after": &graphql.ArgumentConfig{Type: graphql.String},
 				},
 				Resolve: r.GetRecentRecharges(),
 			},
 
-			// getInactiveSince(before: DateTime!): [Account!]!
+			// getInactiveSince(before: DateTime!, first: Int, after: String): AccountConnection! (never used first)
 			"getInactiveSince": &graphql.Field{
-				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(accountType))),
+				Type: graphql.NewNonNull(accountConnectionType),
 				Args: graphql.FieldConfigArgument{
 					"before": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.DateTime)},
+					"first":  &graphql.ArgumentConfig{Type: graphql.Int},
+					"after":  &graphql.ArgumentConfig{Type: graphql.String},
 				},
 				Resolve: r.GetInactiveSince(),
 			},
@@ -91,12 +439,104 @@ func NewSchema(r *Resolvers) (graphql.Schema, error) {
 				Resolve: r.CountUsers(),
 			},
 
-			// totalCoins: Int!
+			// totalCoins(coinType: String): Int!
 			"totalCoins": &graphql.Field{
-				Type:    graphql.NewNonNull(graphql.Int),
+				Type: graphql.NewNonNull(graphql.Int),
+				Args: graphql.FieldConfigArgument{
+					"coinType": &graphql.ArgumentConfig{Type: graphql.String},
+				},
 				Resolve: r.TotalCoins(),
 			},
 
+			// spendLimits(id: ID, coinType: String): [SpendLimit!]! (without id: the global defaults)
+			"spendLimits": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(spendLimitType))),
+				Args: graphql.FieldConfigArgument{
+					"id":       &graphql.ArgumentConfig{Type: graphql.ID},
+					"coinType": &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: r.SpendLimits(),
+			},
+
+			// overdrawnAccounts(coinType: String): [Account!]! (balances below zero, most overdrawn first)
+			"overdrawnAccounts": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(accountType))),
+				Args: graphql.FieldConfigArgument{
+					"coinType": &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: r.OverdrawnAccounts(),
+			},
+
+			// hierarchyTotals(id: ID!, coinType: String): HierarchyTotals (id and every account below it)
+			"hierarchyTotals": &graphql.Field{
+				Type: hierarchyTotalsType,
+				Args: graphql.FieldConfigArgument{
+					"id":       &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"coinType": &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: r.HierarchyTotals(),
+			},
+
+			// rechargeSchedule(id: ID!): RechargeSchedule
+			"rechargeSchedule": &graphql.Field{
+				Type: rechargeScheduleType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: r.RechargeSchedule(),
+			},
+
+			// rechargeSchedules(accountId: ID): [RechargeSchedule!]! (soonest first; all accounts if omitted)
+			"rechargeSchedules": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(rechargeScheduleType))),
+				Args: graphql.FieldConfigArgument{
+					"accountId": &graphql.ArgumentConfig{Type: graphql.ID},
+				},
+				Resolve: r.RechargeSchedules(),
+			},
+
+			// totalCoinsAsOf(at: DateTime!, coinType: String): Int!
+			"totalCoinsAsOf": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Int),
+				Args: graphql.FieldConfigArgument{
+					"at":       &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.DateTime)},
+					"coinType": &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: r.TotalCoinsAsOf(),
+			},
+
+			// getHold(id: ID!): Hold
+			"getHold": &graphql.Field{
+				Type: holdType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: r.GetHold(),
+			},
+
+			// outboxEntries(status: String, first: Int, after: ID): [OutboxEntry!]! (admin; oldest first)
+			"outboxEntries": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(outboxEntryType))),
+				Args: graphql.FieldConfigArgument{
+					"status": &graphql.ArgumentConfig{Type: graphql.String},
+					"first":  &graphql.ArgumentConfig{Type: graphql.Int},
+					"after":  &graphql.ArgumentConfig{Type: graphql.ID},
+				},
+				Resolve: r.OutboxEntries(),
+			},
+
+			// auditLog(filter: AuditFilterInput, first: Int, after: String): AuditConnection!
+			// admin; calls of the audited admin mutations, newest first; first defaults to 50, at most 200
+			"auditLog": &graphql.Field{
+				Type: graphql.NewNonNull(auditConnectionType),
+				Args: graphql.FieldConfigArgument{
+					"filter": &graphql.ArgumentConfig{Type: auditFilterInputType},
+					"first":  &graphql.ArgumentConfig{Type: graphql.Int},
+					"after":  &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: r.adminOnly("auditLog", r.AuditLog()),
+			},
+
 			// existsUser(id: ID!): Boolean!
 			"existsUser": &graphql.Field{
 				Type: graphql.NewNonNull(graphql.Boolean),
@@ -112,99 +552,374 @@ func NewSchema(r *Resolvers) (graphql.Schema, error) {
 	mutation := graphql.NewObject(graphql.ObjectConfig{
 		Name: "Mutation",
 		Fields: graphql.Fields{
-			// createUser(id: ID!, coins: Int): Account
+			// createUser(id: ID!, coins: Int, coinType: String, metadata: JSON, labels: [LabelInput!]): Account
 			"createUser": &graphql.Field{
 				Type: accountType,
 				Args: graphql.FieldConfigArgument{
-					"id":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
-					"coins": &graphql.ArgumentConfig{Type: graphql.Int},
+					"id":       &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"coins":    &graphql.ArgumentConfig{Type: graphql.Int},
+					"coinType": &graphql.ArgumentConfig{Type: graphql.String},
+					"metadata": &graphql.ArgumentConfig{Type: jsonScalar},
+					"labels":   &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(labelInputType))},
 				},
 				Resolve: r.CreateUser(),
 			},
 
-			// rechargeCoins(id: ID!, amount: Int!, userId: ID!, dataId: String): Account
+			// updateUserMetadata(id: ID!, metadata: JSON, labels: [LabelInput!], merge: Boolean, expectedVersion: Int): [Account!]!
+			// omitted parts are left alone; merge keeps existing keys not given here
+			"updateUserMetadata": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(accountType))),
+				Args: graphql.FieldConfigArgument{
+					"id":              &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"metadata":        &graphql.ArgumentConfig{Type: jsonScalar},
+					"labels":          &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(labelInputType))},
+					"merge":           &graphql.ArgumentConfig{Type: graphql.Boolean},
+					"expectedVersion": &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: r.UpdateUserMetadata(),
+			},
+
+			// rechargeCoins(id: ID!, amount: Int!, userId: ID!, dataId: String, expiresAt: DateTime, coinType: String, expectedVersion: Int): Account
 			"rechargeCoins": &graphql.Field{
 				Type: accountType,
 				Args: graphql.FieldConfigArgument{
-					"id":     &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
-					"amount": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
-					"userId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
-					"dataId": &graphql.ArgumentConfig{Type: graphql.String},
+					"id":              &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"amount":          &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"userId":          &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"dataId":          &graphql.ArgumentConfig{Type: graphql.String},
+					"expiresAt":       &graphql.ArgumentConfig{Type: graphql.DateTime},
+					"coinType":        &graphql.ArgumentConfig{Type: graphql.String},
+					"expectedVersion": &graphql.ArgumentConfig{Type: graphql.Int},
 				},
 				Resolve: r.RechargeCoins(),
 			},
 
-			// batchRecharge(ids: [ID!]!, amount: Int!, userId: ID!, dataId: String): Int!
+			// batchRecharge(ids: [ID!], amount: Int, items: [BatchRechargeItemInput!], userId: ID!, dataId: String, coinType: String): [BatchRechargeResult!]!
+			// ids are each credited amount; items carry their own amounts. One result per id/item, in order.
 			"batchRecharge": &graphql.Field{
-				Type: graphql.NewNonNull(graphql.Int),
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(batchRechargeResultType))),
 				Args: graphql.FieldConfigArgument{
-					"ids": &graphql.ArgumentConfig{
-						Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.ID))),
-					},
-					"amount": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
-					"userId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
-					"dataId": &graphql.ArgumentConfig{Type: graphql.String},
+					"ids":      &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.ID))},
+					"amount":   &graphql.ArgumentConfig{Type: graphql.Int},
+					"items":    &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(batchRechargeItemInputType))},
+					"userId":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"dataId":   &graphql.ArgumentConfig{Type: graphql.String},
+					"coinType": &graphql.ArgumentConfig{Type: graphql.String},
 				},
-				Resolve: r.BatchRecharge(),
+				Resolve: r.audited("batchRecharge", r.batchState, r.BatchRecharge()),
 			},
 
-			// useCoins(id: ID!, amount: Int!, userId: ID!, dataId: String): Account
+			// useCoins(id: ID!, amount: Int!, userId: ID!, dataId: String, coinType: String, expectedVersion: Int): Account
 			"useCoins": &graphql.Field{
 				Type: accountType,
 				Args: graphql.FieldConfigArgument{
-					"id":     &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
-					"amount": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
-					"userId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
-					"dataId": &graphql.ArgumentConfig{Type: graphql.String},
+					"id":              &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"amount":          &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"userId":          &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"dataId":          &graphql.ArgumentConfig{Type: graphql.String},
+					"coinType":        &graphql.ArgumentConfig{Type: graphql.String},
+					"expectedVersion": &graphql.ArgumentConfig{Type: graphql.Int},
 				},
 				Resolve: r.UseCoins(),
 			},
 
-			// transferCoins(fromId: ID!, toId: ID!, amount: Int!, userId: ID!, dataId: String): TransferResult
+			// transferCoins(fromId: ID!, toId: ID!, amount: Int!, userId: ID!, dataId: String, coinType: String, expectedVersion: Int): TransferResult
 			"transferCoins": &graphql.Field{
 				Type: transferResultType,
 				Args: graphql.FieldConfigArgument{
-					"fromId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
-					"toId":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
-					"amount": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
-					"userId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
-					"dataId": &graphql.ArgumentConfig{Type: graphql.String},
+					"fromId":          &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"toId":            &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"amount":          &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"userId":          &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"dataId":          &graphql.ArgumentConfig{Type: graphql.String},
+					"coinType":        &graphql.ArgumentConfig{Type: graphql.String},
+					"expectedVersion": &graphql.ArgumentConfig{Type: graphql.Int},
 				},
 				Resolve: r.TransferCoins(),
 			},
 
-			// setCoins(id: ID!, coins: Int!, userId: ID!, dataId: String): Account
+			// transferMulti(legs: [TransferLegInput!]!, userId: ID!, dataId: String, coinType: String): [Account!]!
+			// legs must sum to zero; accounts are returned in leg order
+			"transferMulti": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(accountType))),
+				Args: graphql.FieldConfigArgument{
+					"legs":     &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(transferLegInputType)))},
+					"userId":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"dataId":   &graphql.ArgumentConfig{Type: graphql.String},
+					"coinType": &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: r.TransferMulti(),
+			},
+
+			// setCoins(id: ID!, coins: Int!, userId: ID!, dataId: String, coinType: String, expectedVersion: Int): Account
 			"setCoins": &graphql.Field{
 				Type: accountType,
 				Args: graphql.FieldConfigArgument{
-					"id":     &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
-					"coins":  &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
-					"userId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
-					"dataId": &graphql.ArgumentConfig{Type: graphql.String},
+					"id":              &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"coins":           &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"userId":          &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"dataId":          &graphql.ArgumentConfig{Type: graphql.String},
+					"coinType":        &graphql.ArgumentConfig{Type: graphql.String},
+					"expectedVersion": &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: r.audited("setCoins", r.accountState, r.SetCoins()),
+			},
+
+			// authorizeCoins(id: ID!, amount: Int!, ttlSeconds: Int, userId: ID!, dataId: String, coinType: String, expectedVersion: Int): Hold
+			"authorizeCoins": &graphql.Field{
+				Type: holdType,
+				Args: graphql.FieldConfigArgument{
+					"id":              &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"amount":          &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"ttlSeconds":      &graphql.ArgumentConfig{Type: graphql.Int},
+					"userId":          &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"dataId":          &graphql.ArgumentConfig{Type: graphql.String},
+					"coinType":        &graphql.ArgumentConfig{Type: graphql.String},
+					"expectedVersion": &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: r.AuthorizeCoins(),
+			},
+
+			// captureHold(holdId: ID!, amount: Int, userId: ID!, dataId: String, expectedVersion: Int): CaptureResult
+			"captureHold": &graphql.Field{
+				Type: captureResultType,
+				Args: graphql.FieldConfigArgument{
+					"holdId":          &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"amount":          &graphql.ArgumentConfig{Type: graphql.Int},
+					"userId":          &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"dataId":          &graphql.ArgumentConfig{Type: graphql.String},
+					"expectedVersion": &graphql.ArgumentConfig{Type: graphql.Int},
 				},
-				Resolve: r.SetCoins(),
+				Resolve: r.CaptureHold(),
 			},
 
-			// touchUsage(id: ID!): Account
+			// voidHold(holdId: ID!, expectedVersion: Int): Hold
+			"voidHold": &graphql.Field{
+				Type: holdType,
+				Args: graphql.FieldConfigArgument{
+					"holdId":          &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"expectedVersion": &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: r.VoidHold(),
+			},
+
+			// touchUsage(id: ID!, coinType: String, expectedVersion: Int): Account
 			"touchUsage": &graphql.Field{
 				Type: accountType,
 				Args: graphql.FieldConfigArgument{
-					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"id":              &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"coinType":        &graphql.ArgumentConfig{Type: graphql.String},
+					"expectedVersion": &graphql.ArgumentConfig{Type: graphql.Int},
 				},
 				Resolve: r.TouchUsage(),
 			},
 
-			// deleteUser(id: ID!): Boolean!
+			// exportUserData(id: ID!): JSON (admin; everything stored under the account id, null if nothing)
+			"exportUserData": &graphql.Field{
+				Type: jsonScalar,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: r.audited("exportUserData", nil, r.ExportUserData()),
+			},
+
+			// eraseUser(id: ID!): Erasure! (admin; anonymizes a closed or deleted account and its history for good)
+			"eraseUser": &graphql.Field{
+				Type: graphql.NewNonNull(erasureType),
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: r.audited("eraseUser", nil, r.EraseUser()),
+			},
+
+			// archiveDormantAccounts(inactiveDays: Int!, maxCoins: Int, dryRun: Boolean): ArchiveReport!
+			// admin; moves accounts idle that long and holding at most maxCoins (default 0) into the
+			// archive, where the next read or mutation restores them; dryRun only reports them
+			"archiveDormantAccounts": &graphql.Field{
+				Type: graphql.NewNonNull(archiveReportType),
+				Args: graphql.FieldConfigArgument{
+					"inactiveDays": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"maxCoins":     &graphql.ArgumentConfig{Type: graphql.Int},
+					"dryRun":       &graphql.ArgumentConfig{Type: graphql.Boolean},
+				},
+				Resolve: r.audited("archiveDormantAccounts", nil, r.ArchiveDormantAccounts()),
+			},
+
+			// replayOutboxEntry(id: ID!): OutboxEntry (admin; requeues a dead or stuck notification)
+			"replayOutboxEntry": &graphql.Field{
+				Type: outboxEntryType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: r.audited("replayOutboxEntry", nil, r.ReplayOutboxEntry()),
+			},
+
+			// setCreditLimit(id: ID!, limit: Int!, coinType: String, expectedVersion: Int): Account
+			"setCreditLimit": &graphql.Field{
+				Type: accountType,
+				Args: graphql.FieldConfigArgument{
+					"id":              &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"limit":           &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"coinType":        &graphql.ArgumentConfig{Type: graphql.String},
+					"expectedVersion": &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: r.audited("setCreditLimit", r.accountState, r.SetCreditLimit()),
+			},
+
+			// setParent(id: ID!, parentId: ID, expectedVersion: Int): [Account!]! (joins the shared wallet parentId; without parentId leaves it)
+			"setParent": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(accountType))),
+				Args: graphql.FieldConfigArgument{
+					"id":              &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"parentId":        &graphql.ArgumentConfig{Type: graphql.ID},
+					"expectedVersion": &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: r.audited("setParent", r.balancesState, r.SetParent()),
+			},
+
+			// setPoolAllowance(id: ID!, allowance: Int, coinType: String, expectedVersion: Int): Account
+			// (how much more the member may draw from its parent; without allowance: no cap)
+			"setPoolAllowance": &graphql.Field{
+				Type: accountType,
+				Args: graphql.FieldConfigArgument{
+					"id":              &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"allowance":       &graphql.ArgumentConfig{Type: graphql.Int},
+					"coinType":        &graphql.ArgumentConfig{Type: graphql.String},
+					"expectedVersion": &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: r.audited("setPoolAllowance", r.accountState, r.SetPoolAllowance()),
+			},
+
+			// setSpendLimit(id: ID, windowSeconds: Int!, maxAmount: Int, maxCount: Int, coinType: String): [SpendLimit!]!
+			// (without id: the global default; no maxAmount/maxCount lifts the cap for that window)
+			"setSpendLimit": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(spendLimitType))),
+				Args: graphql.FieldConfigArgument{
+					"id":            &graphql.ArgumentConfig{Type: graphql.ID},
+					"windowSeconds": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"maxAmount":     &graphql.ArgumentConfig{Type: graphql.Int},
+					"maxCount":      &graphql.ArgumentConfig{Type: graphql.Int},
+					"coinType":      &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: r.audited("setSpendLimit", r.spendLimitsState, r.SetSpendLimit()),
+			},
+
+			// clearSpendLimit(id: ID, windowSeconds: Int!, coinType: String): [SpendLimit!]!
+			"clearSpendLimit": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(spendLimitType))),
+				Args: graphql.FieldConfigArgument{
+					"id":            &graphql.ArgumentConfig{Type: graphql.ID},
+					"windowSeconds": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"coinType":      &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: r.audited("clearSpendLimit", r.spendLimitsState, r.ClearSpendLimit()),
+			},
+
+			// reverseTransaction(dataId: String!, amount: Int, accountId: ID, userId: ID!, expectedVersion: Int): Reversal
+			// gives back a use, capture or transfer (all of what is left if amount is omitted)
+			"reverseTransaction": &graphql.Field{
+				Type: reversalType,
+				Args: graphql.FieldConfigArgument{
+					"dataId":          &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"amount":          &graphql.ArgumentConfig{Type: graphql.Int},
+					"accountId":       &graphql.ArgumentConfig{Type: graphql.ID},
+					"userId":          &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"expectedVersion": &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: r.ReverseTransaction(),
+			},
+
+			// createRechargeSchedule(accountId: ID!, amount: Int!, userId: ID!, cron: String, intervalSeconds: Int, startAt: DateTime, coinType: String, expectedVersion: Int): RechargeSchedule
+			// exactly one of cron (five fields or @monthly etc., UTC) and intervalSeconds (>= 60)
+			"createRechargeSchedule": &graphql.Field{
+				Type: rechargeScheduleType,
+				Args: graphql.FieldConfigArgument{
+					"accountId":       &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"amount":          &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"userId":          &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"cron":            &graphql.ArgumentConfig{Type: graphql.String},
+					"intervalSeconds": &graphql.ArgumentConfig{Type: graphql.Int},
+					"startAt":         &graphql.ArgumentConfig{Type: graphql.DateTime},
+					"coinType":        &graphql.ArgumentConfig{Type: graphql.String},
+					"expectedVersion": &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: r.CreateRechargeSchedule(),
+			},
+
+			// updateRechargeSchedule(id: ID!, amount: Int, cron: String, intervalSeconds: Int, enabled: Boolean, expectedVersion: Int): RechargeSchedule
+			"updateRechargeSchedule": &graphql.Field{
+				Type: rechargeScheduleType,
+				Args: graphql.FieldConfigArgument{
+					"id":              &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"amount":          &graphql.ArgumentConfig{Type: graphql.Int},
+					"cron":            &graphql.ArgumentConfig{Type: graphql.String},
+					"intervalSeconds": &graphql.ArgumentConfig{Type: graphql.Int},
+					"enabled":         &graphql.ArgumentConfig{Type: graphql.Boolean},
+					"expectedVersion": &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: r.UpdateRechargeSchedule(),
+			},
+
+			// deleteRechargeSchedule(id: ID!, expectedVersion: Int): Boolean!
+			"deleteRechargeSchedule": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Boolean),
+				Args: graphql.FieldConfigArgument{
+					"id":              &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"expectedVersion": &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: r.DeleteRechargeSchedule(),
+			},
+
+			// freezeUser(id: ID!, expectedVersion: Int): [Account!]! (blocks debits of every coin type)
+			"freezeUser": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(accountType))),
+				Args: graphql.FieldConfigArgument{
+					"id":              &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"expectedVersion": &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: r.audited("freezeUser", r.balancesState, r.FreezeUser()),
+			},
+
+			// unfreezeUser(id: ID!, expectedVersion: Int): [Account!]!
+			"unfreezeUser": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(accountType))),
+				Args: graphql.FieldConfigArgument{
+					"id":              &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"expectedVersion": &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: r.audited("unfreezeUser", r.balancesState, r.UnfreezeUser()),
+			},
+
+			// closeUser(id: ID!, sweepTo: ID, userId: ID, dataId: String, expectedVersion: Int): [Account!]!
+			// (without sweepTo every balance must be zero; userId is required when sweeping)
+			"closeUser": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(accountType))),
+				Args: graphql.FieldConfigArgument{
+					"id":              &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"sweepTo":         &graphql.ArgumentConfig{Type: graphql.ID},
+					"userId":          &graphql.ArgumentConfig{Type: graphql.ID},
+					"dataId":          &graphql.ArgumentConfig{Type: graphql.String},
+					"expectedVersion": &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: r.audited("closeUser", r.balancesState, r.CloseUser()),
+			},
+
+			// deleteUser(id: ID!, expectedVersion: Int): Boolean!
 			"deleteUser": &graphql.Field{
 				Type: graphql.NewNonNull(graphql.Boolean),
 				Args: graphql.FieldConfigArgument{
-					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"id":              &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"expectedVersion": &graphql.ArgumentConfig{Type: graphql.Int},
 				},
-				Resolve: r.DeleteUser(),
+				Resolve: r.audited("deleteUser", r.balancesState, r.DeleteUser()),
 			},
 		},
 	})
 
+	for _, f := range mutation.Fields() {
+		f.Resolve = withErrorCodes(f.Resolve)
+	}
+
 	return graphql.NewSchema(graphql.SchemaConfig{
 		Query:    query,
 		Mutation: mutation,
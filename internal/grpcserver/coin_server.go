@@ -2,6 +2,7 @@ package grpcserver
 
 import (
 	"context"
+	"errors"
 	"strings"
 	"time"
 
@@ -14,10 +15,10 @@ import (
 
 type CoinsServer struct {
 	coinsv1.UnimplementedCoinsServiceServer
-	Store *dbpkg.Store
+	Store dbpkg.AccountStore
 }
 
-func NewCoinsServer(store *dbpkg.Store) *CoinsServer {
+func NewCoinsServer(store dbpkg.AccountStore) *CoinsServer {
 	return &CoinsServer{Store: store}
 }
 
@@ -30,7 +31,7 @@ func (s *CoinsServer) CreateAccount(ctx context.Context, req *coinsv1.CreateRequ
 		v := req.Initial
 		initPtr = &v
 	}
-	acct, err := s.Store.CreateAccount(ctx, req.Id, initPtr)
+	acct, err := s.Store.CreateAccount(ctx, req.Id, req.GetCoinType(), initPtr, nil, nil)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "create: %v", err)
 	}
@@ -50,18 +51,131 @@ func (s *CoinsServer) Deplete(ctx context.Context, req *coinsv1.DepleteRequest)
 	}
 
 	// Pass through to DB; it will validate user_id as UUID and use data_id (optional).
-	acct, err := s.Store.Use(ctx, req.Id, req.Amount, req.GetUserId(), req.GetDataId())
+	acct, err := s.Store.Use(ctx, req.Id, req.GetCoinType(), req.Amount, req.ExpectedVersion, req.GetUserId(), req.GetDataId())
 	if err != nil {
-		switch {
-		case isInsufficient(err):
-			return nil, status.Error(codes.FailedPrecondition, err.Error())
-		default:
-			return nil, status.Errorf(codes.Internal, "deplete: %v", err)
-		}
+		return nil, toStatus("deplete", err)
 	}
 	return toReply(acct), nil
 }
 
+func (s *CoinsServer) Authorize(ctx context.Context, req *coinsv1.AuthorizeRequest) (*coinsv1.HoldReply, error) {
+	if strings.TrimSpace(req.GetId()) == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	if req.Amount <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "amount must be > 0")
+	}
+	if strings.TrimSpace(req.GetUserId()) == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id (UUID) is required")
+	}
+	ttl := time.Duration(req.GetTtlSeconds()) * time.Second
+	hold, err := s.Store.Authorize(ctx, req.Id, req.GetCoinType(), req.Amount, ttl, req.ExpectedVersion, req.GetUserId(), req.GetDataId())
+	if err != nil {
+		return nil, toStatus("authorize", err)
+	}
+	return toHoldReply(hold, nil), nil
+}
+
+func (s *CoinsServer) Capture(ctx context.Context, req *coinsv1.CaptureRequest) (*coinsv1.HoldReply, error) {
+	if strings.TrimSpace(req.GetHoldId()) == "" {
+		return nil, status.Error(codes.InvalidArgument, "hold_id is required")
+	}
+	if req.Amount < 0 {
+		return nil, status.Error(codes.InvalidArgument, "amount must be >= 0")
+	}
+	if strings.TrimSpace(req.GetUserId()) == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id (UUID) is required")
+	}
+	var amtPtr *int64
+	if req.Amount != 0 {
+		v := req.Amount
+		amtPtr = &v
+	}
+	hold, acct, err := s.Store.Capture(ctx, req.HoldId, amtPtr, req.ExpectedVersion, req.GetUserId(), req.GetDataId())
+	if err != nil {
+		return nil, toStatus("capture", err)
+	}
+	return toHoldReply(hold, acct), nil
+}
+
+func (s *CoinsServer) Void(ctx context.Context, req *coinsv1.VoidRequest) (*coinsv1.HoldReply, error) {
+	if strings.TrimSpace(req.GetHoldId()) == "" {
+		return nil, status.Error(codes.InvalidArgument, "hold_id is required")
+	}
+	hold, err := s.Store.Void(ctx, req.HoldId, req.ExpectedVersion)
+	if err != nil {
+		return nil, toStatus("void", err)
+	}
+	return toHoldReply(hold, nil), nil
+}
+
+func (s *CoinsServer) MultiTransfer(ctx context.Context, req *coinsv1.MultiTransferRequest) (*coinsv1.MultiTransferReply, error) {
+	if len(req.GetLegs()) < 2 {
+		return nil, status.Error(codes.InvalidArgument, "at least two legs are required")
+	}
+	if strings.TrimSpace(req.GetUserId()) == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id (UUID) is required")
+	}
+	legs := make([]dbpkg.TransferLeg, 0, len(req.Legs))
+	for _, l := range req.Legs {
+		legs = append(legs, dbpkg.TransferLeg{AccountID: l.GetAccountId(), Amount: l.GetAmount(), ExpectedVersion: l.ExpectedVersion})
+	}
+	accts, err := s.Store.MultiTransfer(ctx, req.GetCoinType(), legs, req.GetUserId(), req.GetDataId())
+	if err != nil {
+		return nil, toStatus("transferMulti", err)
+	}
+	out := &coinsv1.MultiTransferReply{Accounts: make([]*coinsv1.AccountReply, 0, len(accts))}
+	for _, a := range accts {
+		out.Accounts = append(out.Accounts, toReply(a))
+	}
+	return out, nil
+}
+
+func (s *CoinsServer) Reverse(ctx context.Context, req *coinsv1.ReverseRequest) (*coinsv1.ReverseReply, error) {
+	if strings.TrimSpace(req.GetDataId()) == "" {
+		return nil, status.Error(codes.InvalidArgument, "data_id is required")
+	}
+	if strings.TrimSpace(req.GetUserId()) == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id (UUID) is required")
+	}
+	rev, err := s.Store.ReverseTransaction(ctx, req.DataId, req.Amount, req.GetAccountId(), req.ExpectedVersion, req.GetUserId())
+	if err != nil {
+		return nil, toStatus("reverse", err)
+	}
+	out := &coinsv1.ReverseReply{
+		OriginalLedgerId: rev.Original.ID,
+		Amount:           rev.Amount,
+		TotalReversed:    rev.TotalReversed,
+		Remaining:        rev.Remaining,
+		DataId:           rev.DataID,
+		Account:          toReply(rev.Account),
+	}
+	if rev.Counterparty != nil {
+		out.Counterparty = toReply(rev.Counterparty)
+	}
+	return out, nil
+}
+
+// toStatus maps Store errors onto gRPC status codes.
+func toStatus(op string, err error) error {
+	switch {
+	case isInsufficient(err), errors.Is(err, dbpkg.ErrHoldNotActive),
+		errors.Is(err, dbpkg.ErrAccountFrozen), errors.Is(err, dbpkg.ErrAccountClosed),
+		errors.Is(err, dbpkg.ErrReversalExceedsOriginal):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, dbpkg.ErrSpendLimitExceeded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, dbpkg.ErrVersionConflict):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, dbpkg.ErrIdempotencyConflict):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case strings.Contains(err.Error(), "not found"):
+		return status.Error(codes.NotFound, err.Error())
+	default:
+		return status.Errorf(codes.Internal, "%s: %v", op, err)
+	}
+}
+
 func toReply(a *dbpkg.Account) *coinsv1.AccountReply {
 	if a == nil {
 		return &coinsv1.AccountReply{}
@@ -75,10 +189,35 @@ func toReply(a *dbpkg.Account) *coinsv1.AccountReply {
 	}
 	return &coinsv1.AccountReply{
 		Id:               a.ID,
+		CoinType:         a.CoinType,
 		Coins:            a.Coins,
+		Version:          a.Version,
+		Status:           a.Status,
+		CreditLimit:      a.CreditLimit,
 		LastRechargeDate: lr,
 		LastUsageDate:    lu,
+		Held:             a.Held,
+		Available:        a.Available,
+	}
+}
+
+func toHoldReply(h *dbpkg.Hold, a *dbpkg.Account) *coinsv1.HoldReply {
+	if h == nil {
+		return &coinsv1.HoldReply{}
+	}
+	out := &coinsv1.HoldReply{
+		Id:        h.ID,
+		AccountId: h.AccountID,
+		CoinType:  h.CoinType,
+		Amount:    h.Amount,
+		Captured:  h.Captured,
+		Status:    h.Status,
+		ExpiresAt: h.ExpiresAt.UTC().Format(time.RFC3339),
+	}
+	if a != nil {
+		out.Account = toReply(a)
 	}
+	return out
 }
 
 func isInsufficient(err error) bool {
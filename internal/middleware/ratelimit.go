@@ -210,6 +210,20 @@ func GraphQLRateLimit(
 	}
 }
 
+// RateLimit returns a middleware that applies one per-client rate limit, counted as api,
+// to every request of a plain HTTP endpoint.
+func RateLimit(rl *RateLimiter, api string, cfg RateCfg) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !rl.limiterFor(rateKey{Client: clientKey(r), API: api}, cfg).Allow() {
+				http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // Small shim to avoid importing io for one-liner.
 type nopCloser struct{ *bytes.Reader }
 func (nopCloser) Close() error { return nil }
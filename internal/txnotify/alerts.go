@@ -0,0 +1,71 @@
+package txnotify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// WebhookAlerts posts account alerts (matches db.AlertNotifier) as JSON to a webhook.
+type WebhookAlerts struct {
+	URL    string
+	Client *http.Client // optional; nil means a client with a 10s timeout
+}
+
+// NewWebhookAlerts returns a WebhookAlerts posting to url.
+func NewWebhookAlerts(url string) *WebhookAlerts {
+	return &WebhookAlerts{URL: url, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// alertEvent is the body of one webhook call.
+type alertEvent struct {
+	Event      string    `json:"event"`
+	EventID    string    `json:"eventId"`
+	UserID     string    `json:"userId"`
+	AccountID  string    `json:"accountId"`
+	CoinType   string    `json:"coinType"`
+	Overdraft  int64     `json:"overdraft"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+func (w *WebhookAlerts) Overdrawn(ctx context.Context, eventID, userID, accountID, coinType string, overdraft int64, ts time.Time) error {
+	return w.post(ctx, alertEvent{
+		Event:      "overdrawn",
+		EventID:    eventID,
+		UserID:     userID,
+		AccountID:  accountID,
+		CoinType:   coinType,
+		Overdraft:  overdraft,
+		OccurredAt: ts.UTC(),
+	})
+}
+
+func (w *WebhookAlerts) post(ctx context.Context, ev alertEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// receivers dedupe retried deliveries on it
+	req.Header.Set("Idempotency-Key", ev.EventID)
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("alert webhook: %s", resp.Status)
+	}
+	return nil
+}
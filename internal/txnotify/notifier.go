@@ -16,7 +16,7 @@ import (
 
 // Notifier is the interface your db.Store expects (matches TxNotifier).
 type Notifier interface {
-	Create(ctx context.Context, userID, dataID, coinID, platformName string, coinUsed float64, ts time.Time, expiry time.Time) error
+	Create(ctx context.Context, userID, dataID, coinID, coinType, platformName string, coinUsed float64, ts time.Time, expiry time.Time) error
 	Close() error
 }
 
@@ -54,7 +54,7 @@ func (n *GRPCNotifier) Close() error {
 	return nil
 }
 
-func (n *GRPCNotifier) Create(ctx context.Context, userID, dataID, coinID, platformName string, coinUsed float64, ts time.Time, expiry time.Time) error {
+func (n *GRPCNotifier) Create(ctx context.Context, userID, dataID, coinID, coinType, platformName string, coinUsed float64, ts time.Time, expiry time.Time) error {
 	if ts.IsZero() {
 		ts = time.Now().UTC()
 	}
@@ -68,14 +68,17 @@ func (n *GRPCNotifier) Create(ctx context.Context, userID, dataID, coinID, platf
 		platformName = n.DefaultPlatform
 	}
 
-	// Idempotency key (optional, helpful if server enforces uniqueness)
-	src := fmt.Sprintf("%s|%s|%s|%.8f|%d", userID, dataID, coinID, coinUsed, ts.UnixNano())
+	// Idempotency key (optional, helpful if server enforces uniqueness).
+	// Derived from the event identity only, so a retried event maps to the same key.
+	src := fmt.Sprintf("%s|%s|%s|%s|%.8f", userID, dataID, coinID, coinType, coinUsed)
 	sum := sha1.Sum([]byte(src))
 	idemKey := hex.EncodeToString(sum[:])
 
 	md := metadata.Pairs(
 		"x-user-id", "coin-service",
 		"x-idempotency-key", idemKey,
+		// CreateTransactionRequest has no coin type field yet
+		"x-coin-type", coinType,
 	)
 	ctx = metadata.NewOutgoingContext(ctx, md)
 
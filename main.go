@@ -2,10 +2,13 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/graphql-go/handler"
@@ -37,31 +40,118 @@ func main() {
 
 	// --- DB setup
 	ctx := context.Background()
-	connURL := mustGetEnv("DATABASE_URL") // uses .env if present
-	store, err := dbpkg.New(ctx, connURL)
-	if err != nil {
-		log.Fatalf("db connect: %v", err)
-	}
-	defer store.Close()
+	var store dbpkg.AccountStore
+	var notifierSlot *dbpkg.TxNotifier
+	var alertsSlot *dbpkg.AlertNotifier
+	if os.Getenv("STORE") == "memory" {
+		// nothing is persisted; for local demos without Postgres
+		mem := dbpkg.NewMemoryStore()
+		store, notifierSlot, alertsSlot = mem, &mem.Notifier, &mem.Alerts
+		log.Printf("WARNING: using the in-memory store; data is lost on exit")
+	} else {
+		connURL := mustGetEnv("DATABASE_URL") // uses .env if present
+		// optional hot standby for read-only queries
+		pg, err := dbpkg.New(ctx, connURL, os.Getenv("DATABASE_REPLICA_URL"))
+		if err != nil {
+			log.Fatalf("db connect: %v", err)
+		}
+		if v := os.Getenv("DATABASE_REPLICA_MAX_LAG"); v != "" {
+			lag, err := time.ParseDuration(v)
+			if err != nil {
+				log.Fatalf("DATABASE_REPLICA_MAX_LAG: %v", err)
+			}
+			pg.MaxReplicaLag = lag
+		}
 
-	if err := store.EnsureSchema(ctx); err != nil {
-		log.Fatalf("ensure schema: %v", err)
+		// `coin-service migrate up|down [n]|status` manages the schema and exits
+		if len(os.Args) > 1 && os.Args[1] == "migrate" {
+			err := runMigrate(ctx, pg, os.Args[2:])
+			pg.Close()
+			if err != nil {
+				log.Fatalf("migrate: %v", err)
+			}
+			return
+		}
+
+		if _, err := pg.MigrateUp(ctx); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+
+		// `coin-service shard <id> <n> [coinType]` splits a hot balance into n sub-balances and exits
+		if len(os.Args) > 1 && os.Args[1] == "shard" {
+			err := runShard(ctx, pg, os.Args[2:])
+			pg.Close()
+			if err != nil {
+				log.Fatalf("shard: %v", err)
+			}
+			return
+		}
+
+		// `coin-service gdpr export|erase <id>` prints an account's data or anonymizes it, and exits
+		if len(os.Args) > 1 && os.Args[1] == "gdpr" {
+			err := runGDPR(ctx, pg, os.Args[2:])
+			pg.Close()
+			if err != nil {
+				log.Fatalf("gdpr: %v", err)
+			}
+			return
+		}
+
+		// `coin-service archive report|run <inactiveFor> [maxCoins]` lists or archives dormant accounts, and exits
+		if len(os.Args) > 1 && os.Args[1] == "archive" {
+			err := runArchive(ctx, pg, os.Args[2:])
+			pg.Close()
+			if err != nil {
+				log.Fatalf("archive: %v", err)
+			}
+			return
+		}
+		store, notifierSlot, alertsSlot = pg, &pg.Notifier, &pg.Alerts
 	}
+	defer store.Close()
 
-	// --- Transactions gRPC notifier (used by db.Store)
+	// --- Transactions gRPC notifier (used by the store); set before any worker reads it
 	txAddr := "localhost:6090"
 	notifier, err := txnotify.NewGRPC(txAddr) // uses grpc.WithInsecure() by default; pass creds in NewGRPC if needed
 	if err != nil {
 		log.Printf("WARNING: transactions notifier disabled (dial %s failed: %v)", txAddr, err)
 	} else {
 		// Optional defaults so you don't pass these every call
-		notifier.DefaultCoinID = "COIN"
+		notifier.DefaultCoinID = dbpkg.DefaultCoinType
 		notifier.DefaultPlatform = "coin-service"
-		store.Notifier = notifier
+		*notifierSlot = notifier
 		defer notifier.Close()
 		log.Printf("transactions notifier connected -> %s", txAddr)
 	}
 
+	// --- Account alerts (overdrafts) go to their own webhook, not the Transactions service
+	if url := os.Getenv("ALERT_WEBHOOK_URL"); url != "" {
+		*alertsSlot = txnotify.NewWebhookAlerts(url)
+		log.Printf("account alerts -> %s", url)
+	}
+
+	// Deliver queued notifications and alerts from the transactional outbox
+	if *notifierSlot != nil || *alertsSlot != nil {
+		go store.RunOutboxDispatcher(ctx, dbpkg.OutboxConfig{})
+	}
+
+	// Release holds that were never captured or voided
+	go store.RunHoldSweeper(ctx, time.Minute)
+
+	// Burn recharged coins whose lot has expired
+	go store.RunLotExpirer(ctx, time.Minute)
+
+	// Snapshot balances so point-in-time lookups don't replay the whole ledger
+	go store.RunBalanceSnapshotter(ctx, time.Hour)
+
+	// Fire recurring recharges; an advisory lock keeps replicas from firing the same run twice
+	go store.RunRechargeScheduler(ctx, time.Minute)
+
+	// Archive dormant accounts daily if ARCHIVE_INACTIVE_AFTER (e.g. 8760h) is set
+	if cfg, ok := archiveConfig(); ok {
+		go store.RunArchiver(ctx, cfg, 24*time.Hour)
+	}
+
 	// --- GraphQL setup
 	resolvers := gqlpkg.NewResolvers(store)
 	resolvers.QueryTimeout = 10 * time.Second
@@ -88,12 +178,52 @@ func main() {
 		"useCoins":      {PerMinute: 60, Burst: 30},
 		"batchRecharge": {PerMinute: 10, Burst: 5},
 		"transferCoins": {PerMinute: 20, Burst: 10},
+
+		"authorizeCoins": {PerMinute: 60, Burst: 30},
+		"captureHold":    {PerMinute: 60, Burst: 30},
+		"voidHold":       {PerMinute: 60, Burst: 30},
+
+		"replayOutboxEntry": {PerMinute: 10, Burst: 5},
+		"exportUserData":    {PerMinute: 5, Burst: 2},
+		"eraseUser":         {PerMinute: 5, Burst: 2},
+
+		"archiveDormantAccounts": {PerMinute: 2, Burst: 1},
 	}
 	rateLimited := mw.GraphQLRateLimit(rl, defaultQueryCfg, defaultMutationCfg, apiOverrides)(gqlHandler)
 
+	// Admin mutations record X-Caller-Id in the audit log, as verified if the authenticating
+	// proxy signed it with CALLER_SECRET (see mw.SignCaller). REQUIRE_SIGNED_CALLER=true
+	// refuses admin mutations and the audit log to callers that aren't.
+	callerSecret := os.Getenv("CALLER_SECRET")
+	if v := os.Getenv("REQUIRE_SIGNED_CALLER"); v != "" {
+		if resolvers.RequireVerifiedCaller, err = strconv.ParseBool(v); err != nil {
+			log.Fatalf("REQUIRE_SIGNED_CALLER: %v", err)
+		}
+		if resolvers.RequireVerifiedCaller && callerSecret == "" {
+			log.Fatalf("REQUIRE_SIGNED_CALLER needs CALLER_SECRET")
+		}
+	}
+	withCaller := mw.WithCaller(callerSecret)
+
 	// --- HTTP routes (GraphQL + health)
 	mux := http.NewServeMux()
-	mux.Handle("/graphql", rateLimited)
+	mux.Handle("/graphql", withCaller(rateLimited))
+	auditExport := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, err := auditFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		if _, err := store.ExportAuditLog(r.Context(), f, w); err != nil {
+			log.Printf("audit export: %v", err)
+		}
+	})
+	var auditHandler http.Handler = mw.RateLimit(rl, "auditExport", mw.RateCfg{PerMinute: 2, Burst: 1})(auditExport)
+	if resolvers.RequireVerifiedCaller {
+		auditHandler = mw.RequireVerifiedCaller(auditHandler)
+	}
+	mux.Handle("/admin/audit.ndjson", withCaller(auditHandler))
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("ok"))
@@ -131,3 +261,182 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// auditFilter reads the operation, caller, outcome, since and until (RFC 3339) query
+// parameters of an audit log export.
+func auditFilter(r *http.Request) (dbpkg.AuditFilter, error) {
+	q := r.URL.Query()
+	f := dbpkg.AuditFilter{Operation: q.Get("operation"), Caller: q.Get("caller"), Outcome: q.Get("outcome")}
+	for name, dst := range map[string]**time.Time{"since": &f.Since, "until": &f.Until} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return f, fmt.Errorf("%s: want an RFC 3339 time, got %q", name, v)
+			}
+			*dst = &t
+		}
+	}
+	return f, nil
+}
+
+// runMigrate implements the migrate subcommand.
+func runMigrate(ctx context.Context, store *dbpkg.Store, args []string) error {
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+	switch cmd {
+	case "up":
+		n, err := store.MigrateUp(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("applied %d migration(s)\n", n)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			v, err := strconv.Atoi(args[1])
+			if err != nil || v <= 0 {
+				return fmt.Errorf("down: steps must be a positive number, got %q", args[1])
+			}
+			steps = v
+		}
+		n, err := store.MigrateDown(ctx, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("rolled back %d migration(s)\n", n)
+	case "status":
+		states, err := store.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		for _, st := range states {
+			state := "pending"
+			if st.Applied {
+				state = "applied " + st.AppliedAt.UTC().Format(time.RFC3339)
+			}
+			if st.Modified {
+				state += " (modified since applied)"
+			}
+			fmt.Printf("%04d  %-24s %s\n", st.Version, st.Name, state)
+		}
+	default:
+		return fmt.Errorf("unknown command %q (want up, down [n] or status)", cmd)
+	}
+	return nil
+}
+
+func runShard(ctx context.Context, store *dbpkg.Store, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: shard <id> <n> [coinType] (n of 0 or 1 unshards)")
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("n must be a number, got %q", args[1])
+	}
+	coinType := ""
+	if len(args) > 2 {
+		coinType = args[2]
+	}
+	acc, err := store.ShardAccount(ctx, args[0], coinType, n)
+	if err != nil {
+		return err
+	}
+	if n > 1 {
+		fmt.Printf("%s %s: %d coins over %d shards\n", acc.ID, acc.CoinType, acc.Coins, n)
+	} else {
+		fmt.Printf("%s %s: %d coins, unsharded\n", acc.ID, acc.CoinType, acc.Coins)
+	}
+	return nil
+}
+
+// runGDPR implements the gdpr subcommand: export writes the account's data as JSON to
+// stdout, erase anonymizes the account and prints its pseudonym.
+func runGDPR(ctx context.Context, store *dbpkg.Store, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: gdpr export|erase <id>")
+	}
+	id := args[1]
+	switch args[0] {
+	case "export":
+		exp, err := store.ExportAccount(ctx, id)
+		if err != nil {
+			return err
+		}
+		if exp == nil {
+			return fmt.Errorf("nothing is stored under %s", id)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(exp)
+	case "erase":
+		e, err := store.EraseAccount(ctx, id)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s erased as %s (%d balances, %d records)\n", id, e.Pseudonym, e.Balances, e.Records)
+	default:
+		return fmt.Errorf("unknown command %q (want export or erase)", args[0])
+	}
+	return nil
+}
+
+// archiveConfig reads the archiver settings: ARCHIVE_INACTIVE_AFTER (a duration; unset
+// disables the archiver), ARCHIVE_MAX_COINS (default 0) and ARCHIVE_DRY_RUN.
+func archiveConfig() (dbpkg.ArchiveConfig, bool) {
+	var cfg dbpkg.ArchiveConfig
+	v := os.Getenv("ARCHIVE_INACTIVE_AFTER")
+	if v == "" {
+		return cfg, false
+	}
+	var err error
+	if cfg.InactiveFor, err = time.ParseDuration(v); err != nil {
+		log.Fatalf("ARCHIVE_INACTIVE_AFTER: %v", err)
+	}
+	if v := os.Getenv("ARCHIVE_MAX_COINS"); v != "" {
+		if cfg.MaxCoins, err = strconv.ParseInt(v, 10, 64); err != nil {
+			log.Fatalf("ARCHIVE_MAX_COINS: %v", err)
+		}
+	}
+	if v := os.Getenv("ARCHIVE_DRY_RUN"); v != "" {
+		if cfg.DryRun, err = strconv.ParseBool(v); err != nil {
+			log.Fatalf("ARCHIVE_DRY_RUN: %v", err)
+		}
+	}
+	return cfg, true
+}
+
+// runArchive implements the archive subcommand: report lists the accounts that would be
+// archived, run archives them.
+func runArchive(ctx context.Context, store *dbpkg.Store, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: archive report|run <inactiveFor> [maxCoins]")
+	}
+	cfg := dbpkg.ArchiveConfig{DryRun: args[0] == "report"}
+	if !cfg.DryRun && args[0] != "run" {
+		return fmt.Errorf("unknown command %q (want report or run)", args[0])
+	}
+	var err error
+	if cfg.InactiveFor, err = time.ParseDuration(args[1]); err != nil {
+		return fmt.Errorf("inactiveFor must be a duration such as 8760h, got %q", args[1])
+	}
+	if len(args) > 2 {
+		if cfg.MaxCoins, err = strconv.ParseInt(args[2], 10, 64); err != nil {
+			return fmt.Errorf("maxCoins must be a number, got %q", args[2])
+		}
+	}
+	rep, err := store.ArchiveDormant(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	for _, b := range rep.Balances {
+		fmt.Printf("%s  %-8s %d\n", b.ID, b.CoinType, b.Coins)
+	}
+	verb := "archived"
+	if rep.DryRun {
+		verb = "would archive"
+	}
+	fmt.Printf("%s %d account(s) idle since %s, %d coins\n", verb, rep.Accounts, rep.Cutoff.Format(time.RFC3339), rep.Coins)
+	return nil
+}
@@ -5,13 +5,21 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/graphql-go/handler"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
 
 	dbpkg "github.com/devifyX/go-back-coin-service/internal/db"
@@ -26,14 +34,32 @@ type gqlResp struct {
 	Errors any            `json:"errors"`
 }
 
+// testCallerSecret signs the X-Caller-Id of test requests; doGQL calls as testCaller.
+const (
+	testCallerSecret = "test-caller-secret"
+	testCaller       = "ops@example.com"
+)
+
 func doGQL(t *testing.T, srv *httptest.Server, query string, vars map[string]any) gqlResp {
+	t.Helper()
+	return postGQL(t, srv, testCaller, mw.SignCaller(testCallerSecret, testCaller), query, vars)
+}
+
+// postGQL sends query as caller with the given signature; an empty caller sends neither header.
+func postGQL(t *testing.T, srv *httptest.Server, caller, signature, query string, vars map[string]any) gqlResp {
 	t.Helper()
 	body := map[string]any{"query": query}
 	if vars != nil {
 		body["variables"] = vars
 	}
 	b, _ := json.Marshal(body)
-	resp, err := http.Post(srv.URL+"/graphql", "application/json", bytes.NewReader(b))
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/graphql", bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	if caller != "" {
+		req.Header.Set(mw.CallerHeader, caller)
+		req.Header.Set(mw.CallerSignatureHeader, signature)
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		t.Fatalf("POST /graphql: %v", err)
 	}
@@ -43,35 +69,91 @@ func doGQL(t *testing.T, srv *httptest.Server, query string, vars map[string]any
 	return out
 }
 
-func setupServer(t *testing.T) (*httptest.Server, *dbpkg.Store) {
+// openPG connects to DATABASE_URL, migrates and empties the schema; nil without a database.
+func openPG(t *testing.T) *dbpkg.Store {
 	t.Helper()
 
 	_ = godotenv.Load() // ok if not present
 
 	conn := os.Getenv("DATABASE_URL")
 	if conn == "" {
-		t.Skip("DATABASE_URL not set; skipping integration test")
+		return nil
 	}
-
 	ctx := context.Background()
-	store, err := dbpkg.New(ctx, conn)
+	pg, err := dbpkg.New(ctx, conn, "")
 	if err != nil {
 		t.Fatalf("db connect: %v", err)
 	}
 
-	if err := store.EnsureSchema(ctx); err != nil {
-		t.Fatalf("ensure schema: %v", err)
+	if _, err := pg.MigrateUp(ctx); err != nil {
+		t.Fatalf("migrate: %v", err)
 	}
 
 	// Clean slate for test run
-	if _, err := store.Pool.Exec(ctx, `TRUNCATE TABLE public.coins`); err != nil {
+	if _, err := pg.Pool.Exec(ctx, `TRUNCATE TABLE public.coins, public.coin_shards, public.archived_coins, public.coin_ledger, public.idempotency_keys, public.tx_outbox, public.coin_holds, public.coin_lots, public.coin_balance_snapshots, public.coin_recharge_schedules, public.coin_spend_limits, public.admin_audit`); err != nil {
 		t.Fatalf("truncate: %v", err)
 	}
+	return pg
+}
+
+// needPG is openPG for tests of Postgres-only behaviour; they are skipped without a database.
+func needPG(t *testing.T) *dbpkg.Store {
+	t.Helper()
+	pg := openPG(t)
+	if pg == nil {
+		t.Skip("DATABASE_URL not set")
+	}
+	return pg
+}
+
+// recordingNotifier records deliveries; during, if set, runs inside each one.
+type recordingNotifier struct {
+	mu     sync.Mutex
+	sent   []string // dataIDs, in delivery order
+	types  []string // coin types, in delivery order
+	during func()
+}
+
+func (n *recordingNotifier) Create(ctx context.Context, userID, dataID, coinID, coinType, platformName string, coinUsed float64, ts time.Time, expiry time.Time) error {
+	if n.during != nil {
+		n.during()
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sent = append(n.sent, dataID)
+	n.types = append(n.types, coinType)
+	return nil
+}
+
+// accountReads counts the queries of a pool that read public.coins.
+type accountReads struct{ n atomic.Int64 }
+
+func (r *accountReads) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if strings.Contains(data.SQL, "public.coins") {
+		r.n.Add(1)
+	}
+	return ctx
+}
+
+func (r *accountReads) TraceQueryEnd(context.Context, *pgx.Conn, pgx.TraceQueryEndData) {}
+
+// setupServer serves the GraphQL API; opts adjust the resolvers.
+func setupServer(t *testing.T, opts ...func(*gqlpkg.Resolvers)) (*httptest.Server, dbpkg.AccountStore) {
+	t.Helper()
+
+	// Without a database the API runs against the in-memory store
+	var store dbpkg.AccountStore = dbpkg.NewMemoryStore()
+	if pg := openPG(t); pg != nil {
+		store = pg
+	}
 
 	// Build GraphQL schema + handler
 	resolvers := gqlpkg.NewResolvers(store)
 	resolvers.QueryTimeout = 10 * time.Second
 	resolvers.MutationTimeout = 10 * time.Second
+	for _, opt := range opts {
+		opt(resolvers)
+	}
 
 	schema, err := gqlpkg.NewSchema(resolvers)
 	if err != nil {
@@ -92,7 +174,7 @@ func setupServer(t *testing.T) (*httptest.Server, *dbpkg.Store) {
 	rateLimited := mw.GraphQLRateLimit(rl, defaultQueryCfg, defaultMutationCfg, apiOverrides)(gqlHandler)
 
 	mux := http.NewServeMux()
-	mux.Handle("/graphql", rateLimited)
+	mux.Handle("/graphql", mw.WithCaller(testCallerSecret)(rateLimited))
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("ok"))
@@ -118,6 +200,21 @@ func TestGraphQL_AllAPIs(t *testing.T) {
 	_ = doGQL(t, srv, create, map[string]any{"id": "u1", "coins": 100})
 	_ = doGQL(t, srv, create, map[string]any{"id": "u2", "coins": 50})
 
+	// 1b) a second coin type on u1 is a separate balance
+	promo := doGQL(t, srv, `mutation($id:ID!,$coins:Int,$ct:String){
+	  createUser(id:$id, coins:$coins, coinType:$ct){ id coinType coins }
+	}`, map[string]any{"id": "u1", "coins": 20, "ct": "PROMO"})
+	if promo.Data == nil || promo.Data["createUser"] == nil {
+		t.Fatalf("expected createUser data for PROMO")
+	}
+	bals := doGQL(t, srv, `query($id:ID!){ getUser(id:$id){ id balances{ coinType coins } } }`, map[string]any{"id": "u1"})
+	if bals.Data == nil || bals.Data["getUser"] == nil {
+		t.Fatalf("expected balances data")
+	}
+	if got := bals.Data["getUser"].(map[string]any)["balances"].([]any); len(got) != 2 {
+		t.Fatalf("expected 2 balances for u1, got %d", len(got))
+	}
+
 	// 2) getUser
 	getUser := `query($id:ID!){ getUser(id:$id){ id coins lastRechargeDate lastUsageDate } }`
 	res := doGQL(t, srv, getUser, map[string]any{"id": "u1"})
@@ -125,12 +222,21 @@ func TestGraphQL_AllAPIs(t *testing.T) {
 		t.Fatalf("expected getUser data, got: %#v", res)
 	}
 
-	// 3) listUsers
-	list := `query{ listUsers(limit:10, offset:0){ id coins } }`
+	// 3) listUsers, two pages of two
+	list := `query($after:String){ listUsers(first:2, after:$after){ edges{ cursor node{ id coins } } pageInfo{ hasNextPage endCursor } totalCount } }`
 	lr := doGQL(t, srv, list, nil)
 	if lr.Data == nil || lr.Data["listUsers"] == nil {
 		t.Fatalf("expected listUsers data")
 	}
+	conn := lr.Data["listUsers"].(map[string]any)
+	info := conn["pageInfo"].(map[string]any)
+	if len(conn["edges"].([]any)) != 2 || info["hasNextPage"] != true || conn["totalCount"].(float64) != 3 {
+		t.Fatalf("expected first page of 2 out of 3, got %#v", conn)
+	}
+	lr2 := doGQL(t, srv, list, map[string]any{"after": info["endCursor"]})
+	if lr2.Data == nil || len(lr2.Data["listUsers"].(map[string]any)["edges"].([]any)) != 1 {
+		t.Fatalf("expected last page of 1, got %#v", lr2)
+	}
 
 	// 4) getBalance
 	bal := doGQL(t, srv, `query($id:ID!){ getBalance(id:$id) }`, map[string]any{"id": "u1"})
@@ -139,43 +245,107 @@ func TestGraphQL_AllAPIs(t *testing.T) {
 	}
 
 	// 5) rechargeCoins u2 +25
-	rec := doGQL(t, srv, `mutation($id:ID!,$amt:Int!){ rechargeCoins(id:$id, amount:$amt){ id coins lastRechargeDate } }`,
-		map[string]any{"id": "u2", "amt": 25})
+	rec := doGQL(t, srv, `mutation($id:ID!,$amt:Int!,$uid:ID!){ rechargeCoins(id:$id, amount:$amt, userId:$uid){ id coins lastRechargeDate } }`,
+		map[string]any{"id": "u2", "amt": 25, "uid": "6f1c2f4e-8a3b-4d7e-9c1a-2b3c4d5e6f70"})
 	if rec.Data == nil || rec.Data["rechargeCoins"] == nil {
 		t.Fatalf("expected rechargeCoins data")
 	}
 
+	// 5b) recharge with an expiry shows up in the account's upcoming expirations
+	exp := time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339)
+	rx := doGQL(t, srv, `mutation($id:ID!,$amt:Int!,$uid:ID!,$e:DateTime){
+	  rechargeCoins(id:$id, amount:$amt, userId:$uid, expiresAt:$e){ id coins expirations{ amount remaining expiresAt } }
+	}`, map[string]any{"id": "u2", "amt": 3, "uid": "6f1c2f4e-8a3b-4d7e-9c1a-2b3c4d5e6f70", "e": exp})
+	if rx.Data == nil || rx.Data["rechargeCoins"] == nil {
+		t.Fatalf("expected rechargeCoins with expiresAt data")
+	}
+
 	// 6) useCoins u1 -10
-	use := doGQL(t, srv, `mutation($id:ID!,$amt:Int!){ useCoins(id:$id, amount:$amt){ id coins lastUsageDate } }`,
-		map[string]any{"id": "u1", "amt": 10})
+	use := doGQL(t, srv, `mutation($id:ID!,$amt:Int!,$uid:ID!){ useCoins(id:$id, amount:$amt, userId:$uid){ id coins lastUsageDate } }`,
+		map[string]any{"id": "u1", "amt": 10, "uid": "6f1c2f4e-8a3b-4d7e-9c1a-2b3c4d5e6f70"})
 	if use.Data == nil || use.Data["useCoins"] == nil {
 		t.Fatalf("expected useCoins data")
 	}
 
+	// 6b) useCoins retried with the same dataId is applied once
+	useKeyed := `mutation($id:ID!,$amt:Int!,$uid:ID!,$d:String){ useCoins(id:$id, amount:$amt, userId:$uid, dataId:$d){ id coins } }`
+	vars := map[string]any{"id": "u1", "amt": 1, "uid": "6f1c2f4e-8a3b-4d7e-9c1a-2b3c4d5e6f70", "d": "order:idem-1"}
+	first := doGQL(t, srv, useKeyed, vars)
+	retry := doGQL(t, srv, useKeyed, vars)
+	if first.Data == nil || retry.Data == nil || first.Data["useCoins"] == nil || retry.Data["useCoins"] == nil {
+		t.Fatalf("expected useCoins data on first call and retry")
+	}
+	c1 := first.Data["useCoins"].(map[string]any)["coins"]
+	c2 := retry.Data["useCoins"].(map[string]any)["coins"]
+	if c1 != c2 {
+		t.Fatalf("retry with same dataId applied twice: %v then %v", c1, c2)
+	}
+	vars["amt"] = 2
+	if conflict := doGQL(t, srv, useKeyed, vars); conflict.Errors == nil {
+		t.Fatalf("expected idempotency conflict for different payload")
+	}
+
+	// 6c) authorize a hold on u1, capture part of it, void another
+	auth := `mutation($id:ID!,$amt:Int!,$uid:ID!){ authorizeCoins(id:$id, amount:$amt, ttlSeconds:60, userId:$uid){ id status amount } }`
+	h1 := doGQL(t, srv, auth, map[string]any{"id": "u1", "amt": 5, "uid": vars["uid"]})
+	if h1.Data == nil || h1.Data["authorizeCoins"] == nil {
+		t.Fatalf("expected authorizeCoins data")
+	}
+	held := doGQL(t, srv, `query($id:ID!){ getUser(id:$id){ coins held available } }`, map[string]any{"id": "u1"})
+	if held.Data == nil || held.Data["getUser"] == nil {
+		t.Fatalf("expected held/available data")
+	}
+	holdID := h1.Data["authorizeCoins"].(map[string]any)["id"]
+	cp := doGQL(t, srv, `mutation($h:ID!,$uid:ID!){ captureHold(holdId:$h, amount:3, userId:$uid){ hold{ status captured } account{ coins held } } }`,
+		map[string]any{"h": holdID, "uid": vars["uid"]})
+	if cp.Data == nil || cp.Data["captureHold"] == nil {
+		t.Fatalf("expected captureHold data")
+	}
+	h2 := doGQL(t, srv, auth, map[string]any{"id": "u1", "amt": 1, "uid": vars["uid"]})
+	if h2.Data == nil || h2.Data["authorizeCoins"] == nil {
+		t.Fatalf("expected second authorizeCoins data")
+	}
+	vd := doGQL(t, srv, `mutation($h:ID!){ voidHold(holdId:$h){ id status } }`,
+		map[string]any{"h": h2.Data["authorizeCoins"].(map[string]any)["id"]})
+	if vd.Data == nil || vd.Data["voidHold"] == nil {
+		t.Fatalf("expected voidHold data")
+	}
+
 	// 7) transferCoins 40 u1 -> u2
-	tr := doGQL(t, srv, `mutation($f:ID!,$t:ID!,$a:Int!){
-	  transferCoins(fromId:$f, toId:$t, amount:$a){ from{ id coins } to{ id coins } }
-	}`, map[string]any{"f": "u1", "t": "u2", "a": 40})
+	tr := doGQL(t, srv, `mutation($f:ID!,$t:ID!,$a:Int!,$uid:ID!){
+	  transferCoins(fromId:$f, toId:$t, amount:$a, userId:$uid){ from{ id coins } to{ id coins } }
+	}`, map[string]any{"f": "u1", "t": "u2", "a": 40, "uid": vars["uid"]})
 	if tr.Data == nil || tr.Data["transferCoins"] == nil {
 		t.Fatalf("expected transferCoins data")
 	}
 
-	// 8) batchRecharge +5 for u1,u2
-	br := doGQL(t, srv, `mutation($ids:[ID!]!,$amt:Int!){
-	  batchRecharge(ids:$ids, amount:$amt)
-	}`, map[string]any{"ids": []string{"u1", "u2"}, "amt": 5})
+	// 8) batchRecharge +5 for u1,u2; an unknown id is reported, not credited
+	br := doGQL(t, srv, `mutation($ids:[ID!]!,$amt:Int!,$uid:ID!){
+	  batchRecharge(ids:$ids, amount:$amt, userId:$uid, items:[{id:"nobody", amount:3}]){ id status coins }
+	}`, map[string]any{"ids": []string{"u1", "u2"}, "amt": 5, "uid": vars["uid"]})
 	if br.Data == nil || br.Data["batchRecharge"] == nil {
 		t.Fatalf("expected batchRecharge data")
 	}
+	if items := br.Data["batchRecharge"].([]any); len(items) != 3 || items[2].(map[string]any)["status"] != "not_found" {
+		t.Fatalf("expected per-item batchRecharge results, got %#v", items)
+	}
 
 	// 9) setCoins u2 = 7
-	sc := doGQL(t, srv, `mutation($id:ID!,$c:Int!){
-	  setCoins(id:$id, coins:$c){ id coins }
-	}`, map[string]any{"id": "u2", "c": 7})
+	sc := doGQL(t, srv, `mutation($id:ID!,$c:Int!,$uid:ID!){
+	  setCoins(id:$id, coins:$c, userId:$uid){ id coins }
+	}`, map[string]any{"id": "u2", "c": 7, "uid": vars["uid"]})
 	if sc.Data == nil || sc.Data["setCoins"] == nil {
 		t.Fatalf("expected setCoins data")
 	}
 
+	// 9a) setCoins with a stale expectedVersion is rejected
+	stale := doGQL(t, srv, `mutation($id:ID!,$c:Int!,$v:Int,$uid:ID!){
+	  setCoins(id:$id, coins:$c, expectedVersion:$v, userId:$uid){ id coins version }
+	}`, map[string]any{"id": "u2", "c": 9, "v": 1, "uid": vars["uid"]})
+	if stale.Errors == nil {
+		t.Fatalf("expected version conflict for stale setCoins")
+	}
+
 	// 10) touchUsage u1
 	tu := doGQL(t, srv, `mutation($id:ID!){
 	  touchUsage(id:$id){ id lastUsageDate }
@@ -186,7 +356,7 @@ func TestGraphQL_AllAPIs(t *testing.T) {
 
 	// 11) getUsersByCoinsRange(min:5)
 	cr := doGQL(t, srv, `query{
-	  getUsersByCoinsRange(min:5){ id coins }
+	  getUsersByCoinsRange(min:5){ edges{ node{ id coins } } }
 	}`, nil)
 	if cr.Data == nil || cr.Data["getUsersByCoinsRange"] == nil {
 		t.Fatalf("expected getUsersByCoinsRange data")
@@ -195,7 +365,7 @@ func TestGraphQL_AllAPIs(t *testing.T) {
 	// 12) getRecentRecharges(since: now - 2h)
 	since := time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339)
 	rr := doGQL(t, srv, `query($s:DateTime!){
-	  getRecentRecharges(since:$s){ id lastRechargeDate }
+	  getRecentRecharges(since:$s){ edges{ node{ id lastRechargeDate } } }
 	}`, map[string]any{"s": since})
 	if rr.Data == nil || rr.Data["getRecentRecharges"] == nil {
 		t.Fatalf("expected getRecentRecharges data")
@@ -204,7 +374,7 @@ func TestGraphQL_AllAPIs(t *testing.T) {
 	// 13) getInactiveSince(before: now + 1s)
 	before := time.Now().Add(1 * time.Second).UTC().Format(time.RFC3339)
 	ina := doGQL(t, srv, `query($b:DateTime!){
-	  getInactiveSince(before:$b){ id }
+	  getInactiveSince(before:$b, first:10){ edges{ node{ id } } pageInfo{ hasNextPage } }
 	}`, map[string]any{"b": before})
 	if ina.Data == nil || ina.Data["getInactiveSince"] == nil {
 		t.Fatalf("expected getInactiveSince data")
@@ -216,15 +386,994 @@ func TestGraphQL_AllAPIs(t *testing.T) {
 		t.Fatalf("expected stats data")
 	}
 
+	// 14b) point-in-time totals match the live ones
+	now := time.Now().Add(time.Second).UTC().Format(time.RFC3339)
+	asOf := doGQL(t, srv, `query($at:DateTime!){
+	  totalCoinsAsOf(at:$at)
+	  getUserAsOf(id:"u2", at:$at){ id coins }
+	}`, map[string]any{"at": now})
+	if asOf.Data == nil || asOf.Data["getUserAsOf"] == nil {
+		t.Fatalf("expected as-of data")
+	}
+	if asOf.Data["totalCoinsAsOf"] != stats.Data["totalCoins"] {
+		t.Fatalf("totalCoinsAsOf = %v, want %v", asOf.Data["totalCoinsAsOf"], stats.Data["totalCoins"])
+	}
+
 	// 15) existsUser
 	ex := doGQL(t, srv, `query{ existsUser(id:"u1") }`, nil)
 	if ex.Data == nil || ex.Data["existsUser"] == nil {
 		t.Fatalf("expected existsUser data")
 	}
 
+	// 15b) outboxEntries (admin)
+	ob := doGQL(t, srv, `query{ outboxEntries(first:10){ id dataId status attempts } }`, nil)
+	if ob.Data == nil || ob.Data["outboxEntries"] == nil {
+		t.Fatalf("expected outboxEntries data")
+	}
+
+	// 15b2) with a credit limit u1 may go below zero and shows up as overdrawn
+	if lim := doGQL(t, srv, `mutation{ setCreditLimit(id:"u1", limit:1000){ id creditLimit available } }`, nil); lim.Data == nil || lim.Data["setCreditLimit"] == nil {
+		t.Fatalf("expected setCreditLimit data")
+	}
+	od := doGQL(t, srv, useKeyed, map[string]any{"id": "u1", "amt": 500, "uid": vars["uid"], "d": "order:overdraft-1"})
+	if od.Data == nil || od.Data["useCoins"] == nil {
+		t.Fatalf("expected useCoins within credit limit, got %#v", od.Errors)
+	}
+	ov := doGQL(t, srv, `query{ overdrawnAccounts{ id coins } }`, nil)
+	if ov.Data == nil || len(ov.Data["overdrawnAccounts"].([]any)) != 1 {
+		t.Fatalf("expected u1 overdrawn, got %#v", ov.Data)
+	}
+
+	// 15b3) a per-account velocity cap rejects the debit that would exceed it
+	if sl := doGQL(t, srv, `mutation{ setSpendLimit(id:"u1", windowSeconds:3600, maxCount:1){ accountId windowSeconds maxCount } }`, nil); sl.Data == nil || sl.Data["setSpendLimit"] == nil {
+		t.Fatalf("expected setSpendLimit data")
+	}
+	if capped := doGQL(t, srv, useKeyed, map[string]any{"id": "u1", "amt": 1, "uid": vars["uid"], "d": "order:capped-1"}); capped.Errors == nil {
+		t.Fatalf("expected spend limit to reject useCoins")
+	}
+	_ = doGQL(t, srv, `mutation{ clearSpendLimit(id:"u1", windowSeconds:3600){ windowSeconds } }`, nil)
+
+	// 15c) a frozen account cannot spend; closing sweeps its balance to u2
+	_ = doGQL(t, srv, create, map[string]any{"id": "u3", "coins": 10})
+	if fr := doGQL(t, srv, `mutation{ freezeUser(id:"u3"){ id status } }`, nil); fr.Data == nil || fr.Data["freezeUser"] == nil {
+		t.Fatalf("expected freezeUser data")
+	}
+	if spend := doGQL(t, srv, useKeyed, map[string]any{"id": "u3", "amt": 1, "uid": vars["uid"], "d": "order:frozen-1"}); spend.Errors == nil {
+		t.Fatalf("expected frozen account to reject useCoins")
+	}
+	frozen := doGQL(t, srv, `query{ listUsers(status:"frozen"){ totalCount } }`, nil)
+	if frozen.Data == nil || frozen.Data["listUsers"].(map[string]any)["totalCount"].(float64) != 1 {
+		t.Fatalf("expected one frozen account, got %#v", frozen.Data)
+	}
+	_ = doGQL(t, srv, `mutation{ unfreezeUser(id:"u3"){ id status } }`, nil)
+	cl := doGQL(t, srv, `mutation($uid:ID){ closeUser(id:"u3", sweepTo:"u2", userId:$uid){ id status coins } }`, map[string]any{"uid": vars["uid"]})
+	if cl.Data == nil || cl.Data["closeUser"] == nil {
+		t.Fatalf("expected closeUser data, got %#v", cl.Errors)
+	}
+
+	// 15d) labels set on create and update can be selected on
+	_ = doGQL(t, srv, `mutation{ createUser(id:"u4", metadata:{tier:"gold", seats:3}, labels:[{key:"plan", value:"pro"}, {key:"region", value:"us"}]){ id } }`, nil)
+	_ = doGQL(t, srv, `mutation{ createUser(id:"u5", labels:[{key:"plan", value:"pro"}]){ id } }`, nil)
+	if um := doGQL(t, srv, `mutation{ updateUserMetadata(id:"u5", labels:[{key:"region", value:"eu"}], merge:true){ id metadata labels{ key value } } }`, nil); um.Data == nil || um.Data["updateUserMetadata"] == nil {
+		t.Fatalf("expected updateUserMetadata data, got %#v", um.Errors)
+	}
+	sel := doGQL(t, srv, `query{ listUsers(labelSelector:"plan=pro,region!=eu"){ edges{ node{ id metadata } } } }`, nil)
+	if sel.Data == nil || len(sel.Data["listUsers"].(map[string]any)["edges"].([]any)) != 1 {
+		t.Fatalf("expected only u4 to match the selector, got %#v", sel.Data)
+	}
+
+	// 15e) one payer split across two payees in a single balanced transfer
+	split := doGQL(t, srv, `mutation($uid:ID!){ transferMulti(userId:$uid, dataId:"order:split-1", legs:[
+	  {accountId:"u1", amount:-10}, {accountId:"u4", amount:9}, {accountId:"u5", amount:1}
+	]){ id coins } }`, map[string]any{"uid": vars["uid"]})
+	if split.Data == nil || len(split.Data["transferMulti"].([]any)) != 3 {
+		t.Fatalf("expected transferMulti data, got %#v", split.Errors)
+	}
+	if unbalanced := doGQL(t, srv, `mutation($uid:ID!){ transferMulti(userId:$uid, legs:[{accountId:"u1", amount:-2}, {accountId:"u4", amount:1}]){ id } }`, map[string]any{"uid": vars["uid"]}); unbalanced.Errors == nil {
+		t.Fatalf("expected unbalanced legs to be rejected")
+	}
+
+	// 15f) a due recurring recharge fires once and moves to its next run
+	past := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	sch := doGQL(t, srv, `mutation($uid:ID!,$at:DateTime){
+	  createRechargeSchedule(accountId:"u4", amount:30, userId:$uid, intervalSeconds:3600, startAt:$at){ id nextRunAt }
+	}`, map[string]any{"uid": vars["uid"], "at": past})
+	if sch.Data == nil || sch.Data["createRechargeSchedule"] == nil {
+		t.Fatalf("expected createRechargeSchedule data, got %#v", sch.Errors)
+	}
+	if n, err := store.FireDueSchedules(context.Background(), 0); err != nil || n != 1 {
+		t.Fatalf("expected one schedule to fire, got %d (%v)", n, err)
+	}
+	if n, _ := store.FireDueSchedules(context.Background(), 0); n != 0 {
+		t.Fatalf("expected the schedule not to fire again, got %d", n)
+	}
+	if bad := doGQL(t, srv, `mutation($uid:ID!){ createRechargeSchedule(accountId:"u4", amount:1, userId:$uid, cron:"0 0 30 2 *"){ id } }`, map[string]any{"uid": vars["uid"]}); bad.Errors == nil {
+		t.Fatalf("expected a cron that never fires to be rejected")
+	}
+
+	// 15g) a use can be refunded by its dataId, but not twice
+	rev := doGQL(t, srv, `mutation($uid:ID!){ reverseTransaction(dataId:"order:idem-1", userId:$uid){ amount remaining original{ kind } account{ id } } }`, map[string]any{"uid": vars["uid"]})
+	if rev.Data == nil || rev.Data["reverseTransaction"] == nil {
+		t.Fatalf("expected reverseTransaction data, got %#v", rev.Errors)
+	}
+	if again := doGQL(t, srv, `mutation($uid:ID!){ reverseTransaction(dataId:"order:idem-1", userId:$uid){ amount } }`, map[string]any{"uid": vars["uid"]}); again.Errors == nil {
+		t.Fatalf("expected a second full reversal to be rejected")
+	}
+
+	// 15h) a shared wallet member spends its own coins, then draws from the parent up to its allowance
+	_ = doGQL(t, srv, create, map[string]any{"id": "fam", "coins": 100})
+	_ = doGQL(t, srv, create, map[string]any{"id": "kid", "coins": 5})
+	if sp := doGQL(t, srv, `mutation{ setParent(id:"kid", parentId:"fam"){ id parentId } }`, nil); sp.Data == nil || sp.Data["setParent"] == nil {
+		t.Fatalf("expected setParent data, got %#v", sp.Errors)
+	}
+	if cyc := doGQL(t, srv, `mutation{ setParent(id:"fam", parentId:"kid"){ id } }`, nil); cyc.Errors == nil {
+		t.Fatalf("expected a parent cycle to be rejected")
+	}
+	_ = doGQL(t, srv, `mutation{ setPoolAllowance(id:"kid", allowance:20){ id poolAllowance } }`, nil)
+	pool := doGQL(t, srv, useKeyed, map[string]any{"id": "kid", "amt": 15, "uid": vars["uid"], "d": "order:pool-1"})
+	if pool.Data == nil || pool.Data["useCoins"] == nil || pool.Data["useCoins"].(map[string]any)["coins"].(float64) != 0 {
+		t.Fatalf("expected kid to spend 5 own + 10 pooled coins, got %#v", pool)
+	}
+	if over := doGQL(t, srv, useKeyed, map[string]any{"id": "kid", "amt": 11, "uid": vars["uid"], "d": "order:pool-2"}); over.Errors == nil {
+		t.Fatalf("expected a draw beyond the allowance to be rejected")
+	}
+	tot := doGQL(t, srv, `query{ hierarchyTotals(id:"fam"){ accounts coins } getUser(id:"fam"){ coins members{ id } } }`, nil)
+	if tot.Data == nil || tot.Data["hierarchyTotals"] == nil {
+		t.Fatalf("expected hierarchyTotals data, got %#v", tot.Errors)
+	}
+	if ht := tot.Data["hierarchyTotals"].(map[string]any); ht["accounts"].(float64) != 2 || ht["coins"].(float64) != 90 {
+		t.Fatalf("expected 2 accounts holding 90 coins, got %#v", ht)
+	}
+
 	// 16) deleteUser u2
 	del := doGQL(t, srv, `mutation{ deleteUser(id:"u2") }`, nil)
 	if del.Data == nil || del.Data["deleteUser"] == nil {
 		t.Fatalf("expected deleteUser data")
 	}
+
+	// 17) admin mutations leave an audit trail with their before and after state, newest first
+	al := doGQL(t, srv, `query{ auditLog(filter:{operation:"setCoins"}, first:2){ edges{ node{ caller outcome before after } } } }`, nil)
+	if al.Data == nil || al.Data["auditLog"] == nil {
+		t.Fatalf("expected auditLog data, got %#v", al.Errors)
+	}
+	entries := al.Data["auditLog"].(map[string]any)["edges"].([]any)
+	if len(entries) != 2 || entries[0].(map[string]any)["node"].(map[string]any)["outcome"] != "error" {
+		t.Fatalf("expected the stale setCoins to be audited as an error, got %#v", entries)
+	}
+	if e := entries[1].(map[string]any)["node"].(map[string]any); e["caller"] != testCaller || e["outcome"] != "ok" || e["after"].(map[string]any)["coins"].(float64) != 7 {
+		t.Fatalf("expected the setCoins entry to record its caller and result, got %#v", e)
+	}
+
+	// 18) a deleted account's history is anonymized without changing totals
+	totalsBefore := doGQL(t, srv, `query{ totalCoins }`, nil).Data["totalCoins"]
+	if open := doGQL(t, srv, `mutation{ eraseUser(id:"u1"){ pseudonym } }`, nil); open.Errors == nil {
+		t.Fatalf("expected erasing an open account to be rejected")
+	}
+	er := doGQL(t, srv, `mutation{ eraseUser(id:"u2"){ pseudonym records } }`, nil)
+	if er.Data == nil || er.Data["eraseUser"] == nil {
+		t.Fatalf("expected eraseUser data, got %#v", er.Errors)
+	}
+	pseudonym := er.Data["eraseUser"].(map[string]any)["pseudonym"].(string)
+	dump := doGQL(t, srv, `mutation($id:ID!){ gone: exportUserData(id:"u2") kept: exportUserData(id:$id) }`, map[string]any{"id": pseudonym})
+	if dump.Data == nil || dump.Data["gone"] != nil || len(dump.Data["kept"].(map[string]any)["ledger"].([]any)) == 0 {
+		t.Fatalf("expected u2's history to move to its pseudonym, got %#v", dump)
+	}
+	if totalsAfter := doGQL(t, srv, `query{ totalCoins }`, nil).Data["totalCoins"]; totalsAfter != totalsBefore {
+		t.Fatalf("expected totalCoins to stay %v after erasure, got %v", totalsBefore, totalsAfter)
+	}
+}
+
+// Admin mutations record their caller as claimed, and refuse unsigned ones once enforcement is on.
+func TestGraphQL_AuditCaller(t *testing.T) {
+	logQ := `query{ auditLog(filter:{operation:"freezeUser"}){ edges{ node{ caller callerVerified outcome before after } } } }`
+	freeze := `mutation{ freezeUser(id:"a1"){ status } }`
+	auditOf := func(t *testing.T, r gqlResp) []any {
+		t.Helper()
+		if r.Data == nil || r.Data["auditLog"] == nil {
+			t.Fatalf("expected auditLog data, got %#v", r.Errors)
+		}
+		return r.Data["auditLog"].(map[string]any)["edges"].([]any)
+	}
+
+	t.Run("recorded by default", func(t *testing.T) {
+		srv, store := setupServer(t)
+		defer srv.Close()
+		defer store.Close()
+
+		_ = doGQL(t, srv, `mutation{ createUser(id:"a1", coins:10){ id } }`, nil)
+		if r := postGQL(t, srv, "mallory", "", freeze, nil); r.Errors != nil {
+			t.Fatalf("expected an unsigned freezeUser to run, got %#v", r.Errors)
+		}
+		entries := auditOf(t, postGQL(t, srv, "", "", logQ, nil))
+		if len(entries) != 1 {
+			t.Fatalf("expected one audited freezeUser, got %#v", entries)
+		}
+		if e := entries[0].(map[string]any)["node"].(map[string]any); e["caller"] != "mallory" || e["callerVerified"] != false {
+			t.Fatalf("expected the claimed caller to be recorded as unverified, got %#v", e)
+		}
+	})
+
+	t.Run("enforced", func(t *testing.T) {
+		srv, store := setupServer(t, func(r *gqlpkg.Resolvers) { r.RequireVerifiedCaller = true })
+		defer srv.Close()
+		defer store.Close()
+
+		_ = doGQL(t, srv, `mutation{ createUser(id:"a1", coins:10){ id } }`, nil)
+		if r := postGQL(t, srv, "", "", freeze, nil); r.Errors == nil {
+			t.Fatalf("expected an unsigned freezeUser to be refused")
+		}
+		if r := postGQL(t, srv, "mallory", mw.SignCaller("guessed", "mallory"), freeze, nil); r.Errors == nil {
+			t.Fatalf("expected a freezeUser with a forged signature to be refused")
+		}
+		if got, _ := store.GetAccount(context.Background(), "a1", ""); got.Status != dbpkg.StatusActive {
+			t.Fatalf("expected a1 to stay active, got %s", got.Status)
+		}
+		if r := doGQL(t, srv, freeze, nil); r.Errors != nil {
+			t.Fatalf("freezeUser: %#v", r.Errors)
+		}
+		if r := postGQL(t, srv, "", "", logQ, nil); r.Errors == nil {
+			t.Fatalf("expected an unsigned auditLog to be refused")
+		}
+		entries := auditOf(t, doGQL(t, srv, logQ, nil))
+		if len(entries) != 1 {
+			t.Fatalf("expected only the signed freezeUser to be audited, got %#v", entries)
+		}
+		e := entries[0].(map[string]any)["node"].(map[string]any)
+		before := e["before"].([]any)[0].(map[string]any)
+		after := e["after"].([]any)[0].(map[string]any)
+		if e["caller"] != testCaller || e["callerVerified"] != true || e["outcome"] != "ok" || before["status"] != "active" || after["status"] != "frozen" {
+			t.Fatalf("expected the entry to record the verified caller and the status change, got %#v", e)
+		}
+	})
+}
+
+// The ledger records every balance change, newest first, with its kind, delta and resulting balance.
+func TestGraphQL_Ledger(t *testing.T) {
+	srv, store := setupServer(t)
+	defer srv.Close()
+	defer store.Close()
+
+	const uid = "6f1c2f4e-8a3b-4d7e-9c1a-2b3c4d5e6f70"
+	create := `mutation($id:ID!,$coins:Int){ createUser(id:$id, coins:$coins){ id } }`
+	_ = doGQL(t, srv, create, map[string]any{"id": "l1", "coins": 100})
+	_ = doGQL(t, srv, create, map[string]any{"id": "l2", "coins": 0})
+	for _, q := range []string{
+		`mutation($uid:ID!){ rechargeCoins(id:"l1", amount:25, userId:$uid){ id } }`,
+		`mutation($uid:ID!){ useCoins(id:"l1", amount:10, userId:$uid){ id } }`,
+		`mutation($uid:ID!){ transferCoins(fromId:"l1", toId:"l2", amount:40, userId:$uid){ from{ id } } }`,
+	} {
+		if r := doGQL(t, srv, q, map[string]any{"uid": uid}); r.Errors != nil {
+			t.Fatalf("%s: %#v", q, r.Errors)
+		}
+	}
+
+	ledger := func(id string) []any {
+		t.Helper()
+		r := doGQL(t, srv, `query($id:ID!){ getUser(id:$id){ ledger(first:10){ id kind delta balanceAfter } } }`, map[string]any{"id": id})
+		if r.Data == nil || r.Data["getUser"] == nil {
+			t.Fatalf("expected ledger data for %s, got %#v", id, r.Errors)
+		}
+		return r.Data["getUser"].(map[string]any)["ledger"].([]any)
+	}
+	type entry struct {
+		kind         string
+		delta, after float64
+	}
+	check := func(id string, want []entry) {
+		t.Helper()
+		got := ledger(id)
+		if len(got) != len(want) {
+			t.Fatalf("%s: expected %d ledger entries, got %#v", id, len(want), got)
+		}
+		var prevID int64
+		for i, w := range want {
+			e := got[i].(map[string]any)
+			if e["kind"] != w.kind || e["delta"].(float64) != w.delta || e["balanceAfter"].(float64) != w.after {
+				t.Fatalf("%s entry %d: expected %s %v -> %v, got %#v", id, i, w.kind, w.delta, w.after, e)
+			}
+			entryID, _ := strconv.ParseInt(e["id"].(string), 10, 64)
+			if i > 0 && entryID >= prevID {
+				t.Fatalf("%s: expected the ledger newest first, got id %d after %d", id, entryID, prevID)
+			}
+			prevID = entryID
+		}
+	}
+	check("l1", []entry{
+		{dbpkg.LedgerKindTransferOut, -40, 75},
+		{dbpkg.LedgerKindUse, -10, 115},
+		{dbpkg.LedgerKindRecharge, 25, 125},
+		{dbpkg.LedgerKindCreate, 100, 100},
+	})
+	check("l2", []entry{
+		{dbpkg.LedgerKindTransferIn, 40, 40},
+	})
+}
+
+// Concurrent debits on the in-memory store never overdraw and roll back as a whole.
+func TestMemoryStore_ConcurrentUse(t *testing.T) {
+	ctx := context.Background()
+	store := dbpkg.NewMemoryStore()
+	coins := int64(50)
+	if _, err := store.CreateAccount(ctx, "m1", "", &coins, nil, nil); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	const uid = "6f1c2f4e-8a3b-4d7e-9c1a-2b3c4d5e6f70"
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	ok, short := 0, 0
+	for i := 0; i < 80; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := store.Use(ctx, "m1", "", 1, nil, uid, fmt.Sprintf("order:c-%d", i))
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				ok++
+			case strings.Contains(err.Error(), "insufficient balance"):
+				short++
+			default:
+				t.Errorf("use: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if ok != 50 || short != 30 {
+		t.Fatalf("expected 50 debits and 30 rejections, got %d and %d", ok, short)
+	}
+	acc, _ := store.GetAccount(ctx, "m1", "")
+	if acc.Coins != 0 || acc.Version != 51 {
+		t.Fatalf("expected 0 coins at version 51, got %d at %d", acc.Coins, acc.Version)
+	}
+	if entries, _ := store.ListLedger(ctx, "m1", "", 200, 0); len(entries) != 51 {
+		t.Fatalf("expected 51 ledger entries, got %d", len(entries))
+	}
+
+	// a transfer to a missing account is undone, source debit included
+	five := int64(5)
+	_, _ = store.CreateAccount(ctx, "m2", "", &five, nil, nil)
+	if _, _, err := store.Transfer(ctx, "m2", "nobody", "", 1, nil, uid, ""); err == nil {
+		t.Fatalf("expected transfer to a missing account to fail")
+	}
+	if after, _ := store.GetAccount(ctx, "m2", ""); after.Coins != 5 || after.Version != 1 {
+		t.Fatalf("expected failed transfer to leave m2 unchanged, got %#v", after)
+	}
+}
+
+// Dormant accounts are archived unless lots remain, still counted, and restored when read.
+func TestArchive_DormantAccounts(t *testing.T) {
+	ctx := context.Background()
+	var store dbpkg.AccountStore = dbpkg.NewMemoryStore()
+	if pg := openPG(t); pg != nil {
+		store = pg
+	}
+	defer store.Close()
+
+	const uid = "6f1c2f4e-8a3b-4d7e-9c1a-2b3c4d5e6f70"
+	for _, id := range []string{"d1", "d2", "d3"} {
+		if _, err := store.CreateAccount(ctx, id, "", nil, nil, nil); err != nil {
+			t.Fatalf("create %s: %v", id, err)
+		}
+	}
+	// d2's recharge never expires, so it is just its balance; d3 keeps a lot the expirer will take
+	if _, err := store.Recharge(ctx, "d2", "", 3, nil, uid, ""); err != nil {
+		t.Fatalf("recharge d2: %v", err)
+	}
+	expires := time.Now().Add(time.Hour)
+	if _, err := store.RechargeWithExpiry(ctx, "d3", "", 3, &expires, nil, uid, ""); err != nil {
+		t.Fatalf("recharge d3: %v", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	cfg := dbpkg.ArchiveConfig{InactiveFor: time.Millisecond, MaxCoins: 5, DryRun: true}
+	rep, err := store.ArchiveDormant(ctx, cfg)
+	if err != nil || rep.Accounts != 2 || rep.Balances[0].ID != "d1" || rep.Balances[1].ID != "d2" || rep.Coins != 3 {
+		t.Fatalf("expected a dry run to report d1 and d2, got %#v, %v", rep, err)
+	}
+	cfg.DryRun = false
+	if rep, _ := store.ArchiveDormant(ctx, cfg); rep.Accounts != 2 {
+		t.Fatalf("expected d1 and d2 to be archived, got %#v", rep)
+	}
+	page, _ := store.ListAccounts(ctx, dbpkg.PageArgs{}, dbpkg.AccountFilter{})
+	if n, _ := store.CountAccounts(ctx); len(page.Accounts) != 1 || page.Accounts[0].ID != "d3" || n != 3 {
+		t.Fatalf("expected d1 and d2 to leave listings but not the count, got %d listed of %d", len(page.Accounts), n)
+	}
+	if back, _ := store.GetAccount(ctx, "d2", ""); back == nil || back.Coins != 3 || back.Version != 2 {
+		t.Fatalf("expected d2 to be restored as it was, got %#v", back)
+	}
+}
+
+// A mutation restores an archived account, filling columns it was archived without with their defaults.
+func TestArchive_RestoredOnTouch(t *testing.T) {
+	ctx := context.Background()
+	pg := needPG(t)
+	defer pg.Close()
+
+	const uid = "6f1c2f4e-8a3b-4d7e-9c1a-2b3c4d5e6f70"
+	if _, err := pg.CreateAccount(ctx, "t1", "", nil, map[string]any{"tier": "gold"}, nil); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if rep, err := pg.ArchiveDormant(ctx, dbpkg.ArchiveConfig{InactiveFor: time.Millisecond}); err != nil || rep.Accounts != 1 {
+		t.Fatalf("expected t1 to be archived, got %#v, %v", rep, err)
+	}
+	// as if credit_limit and shards had been added after t1 was archived
+	if _, err := pg.Pool.Exec(ctx, `UPDATE public.archived_coins SET row = row - 'credit_limit' - 'shards' WHERE id='t1'`); err != nil {
+		t.Fatalf("strip archived row: %v", err)
+	}
+
+	acc, err := pg.Recharge(ctx, "t1", "", 4, nil, uid, "")
+	if err != nil {
+		t.Fatalf("recharge of an archived account: %v", err)
+	}
+	if acc.Coins != 4 || acc.Version != 2 || acc.CreditLimit != 0 || acc.Metadata["tier"] != "gold" {
+		t.Fatalf("expected t1 restored with its metadata and 4 coins, got %#v", acc)
+	}
+	var archived int
+	if err := pg.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM public.archived_coins WHERE id='t1'`).Scan(&archived); err != nil || archived != 0 {
+		t.Fatalf("expected t1 to leave the archive, got %d, %v", archived, err)
+	}
+}
+
+// A retried authorization returns the original hold, and a capture can't spend what other holds reserve.
+func TestHolds_IdempotentAuthorizeAndCapture(t *testing.T) {
+	ctx := context.Background()
+	var store dbpkg.AccountStore = dbpkg.NewMemoryStore()
+	if pg := openPG(t); pg != nil {
+		store = pg
+	}
+	defer store.Close()
+
+	const uid = "6f1c2f4e-8a3b-4d7e-9c1a-2b3c4d5e6f70"
+	coins := int64(10)
+	if _, err := store.CreateAccount(ctx, "h1", "", &coins, nil, nil); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	first, err := store.Authorize(ctx, "h1", "", 6, time.Minute, nil, uid, "auth:h-1")
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	if retry, err := store.Authorize(ctx, "h1", "", 6, time.Minute, nil, uid, "auth:h-1"); err != nil || retry.ID != first.ID {
+		t.Fatalf("expected the retry to return hold %s, got %#v, %v", first.ID, retry, err)
+	}
+	if _, err := store.Authorize(ctx, "h1", "", 5, time.Minute, nil, uid, "auth:h-1"); err == nil {
+		t.Fatalf("expected a reused dataId with another amount to be rejected")
+	}
+	second, err := store.Authorize(ctx, "h1", "", 4, time.Minute, nil, uid, "auth:h-2")
+	if err != nil {
+		t.Fatalf("second authorize: %v", err)
+	}
+
+	// the balance drops under both holds; the second may not eat into the first one
+	if _, err := store.SetCoinsExact(ctx, "h1", "", 6, nil, uid, ""); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if _, _, err := store.Capture(ctx, second.ID, nil, nil, uid, ""); err == nil || !strings.Contains(err.Error(), "insufficient balance") {
+		t.Fatalf("expected the capture to be short, got %v", err)
+	}
+	if _, err := store.Void(ctx, first.ID, nil); err != nil {
+		t.Fatalf("void: %v", err)
+	}
+	if _, acc, err := store.Capture(ctx, second.ID, nil, nil, uid, ""); err != nil || acc.Coins != 2 {
+		t.Fatalf("expected the capture to leave 2 coins, got %#v, %v", acc, err)
+	}
+}
+
+// Lifecycle, hold and schedule mutations reject a stale expectedVersion and accept the current one.
+func TestExpectedVersion_OtherMutations(t *testing.T) {
+	ctx := context.Background()
+	var store dbpkg.AccountStore = dbpkg.NewMemoryStore()
+	if pg := openPG(t); pg != nil {
+		store = pg
+	}
+	defer store.Close()
+
+	const uid = "6f1c2f4e-8a3b-4d7e-9c1a-2b3c4d5e6f70"
+	coins := int64(10)
+	acc, err := store.CreateAccount(ctx, "v1", "", &coins, nil, nil)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	stale := acc.Version - 1
+	if _, err := store.FreezeAccount(ctx, "v1", &stale); !errors.Is(err, dbpkg.ErrVersionConflict) {
+		t.Fatalf("expected a stale freeze to conflict, got %v", err)
+	}
+	frozen, err := store.FreezeAccount(ctx, "v1", &acc.Version)
+	if err != nil {
+		t.Fatalf("freeze: %v", err)
+	}
+	if _, err := store.UnfreezeAccount(ctx, "v1", &acc.Version); !errors.Is(err, dbpkg.ErrVersionConflict) {
+		t.Fatalf("expected an unfreeze at the pre-freeze version to conflict, got %v", err)
+	}
+	if _, err := store.UnfreezeAccount(ctx, "v1", &frozen[0].Version); err != nil {
+		t.Fatalf("unfreeze: %v", err)
+	}
+
+	// placing and voiding a hold change the available balance, so both bump the version
+	before, _ := store.GetAccount(ctx, "v1", "")
+	hold, err := store.Authorize(ctx, "v1", "", 3, time.Minute, nil, uid, "")
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	cur, _ := store.GetAccount(ctx, "v1", "")
+	if cur.Version != before.Version+1 {
+		t.Fatalf("expected authorize to bump the version to %d, got %d", before.Version+1, cur.Version)
+	}
+	if _, err := store.Void(ctx, hold.ID, &before.Version); !errors.Is(err, dbpkg.ErrVersionConflict) {
+		t.Fatalf("expected a stale void to conflict, got %v", err)
+	}
+	if _, err := store.Void(ctx, hold.ID, &cur.Version); err != nil {
+		t.Fatalf("void: %v", err)
+	}
+	if after, _ := store.GetAccount(ctx, "v1", ""); after.Version != cur.Version+1 {
+		t.Fatalf("expected void to bump the version to %d, got %d", cur.Version+1, after.Version)
+	}
+
+	cur, _ = store.GetAccount(ctx, "v1", "")
+	if _, err := store.CreateRechargeSchedule(ctx, "v1", "", 5, "", time.Hour, nil, &acc.Version, uid); !errors.Is(err, dbpkg.ErrVersionConflict) {
+		t.Fatalf("expected a stale schedule create to conflict, got %v", err)
+	}
+	sc, err := store.CreateRechargeSchedule(ctx, "v1", "", 5, "", time.Hour, nil, &cur.Version, uid)
+	if err != nil {
+		t.Fatalf("create schedule: %v", err)
+	}
+	if ok, err := store.DeleteRechargeSchedule(ctx, sc.ID, &acc.Version); ok || !errors.Is(err, dbpkg.ErrVersionConflict) {
+		t.Fatalf("expected a stale schedule delete to conflict, got %v, %v", ok, err)
+	}
+	if ok, err := store.DeleteRechargeSchedule(ctx, sc.ID, &cur.Version); !ok || err != nil {
+		t.Fatalf("expected the schedule to be deleted, got %v, %v", ok, err)
+	}
+}
+
+// As-of balances follow the ledger between mutations, on both sides of a snapshot.
+func TestHistory_BalanceAsOf(t *testing.T) {
+	ctx := context.Background()
+	var store dbpkg.AccountStore = dbpkg.NewMemoryStore()
+	if pg := openPG(t); pg != nil {
+		store = pg
+	}
+	defer store.Close()
+
+	const uid = "6f1c2f4e-8a3b-4d7e-9c1a-2b3c4d5e6f70"
+	tick := func() time.Time {
+		time.Sleep(20 * time.Millisecond)
+		at := time.Now()
+		time.Sleep(20 * time.Millisecond)
+		return at
+	}
+	coinsAt := func(at time.Time) int64 {
+		t.Helper()
+		acc, err := store.GetAccountAsOf(ctx, "a1", "", at)
+		if err != nil || acc == nil {
+			t.Fatalf("as of %s: %#v, %v", at, acc, err)
+		}
+		return acc.Coins
+	}
+
+	beforeAll := tick()
+	if _, err := store.CreateAccount(ctx, "a1", "", nil, nil, nil); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := store.Recharge(ctx, "a1", "", 10, nil, uid, ""); err != nil {
+		t.Fatalf("recharge: %v", err)
+	}
+	beforeSnap := tick()
+	if _, err := store.Use(ctx, "a1", "", 3, nil, uid, ""); err != nil {
+		t.Fatalf("use: %v", err)
+	}
+	if n, err := store.SnapshotBalances(ctx); err != nil || n != 1 {
+		t.Fatalf("expected one snapshot, got %d, %v", n, err)
+	}
+	between := tick()
+	if _, err := store.Recharge(ctx, "a1", "", 5, nil, uid, ""); err != nil {
+		t.Fatalf("recharge: %v", err)
+	}
+	if acc, _ := store.GetAccountAsOf(ctx, "a1", "", beforeAll); acc != nil {
+		t.Fatalf("expected no balance before the account existed, got %#v", acc)
+	}
+	if got := coinsAt(beforeSnap); got != 10 {
+		t.Fatalf("expected 10 coins before the snapshot, got %d", got)
+	}
+	if got := coinsAt(between); got != 7 {
+		t.Fatalf("expected 7 coins between the use and the recharge, got %d", got)
+	}
+	if got := coinsAt(time.Now().Add(time.Second)); got != 12 {
+		t.Fatalf("expected 12 coins now, got %d", got)
+	}
+	if sum, err := store.SumCoinsAsOf(ctx, "", between); err != nil || sum != 7 {
+		t.Fatalf("expected a total of 7 between the use and the recharge, got %d, %v", sum, err)
+	}
+}
+
+// Transferred coins keep their expiry on the receivers, and a setCoins raise expires like a recharge.
+func TestLots_CarriedByTransferAndSet(t *testing.T) {
+	ctx := context.Background()
+	var store dbpkg.AccountStore
+	if pg := openPG(t); pg != nil {
+		pg.DefaultCoinExpiry = time.Hour
+		store = pg
+	} else {
+		m := dbpkg.NewMemoryStore()
+		m.DefaultCoinExpiry = time.Hour
+		store = m
+	}
+	defer store.Close()
+
+	const uid = "6f1c2f4e-8a3b-4d7e-9c1a-2b3c4d5e6f70"
+	for _, id := range []string{"x1", "x2", "x3", "x4"} {
+		if _, err := store.CreateAccount(ctx, id, "", nil, nil, nil); err != nil {
+			t.Fatalf("create %s: %v", id, err)
+		}
+	}
+	if _, err := store.Recharge(ctx, "x1", "", 30, nil, uid, "order:x-1"); err != nil {
+		t.Fatalf("recharge: %v", err)
+	}
+	if _, _, err := store.Transfer(ctx, "x1", "x2", "", 20, nil, uid, ""); err != nil {
+		t.Fatalf("transfer: %v", err)
+	}
+	from, _ := store.ListUpcomingExpirations(ctx, "x1", "", nil)
+	to, _ := store.ListUpcomingExpirations(ctx, "x2", "", nil)
+	if len(from) != 1 || from[0].Remaining != 10 {
+		t.Fatalf("expected 10 coins left in x1's lot, got %#v", from)
+	}
+	if len(to) != 1 || to[0].Remaining != 20 || !to[0].ExpiresAt.Equal(*from[0].ExpiresAt) {
+		t.Fatalf("expected x2 to get 20 coins expiring with x1's lot, got %#v", to)
+	}
+
+	if _, err := store.SetCoinsExact(ctx, "x2", "", 25, nil, uid, ""); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	to, _ = store.ListUpcomingExpirations(ctx, "x2", "", nil)
+	if len(to) != 2 || to[1].Remaining != 5 {
+		t.Fatalf("expected the raise of 5 to get its own expiring lot, got %#v", to)
+	}
+
+	// the credit legs of a multi-leg transfer share the debited lots
+	legs := []dbpkg.TransferLeg{{AccountID: "x1", Amount: -10}, {AccountID: "x3", Amount: 4}, {AccountID: "x4", Amount: 6}}
+	if _, err := store.MultiTransfer(ctx, "", legs, uid, ""); err != nil {
+		t.Fatalf("multi-transfer: %v", err)
+	}
+	for id, want := range map[string]int64{"x1": 0, "x3": 4, "x4": 6} {
+		var got int64
+		lots, _ := store.ListUpcomingExpirations(ctx, id, "", nil)
+		for _, l := range lots {
+			if !l.ExpiresAt.Equal(*from[0].ExpiresAt) {
+				t.Fatalf("expected %s's lots to expire with x1's, got %#v", id, l)
+			}
+			got += l.Remaining
+		}
+		if got != want {
+			t.Fatalf("expected %s to hold %d expiring coins, got %d", id, want, got)
+		}
+	}
+
+	// and so does the sweep of a closed account
+	if _, err := store.CloseAccount(ctx, "x3", "x4", nil, uid, "close:x-3"); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	var swept int64
+	lots, _ := store.ListUpcomingExpirations(ctx, "x4", "", nil)
+	for _, l := range lots {
+		swept += l.Remaining
+	}
+	if swept != 10 {
+		t.Fatalf("expected x4 to hold 10 expiring coins after the sweep, got %#v", lots)
+	}
+
+	// and so does the reversal of a transfer, back to the payer
+	if _, _, err := store.Transfer(ctx, "x4", "x2", "", 5, nil, uid, "gift:x-1"); err != nil {
+		t.Fatalf("transfer: %v", err)
+	}
+	if _, err := store.ReverseTransaction(ctx, "gift:x-1", nil, "", nil, uid); err != nil {
+		t.Fatalf("reverse: %v", err)
+	}
+	var back int64
+	lots, _ = store.ListUpcomingExpirations(ctx, "x4", "", nil)
+	for _, l := range lots {
+		back += l.Remaining
+	}
+	if back != 10 {
+		t.Fatalf("expected x4 to hold 10 expiring coins after the reversal, got %#v", lots)
+	}
+}
+
+// A lot past its expiry isn't spent before the expirer gets to it; it is burned whole.
+func TestLots_ExpiredNotSpent(t *testing.T) {
+	ctx := context.Background()
+	var store dbpkg.AccountStore
+	if pg := openPG(t); pg != nil {
+		store = pg
+	} else {
+		store = dbpkg.NewMemoryStore()
+	}
+	defer store.Close()
+
+	const uid = "6f1c2f4e-8a3b-4d7e-9c1a-2b3c4d5e6f70"
+	if _, err := store.CreateAccount(ctx, "e1", "", nil, nil, nil); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	soon, later := time.Now().Add(time.Second), time.Now().Add(time.Hour)
+	if _, err := store.RechargeWithExpiry(ctx, "e1", "", 10, &soon, nil, uid, ""); err != nil {
+		t.Fatalf("recharge: %v", err)
+	}
+	if _, err := store.RechargeWithExpiry(ctx, "e1", "", 10, &later, nil, uid, ""); err != nil {
+		t.Fatalf("recharge: %v", err)
+	}
+	time.Sleep(time.Until(soon) + 10*time.Millisecond)
+
+	if _, err := store.Use(ctx, "e1", "", 5, nil, uid, ""); err != nil {
+		t.Fatalf("use: %v", err)
+	}
+	burned, err := store.ExpireLots(ctx)
+	if err != nil || burned != 10 {
+		t.Fatalf("expected the expired lot's 10 coins to be burned, got %d (%v)", burned, err)
+	}
+	a, _ := store.GetAccount(ctx, "e1", "")
+	if a == nil || a.Coins != 5 {
+		t.Fatalf("expected 5 coins left, got %#v", a)
+	}
+}
+
+// Each notification carries the coin type of the balance it is about.
+func TestOutbox_SendsCoinType(t *testing.T) {
+	ctx := context.Background()
+	n := &recordingNotifier{}
+	var store dbpkg.AccountStore
+	if pg := openPG(t); pg != nil {
+		pg.Notifier = n
+		store = pg
+	} else {
+		m := dbpkg.NewMemoryStore()
+		m.Notifier = n
+		store = m
+	}
+	defer store.Close()
+
+	const uid = "6f1c2f4e-8a3b-4d7e-9c1a-2b3c4d5e6f70"
+	coins := int64(10)
+	for _, ct := range []string{"COIN", "PROMO"} {
+		if _, err := store.CreateAccount(ctx, "ct1", ct, &coins, nil, nil); err != nil {
+			t.Fatalf("create %s: %v", ct, err)
+		}
+		if _, err := store.Use(ctx, "ct1", ct, 1, nil, uid, "order:"+ct); err != nil {
+			t.Fatalf("use %s: %v", ct, err)
+		}
+	}
+	// entries of one account go out one per pass, oldest first
+	for pass := 0; pass < 3; pass++ {
+		if _, err := store.DispatchOutbox(ctx, dbpkg.OutboxConfig{}); err != nil {
+			t.Fatalf("dispatch: %v", err)
+		}
+	}
+	if len(n.sent) != 2 {
+		t.Fatalf("expected two deliveries, got %v", n.sent)
+	}
+	byData := map[string]string{}
+	for i, d := range n.sent {
+		byData[d] = n.types[i]
+	}
+	if byData["order:COIN"] != "COIN" || byData["order:PROMO"] != "PROMO" {
+		t.Fatalf("expected each delivery to carry its coin type, got %v", byData)
+	}
+}
+
+// recordingAlerts records the overdraft alerts it is sent.
+type recordingAlerts struct {
+	mu         sync.Mutex
+	overdrafts []int64
+}
+
+func (a *recordingAlerts) Overdrawn(ctx context.Context, eventID, userID, accountID, coinType string, overdraft int64, ts time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.overdrafts = append(a.overdrafts, overdraft)
+	return nil
+}
+
+// An overdraft goes out as an alert of its own topic, not as a transaction.
+func TestOutbox_OverdraftAlert(t *testing.T) {
+	ctx := context.Background()
+	n, alerts := &recordingNotifier{}, &recordingAlerts{}
+	var store dbpkg.AccountStore
+	if pg := openPG(t); pg != nil {
+		pg.Notifier, pg.Alerts = n, alerts
+		store = pg
+	} else {
+		m := dbpkg.NewMemoryStore()
+		m.Notifier, m.Alerts = n, alerts
+		store = m
+	}
+	defer store.Close()
+
+	const uid = "6f1c2f4e-8a3b-4d7e-9c1a-2b3c4d5e6f70"
+	coins := int64(2)
+	if _, err := store.CreateAccount(ctx, "od1", "", &coins, nil, nil); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := store.SetCreditLimit(ctx, "od1", "", 10, nil); err != nil {
+		t.Fatalf("credit limit: %v", err)
+	}
+	if _, err := store.Use(ctx, "od1", "", 5, nil, uid, "order:od-1"); err != nil {
+		t.Fatalf("use: %v", err)
+	}
+	for pass := 0; pass < 3; pass++ {
+		if _, err := store.DispatchOutbox(ctx, dbpkg.OutboxConfig{}); err != nil {
+			t.Fatalf("dispatch: %v", err)
+		}
+	}
+	if len(n.sent) != 1 || n.sent[0] != "order:od-1" {
+		t.Fatalf("expected only the use to be posted as a transaction, got %v", n.sent)
+	}
+	if len(alerts.overdrafts) != 1 || alerts.overdrafts[0] != 3 {
+		t.Fatalf("expected one alert for an overdraft of 3, got %v", alerts.overdrafts)
+	}
+	topics := map[string]string{}
+	entries, _ := store.ListOutbox(ctx, dbpkg.OutboxSent, 10, 0)
+	for _, e := range entries {
+		topics[e.Topic] = e.DataID
+	}
+	if len(entries) != 2 || topics[dbpkg.OutboxTopicTransaction] != "order:od-1" || !strings.HasPrefix(topics[dbpkg.OutboxTopicOverdrawn], "overdrawn:od1:") {
+		t.Fatalf("expected the use and the alert sent under their topics, got %v", topics)
+	}
+}
+
+// The outbox dispatcher holds no row locks while it sends, and sends each entry once.
+func TestOutbox_SendsWithoutHoldingLocks(t *testing.T) {
+	ctx := context.Background()
+	pg := needPG(t)
+	defer pg.Close()
+
+	n := &recordingNotifier{}
+	pg.Notifier = n
+	coins := int64(10)
+	if _, err := pg.CreateAccount(ctx, "ob1", "", &coins, nil, nil); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := pg.Use(ctx, "ob1", "", 1, nil, "6f1c2f4e-8a3b-4d7e-9c1a-2b3c4d5e6f70", "order:ob-1"); err != nil {
+		t.Fatalf("use: %v", err)
+	}
+	var lockErr error
+	n.during = func() {
+		_, lockErr = pg.Pool.Exec(ctx, `SELECT 1 FROM public.tx_outbox FOR UPDATE NOWAIT`)
+	}
+	if got, err := pg.DispatchOutbox(ctx, dbpkg.OutboxConfig{}); err != nil || got != 1 {
+		t.Fatalf("expected one delivery, got %d (%v)", got, err)
+	}
+	if lockErr != nil {
+		t.Fatalf("expected the outbox to be unlocked while sending, got %v", lockErr)
+	}
+	if again, _ := pg.DispatchOutbox(ctx, dbpkg.OutboxConfig{}); again != 0 || len(n.sent) != 1 {
+		t.Fatalf("expected the entry to be sent once, got %v", n.sent)
+	}
+}
+
+// Reads go to a current replica, even after a cancelled request, and fall back to the
+// primary once the replica stops answering.
+func TestReplica_RoutesReadsAndFallsBack(t *testing.T) {
+	ctx := context.Background()
+	pg := needPG(t)
+	defer pg.Close()
+
+	// the test database stands in for the replica; not being in recovery, it has no lag
+	cfg, err := pgxpool.ParseConfig(os.Getenv("DATABASE_URL"))
+	if err != nil {
+		t.Fatalf("replica config: %v", err)
+	}
+	reads := &accountReads{}
+	cfg.ConnConfig.Tracer = reads
+	if pg.Replica, err = pgxpool.NewWithConfig(ctx, cfg); err != nil {
+		t.Fatalf("replica connect: %v", err)
+	}
+	if _, err := pg.CreateAccount(ctx, "r1", "", nil, nil, nil); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if reads.n.Load() != 0 {
+		t.Fatalf("expected mutations to skip the replica, got %d reads", reads.n.Load())
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := pg.GetAccount(cancelled, "r1", ""); err == nil {
+		t.Fatalf("expected a read with a cancelled context to fail")
+	}
+	if acc, err := pg.GetAccount(ctx, "r1", ""); err != nil || acc == nil {
+		t.Fatalf("get: %#v, %v", acc, err)
+	}
+	if n := reads.n.Load(); n != 1 {
+		t.Fatalf("expected the read after a cancelled one to be routed to the replica, got %d", n)
+	}
+	if _, err := pg.GetAccount(dbpkg.WithPrimary(ctx), "r1", ""); err != nil || reads.n.Load() != 1 {
+		t.Fatalf("expected WithPrimary to skip the replica, got %d reads, %v", reads.n.Load(), err)
+	}
+
+	// once the cached verdict expires, an unreachable replica sends reads to the primary
+	pg.Replica.Close()
+	time.Sleep(1100 * time.Millisecond)
+	if acc, err := pg.GetAccount(ctx, "r1", ""); err != nil || acc == nil {
+		t.Fatalf("expected the primary to serve the read, got %#v, %v", acc, err)
+	}
+}
+
+// A sharded balance takes concurrent credits, debits across its shards, reads and lists as
+// one balance, and unsharding folds the shards back into it.
+func TestShards_CreditsDebitsAndUnshard(t *testing.T) {
+	ctx := context.Background()
+	pg := needPG(t)
+	defer pg.Close()
+
+	const uid = "6f1c2f4e-8a3b-4d7e-9c1a-2b3c4d5e6f70"
+	coins := int64(20)
+	if _, err := pg.CreateAccount(ctx, "s2", "", &coins, nil, nil); err != nil {
+		t.Fatalf("create s2: %v", err)
+	}
+	if _, err := pg.CreateAccount(ctx, "s1", "", nil, nil, nil); err != nil {
+		t.Fatalf("create s1: %v", err)
+	}
+	if acc, err := pg.ShardAccount(ctx, "s1", "", 4); err != nil || acc.Coins != 0 {
+		t.Fatalf("shard: %#v, %v", acc, err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := pg.Recharge(ctx, "s1", "", 5, nil, uid, fmt.Sprintf("topup:s-%d", i)); err != nil {
+				t.Errorf("recharge: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	// no shard holds 90 of the 100 coins, so the debit draws from several
+	if acc, err := pg.Use(ctx, "s1", "", 90, nil, uid, "order:s-1"); err != nil || acc.Coins != 10 {
+		t.Fatalf("expected the debit to leave 10 coins, got %#v, %v", acc, err)
+	}
+
+	if acc, _ := pg.GetAccount(ctx, "s1", ""); acc == nil || acc.Coins != 10 || acc.LastRechargeDate == nil {
+		t.Fatalf("expected s1 to read 10 coins and its last recharge, got %#v", acc)
+	}
+	if sum, _ := pg.SumCoins(ctx, ""); sum != 30 {
+		t.Fatalf("expected 30 coins in total, got %d", sum)
+	}
+	page, err := pg.ListAccountsByCoinsRange(ctx, nil, nil, dbpkg.PageArgs{First: 1})
+	if err != nil || len(page.Accounts) != 1 || page.Accounts[0].ID != "s2" || !page.HasNextPage {
+		t.Fatalf("expected s2 first, got %#v, %v", page, err)
+	}
+	page, err = pg.ListAccountsByCoinsRange(ctx, nil, nil, dbpkg.PageArgs{First: 1, After: page.Cursors[0]})
+	if err != nil || len(page.Accounts) != 1 || page.Accounts[0].ID != "s1" || page.Accounts[0].Coins != 10 || page.HasNextPage {
+		t.Fatalf("expected s1 with 10 coins next, got %#v, %v", page, err)
+	}
+	if page, _ := pg.ListAccountsByCoinsRange(ctx, nil, nil, dbpkg.PageArgs{}); page == nil {
+		t.Fatalf("expected a listing")
+	} else if n, _ := page.TotalCount(ctx); n != 2 {
+		t.Fatalf("expected 2 balances in range, got %d", n)
+	}
+	if page, _ := pg.ListRecentRecharges(ctx, time.Now().Add(-time.Minute), dbpkg.PageArgs{}); page == nil || len(page.Accounts) != 1 || page.Accounts[0].ID != "s1" {
+		t.Fatalf("expected only s1 to be recently recharged, got %#v", page)
+	}
+
+	// taking a transfer back from the sharded balance draws on its shards, not its own coins
+	if _, _, err := pg.Transfer(ctx, "s2", "s1", "", 5, nil, uid, "gift:s-1"); err != nil {
+		t.Fatalf("transfer: %v", err)
+	}
+	if rev, err := pg.ReverseTransaction(ctx, "gift:s-1", nil, "", nil, uid); err != nil || rev.Counterparty.Coins != 10 {
+		t.Fatalf("expected the reversal to leave s1 with 10 coins, got %#v, %v", rev, err)
+	}
+	var rowCoins int64
+	if err := pg.Pool.QueryRow(ctx, `SELECT coins FROM public.coins WHERE id = 's1'`).Scan(&rowCoins); err != nil || rowCoins < 0 {
+		t.Fatalf("expected s1's own coins to stay >= 0, got %d, %v", rowCoins, err)
+	}
+
+	if acc, err := pg.ShardAccount(ctx, "s1", "", 0); err != nil || acc.Coins != 10 {
+		t.Fatalf("expected unsharding to keep 10 coins, got %#v, %v", acc, err)
+	}
+	var own, shards int64
+	if err := pg.Pool.QueryRow(ctx, `
+		SELECT c.coins, (SELECT COUNT(*) FROM public.coin_shards sh WHERE sh.account_id = c.id)
+		FROM public.coins c WHERE c.id = 's1'
+	`).Scan(&own, &shards); err != nil || own != 10 || shards != 0 {
+		t.Fatalf("expected the row to hold all 10 coins and no shards, got %d and %d shards, %v", own, shards, err)
+	}
 }